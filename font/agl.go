@@ -0,0 +1,397 @@
+package font
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// aglfn is the Adobe Glyph List for New Fonts: the glyph names fonts should
+// use for the code points it covers, each name mapping to one code point.
+// https://github.com/adobe-type-tools/agl-aglfn
+var aglfn = map[string]rune{
+	"A": 0x0041, "AE": 0x00C6, "AEacute": 0x01FC, "Aacute": 0x00C1, "Abreve": 0x0102,
+	"Acircumflex": 0x00C2, "Adieresis": 0x00C4, "Agrave": 0x00C0, "Alpha": 0x0391,
+	"Alphatonos": 0x0386, "Amacron": 0x0100, "Aogonek": 0x0104, "Aring": 0x00C5, "Aringacute": 0x01FA,
+	"Atilde": 0x00C3, "B": 0x0042, "Beta": 0x0392, "C": 0x0043, "Cacute": 0x0106, "Ccaron": 0x010C,
+	"Ccedilla": 0x00C7, "Ccircumflex": 0x0108, "Cdotaccent": 0x010A, "Chi": 0x03A7, "D": 0x0044,
+	"Dcaron": 0x010E, "Dcroat": 0x0110, "Delta": 0x2206, "E": 0x0045, "Eacute": 0x00C9,
+	"Ebreve": 0x0114, "Ecaron": 0x011A, "Ecircumflex": 0x00CA, "Edieresis": 0x00CB,
+	"Edotaccent": 0x0116, "Egrave": 0x00C8, "Emacron": 0x0112, "Eng": 0x014A, "Eogonek": 0x0118,
+	"Epsilon": 0x0395, "Epsilontonos": 0x0388, "Eta": 0x0397, "Etatonos": 0x0389, "Eth": 0x00D0,
+	"Euro": 0x20AC, "F": 0x0046, "G": 0x0047, "Gamma": 0x0393, "Gbreve": 0x011E, "Gcaron": 0x01E6,
+	"Gcircumflex": 0x011C, "Gdotaccent": 0x0120, "H": 0x0048, "H18533": 0x25CF, "H18543": 0x25AA,
+	"H18551": 0x25AB, "H22073": 0x25A1, "Hbar": 0x0126, "Hcircumflex": 0x0124, "I": 0x0049,
+	"IJ": 0x0132, "Iacute": 0x00CD, "Ibreve": 0x012C, "Icircumflex": 0x00CE, "Idieresis": 0x00CF,
+	"Idotaccent": 0x0130, "Ifraktur": 0x2111, "Igrave": 0x00CC, "Imacron": 0x012A, "Iogonek": 0x012E,
+	"Iota": 0x0399, "Iotadieresis": 0x03AA, "Iotatonos": 0x038A, "Itilde": 0x0128, "J": 0x004A,
+	"Jcircumflex": 0x0134, "K": 0x004B, "Kappa": 0x039A, "L": 0x004C, "Lacute": 0x0139,
+	"Lambda": 0x039B, "Lcaron": 0x013D, "Ldot": 0x013F, "Lslash": 0x0141, "M": 0x004D, "Mu": 0x039C,
+	"N": 0x004E, "Nacute": 0x0143, "Ncaron": 0x0147, "Ntilde": 0x00D1, "Nu": 0x039D, "O": 0x004F,
+	"OE": 0x0152, "Oacute": 0x00D3, "Obreve": 0x014E, "Ocircumflex": 0x00D4, "Odieresis": 0x00D6,
+	"Ograve": 0x00D2, "Ohorn": 0x01A0, "Ohungarumlaut": 0x0150, "Omacron": 0x014C, "Omega": 0x2126,
+	"Omegatonos": 0x038F, "Omicron": 0x039F, "Omicrontonos": 0x038C, "Oslash": 0x00D8,
+	"Oslashacute": 0x01FE, "Otilde": 0x00D5, "P": 0x0050, "Phi": 0x03A6, "Pi": 0x03A0, "Psi": 0x03A8,
+	"Q": 0x0051, "R": 0x0052, "Racute": 0x0154, "Rcaron": 0x0158, "Rfraktur": 0x211C, "Rho": 0x03A1,
+	"S": 0x0053, "SF010000": 0x250C, "SF020000": 0x2514, "SF030000": 0x2510, "SF040000": 0x2518,
+	"SF050000": 0x253C, "SF060000": 0x252C, "SF070000": 0x2534, "SF080000": 0x251C,
+	"SF090000": 0x2524, "SF100000": 0x2500, "SF110000": 0x2502, "SF190000": 0x2561,
+	"SF200000": 0x2562, "SF210000": 0x2556, "SF220000": 0x2555, "SF230000": 0x2563,
+	"SF240000": 0x2551, "SF250000": 0x2557, "SF260000": 0x255D, "SF270000": 0x255C,
+	"SF280000": 0x255B, "SF360000": 0x255E, "SF370000": 0x255F, "SF380000": 0x255A,
+	"SF390000": 0x2554, "SF400000": 0x2569, "SF410000": 0x2566, "SF420000": 0x2560,
+	"SF430000": 0x2550, "SF440000": 0x256C, "SF450000": 0x2567, "SF460000": 0x2568,
+	"SF470000": 0x2564, "SF480000": 0x2565, "SF490000": 0x2559, "SF500000": 0x2558,
+	"SF510000": 0x2552, "SF520000": 0x2553, "SF530000": 0x256B, "SF540000": 0x256A, "Sacute": 0x015A,
+	"Scaron": 0x0160, "Scedilla": 0x015E, "Scircumflex": 0x015C, "Sigma": 0x03A3, "T": 0x0054,
+	"Tau": 0x03A4, "Tbar": 0x0166, "Tcaron": 0x0164, "Theta": 0x0398, "Thorn": 0x00DE, "U": 0x0055,
+	"Uacute": 0x00DA, "Ubreve": 0x016C, "Ucircumflex": 0x00DB, "Udieresis": 0x00DC, "Ugrave": 0x00D9,
+	"Uhorn": 0x01AF, "Uhungarumlaut": 0x0170, "Umacron": 0x016A, "Uogonek": 0x0172, "Upsilon": 0x03A5,
+	"Upsilon1": 0x03D2, "Upsilondieresis": 0x03AB, "Upsilontonos": 0x038E, "Uring": 0x016E,
+	"Utilde": 0x0168, "V": 0x0056, "W": 0x0057, "Wacute": 0x1E82, "Wcircumflex": 0x0174,
+	"Wdieresis": 0x1E84, "Wgrave": 0x1E80, "X": 0x0058, "Xi": 0x039E, "Y": 0x0059, "Yacute": 0x00DD,
+	"Ycircumflex": 0x0176, "Ydieresis": 0x0178, "Ygrave": 0x1EF2, "Z": 0x005A, "Zacute": 0x0179,
+	"Zcaron": 0x017D, "Zdotaccent": 0x017B, "Zeta": 0x0396, "a": 0x0061, "aacute": 0x00E1,
+	"abreve": 0x0103, "acircumflex": 0x00E2, "acute": 0x00B4, "acutecomb": 0x0301,
+	"adieresis": 0x00E4, "ae": 0x00E6, "aeacute": 0x01FD, "agrave": 0x00E0, "aleph": 0x2135,
+	"alpha": 0x03B1, "alphatonos": 0x03AC, "amacron": 0x0101, "ampersand": 0x0026, "angle": 0x2220,
+	"angleleft": 0x2329, "angleright": 0x232A, "anoteleia": 0x0387, "aogonek": 0x0105,
+	"approxequal": 0x2248, "aring": 0x00E5, "aringacute": 0x01FB, "arrowboth": 0x2194,
+	"arrowdblboth": 0x21D4, "arrowdbldown": 0x21D3, "arrowdblleft": 0x21D0, "arrowdblright": 0x21D2,
+	"arrowdblup": 0x21D1, "arrowdown": 0x2193, "arrowleft": 0x2190, "arrowright": 0x2192,
+	"arrowup": 0x2191, "arrowupdn": 0x2195, "arrowupdnbse": 0x21A8, "asciicircum": 0x005E,
+	"asciitilde": 0x007E, "asterisk": 0x002A, "asteriskmath": 0x2217, "at": 0x0040, "atilde": 0x00E3,
+	"b": 0x0062, "backslash": 0x005C, "bar": 0x007C, "beta": 0x03B2, "block": 0x2588,
+	"braceleft": 0x007B, "braceright": 0x007D, "bracketleft": 0x005B, "bracketright": 0x005D,
+	"breve": 0x02D8, "brokenbar": 0x00A6, "bullet": 0x2022, "c": 0x0063, "cacute": 0x0107,
+	"caron": 0x02C7, "carriagereturn": 0x21B5, "ccaron": 0x010D, "ccedilla": 0x00E7,
+	"ccircumflex": 0x0109, "cdotaccent": 0x010B, "cedilla": 0x00B8, "cent": 0x00A2, "chi": 0x03C7,
+	"circle": 0x25CB, "circlemultiply": 0x2297, "circleplus": 0x2295, "circumflex": 0x02C6,
+	"club": 0x2663, "colon": 0x003A, "colonmonetary": 0x20A1, "comma": 0x002C, "congruent": 0x2245,
+	"copyright": 0x00A9, "currency": 0x00A4, "d": 0x0064, "dagger": 0x2020, "daggerdbl": 0x2021,
+	"dcaron": 0x010F, "dcroat": 0x0111, "degree": 0x00B0, "delta": 0x03B4, "diamond": 0x2666,
+	"dieresis": 0x00A8, "dieresistonos": 0x0385, "divide": 0x00F7, "dkshade": 0x2593,
+	"dnblock": 0x2584, "dollar": 0x0024, "dong": 0x20AB, "dotaccent": 0x02D9, "dotbelowcomb": 0x0323,
+	"dotlessi": 0x0131, "dotmath": 0x22C5, "e": 0x0065, "eacute": 0x00E9, "ebreve": 0x0115,
+	"ecaron": 0x011B, "ecircumflex": 0x00EA, "edieresis": 0x00EB, "edotaccent": 0x0117,
+	"egrave": 0x00E8, "eight": 0x0038, "element": 0x2208, "ellipsis": 0x2026, "emacron": 0x0113,
+	"emdash": 0x2014, "emptyset": 0x2205, "endash": 0x2013, "eng": 0x014B, "eogonek": 0x0119,
+	"epsilon": 0x03B5, "epsilontonos": 0x03AD, "equal": 0x003D, "equivalence": 0x2261,
+	"estimated": 0x212E, "eta": 0x03B7, "etatonos": 0x03AE, "eth": 0x00F0, "exclam": 0x0021,
+	"exclamdbl": 0x203C, "exclamdown": 0x00A1, "existential": 0x2203, "f": 0x0066, "female": 0x2640,
+	"figuredash": 0x2012, "filledbox": 0x25A0, "filledrect": 0x25AC, "five": 0x0035,
+	"fiveeighths": 0x215D, "florin": 0x0192, "four": 0x0034, "fraction": 0x2044, "franc": 0x20A3,
+	"g": 0x0067, "gamma": 0x03B3, "gbreve": 0x011F, "gcaron": 0x01E7, "gcircumflex": 0x011D,
+	"gdotaccent": 0x0121, "germandbls": 0x00DF, "gradient": 0x2207, "grave": 0x0060,
+	"gravecomb": 0x0300, "greater": 0x003E, "greaterequal": 0x2265, "guillemotleft": 0x00AB,
+	"guillemotright": 0x00BB, "guilsinglleft": 0x2039, "guilsinglright": 0x203A, "h": 0x0068,
+	"hbar": 0x0127, "hcircumflex": 0x0125, "heart": 0x2665, "hookabovecomb": 0x0309, "house": 0x2302,
+	"hungarumlaut": 0x02DD, "hyphen": 0x002D, "i": 0x0069, "iacute": 0x00ED, "ibreve": 0x012D,
+	"icircumflex": 0x00EE, "idieresis": 0x00EF, "igrave": 0x00EC, "ij": 0x0133, "imacron": 0x012B,
+	"infinity": 0x221E, "integral": 0x222B, "integralbt": 0x2321, "integraltp": 0x2320,
+	"intersection": 0x2229, "invbullet": 0x25D8, "invcircle": 0x25D9, "invsmileface": 0x263B,
+	"iogonek": 0x012F, "iota": 0x03B9, "iotadieresis": 0x03CA, "iotadieresistonos": 0x0390,
+	"iotatonos": 0x03AF, "itilde": 0x0129, "j": 0x006A, "jcircumflex": 0x0135, "k": 0x006B,
+	"kappa": 0x03BA, "kgreenlandic": 0x0138, "l": 0x006C, "lacute": 0x013A, "lambda": 0x03BB,
+	"lcaron": 0x013E, "ldot": 0x0140, "less": 0x003C, "lessequal": 0x2264, "lfblock": 0x258C,
+	"lira": 0x20A4, "logicaland": 0x2227, "logicalnot": 0x00AC, "logicalor": 0x2228, "longs": 0x017F,
+	"lozenge": 0x25CA, "lslash": 0x0142, "ltshade": 0x2591, "m": 0x006D, "macron": 0x00AF,
+	"male": 0x2642, "minus": 0x2212, "minute": 0x2032, "mu": 0x00B5, "multiply": 0x00D7,
+	"musicalnote": 0x266A, "musicalnotedbl": 0x266B, "n": 0x006E, "nacute": 0x0144,
+	"napostrophe": 0x0149, "ncaron": 0x0148, "nine": 0x0039, "notelement": 0x2209, "notequal": 0x2260,
+	"notsubset": 0x2284, "ntilde": 0x00F1, "nu": 0x03BD, "numbersign": 0x0023, "o": 0x006F,
+	"oacute": 0x00F3, "obreve": 0x014F, "ocircumflex": 0x00F4, "odieresis": 0x00F6, "oe": 0x0153,
+	"ogonek": 0x02DB, "ograve": 0x00F2, "ohorn": 0x01A1, "ohungarumlaut": 0x0151, "omacron": 0x014D,
+	"omega": 0x03C9, "omega1": 0x03D6, "omegatonos": 0x03CE, "omicron": 0x03BF,
+	"omicrontonos": 0x03CC, "one": 0x0031, "onedotenleader": 0x2024, "oneeighth": 0x215B,
+	"onehalf": 0x00BD, "onequarter": 0x00BC, "onethird": 0x2153, "openbullet": 0x25E6,
+	"ordfeminine": 0x00AA, "ordmasculine": 0x00BA, "orthogonal": 0x221F, "oslash": 0x00F8,
+	"oslashacute": 0x01FF, "otilde": 0x00F5, "p": 0x0070, "paragraph": 0x00B6, "parenleft": 0x0028,
+	"parenright": 0x0029, "partialdiff": 0x2202, "percent": 0x0025, "period": 0x002E,
+	"periodcentered": 0x00B7, "perpendicular": 0x22A5, "perthousand": 0x2030, "peseta": 0x20A7,
+	"phi": 0x03C6, "phi1": 0x03D5, "pi": 0x03C0, "plus": 0x002B, "plusminus": 0x00B1,
+	"prescription": 0x211E, "product": 0x220F, "propersubset": 0x2282, "propersuperset": 0x2283,
+	"proportional": 0x221D, "psi": 0x03C8, "q": 0x0071, "question": 0x003F, "questiondown": 0x00BF,
+	"quotedbl": 0x0022, "quotedblbase": 0x201E, "quotedblleft": 0x201C, "quotedblright": 0x201D,
+	"quoteleft": 0x2018, "quotereversed": 0x201B, "quoteright": 0x2019, "quotesinglbase": 0x201A,
+	"quotesingle": 0x0027, "r": 0x0072, "racute": 0x0155, "radical": 0x221A, "rcaron": 0x0159,
+	"reflexsubset": 0x2286, "reflexsuperset": 0x2287, "registered": 0x00AE, "revlogicalnot": 0x2310,
+	"rho": 0x03C1, "ring": 0x02DA, "rtblock": 0x2590, "s": 0x0073, "sacute": 0x015B, "scaron": 0x0161,
+	"scedilla": 0x015F, "scircumflex": 0x015D, "second": 0x2033, "section": 0x00A7,
+	"semicolon": 0x003B, "seven": 0x0037, "seveneighths": 0x215E, "shade": 0x2592, "sigma": 0x03C3,
+	"sigma1": 0x03C2, "similar": 0x223C, "six": 0x0036, "slash": 0x002F, "smileface": 0x263A,
+	"space": 0x0020, "spade": 0x2660, "sterling": 0x00A3, "suchthat": 0x220B, "summation": 0x2211,
+	"sun": 0x263C, "t": 0x0074, "tau": 0x03C4, "tbar": 0x0167, "tcaron": 0x0165, "therefore": 0x2234,
+	"theta": 0x03B8, "theta1": 0x03D1, "thorn": 0x00FE, "three": 0x0033, "threeeighths": 0x215C,
+	"threequarters": 0x00BE, "tilde": 0x02DC, "tildecomb": 0x0303, "tonos": 0x0384,
+	"trademark": 0x2122, "triagdn": 0x25BC, "triaglf": 0x25C4, "triagrt": 0x25BA, "triagup": 0x25B2,
+	"two": 0x0032, "twodotenleader": 0x2025, "twothirds": 0x2154, "u": 0x0075, "uacute": 0x00FA,
+	"ubreve": 0x016D, "ucircumflex": 0x00FB, "udieresis": 0x00FC, "ugrave": 0x00F9, "uhorn": 0x01B0,
+	"uhungarumlaut": 0x0171, "umacron": 0x016B, "underscore": 0x005F, "underscoredbl": 0x2017,
+	"union": 0x222A, "universal": 0x2200, "uogonek": 0x0173, "upblock": 0x2580, "upsilon": 0x03C5,
+	"upsilondieresis": 0x03CB, "upsilondieresistonos": 0x03B0, "upsilontonos": 0x03CD,
+	"uring": 0x016F, "utilde": 0x0169, "v": 0x0076, "w": 0x0077, "wacute": 0x1E83,
+	"wcircumflex": 0x0175, "wdieresis": 0x1E85, "weierstrass": 0x2118, "wgrave": 0x1E81, "x": 0x0078,
+	"xi": 0x03BE, "y": 0x0079, "yacute": 0x00FD, "ycircumflex": 0x0177, "ydieresis": 0x00FF,
+	"yen": 0x00A5, "ygrave": 0x1EF3, "z": 0x007A, "zacute": 0x017A, "zcaron": 0x017E,
+	"zdotaccent": 0x017C, "zero": 0x0030, "zeta": 0x03B6,
+	"Gcommaaccent": 0x0122, "gcommaaccent": 0x0123, "Kcommaaccent": 0x0136, "kcommaaccent": 0x0137,
+	"Lcommaaccent": 0x013B, "lcommaaccent": 0x013C, "Ncommaaccent": 0x0145, "ncommaaccent": 0x0146,
+	"Rcommaaccent": 0x0156, "rcommaaccent": 0x0157, "Scommaaccent": 0x0218, "scommaaccent": 0x0219,
+	"Tcommaaccent": 0x0162, "tcommaaccent": 0x0163, "onesuperior": 0x00B9, "twosuperior": 0x00B2,
+	"threesuperior": 0x00B3,
+}
+
+// aglExtra holds the names of the Adobe Glyph List that AGLFN leaves out:
+// the afii names, the PostScript names of the Macintosh standard glyph order
+// and the legacy names of old style figures, small capitals and math
+// extension pieces in the private use area. Where AGL gives a name two code
+// points, AGLFN's choice is kept in aglfn.
+// https://github.com/adobe-type-tools/agl-aglfn
+var aglExtra = map[string]rune{
+	"AEsmall": 0xF7E6, "Aacutesmall": 0xF7E1, "Acircumflexsmall": 0xF7E2, "Acute": 0xF6C9,
+	"Acutesmall": 0xF7B4, "Adieresissmall": 0xF7E4, "Agravesmall": 0xF7E0, "Aringsmall": 0xF7E5,
+	"Asmall": 0xF761, "Atildesmall": 0xF7E3, "Brevesmall": 0xF6F4, "Bsmall": 0xF762, "Caron": 0xF6CA,
+	"Caronsmall": 0xF6F5, "Ccedillasmall": 0xF7E7, "Cedillasmall": 0xF7B8, "Circumflexsmall": 0xF6F6,
+	"Csmall": 0xF763, "Dieresis": 0xF6CB, "DieresisAcute": 0xF6CC, "DieresisGrave": 0xF6CD,
+	"Dieresissmall": 0xF7A8, "Dotaccentsmall": 0xF6F7, "Dslash": 0x0110, "Dsmall": 0xF764,
+	"Eacutesmall": 0xF7E9, "Ecircumflexsmall": 0xF7EA, "Edieresissmall": 0xF7EB,
+	"Egravesmall": 0xF7E8, "Esmall": 0xF765, "Ethsmall": 0xF7F0, "Fsmall": 0xF766, "Grave": 0xF6CE,
+	"Gravesmall": 0xF760, "Gsmall": 0xF767, "Hsmall": 0xF768, "Hungarumlaut": 0xF6CF,
+	"Hungarumlautsmall": 0xF6F8, "Iacutesmall": 0xF7ED, "Icircumflexsmall": 0xF7EE,
+	"Idieresissmall": 0xF7EF, "Igravesmall": 0xF7EC, "Ismall": 0xF769, "Jsmall": 0xF76A,
+	"Ksmall": 0xF76B, "LL": 0xF6BF, "Lslashsmall": 0xF6F9, "Lsmall": 0xF76C, "Macron": 0xF6D0,
+	"Macronsmall": 0xF7AF, "Msmall": 0xF76D, "Nsmall": 0xF76E, "Ntildesmall": 0xF7F1,
+	"OEsmall": 0xF6FA, "Oacutesmall": 0xF7F3, "Ocircumflexsmall": 0xF7F4, "Odieresissmall": 0xF7F6,
+	"Ogoneksmall": 0xF6FB, "Ogravesmall": 0xF7F2, "Ohm": 0x2126, "Oslashsmall": 0xF7F8,
+	"Osmall": 0xF76F, "Otildesmall": 0xF7F5, "Psmall": 0xF770, "Qsmall": 0xF771, "Ringsmall": 0xF6FC,
+	"Rsmall": 0xF772, "Scaronsmall": 0xF6FD, "Ssmall": 0xF773, "Thornsmall": 0xF7FE,
+	"Tildesmall": 0xF6FE, "Tsmall": 0xF774, "Uacutesmall": 0xF7FA, "Ucircumflexsmall": 0xF7FB,
+	"Udieresissmall": 0xF7FC, "Ugravesmall": 0xF7F9, "Usmall": 0xF775, "Vsmall": 0xF776,
+	"Wsmall": 0xF777, "Xsmall": 0xF778, "Yacutesmall": 0xF7FD, "Ydieresissmall": 0xF7FF,
+	"Ysmall": 0xF779, "Zcaronsmall": 0xF6FF, "Zsmall": 0xF77A, "afii00208": 0x2015,
+	"afii10017": 0x0410, "afii10018": 0x0411, "afii10019": 0x0412, "afii10020": 0x0413,
+	"afii10021": 0x0414, "afii10022": 0x0415, "afii10023": 0x0401, "afii10024": 0x0416,
+	"afii10025": 0x0417, "afii10026": 0x0418, "afii10027": 0x0419, "afii10028": 0x041A,
+	"afii10029": 0x041B, "afii10030": 0x041C, "afii10031": 0x041D, "afii10032": 0x041E,
+	"afii10033": 0x041F, "afii10034": 0x0420, "afii10035": 0x0421, "afii10036": 0x0422,
+	"afii10037": 0x0423, "afii10038": 0x0424, "afii10039": 0x0425, "afii10040": 0x0426,
+	"afii10041": 0x0427, "afii10042": 0x0428, "afii10043": 0x0429, "afii10044": 0x042A,
+	"afii10045": 0x042B, "afii10046": 0x042C, "afii10047": 0x042D, "afii10048": 0x042E,
+	"afii10049": 0x042F, "afii10050": 0x0490, "afii10051": 0x0402, "afii10052": 0x0403,
+	"afii10053": 0x0404, "afii10054": 0x0405, "afii10055": 0x0406, "afii10056": 0x0407,
+	"afii10057": 0x0408, "afii10058": 0x0409, "afii10059": 0x040A, "afii10060": 0x040B,
+	"afii10061": 0x040C, "afii10062": 0x040E, "afii10063": 0xF6C4, "afii10064": 0xF6C5,
+	"afii10065": 0x0430, "afii10066": 0x0431, "afii10067": 0x0432, "afii10068": 0x0433,
+	"afii10069": 0x0434, "afii10070": 0x0435, "afii10071": 0x0451, "afii10072": 0x0436,
+	"afii10073": 0x0437, "afii10074": 0x0438, "afii10075": 0x0439, "afii10076": 0x043A,
+	"afii10077": 0x043B, "afii10078": 0x043C, "afii10079": 0x043D, "afii10080": 0x043E,
+	"afii10081": 0x043F, "afii10082": 0x0440, "afii10083": 0x0441, "afii10084": 0x0442,
+	"afii10085": 0x0443, "afii10086": 0x0444, "afii10087": 0x0445, "afii10088": 0x0446,
+	"afii10089": 0x0447, "afii10090": 0x0448, "afii10091": 0x0449, "afii10092": 0x044A,
+	"afii10093": 0x044B, "afii10094": 0x044C, "afii10095": 0x044D, "afii10096": 0x044E,
+	"afii10097": 0x044F, "afii10098": 0x0491, "afii10099": 0x0452, "afii10100": 0x0453,
+	"afii10101": 0x0454, "afii10102": 0x0455, "afii10103": 0x0456, "afii10104": 0x0457,
+	"afii10105": 0x0458, "afii10106": 0x0459, "afii10107": 0x045A, "afii10108": 0x045B,
+	"afii10109": 0x045C, "afii10110": 0x045E, "afii10145": 0x040F, "afii10146": 0x0462,
+	"afii10147": 0x0472, "afii10148": 0x0474, "afii10192": 0xF6C6, "afii10193": 0x045F,
+	"afii10194": 0x0463, "afii10195": 0x0473, "afii10196": 0x0475, "afii10831": 0xF6C7,
+	"afii10832": 0xF6C8, "afii10846": 0x04D9, "afii299": 0x200E, "afii300": 0x200F, "afii301": 0x200D,
+	"afii57381": 0x066A, "afii57388": 0x060C, "afii57392": 0x0660, "afii57393": 0x0661,
+	"afii57394": 0x0662, "afii57395": 0x0663, "afii57396": 0x0664, "afii57397": 0x0665,
+	"afii57398": 0x0666, "afii57399": 0x0667, "afii57400": 0x0668, "afii57401": 0x0669,
+	"afii57403": 0x061B, "afii57407": 0x061F, "afii57409": 0x0621, "afii57410": 0x0622,
+	"afii57411": 0x0623, "afii57412": 0x0624, "afii57413": 0x0625, "afii57414": 0x0626,
+	"afii57415": 0x0627, "afii57416": 0x0628, "afii57417": 0x0629, "afii57418": 0x062A,
+	"afii57419": 0x062B, "afii57420": 0x062C, "afii57421": 0x062D, "afii57422": 0x062E,
+	"afii57423": 0x062F, "afii57424": 0x0630, "afii57425": 0x0631, "afii57426": 0x0632,
+	"afii57427": 0x0633, "afii57428": 0x0634, "afii57429": 0x0635, "afii57430": 0x0636,
+	"afii57431": 0x0637, "afii57432": 0x0638, "afii57433": 0x0639, "afii57434": 0x063A,
+	"afii57440": 0x0640, "afii57441": 0x0641, "afii57442": 0x0642, "afii57443": 0x0643,
+	"afii57444": 0x0644, "afii57445": 0x0645, "afii57446": 0x0646, "afii57448": 0x0648,
+	"afii57449": 0x0649, "afii57450": 0x064A, "afii57451": 0x064B, "afii57452": 0x064C,
+	"afii57453": 0x064D, "afii57454": 0x064E, "afii57455": 0x064F, "afii57456": 0x0650,
+	"afii57457": 0x0651, "afii57458": 0x0652, "afii57470": 0x0647, "afii57505": 0x06A4,
+	"afii57506": 0x067E, "afii57507": 0x0686, "afii57508": 0x0698, "afii57509": 0x06AF,
+	"afii57511": 0x0679, "afii57512": 0x0688, "afii57513": 0x0691, "afii57514": 0x06BA,
+	"afii57519": 0x06D2, "afii57534": 0x06D5, "afii57636": 0x20AA, "afii57645": 0x05BE,
+	"afii57658": 0x05C3, "afii57664": 0x05D0, "afii57665": 0x05D1, "afii57666": 0x05D2,
+	"afii57667": 0x05D3, "afii57668": 0x05D4, "afii57669": 0x05D5, "afii57670": 0x05D6,
+	"afii57671": 0x05D7, "afii57672": 0x05D8, "afii57673": 0x05D9, "afii57674": 0x05DA,
+	"afii57675": 0x05DB, "afii57676": 0x05DC, "afii57677": 0x05DD, "afii57678": 0x05DE,
+	"afii57679": 0x05DF, "afii57680": 0x05E0, "afii57681": 0x05E1, "afii57682": 0x05E2,
+	"afii57683": 0x05E3, "afii57684": 0x05E4, "afii57685": 0x05E5, "afii57686": 0x05E6,
+	"afii57687": 0x05E7, "afii57688": 0x05E8, "afii57689": 0x05E9, "afii57690": 0x05EA,
+	"afii57694": 0xFB2A, "afii57695": 0xFB2B, "afii57700": 0xFB4B, "afii57705": 0xFB1F,
+	"afii57716": 0x05F0, "afii57717": 0x05F1, "afii57718": 0x05F2, "afii57723": 0xFB35,
+	"afii57793": 0x05B4, "afii57794": 0x05B5, "afii57795": 0x05B6, "afii57796": 0x05BB,
+	"afii57797": 0x05B8, "afii57798": 0x05B7, "afii57799": 0x05B0, "afii57800": 0x05B2,
+	"afii57801": 0x05B1, "afii57802": 0x05B3, "afii57803": 0x05C2, "afii57804": 0x05C1,
+	"afii57806": 0x05B9, "afii57807": 0x05BC, "afii57839": 0x05BD, "afii57841": 0x05BF,
+	"afii57842": 0x05C0, "afii57929": 0x02BC, "afii61248": 0x2105, "afii61289": 0x2113,
+	"afii61352": 0x2116, "afii61573": 0x202C, "afii61574": 0x202D, "afii61575": 0x202E,
+	"afii61664": 0x200C, "afii63167": 0x066D, "afii64937": 0x02BD, "ampersandsmall": 0xF726,
+	"apple": 0xF8FF, "arrowhorizex": 0xF8E7, "arrowvertex": 0xF8E6, "asuperior": 0xF6E9,
+	"braceex": 0xF8F4, "braceleftbt": 0xF8F3, "braceleftmid": 0xF8F2, "bracelefttp": 0xF8F1,
+	"bracerightbt": 0xF8FE, "bracerightmid": 0xF8FD, "bracerighttp": 0xF8FC, "bracketleftbt": 0xF8F0,
+	"bracketleftex": 0xF8EF, "bracketlefttp": 0xF8EE, "bracketrightbt": 0xF8FB,
+	"bracketrightex": 0xF8FA, "bracketrighttp": 0xF8F9, "bsuperior": 0xF6EA, "centinferior": 0xF6DF,
+	"centoldstyle": 0xF7A2, "centsuperior": 0xF6E0, "commaaccent": 0xF6C3, "commainferior": 0xF6E1,
+	"commasuperior": 0xF6E2, "copyrightsans": 0xF8E9, "copyrightserif": 0xF6D9, "cyrBreve": 0xF6D1,
+	"cyrFlex": 0xF6D2, "cyrbreve": 0xF6D4, "cyrflex": 0xF6D5, "dblGrave": 0xF6D3, "dblgrave": 0xF6D6,
+	"dieresisacute": 0xF6D7, "dieresisgrave": 0xF6D8, "dmacron": 0x0111, "dollarinferior": 0xF6E3,
+	"dollaroldstyle": 0xF724, "dollarsuperior": 0xF6E4, "dotlessj": 0xF6BE, "dsuperior": 0xF6EB,
+	"eightinferior": 0x2088, "eightoldstyle": 0xF738, "eightsuperior": 0x2078, "esuperior": 0xF6EC,
+	"exclamdownsmall": 0xF7A1, "exclamsmall": 0xF721, "ff": 0xFB00, "ffi": 0xFB03, "ffl": 0xFB04,
+	"fi": 0xFB01, "fiveinferior": 0x2085, "fiveoldstyle": 0xF735, "fivesuperior": 0x2075,
+	"fl": 0xFB02, "fourinferior": 0x2084, "fouroldstyle": 0xF734, "foursuperior": 0x2074,
+	"hypheninferior": 0xF6E5, "hyphensuperior": 0xF6E6, "increment": 0x2206, "integralex": 0xF8F5,
+	"isuperior": 0xF6ED, "ll": 0xF6C0, "lsuperior": 0xF6EE, "middot": 0x00B7, "msuperior": 0xF6EF,
+	"nbspace": 0x00A0, "nineinferior": 0x2089, "nineoldstyle": 0xF739, "ninesuperior": 0x2079,
+	"nonbreakingspace": 0x00A0, "nsuperior": 0x207F, "onefitted": 0xF6DC, "oneinferior": 0x2081,
+	"oneoldstyle": 0xF731, "osuperior": 0xF6F0, "overscore": 0x00AF, "parenleftbt": 0xF8ED,
+	"parenleftex": 0xF8EC, "parenleftinferior": 0x208D, "parenleftsuperior": 0x207D,
+	"parenlefttp": 0xF8EB, "parenrightbt": 0xF8F8, "parenrightex": 0xF8F7,
+	"parenrightinferior": 0x208E, "parenrightsuperior": 0x207E, "parenrighttp": 0xF8F6,
+	"periodinferior": 0xF6E7, "periodsuperior": 0xF6E8, "questiondownsmall": 0xF7BF,
+	"questionsmall": 0xF73F, "radicalex": 0xF8E5, "registersans": 0xF8E8, "registerserif": 0xF6DA,
+	"rsuperior": 0xF6F1, "rupiah": 0xF6DD, "seveninferior": 0x2087, "sevenoldstyle": 0xF737,
+	"sevensuperior": 0x2077, "sfthyphen": 0x00AD, "sixinferior": 0x2086, "sixoldstyle": 0xF736,
+	"sixsuperior": 0x2076, "ssuperior": 0xF6F2, "threeinferior": 0x2083, "threeoldstyle": 0xF733,
+	"threequartersemdash": 0xF6DE, "trademarksans": 0xF8EA, "trademarkserif": 0xF6DB,
+	"tsuperior": 0xF6F3, "twoinferior": 0x2082, "twooldstyle": 0xF732, "zeroinferior": 0x2080,
+	"zerooldstyle": 0xF730, "zerosuperior": 0x2070,
+}
+
+// aglSequences holds the Adobe Glyph List names that stand for more than
+// one code point: Hebrew letters with a point and Arabic ligatures.
+var aglSequences = map[string][]rune{
+	"dalethhatafpatah": {0x05D3, 0x05B2}, "dalethhatafpatahhebrew": {0x05D3, 0x05B2},
+	"dalethhatafsegol": {0x05D3, 0x05B1}, "dalethhatafsegolhebrew": {0x05D3, 0x05B1},
+	"dalethhiriq": {0x05D3, 0x05B4}, "dalethhiriqhebrew": {0x05D3, 0x05B4},
+	"dalethholam": {0x05D3, 0x05B9}, "dalethholamhebrew": {0x05D3, 0x05B9},
+	"dalethpatah": {0x05D3, 0x05B7}, "dalethpatahhebrew": {0x05D3, 0x05B7},
+	"dalethqamats": {0x05D3, 0x05B8}, "dalethqamatshebrew": {0x05D3, 0x05B8},
+	"dalethqubuts": {0x05D3, 0x05BB}, "dalethqubutshebrew": {0x05D3, 0x05BB},
+	"dalethsegol": {0x05D3, 0x05B6}, "dalethsegolhebrew": {0x05D3, 0x05B6},
+	"dalethsheva": {0x05D3, 0x05B0}, "dalethshevahebrew": {0x05D3, 0x05B0},
+	"dalethtsere": {0x05D3, 0x05B5}, "dalethtserehebrew": {0x05D3, 0x05B5},
+	"finalkafqamats": {0x05DA, 0x05B8}, "finalkafqamatshebrew": {0x05DA, 0x05B8},
+	"finalkafsheva": {0x05DA, 0x05B0}, "finalkafshevahebrew": {0x05DA, 0x05B0},
+	"hamzadammaarabic": {0x0621, 0x064F}, "hamzadammatanarabic": {0x0621, 0x064C},
+	"hamzafathaarabic": {0x0621, 0x064E}, "hamzafathatanarabic": {0x0621, 0x064B},
+	"hamzalowkasraarabic": {0x0621, 0x0650}, "hamzalowkasratanarabic": {0x0621, 0x064D},
+	"hamzasukunarabic": {0x0621, 0x0652}, "lamedholam": {0x05DC, 0x05B9},
+	"lamedholamdagesh": {0x05DC, 0x05B9, 0x05BC}, "lamedholamdageshhebrew": {0x05DC, 0x05B9, 0x05BC},
+	"lamedholamhebrew": {0x05DC, 0x05B9}, "lammeemjeeminitialarabic": {0xFEDF, 0xFEE4, 0xFEA0},
+	"lammeemkhahinitialarabic": {0xFEDF, 0xFEE4, 0xFEA8}, "noonhehinitialarabic": {0xFEE7, 0xFEEC},
+	"qofhatafpatah": {0x05E7, 0x05B2}, "qofhatafpatahhebrew": {0x05E7, 0x05B2},
+	"qofhatafsegol": {0x05E7, 0x05B1}, "qofhatafsegolhebrew": {0x05E7, 0x05B1},
+	"qofhiriq": {0x05E7, 0x05B4}, "qofhiriqhebrew": {0x05E7, 0x05B4}, "qofholam": {0x05E7, 0x05B9},
+	"qofholamhebrew": {0x05E7, 0x05B9}, "qofpatah": {0x05E7, 0x05B7},
+	"qofpatahhebrew": {0x05E7, 0x05B7}, "qofqamats": {0x05E7, 0x05B8},
+	"qofqamatshebrew": {0x05E7, 0x05B8}, "qofqubuts": {0x05E7, 0x05BB},
+	"qofqubutshebrew": {0x05E7, 0x05BB}, "qofsegol": {0x05E7, 0x05B6},
+	"qofsegolhebrew": {0x05E7, 0x05B6}, "qofsheva": {0x05E7, 0x05B0},
+	"qofshevahebrew": {0x05E7, 0x05B0}, "qoftsere": {0x05E7, 0x05B5},
+	"qoftserehebrew": {0x05E7, 0x05B5}, "rehyehaleflamarabic": {0x0631, 0xFEF3, 0xFE8E, 0x0644},
+	"reshhatafpatah": {0x05E8, 0x05B2}, "reshhatafpatahhebrew": {0x05E8, 0x05B2},
+	"reshhatafsegol": {0x05E8, 0x05B1}, "reshhatafsegolhebrew": {0x05E8, 0x05B1},
+	"reshhiriq": {0x05E8, 0x05B4}, "reshhiriqhebrew": {0x05E8, 0x05B4}, "reshholam": {0x05E8, 0x05B9},
+	"reshholamhebrew": {0x05E8, 0x05B9}, "reshpatah": {0x05E8, 0x05B7},
+	"reshpatahhebrew": {0x05E8, 0x05B7}, "reshqamats": {0x05E8, 0x05B8},
+	"reshqamatshebrew": {0x05E8, 0x05B8}, "reshqubuts": {0x05E8, 0x05BB},
+	"reshqubutshebrew": {0x05E8, 0x05BB}, "reshsegol": {0x05E8, 0x05B6},
+	"reshsegolhebrew": {0x05E8, 0x05B6}, "reshsheva": {0x05E8, 0x05B0},
+	"reshshevahebrew": {0x05E8, 0x05B0}, "reshtsere": {0x05E8, 0x05B5},
+	"reshtserehebrew": {0x05E8, 0x05B5}, "shaddafathatanarabic": {0x0651, 0x064B},
+	"tchehmeeminitialarabic": {0xFB7C, 0xFEE4},
+}
+
+// aglfnNames maps code points back to their AGLFN names.
+var aglfnNames = func() map[rune]string {
+	names := make(map[rune]string, len(aglfn))
+	for name, code := range aglfn {
+		names[code] = name
+	}
+	return names
+}()
+
+// GlyphNameToUnicode derives the character sequence a glyph name stands for,
+// following the Adobe Glyph List specification: the part after the first
+// period is a suffix and dropped, underscores separate the components of a
+// ligature, and each component is an AGL name, "uni" with one or more groups
+// of four uppercase hex digits, or "u" with four to six. AGL names are
+// those of AGLFN, aglExtra and aglSequences. It returns nil when a
+// component maps to nothing.
+func GlyphNameToUnicode(name string) []rune {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	if name == "" {
+		return nil
+	}
+	var text []rune
+	for _, component := range strings.Split(name, "_") {
+		runes := aglComponentToUnicode(component)
+		if runes == nil {
+			return nil
+		}
+		text = append(text, runes...)
+	}
+	return text
+}
+
+func aglComponentToUnicode(component string) []rune {
+	if r, ok := aglfn[component]; ok {
+		return []rune{r}
+	}
+	if r, ok := aglExtra[component]; ok {
+		return []rune{r}
+	}
+	if runes, ok := aglSequences[component]; ok {
+		return append([]rune(nil), runes...)
+	}
+	if strings.HasPrefix(component, "uni") && len(component) > 3 && (len(component)-3)%4 == 0 {
+		var runes []rune
+		for i := 3; i < len(component); i += 4 {
+			r, ok := parseAGLHex(component[i : i+4])
+			if !ok || r > 0xFFFF {
+				return nil
+			}
+			runes = append(runes, r)
+		}
+		return runes
+	}
+	if strings.HasPrefix(component, "u") && len(component) >= 5 && len(component) <= 7 {
+		if r, ok := parseAGLHex(component[1:]); ok {
+			return []rune{r}
+		}
+	}
+	return nil
+}
+
+// parseAGLHex parses uppercase hex digits as a Unicode scalar value.
+func parseAGLHex(digits string) (rune, bool) {
+	if strings.ToUpper(digits) != digits {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || v > 0x10FFFF || (v >= 0xD800 && v <= 0xDFFF) {
+		return 0, false
+	}
+	return rune(v), true
+}
+
+// UnicodeToGlyphName returns the production name for a code point: its AGLFN
+// name when it has one, otherwise uniXXXX for the BMP or uXXXXX beyond it.
+// Names from the wider AGL are never produced, as AGLFN recommends.
+func UnicodeToGlyphName(r rune) string {
+	if name, ok := aglfnNames[r]; ok {
+		return name
+	}
+	if r <= 0xFFFF {
+		return fmt.Sprintf("uni%04X", r)
+	}
+	return fmt.Sprintf("u%05X", r)
+}
@@ -0,0 +1,896 @@
+package font
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"sort"
+)
+
+// Bitmap strike flags
+const (
+	BITMAP_FLAG_HORIZONTAL_METRICS int8 = 0x01
+	BITMAP_FLAG_VERTICAL_METRICS   int8 = 0x02
+)
+
+// sbix header flags
+const (
+	SBIX_FLAG_DRAW_OUTLINES uint16 = 0x0002
+)
+
+type SbitLineMetrics struct {
+	Ascender              int8  `json:"ascender"`
+	Descender             int8  `json:"descender"`
+	WidthMax              uint8 `json:"widthMax"`
+	CaretSlopeNumerator   int8  `json:"caretSlopeNumerator"`
+	CaretSlopeDenominator int8  `json:"caretSlopeDenominator"`
+	CaretOffset           int8  `json:"caretOffset"`
+	MinOriginSB           int8  `json:"minOriginSB"`
+	MinAdvanceSB          int8  `json:"minAdvanceSB"`
+	MaxBeforeBL           int8  `json:"maxBeforeBL"`
+	MinAfterBL            int8  `json:"minAfterBL"`
+	Pad1                  int8  `json:"pad1"`
+	Pad2                  int8  `json:"pad2"`
+}
+
+// BigGlyphMetrics holds the metrics of a bitmap glyph. Image formats with
+// small metrics only use Height, Width and the horizontal fields, or the
+// vertical ones when the strike has vertical metrics only.
+type BigGlyphMetrics struct {
+	Height       uint8 `json:"height"`
+	Width        uint8 `json:"width"`
+	HoriBearingX int8  `json:"horiBearingX"`
+	HoriBearingY int8  `json:"horiBearingY"`
+	HoriAdvance  uint8 `json:"horiAdvance"`
+	VertBearingX int8  `json:"vertBearingX"`
+	VertBearingY int8  `json:"vertBearingY"`
+	VertAdvance  uint8 `json:"vertAdvance"`
+}
+
+// EbdtComponent places another glyph of the strike in a composite bitmap,
+// its top left corner offset from the composite's
+type EbdtComponent struct {
+	GlyphID uint16 `json:"glyphId"`
+	XOffset int8   `json:"xOffset"`
+	YOffset int8   `json:"yOffset"`
+}
+
+// BitmapGlyph is one glyph image of a strike. For the PNG formats 17, 18 and
+// 19 Data is the PNG file, for the composite formats 8 and 9 Components
+// lists the parts, otherwise Data is the image data that follows the metrics.
+type BitmapGlyph struct {
+	ImageFormat uint16          `json:"imageFormat"`
+	Metrics     BigGlyphMetrics `json:"metrics"`
+	Data        []byte          `json:"data,omitempty"`
+	Components  []EbdtComponent `json:"components,omitempty"`
+}
+
+type BitmapStrike struct {
+	ColorRef uint32                  `json:"colorRef"`
+	Hori     SbitLineMetrics         `json:"hori"`
+	Vert     SbitLineMetrics         `json:"vert"`
+	PpemX    uint8                   `json:"ppemX"`
+	PpemY    uint8                   `json:"ppemY"`
+	BitDepth uint8                   `json:"bitDepth"`
+	Flags    int8                    `json:"flags"`
+	Glyphs   map[uint16]*BitmapGlyph `json:"glyphs"`
+}
+
+// BitmapTables models a bitmap location table (EBLC or CBLC) together with
+// the bitmap data table (EBDT or CBDT) it indexes. Index subtables are
+// rebuilt on write.
+type BitmapTables struct {
+	MajorVersion uint16          `json:"majorVersion"`
+	MinorVersion uint16          `json:"minorVersion"`
+	Strikes      []*BitmapStrike `json:"strikes"`
+}
+
+var errBitmapTruncated = errors.New("bitmap table truncated")
+
+// smallMetricsFormat reports whether an image format starts with small
+// glyph metrics
+func smallMetricsFormat(format uint16) bool {
+	return format == 1 || format == 2 || format == 8 || format == 17
+}
+
+// bigMetricsFormat reports whether an image format starts with big glyph
+// metrics
+func bigMetricsFormat(format uint16) bool {
+	return format == 6 || format == 7 || format == 9 || format == 18
+}
+
+// indexMetricsFormat reports whether an image format keeps its metrics in
+// the index subtable
+func indexMetricsFormat(format uint16) bool {
+	return format == 5 || format == 19
+}
+
+// pngFormat reports whether an image format holds a PNG file
+func pngFormat(format uint16) bool {
+	return format >= 17 && format <= 19
+}
+
+func getSbitLineMetrics(data []byte) SbitLineMetrics {
+	return SbitLineMetrics{
+		Ascender:              getInt8(data[0:1]),
+		Descender:             getInt8(data[1:2]),
+		WidthMax:              getUint8(data[2:3]),
+		CaretSlopeNumerator:   getInt8(data[3:4]),
+		CaretSlopeDenominator: getInt8(data[4:5]),
+		CaretOffset:           getInt8(data[5:6]),
+		MinOriginSB:           getInt8(data[6:7]),
+		MinAdvanceSB:          getInt8(data[7:8]),
+		MaxBeforeBL:           getInt8(data[8:9]),
+		MinAfterBL:            getInt8(data[9:10]),
+		Pad1:                  getInt8(data[10:11]),
+		Pad2:                  getInt8(data[11:12]),
+	}
+}
+
+func writeSbitLineMetrics(m SbitLineMetrics) []byte {
+	return []byte{
+		byte(m.Ascender), byte(m.Descender), m.WidthMax, byte(m.CaretSlopeNumerator),
+		byte(m.CaretSlopeDenominator), byte(m.CaretOffset), byte(m.MinOriginSB), byte(m.MinAdvanceSB),
+		byte(m.MaxBeforeBL), byte(m.MinAfterBL), byte(m.Pad1), byte(m.Pad2),
+	}
+}
+
+func getBigGlyphMetrics(data []byte) BigGlyphMetrics {
+	return BigGlyphMetrics{
+		Height:       data[0],
+		Width:        data[1],
+		HoriBearingX: int8(data[2]),
+		HoriBearingY: int8(data[3]),
+		HoriAdvance:  data[4],
+		VertBearingX: int8(data[5]),
+		VertBearingY: int8(data[6]),
+		VertAdvance:  data[7],
+	}
+}
+
+func writeBigGlyphMetrics(m BigGlyphMetrics) []byte {
+	return []byte{m.Height, m.Width, byte(m.HoriBearingX), byte(m.HoriBearingY), m.HoriAdvance,
+		byte(m.VertBearingX), byte(m.VertBearingY), m.VertAdvance}
+}
+
+// getSmallGlyphMetrics widens small metrics into the horizontal fields, or
+// the vertical ones for a strike with vertical metrics only
+func getSmallGlyphMetrics(data []byte, flags int8) BigGlyphMetrics {
+	m := BigGlyphMetrics{Height: data[0], Width: data[1]}
+	if flags&(BITMAP_FLAG_HORIZONTAL_METRICS|BITMAP_FLAG_VERTICAL_METRICS) == BITMAP_FLAG_VERTICAL_METRICS {
+		m.VertBearingX, m.VertBearingY, m.VertAdvance = int8(data[2]), int8(data[3]), data[4]
+	} else {
+		m.HoriBearingX, m.HoriBearingY, m.HoriAdvance = int8(data[2]), int8(data[3]), data[4]
+	}
+	return m
+}
+
+func writeSmallGlyphMetrics(m BigGlyphMetrics, flags int8) []byte {
+	if flags&(BITMAP_FLAG_HORIZONTAL_METRICS|BITMAP_FLAG_VERTICAL_METRICS) == BITMAP_FLAG_VERTICAL_METRICS {
+		return []byte{m.Height, m.Width, byte(m.VertBearingX), byte(m.VertBearingY), m.VertAdvance}
+	}
+	return []byte{m.Height, m.Width, byte(m.HoriBearingX), byte(m.HoriBearingY), m.HoriAdvance}
+}
+
+// bitmapLocation is where an index subtable places one glyph image
+type bitmapLocation struct {
+	glyph   uint16
+	offset  int
+	size    int
+	metrics *BigGlyphMetrics
+}
+
+// readIndexSubtable lists the glyph images of the index subtable at pos.
+// Offsets are relative to the start of the bitmap data table.
+func readIndexSubtable(data []byte, pos int, first, last int) (imageFormat uint16, locs []bitmapLocation, err error) {
+	if pos+8 > len(data) || last < first {
+		return 0, nil, errBitmapTruncated
+	}
+	indexFormat := getUint16(data[pos : pos+2])
+	imageFormat = getUint16(data[pos+2 : pos+4])
+	imageDataOffset := int(getUint32(data[pos+4 : pos+8]))
+	num := last - first + 1
+	p := pos + 8
+	switch indexFormat {
+	case 1, 3:
+		entrySize := 4
+		if indexFormat == 3 {
+			entrySize = 2
+		}
+		if p+(num+1)*entrySize > len(data) {
+			return 0, nil, errBitmapTruncated
+		}
+		offsetAt := func(i int) int {
+			if indexFormat == 3 {
+				return int(getUint16(data[p+i*2 : p+i*2+2]))
+			}
+			return int(getUint32(data[p+i*4 : p+i*4+4]))
+		}
+		for i := 0; i < num; i++ {
+			start, end := offsetAt(i), offsetAt(i+1)
+			if end > start {
+				locs = append(locs, bitmapLocation{glyph: uint16(first + i), offset: imageDataOffset + start, size: end - start})
+			}
+		}
+	case 2:
+		if p+12 > len(data) {
+			return 0, nil, errBitmapTruncated
+		}
+		imageSize := int(getUint32(data[p : p+4]))
+		metrics := getBigGlyphMetrics(data[p+4 : p+12])
+		for i := 0; i < num; i++ {
+			locs = append(locs, bitmapLocation{uint16(first + i), imageDataOffset + i*imageSize, imageSize, &metrics})
+		}
+	case 4:
+		if p+4 > len(data) {
+			return 0, nil, errBitmapTruncated
+		}
+		numGlyphs := int(getUint32(data[p : p+4]))
+		p += 4
+		if p+(numGlyphs+1)*4 > len(data) {
+			return 0, nil, errBitmapTruncated
+		}
+		for i := 0; i < numGlyphs; i++ {
+			start := int(getUint16(data[p+i*4+2 : p+i*4+4]))
+			end := int(getUint16(data[p+i*4+6 : p+i*4+8]))
+			if end > start {
+				locs = append(locs, bitmapLocation{glyph: getUint16(data[p+i*4 : p+i*4+2]), offset: imageDataOffset + start, size: end - start})
+			}
+		}
+	case 5:
+		if p+16 > len(data) {
+			return 0, nil, errBitmapTruncated
+		}
+		imageSize := int(getUint32(data[p : p+4]))
+		metrics := getBigGlyphMetrics(data[p+4 : p+12])
+		numGlyphs := int(getUint32(data[p+12 : p+16]))
+		p += 16
+		if p+numGlyphs*2 > len(data) {
+			return 0, nil, errBitmapTruncated
+		}
+		for i := 0; i < numGlyphs; i++ {
+			locs = append(locs, bitmapLocation{getUint16(data[p+i*2 : p+i*2+2]), imageDataOffset + i*imageSize, imageSize, &metrics})
+		}
+	default:
+		return 0, nil, errors.New("unknown bitmap index subtable format")
+	}
+	return imageFormat, locs, nil
+}
+
+// readBitmapGlyph splits one glyph image into its metrics and data
+func readBitmapGlyph(data []byte, loc bitmapLocation, imageFormat uint16, flags int8) (*BitmapGlyph, error) {
+	if loc.offset+loc.size > len(data) {
+		return nil, errBitmapTruncated
+	}
+	image := data[loc.offset : loc.offset+loc.size]
+	glyph := &BitmapGlyph{ImageFormat: imageFormat}
+	switch {
+	case smallMetricsFormat(imageFormat):
+		if len(image) < 5 {
+			return nil, errBitmapTruncated
+		}
+		glyph.Metrics = getSmallGlyphMetrics(image, flags)
+		image = image[5:]
+		if imageFormat == 8 && len(image) > 0 {
+			// pad byte before the components
+			image = image[1:]
+		}
+	case bigMetricsFormat(imageFormat):
+		if len(image) < 8 {
+			return nil, errBitmapTruncated
+		}
+		glyph.Metrics = getBigGlyphMetrics(image)
+		image = image[8:]
+	case indexMetricsFormat(imageFormat):
+		if loc.metrics != nil {
+			glyph.Metrics = *loc.metrics
+		}
+	}
+	if pngFormat(imageFormat) {
+		if len(image) < 4 || int(getUint32(image[0:4])) > len(image)-4 {
+			return nil, errBitmapTruncated
+		}
+		image = image[4 : 4+int(getUint32(image[0:4]))]
+	}
+	if imageFormat == 8 || imageFormat == 9 {
+		if len(image) < 2 || len(image) < 2+int(getUint16(image[0:2]))*4 {
+			return nil, errBitmapTruncated
+		}
+		for i := 0; i < int(getUint16(image[0:2])); i++ {
+			p := 2 + i*4
+			glyph.Components = append(glyph.Components, EbdtComponent{
+				GlyphID: getUint16(image[p : p+2]),
+				XOffset: getInt8(image[p+2 : p+3]),
+				YOffset: getInt8(image[p+3 : p+4]),
+			})
+		}
+		return glyph, nil
+	}
+	glyph.Data = append([]byte{}, image...)
+	return glyph, nil
+}
+
+// writeBitmapGlyph is the inverse of readBitmapGlyph
+func writeBitmapGlyph(glyph *BitmapGlyph, flags int8) []byte {
+	data := []byte{}
+	switch {
+	case smallMetricsFormat(glyph.ImageFormat):
+		data = append(data, writeSmallGlyphMetrics(glyph.Metrics, flags)...)
+		if glyph.ImageFormat == 8 {
+			data = append(data, 0)
+		}
+	case bigMetricsFormat(glyph.ImageFormat):
+		data = append(data, writeBigGlyphMetrics(glyph.Metrics)...)
+	}
+	if pngFormat(glyph.ImageFormat) {
+		data = append(data, writeUint32(uint32(len(glyph.Data)))...)
+	}
+	if glyph.ImageFormat == 8 || glyph.ImageFormat == 9 {
+		data = append(data, writeUint16(uint16(len(glyph.Components)))...)
+		for _, c := range glyph.Components {
+			data = append(data, writeUint16(c.GlyphID)...)
+			data = append(data, byte(c.XOffset), byte(c.YOffset))
+		}
+		return data
+	}
+	return append(data, glyph.Data...)
+}
+
+// getBitmapTables reads the strikes of a bitmap location table at locPos
+// and their images from the bitmap data table at dataPos
+func getBitmapTables(data []byte, locPos, dataPos int) (bt *BitmapTables, err error) {
+	if locPos+8 > len(data) {
+		return nil, errBitmapTruncated
+	}
+	bt = &BitmapTables{
+		MajorVersion: getUint16(data[locPos : locPos+2]),
+		MinorVersion: getUint16(data[locPos+2 : locPos+4]),
+	}
+	numSizes := int(getUint32(data[locPos+4 : locPos+8]))
+	if locPos+8+numSizes*48 > len(data) {
+		return nil, errBitmapTruncated
+	}
+	for i := 0; i < numSizes; i++ {
+		p := locPos + 8 + i*48
+		arrayPos := locPos + int(getUint32(data[p:p+4]))
+		numSubtables := int(getUint32(data[p+8 : p+12]))
+		strike := &BitmapStrike{
+			ColorRef: getUint32(data[p+12 : p+16]),
+			Hori:     getSbitLineMetrics(data[p+16 : p+28]),
+			Vert:     getSbitLineMetrics(data[p+28 : p+40]),
+			PpemX:    getUint8(data[p+44 : p+45]),
+			PpemY:    getUint8(data[p+45 : p+46]),
+			BitDepth: getUint8(data[p+46 : p+47]),
+			Flags:    getInt8(data[p+47 : p+48]),
+			Glyphs:   make(map[uint16]*BitmapGlyph),
+		}
+		if arrayPos+numSubtables*8 > len(data) {
+			return nil, errBitmapTruncated
+		}
+		for j := 0; j < numSubtables; j++ {
+			q := arrayPos + j*8
+			first := int(getUint16(data[q : q+2]))
+			last := int(getUint16(data[q+2 : q+4]))
+			imageFormat, locs, locErr := readIndexSubtable(data, arrayPos+int(getUint32(data[q+4:q+8])), first, last)
+			if locErr != nil {
+				return nil, locErr
+			}
+			for _, loc := range locs {
+				loc.offset += dataPos
+				glyph, glyphErr := readBitmapGlyph(data, loc, imageFormat, strike.Flags)
+				if glyphErr != nil {
+					return nil, glyphErr
+				}
+				strike.Glyphs[loc.glyph] = glyph
+			}
+		}
+		bt.Strikes = append(bt.Strikes, strike)
+	}
+	return bt, nil
+}
+
+// writeBitmapTables lays out the location and data tables. Each run of
+// consecutive glyphs sharing an image format gets an index subtable of
+// format 1, or format 2 when the metrics live in the index and the run
+// shares them and its image size.
+func writeBitmapTables(bt *BitmapTables, dataMajorVersion uint16) (loc []byte, dat []byte) {
+	dat = append(writeUint16(dataMajorVersion), writeUint16(0)...)
+
+	var records, subtables []byte
+	recordsSize := 8 + 48*len(bt.Strikes)
+	for _, strike := range bt.Strikes {
+		glyphs := sortedGlyphKeys(strike.Glyphs)
+		images := make([][]byte, len(glyphs))
+		for i, gid := range glyphs {
+			images[i] = writeBitmapGlyph(strike.Glyphs[gid], strike.Flags)
+		}
+
+		// split the glyphs into runs sharing one index subtable
+		var runs [][2]int
+		for i := range glyphs {
+			if n := len(runs); n > 0 {
+				prev, glyph, prevGlyph := runs[n-1][1], strike.Glyphs[glyphs[i]], strike.Glyphs[glyphs[i-1]]
+				if glyphs[prev]+1 == glyphs[i] && glyph.ImageFormat == prevGlyph.ImageFormat &&
+					(!indexMetricsFormat(glyph.ImageFormat) || (glyph.Metrics == prevGlyph.Metrics && len(images[i]) == len(images[i-1]))) {
+					runs[n-1][1] = i
+					continue
+				}
+			}
+			runs = append(runs, [2]int{i, i})
+		}
+
+		var array, tables []byte
+		for _, run := range runs {
+			glyph := strike.Glyphs[glyphs[run[0]]]
+			array = append(array, writeUint16(glyphs[run[0]])...)
+			array = append(array, writeUint16(glyphs[run[1]])...)
+			array = append(array, writeUint32(uint32(len(runs)*8+len(tables)))...)
+			var indexFormat uint16 = 1
+			if indexMetricsFormat(glyph.ImageFormat) {
+				indexFormat = 2
+			}
+			tables = append(tables, writeUint16(indexFormat)...)
+			tables = append(tables, writeUint16(glyph.ImageFormat)...)
+			tables = append(tables, writeUint32(uint32(len(dat)))...)
+			if indexFormat == 2 {
+				tables = append(tables, writeUint32(uint32(len(images[run[0]])))...)
+				tables = append(tables, writeBigGlyphMetrics(glyph.Metrics)...)
+				for i := run[0]; i <= run[1]; i++ {
+					dat = append(dat, images[i]...)
+				}
+				continue
+			}
+			offset := 0
+			for i := run[0]; i <= run[1]; i++ {
+				tables = append(tables, writeUint32(uint32(offset))...)
+				offset += len(images[i])
+				dat = append(dat, images[i]...)
+			}
+			tables = append(tables, writeUint32(uint32(offset))...)
+		}
+
+		var startGlyph, endGlyph uint16
+		if len(glyphs) > 0 {
+			startGlyph, endGlyph = glyphs[0], glyphs[len(glyphs)-1]
+		}
+		records = append(records, writeUint32(uint32(recordsSize+len(subtables)))...)
+		records = append(records, writeUint32(uint32(len(array)+len(tables)))...)
+		records = append(records, writeUint32(uint32(len(runs)))...)
+		records = append(records, writeUint32(strike.ColorRef)...)
+		records = append(records, writeSbitLineMetrics(strike.Hori)...)
+		records = append(records, writeSbitLineMetrics(strike.Vert)...)
+		records = append(records, writeUint16(startGlyph)...)
+		records = append(records, writeUint16(endGlyph)...)
+		records = append(records, strike.PpemX, strike.PpemY, strike.BitDepth, byte(strike.Flags))
+		subtables = append(subtables, array...)
+		subtables = append(subtables, tables...)
+	}
+
+	loc = append(loc, writeUint16(bt.MajorVersion)...)
+	loc = append(loc, writeUint16(bt.MinorVersion)...)
+	loc = append(loc, writeUint32(uint32(len(bt.Strikes)))...)
+	loc = append(loc, records...)
+	loc = append(loc, subtables...)
+	return loc, dat
+}
+
+// GetCbdt reads the color bitmap strikes from the CBLC table at cblcPos and
+// the CBDT table at cbdtPos
+func GetCbdt(data []byte, cblcPos, cbdtPos int) (*BitmapTables, error) {
+	return getBitmapTables(data, cblcPos, cbdtPos)
+}
+
+func WriteCblc(cbdt *BitmapTables) []byte {
+	loc, _ := writeBitmapTables(cbdt, 3)
+	return loc
+}
+
+func WriteCbdt(cbdt *BitmapTables) []byte {
+	_, dat := writeBitmapTables(cbdt, 3)
+	return dat
+}
+
+// GetEbdt reads the bitmap strikes from the EBLC table at eblcPos and the
+// EBDT table at ebdtPos
+func GetEbdt(data []byte, eblcPos, ebdtPos int) (*BitmapTables, error) {
+	return getBitmapTables(data, eblcPos, ebdtPos)
+}
+
+func WriteEblc(ebdt *BitmapTables) []byte {
+	loc, _ := writeBitmapTables(ebdt, 2)
+	return loc
+}
+
+func WriteEbdt(ebdt *BitmapTables) []byte {
+	_, dat := writeBitmapTables(ebdt, 2)
+	return dat
+}
+
+// ComponentGlyphs returns the glyphs the composite bitmaps of glyph gid are
+// built from, in any strike
+func (bt *BitmapTables) ComponentGlyphs(gid int) []int {
+	var glyphs []int
+	for _, strike := range bt.Strikes {
+		if glyph, ok := strike.Glyphs[uint16(gid)]; ok {
+			for _, c := range glyph.Components {
+				glyphs = append(glyphs, int(c.GlyphID))
+			}
+		}
+	}
+	return glyphs
+}
+
+// remapBitmapStrikes keeps the images of retained glyphs under their new
+// indices, renumbers composite components and drops strikes left empty
+func remapBitmapStrikes(bt *BitmapTables, oldToNew map[int]int) {
+	strikes := bt.Strikes[:0]
+	for _, strike := range bt.Strikes {
+		glyphs := make(map[uint16]*BitmapGlyph)
+		for gid, glyph := range strike.Glyphs {
+			if newGid, ok := oldToNew[int(gid)]; ok {
+				for i, c := range glyph.Components {
+					glyph.Components[i].GlyphID = uint16(oldToNew[int(c.GlyphID)])
+				}
+				glyphs[uint16(newGid)] = glyph
+			}
+		}
+		if len(glyphs) == 0 {
+			continue
+		}
+		strike.Glyphs = glyphs
+		strikes = append(strikes, strike)
+	}
+	bt.Strikes = strikes
+}
+
+// maxBitmapComponentNesting bounds composite bitmap recursion in broken fonts
+const maxBitmapComponentNesting = 16
+
+// bitmapPixel reads the depth bit value starting at bit of data, most
+// significant bit first
+func bitmapPixel(data []byte, bit int, depth int) uint8 {
+	var v int
+	for i := 0; i < depth; i++ {
+		byteIndex := (bit + i) / 8
+		if byteIndex >= len(data) {
+			return 0
+		}
+		v = v<<1 | int(data[byteIndex]>>(7-uint((bit+i)%8))&1)
+	}
+	return uint8(v * 255 / (1<<uint(depth) - 1))
+}
+
+// Image decodes glyph gid of the strike into a coverage mask. The bounds
+// are relative to the glyph origin, y growing down, so the image's top left
+// corner sits at the horizontal bearings.
+func (strike *BitmapStrike) Image(gid int) (*image.Alpha, error) {
+	return strike.image(gid, 0)
+}
+
+func (strike *BitmapStrike) image(gid int, nesting int) (*image.Alpha, error) {
+	if nesting > maxBitmapComponentNesting {
+		return nil, errors.New("bitmap components nested too deeply")
+	}
+	glyph, ok := strike.Glyphs[uint16(gid)]
+	if !ok {
+		return nil, errors.New("glyph has no bitmap in this strike")
+	}
+	m := glyph.Metrics
+	width, height := int(m.Width), int(m.Height)
+	img := image.NewAlpha(image.Rect(0, 0, width, height).Add(image.Pt(int(m.HoriBearingX), -int(m.HoriBearingY))))
+	depth := int(strike.BitDepth)
+	if depth == 0 {
+		depth = 1
+	}
+
+	switch glyph.ImageFormat {
+	case 1, 6:
+		rowBytes := (width*depth + 7) / 8
+		for y := 0; y < height; y++ {
+			row := glyph.Data
+			if y*rowBytes > len(row) {
+				break
+			}
+			row = row[y*rowBytes:]
+			for x := 0; x < width; x++ {
+				img.Pix[y*img.Stride+x] = bitmapPixel(row, x*depth, depth)
+			}
+		}
+	case 2, 5, 7:
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				img.Pix[y*img.Stride+x] = bitmapPixel(glyph.Data, (y*width+x)*depth, depth)
+			}
+		}
+	case 8, 9:
+		for _, c := range glyph.Components {
+			part, err := strike.image(int(c.GlyphID), nesting+1)
+			if err != nil {
+				return nil, err
+			}
+			left, top := img.Rect.Min.X+int(c.XOffset), img.Rect.Min.Y+int(c.YOffset)
+			for y := 0; y < part.Rect.Dy(); y++ {
+				for x := 0; x < part.Rect.Dx(); x++ {
+					v := part.Pix[y*part.Stride+x]
+					p := image.Pt(left+x, top+y)
+					if p.In(img.Rect) && v > img.AlphaAt(p.X, p.Y).A {
+						img.SetAlpha(p.X, p.Y, color.Alpha{A: v})
+					}
+				}
+			}
+		}
+	default:
+		return nil, errors.New("bitmap image format is not a coverage mask")
+	}
+	return img, nil
+}
+
+// GlyphBitmap returns the EBDT bitmap of glyph gid for the strike of the
+// given ppem, see BitmapStrike.Image.
+func (f *Font) GlyphBitmap(gid int, ppem int) (*image.Alpha, error) {
+	if f.fontInfo == nil {
+		return nil, errors.New("fontInfo is nil, call GetFontInfo first")
+	}
+	if f.fontInfo.Tables.Ebdt != nil {
+		for _, strike := range f.fontInfo.Tables.Ebdt.Strikes {
+			if int(strike.PpemY) == ppem {
+				if _, ok := strike.Glyphs[uint16(gid)]; ok {
+					return strike.Image(gid)
+				}
+			}
+		}
+	}
+	return nil, errors.New("no bitmap strike for this glyph and ppem")
+}
+
+// BitmapScale asks for the strike of the substitute ppem to be scaled when
+// rendering at the given ppem
+type BitmapScale struct {
+	Hori            SbitLineMetrics `json:"hori"`
+	Vert            SbitLineMetrics `json:"vert"`
+	PpemX           uint8           `json:"ppemX"`
+	PpemY           uint8           `json:"ppemY"`
+	SubstitutePpemX uint8           `json:"substitutePpemX"`
+	SubstitutePpemY uint8           `json:"substitutePpemY"`
+}
+
+type Ebsc struct {
+	MajorVersion uint16        `json:"majorVersion"`
+	MinorVersion uint16        `json:"minorVersion"`
+	Scales       []BitmapScale `json:"scales"`
+}
+
+func GetEbsc(data []byte, pos int) (ebsc *Ebsc, err error) {
+	if pos+8 > len(data) {
+		return nil, errors.New("EBSC table truncated")
+	}
+	ebsc = &Ebsc{
+		MajorVersion: getUint16(data[pos : pos+2]),
+		MinorVersion: getUint16(data[pos+2 : pos+4]),
+	}
+	numSizes := int(getUint32(data[pos+4 : pos+8]))
+	pos += 8
+	if pos+numSizes*28 > len(data) {
+		return nil, errors.New("EBSC table truncated")
+	}
+	for i := 0; i < numSizes; i++ {
+		ebsc.Scales = append(ebsc.Scales, BitmapScale{
+			Hori:            getSbitLineMetrics(data[pos : pos+12]),
+			Vert:            getSbitLineMetrics(data[pos+12 : pos+24]),
+			PpemX:           data[pos+24],
+			PpemY:           data[pos+25],
+			SubstitutePpemX: data[pos+26],
+			SubstitutePpemY: data[pos+27],
+		})
+		pos += 28
+	}
+	return ebsc, nil
+}
+
+func WriteEbsc(ebsc *Ebsc) []byte {
+	data := []byte{}
+	data = append(data, writeUint16(ebsc.MajorVersion)...)
+	data = append(data, writeUint16(ebsc.MinorVersion)...)
+	data = append(data, writeUint32(uint32(len(ebsc.Scales)))...)
+	for _, scale := range ebsc.Scales {
+		data = append(data, writeSbitLineMetrics(scale.Hori)...)
+		data = append(data, writeSbitLineMetrics(scale.Vert)...)
+		data = append(data, scale.PpemX, scale.PpemY, scale.SubstitutePpemX, scale.SubstitutePpemY)
+	}
+	return data
+}
+
+// SbixGlyph is one image of an sbix strike. GraphicType is a tag such as
+// "png ", "jpg " or "tiff"; a "dupe" glyph's Data is the big-endian index of
+// the glyph whose image it reuses.
+type SbixGlyph struct {
+	OriginOffsetX int16  `json:"originOffsetX"`
+	OriginOffsetY int16  `json:"originOffsetY"`
+	GraphicType   string `json:"graphicType"`
+	Data          []byte `json:"data"`
+}
+
+type SbixStrike struct {
+	Ppem   uint16                `json:"ppem"`
+	Ppi    uint16                `json:"ppi"`
+	Glyphs map[uint16]*SbixGlyph `json:"glyphs"`
+}
+
+type Sbix struct {
+	Version uint16        `json:"version"`
+	Flags   uint16        `json:"flags"`
+	Strikes []*SbixStrike `json:"strikes"`
+}
+
+func GetSbix(data []byte, pos int, numGlyphs int) (sbix *Sbix, err error) {
+	start := pos
+	if pos+8 > len(data) {
+		return nil, errors.New("sbix table truncated")
+	}
+	sbix = &Sbix{
+		Version: getUint16(data[pos : pos+2]),
+		Flags:   getUint16(data[pos+2 : pos+4]),
+	}
+	numStrikes := int(getUint32(data[pos+4 : pos+8]))
+	if pos+8+numStrikes*4 > len(data) {
+		return nil, errors.New("sbix table truncated")
+	}
+	for i := 0; i < numStrikes; i++ {
+		strikePos := start + int(getUint32(data[pos+8+i*4:pos+12+i*4]))
+		if strikePos+4+(numGlyphs+1)*4 > len(data) {
+			return nil, errors.New("sbix strike truncated")
+		}
+		strike := &SbixStrike{
+			Ppem:   getUint16(data[strikePos : strikePos+2]),
+			Ppi:    getUint16(data[strikePos+2 : strikePos+4]),
+			Glyphs: make(map[uint16]*SbixGlyph),
+		}
+		for gid := 0; gid < numGlyphs; gid++ {
+			p := strikePos + 4 + gid*4
+			glyphStart := strikePos + int(getUint32(data[p:p+4]))
+			glyphEnd := strikePos + int(getUint32(data[p+4:p+8]))
+			if glyphEnd <= glyphStart {
+				continue
+			}
+			if glyphEnd > len(data) || glyphEnd-glyphStart < 8 {
+				return nil, errors.New("sbix glyph data out of range")
+			}
+			strike.Glyphs[uint16(gid)] = &SbixGlyph{
+				OriginOffsetX: getInt16(data[glyphStart : glyphStart+2]),
+				OriginOffsetY: getInt16(data[glyphStart+2 : glyphStart+4]),
+				GraphicType:   getString(data[glyphStart+4 : glyphStart+8]),
+				Data:          append([]byte{}, data[glyphStart+8:glyphEnd]...),
+			}
+		}
+		sbix.Strikes = append(sbix.Strikes, strike)
+	}
+	return sbix, nil
+}
+
+func WriteSbix(sbix *Sbix, numGlyphs int) []byte {
+	data := []byte{}
+	data = append(data, writeUint16(sbix.Version)...)
+	data = append(data, writeUint16(sbix.Flags)...)
+	data = append(data, writeUint32(uint32(len(sbix.Strikes)))...)
+	var strikes []byte
+	offset := 8 + 4*len(sbix.Strikes)
+	for _, strike := range sbix.Strikes {
+		data = append(data, writeUint32(uint32(offset+len(strikes)))...)
+		strikeData := append(writeUint16(strike.Ppem), writeUint16(strike.Ppi)...)
+		var glyphData []byte
+		glyphStart := 4 + (numGlyphs+1)*4
+		for gid := 0; gid < numGlyphs; gid++ {
+			strikeData = append(strikeData, writeUint32(uint32(glyphStart+len(glyphData)))...)
+			glyph, ok := strike.Glyphs[uint16(gid)]
+			if !ok {
+				continue
+			}
+			glyphData = append(glyphData, writeInt16(glyph.OriginOffsetX)...)
+			glyphData = append(glyphData, writeInt16(glyph.OriginOffsetY)...)
+			tag := []byte((glyph.GraphicType + "    ")[:4])
+			glyphData = append(glyphData, tag...)
+			glyphData = append(glyphData, glyph.Data...)
+		}
+		strikeData = append(strikeData, writeUint32(uint32(glyphStart+len(glyphData)))...)
+		strikes = append(strikes, strikeData...)
+		strikes = append(strikes, glyphData...)
+	}
+	return append(data, strikes...)
+}
+
+// dupeTarget returns the glyph a "dupe" image points at
+func (glyph *SbixGlyph) dupeTarget() (int, bool) {
+	if glyph.GraphicType != "dupe" || len(glyph.Data) < 2 {
+		return 0, false
+	}
+	return int(getUint16(glyph.Data[0:2])), true
+}
+
+// DupeGlyphs returns the glyphs whose images glyph gid reuses in any strike
+func (sbix *Sbix) DupeGlyphs(gid int) []int {
+	var glyphs []int
+	for _, strike := range sbix.Strikes {
+		if glyph, ok := strike.Glyphs[uint16(gid)]; ok {
+			if target, ok := glyph.dupeTarget(); ok {
+				glyphs = append(glyphs, target)
+			}
+		}
+	}
+	return glyphs
+}
+
+// remapSbix keeps the images of retained glyphs under their new indices and
+// renumbers dupe references
+func remapSbix(sbix *Sbix, oldToNew map[int]int) {
+	for _, strike := range sbix.Strikes {
+		glyphs := make(map[uint16]*SbixGlyph)
+		for gid, glyph := range strike.Glyphs {
+			newGid, ok := oldToNew[int(gid)]
+			if !ok {
+				continue
+			}
+			if target, ok := glyph.dupeTarget(); ok {
+				newTarget, kept := oldToNew[target]
+				if !kept {
+					continue
+				}
+				glyph.Data = writeUint16(uint16(newTarget))
+			}
+			glyphs[uint16(newGid)] = glyph
+		}
+		strike.Glyphs = glyphs
+	}
+}
+
+// pngStrike is a strike size with the PNG image it holds for one glyph
+type pngStrike struct {
+	ppem int
+	data []byte
+}
+
+// GlyphPNG returns the PNG image of glyph gid from the CBDT or sbix strike
+// whose size is nearest to ppem, preferring the larger strike on a tie, and
+// the ppem of that strike.
+func (f *Font) GlyphPNG(gid int, ppem int) (png []byte, strikePpem int, err error) {
+	if f.fontInfo == nil {
+		return nil, 0, errors.New("fontInfo is nil, call GetFontInfo first")
+	}
+	tables := f.fontInfo.Tables
+	var candidates []pngStrike
+	if tables.Cbdt != nil {
+		for _, strike := range tables.Cbdt.Strikes {
+			if glyph, ok := strike.Glyphs[uint16(gid)]; ok && pngFormat(glyph.ImageFormat) {
+				candidates = append(candidates, pngStrike{int(strike.PpemY), glyph.Data})
+			}
+		}
+	}
+	if tables.Sbix != nil {
+		for _, strike := range tables.Sbix.Strikes {
+			glyph, ok := strike.Glyphs[uint16(gid)]
+			if ok {
+				if target, isDupe := glyph.dupeTarget(); isDupe {
+					glyph, ok = strike.Glyphs[uint16(target)]
+				}
+			}
+			if ok && glyph.GraphicType == "png " {
+				candidates = append(candidates, pngStrike{int(strike.Ppem), glyph.Data})
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, 0, errors.New("glyph has no PNG image")
+	}
+	distance := func(c pngStrike) int {
+		if c.ppem > ppem {
+			return c.ppem - ppem
+		}
+		return ppem - c.ppem
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if di, dj := distance(candidates[i]), distance(candidates[j]); di != dj {
+			return di < dj
+		}
+		return candidates[i].ppem > candidates[j].ppem
+	})
+	return candidates[0].data, candidates[0].ppem, nil
+}
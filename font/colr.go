@@ -0,0 +1,1194 @@
+package font
+
+import (
+	"errors"
+	"image/color"
+	"strconv"
+)
+
+// COLR version 1 paint formats
+const (
+	PAINT_COLR_LAYERS                     uint8 = 1
+	PAINT_SOLID                           uint8 = 2
+	PAINT_VAR_SOLID                       uint8 = 3
+	PAINT_LINEAR_GRADIENT                 uint8 = 4
+	PAINT_VAR_LINEAR_GRADIENT             uint8 = 5
+	PAINT_RADIAL_GRADIENT                 uint8 = 6
+	PAINT_VAR_RADIAL_GRADIENT             uint8 = 7
+	PAINT_SWEEP_GRADIENT                  uint8 = 8
+	PAINT_VAR_SWEEP_GRADIENT              uint8 = 9
+	PAINT_GLYPH                           uint8 = 10
+	PAINT_COLR_GLYPH                      uint8 = 11
+	PAINT_TRANSFORM                       uint8 = 12
+	PAINT_VAR_TRANSFORM                   uint8 = 13
+	PAINT_TRANSLATE                       uint8 = 14
+	PAINT_VAR_TRANSLATE                   uint8 = 15
+	PAINT_SCALE                           uint8 = 16
+	PAINT_VAR_SCALE                       uint8 = 17
+	PAINT_SCALE_AROUND_CENTER             uint8 = 18
+	PAINT_VAR_SCALE_AROUND_CENTER         uint8 = 19
+	PAINT_SCALE_UNIFORM                   uint8 = 20
+	PAINT_VAR_SCALE_UNIFORM               uint8 = 21
+	PAINT_SCALE_UNIFORM_AROUND_CENTER     uint8 = 22
+	PAINT_VAR_SCALE_UNIFORM_AROUND_CENTER uint8 = 23
+	PAINT_ROTATE                          uint8 = 24
+	PAINT_VAR_ROTATE                      uint8 = 25
+	PAINT_ROTATE_AROUND_CENTER            uint8 = 26
+	PAINT_VAR_ROTATE_AROUND_CENTER        uint8 = 27
+	PAINT_SKEW                            uint8 = 28
+	PAINT_VAR_SKEW                        uint8 = 29
+	PAINT_SKEW_AROUND_CENTER              uint8 = 30
+	PAINT_VAR_SKEW_AROUND_CENTER          uint8 = 31
+	PAINT_COMPOSITE                       uint8 = 32
+)
+
+// Color line extend modes
+const (
+	EXTEND_PAD     uint8 = 0
+	EXTEND_REPEAT  uint8 = 1
+	EXTEND_REFLECT uint8 = 2
+)
+
+// CPAL palette types
+const (
+	CPAL_PALETTE_LIGHT_BACKGROUND uint32 = 0x0001
+	CPAL_PALETTE_DARK_BACKGROUND  uint32 = 0x0002
+)
+
+// maxPaintDepth bounds paint graph recursion in broken fonts
+const maxPaintDepth = 64
+
+type LayerRecord struct {
+	GlyphID      uint16 `json:"glyphId"`
+	PaletteIndex uint16 `json:"paletteIndex"`
+}
+
+type ColorStop struct {
+	StopOffset   float32 `json:"stopOffset"`
+	PaletteIndex uint16  `json:"paletteIndex"`
+	Alpha        float32 `json:"alpha"`
+	VarIndexBase uint32  `json:"varIndexBase,omitempty"`
+}
+
+type ColorLine struct {
+	Extend uint8       `json:"extend"`
+	Stops  []ColorStop `json:"stops"`
+}
+
+// Affine2x3 maps (x, y) to (XX*x + XY*y + Dx, YX*x + YY*y + Dy)
+type Affine2x3 struct {
+	XX           float64 `json:"xx"`
+	YX           float64 `json:"yx"`
+	XY           float64 `json:"xy"`
+	YY           float64 `json:"yy"`
+	Dx           float64 `json:"dx"`
+	Dy           float64 `json:"dy"`
+	VarIndexBase uint32  `json:"varIndexBase,omitempty"`
+}
+
+// Paint is one node of a COLR version 1 paint graph. Format selects which
+// fields are used; the variable formats also use VarIndexBase. Angles are
+// kept as stored, in half turns (multiply by 180 for degrees). A paint
+// referenced from several places is read as one shared *Paint.
+type Paint struct {
+	Format uint8 `json:"format"`
+	// PAINT_COLR_LAYERS draws NumLayers paints of the layer list
+	NumLayers       uint8  `json:"numLayers,omitempty"`
+	FirstLayerIndex uint32 `json:"firstLayerIndex,omitempty"`
+	// PAINT_SOLID
+	PaletteIndex uint16  `json:"paletteIndex,omitempty"`
+	Alpha        float32 `json:"alpha,omitempty"`
+	// Gradients. Linear gradients use all three points, radial gradients
+	// the first two with their radii.
+	ColorLine  *ColorLine `json:"colorLine,omitempty"`
+	X0         int16      `json:"x0,omitempty"`
+	Y0         int16      `json:"y0,omitempty"`
+	X1         int16      `json:"x1,omitempty"`
+	Y1         int16      `json:"y1,omitempty"`
+	X2         int16      `json:"x2,omitempty"`
+	Y2         int16      `json:"y2,omitempty"`
+	Radius0    uint16     `json:"radius0,omitempty"`
+	Radius1    uint16     `json:"radius1,omitempty"`
+	StartAngle float32    `json:"startAngle,omitempty"`
+	EndAngle   float32    `json:"endAngle,omitempty"`
+	// PAINT_GLYPH clips Paint to the glyph outline; PAINT_COLR_GLYPH draws
+	// another color glyph
+	GlyphID uint16 `json:"glyphId,omitempty"`
+	// Paint is the child of PAINT_GLYPH and the transforms, and the source
+	// of PAINT_COMPOSITE
+	Paint     *Paint     `json:"paint,omitempty"`
+	Transform *Affine2x3 `json:"transform,omitempty"`
+	Dx        int16      `json:"dx,omitempty"`
+	Dy        int16      `json:"dy,omitempty"`
+	// ScaleX is also the uniform scale
+	ScaleX float32 `json:"scaleX,omitempty"`
+	ScaleY float32 `json:"scaleY,omitempty"`
+	Angle  float32 `json:"angle,omitempty"`
+	SkewX  float32 `json:"skewX,omitempty"`
+	SkewY  float32 `json:"skewY,omitempty"`
+	// CenterX and CenterY are the sweep gradient center and the center of
+	// the *_AROUND_CENTER transforms
+	CenterX       int16  `json:"centerX,omitempty"`
+	CenterY       int16  `json:"centerY,omitempty"`
+	CompositeMode uint8  `json:"compositeMode,omitempty"`
+	Backdrop      *Paint `json:"backdrop,omitempty"`
+	VarIndexBase  uint32 `json:"varIndexBase,omitempty"`
+}
+
+// isVarPaint reports whether a paint format carries a VarIndexBase
+func isVarPaint(format uint8) bool {
+	return format >= PAINT_VAR_SOLID && format <= PAINT_VAR_SKEW_AROUND_CENTER && format%2 == 1 && format != PAINT_COLR_GLYPH
+}
+
+type ClipBox struct {
+	Format       uint8  `json:"format"`
+	XMin         int16  `json:"xMin"`
+	YMin         int16  `json:"yMin"`
+	XMax         int16  `json:"xMax"`
+	YMax         int16  `json:"yMax"`
+	VarIndexBase uint32 `json:"varIndexBase,omitempty"`
+}
+
+// Colr is the color table. Version 0 glyphs are stacks of layers keyed by
+// base glyph; version 1 glyphs are paint graphs. The variation index map and
+// item variation store are kept as raw bytes.
+type Colr struct {
+	Version            uint16                   `json:"version"`
+	BaseGlyphLayers    map[uint16][]LayerRecord `json:"baseGlyphLayers,omitempty"`
+	BaseGlyphPaints    map[uint16]*Paint        `json:"baseGlyphPaints,omitempty"`
+	LayerList          []*Paint                 `json:"layerList,omitempty"`
+	Clips              map[uint16]*ClipBox      `json:"clips,omitempty"`
+	VarIndexMap        []byte                   `json:"varIndexMap,omitempty"`
+	ItemVariationStore []byte                   `json:"itemVariationStore,omitempty"`
+}
+
+var errColrTruncated = errors.New("COLR table truncated")
+
+func GetColr(data []byte, pos int) (colr *Colr, err error) {
+	start := pos
+	colr = &Colr{
+		BaseGlyphLayers: make(map[uint16][]LayerRecord),
+		BaseGlyphPaints: make(map[uint16]*Paint),
+		Clips:           make(map[uint16]*ClipBox),
+	}
+	if pos+14 > len(data) {
+		err = errColrTruncated
+		return
+	}
+	colr.Version = getUint16(data[pos : pos+2])
+	if colr.Version > 1 {
+		err = errors.New("unsupported COLR table version")
+		return
+	}
+	numBaseGlyphRecords := int(getUint16(data[pos+2 : pos+4]))
+	baseGlyphRecordsOffset := int(getUint32(data[pos+4 : pos+8]))
+	layerRecordsOffset := int(getUint32(data[pos+8 : pos+12]))
+	numLayerRecords := int(getUint16(data[pos+12 : pos+14]))
+
+	if numBaseGlyphRecords > 0 {
+		recPos := start + baseGlyphRecordsOffset
+		layerPos := start + layerRecordsOffset
+		if recPos+numBaseGlyphRecords*6 > len(data) || layerPos+numLayerRecords*4 > len(data) {
+			err = errColrTruncated
+			return
+		}
+		for i := 0; i < numBaseGlyphRecords; i++ {
+			gid := getUint16(data[recPos : recPos+2])
+			first := int(getUint16(data[recPos+2 : recPos+4]))
+			num := int(getUint16(data[recPos+4 : recPos+6]))
+			recPos += 6
+			if first+num > numLayerRecords {
+				err = errColrTruncated
+				return
+			}
+			layers := make([]LayerRecord, num)
+			for j := range layers {
+				p := layerPos + (first+j)*4
+				layers[j] = LayerRecord{getUint16(data[p : p+2]), getUint16(data[p+2 : p+4])}
+			}
+			colr.BaseGlyphLayers[gid] = layers
+		}
+	}
+	if colr.Version == 0 {
+		return
+	}
+
+	if pos+34 > len(data) {
+		err = errColrTruncated
+		return
+	}
+	baseGlyphListOffset := int(getUint32(data[pos+14 : pos+18]))
+	layerListOffset := int(getUint32(data[pos+18 : pos+22]))
+	clipListOffset := int(getUint32(data[pos+22 : pos+26]))
+	varIndexMapOffset := int(getUint32(data[pos+26 : pos+30]))
+	itemVariationStoreOffset := int(getUint32(data[pos+30 : pos+34]))
+
+	// paints read so far by position, so that paints shared in the paint
+	// graph are read once
+	paints := make(map[int]*Paint)
+	if baseGlyphListOffset != 0 {
+		listPos := start + baseGlyphListOffset
+		if listPos+4 > len(data) {
+			err = errColrTruncated
+			return
+		}
+		num := int(getUint32(data[listPos : listPos+4]))
+		if listPos+4+num*6 > len(data) {
+			err = errColrTruncated
+			return
+		}
+		for i := 0; i < num; i++ {
+			recPos := listPos + 4 + i*6
+			gid := getUint16(data[recPos : recPos+2])
+			var paint *Paint
+			if paint, err = readPaint(data, listPos+int(getUint32(data[recPos+2:recPos+6])), 0, paints); err != nil {
+				return
+			}
+			colr.BaseGlyphPaints[gid] = paint
+		}
+	}
+
+	if layerListOffset != 0 {
+		listPos := start + layerListOffset
+		if listPos+4 > len(data) {
+			err = errColrTruncated
+			return
+		}
+		num := int(getUint32(data[listPos : listPos+4]))
+		if listPos+4+num*4 > len(data) {
+			err = errColrTruncated
+			return
+		}
+		for i := 0; i < num; i++ {
+			offPos := listPos + 4 + i*4
+			var paint *Paint
+			if paint, err = readPaint(data, listPos+int(getUint32(data[offPos:offPos+4])), 0, paints); err != nil {
+				return
+			}
+			colr.LayerList = append(colr.LayerList, paint)
+		}
+	}
+
+	if clipListOffset != 0 {
+		if err = readClipList(colr, data, start+clipListOffset); err != nil {
+			return
+		}
+	}
+
+	if varIndexMapOffset != 0 {
+		mapPos := start + varIndexMapOffset
+		var length int
+		if length, err = deltaSetIndexMapLength(data, mapPos); err != nil {
+			return
+		}
+		colr.VarIndexMap = append([]byte{}, data[mapPos:mapPos+length]...)
+	}
+
+	if itemVariationStoreOffset != 0 {
+		storePos := start + itemVariationStoreOffset
+		var length int
+		if length, err = itemVariationStoreLength(data, storePos); err != nil {
+			return
+		}
+		colr.ItemVariationStore = append([]byte{}, data[storePos:storePos+length]...)
+	}
+	return
+}
+
+func readClipList(colr *Colr, data []byte, pos int) error {
+	if pos+5 > len(data) {
+		return errColrTruncated
+	}
+	num := int(getUint32(data[pos+1 : pos+5]))
+	if pos+5+num*7 > len(data) {
+		return errColrTruncated
+	}
+	for i := 0; i < num; i++ {
+		clipPos := pos + 5 + i*7
+		startGlyph := int(getUint16(data[clipPos : clipPos+2]))
+		endGlyph := int(getUint16(data[clipPos+2 : clipPos+4]))
+		boxOffset, _ := getUint24(data[clipPos+4 : clipPos+7])
+		boxPos := pos + boxOffset
+		if boxPos+9 > len(data) {
+			return errColrTruncated
+		}
+		box := &ClipBox{
+			Format: getUint8(data[boxPos : boxPos+1]),
+			XMin:   getFWord(data[boxPos+1 : boxPos+3]),
+			YMin:   getFWord(data[boxPos+3 : boxPos+5]),
+			XMax:   getFWord(data[boxPos+5 : boxPos+7]),
+			YMax:   getFWord(data[boxPos+7 : boxPos+9]),
+		}
+		if box.Format == 2 {
+			if boxPos+13 > len(data) {
+				return errColrTruncated
+			}
+			box.VarIndexBase = getUint32(data[boxPos+9 : boxPos+13])
+		}
+		for gid := startGlyph; gid <= endGlyph; gid++ {
+			colr.Clips[uint16(gid)] = box
+		}
+	}
+	return nil
+}
+
+// paintSizes is the fixed size of each paint format, offsets included
+var paintSizes = map[uint8]int{
+	PAINT_COLR_LAYERS: 6, PAINT_SOLID: 5, PAINT_VAR_SOLID: 9,
+	PAINT_LINEAR_GRADIENT: 16, PAINT_VAR_LINEAR_GRADIENT: 20,
+	PAINT_RADIAL_GRADIENT: 16, PAINT_VAR_RADIAL_GRADIENT: 20,
+	PAINT_SWEEP_GRADIENT: 12, PAINT_VAR_SWEEP_GRADIENT: 16,
+	PAINT_GLYPH: 6, PAINT_COLR_GLYPH: 3,
+	PAINT_TRANSFORM: 7, PAINT_VAR_TRANSFORM: 7,
+	PAINT_TRANSLATE: 8, PAINT_VAR_TRANSLATE: 12,
+	PAINT_SCALE: 8, PAINT_VAR_SCALE: 12,
+	PAINT_SCALE_AROUND_CENTER: 12, PAINT_VAR_SCALE_AROUND_CENTER: 16,
+	PAINT_SCALE_UNIFORM: 6, PAINT_VAR_SCALE_UNIFORM: 10,
+	PAINT_SCALE_UNIFORM_AROUND_CENTER: 10, PAINT_VAR_SCALE_UNIFORM_AROUND_CENTER: 14,
+	PAINT_ROTATE: 6, PAINT_VAR_ROTATE: 10,
+	PAINT_ROTATE_AROUND_CENTER: 10, PAINT_VAR_ROTATE_AROUND_CENTER: 14,
+	PAINT_SKEW: 8, PAINT_VAR_SKEW: 12,
+	PAINT_SKEW_AROUND_CENTER: 12, PAINT_VAR_SKEW_AROUND_CENTER: 16,
+	PAINT_COMPOSITE: 8,
+}
+
+func readPaint(data []byte, pos int, depth int, paints map[int]*Paint) (paint *Paint, err error) {
+	if paint, ok := paints[pos]; ok {
+		return paint, nil
+	}
+	if depth > maxPaintDepth {
+		return nil, errors.New("COLR paint graph nested too deeply")
+	}
+	if pos+1 > len(data) {
+		return nil, errColrTruncated
+	}
+	paint = &Paint{Format: getUint8(data[pos : pos+1])}
+	size, ok := paintSizes[paint.Format]
+	if !ok {
+		return nil, errors.New("unknown COLR paint format")
+	}
+	if pos+size > len(data) {
+		return nil, errColrTruncated
+	}
+	if isVarPaint(paint.Format) && paint.Format != PAINT_VAR_TRANSFORM {
+		paint.VarIndexBase = getUint32(data[pos+size-4 : pos+size])
+	}
+	// child reads the paint at an Offset24 relative to this paint
+	child := func(at int) (*Paint, error) {
+		offset, _ := getUint24(data[at : at+3])
+		if offset == 0 {
+			return nil, nil
+		}
+		return readPaint(data, pos+offset, depth+1, paints)
+	}
+	p := pos + 1
+
+	switch paint.Format {
+	case PAINT_COLR_LAYERS:
+		paint.NumLayers = getUint8(data[p : p+1])
+		paint.FirstLayerIndex = getUint32(data[p+1 : p+5])
+	case PAINT_SOLID, PAINT_VAR_SOLID:
+		paint.PaletteIndex = getUint16(data[p : p+2])
+		paint.Alpha = get2Dot14(data[p+2 : p+4])
+	case PAINT_LINEAR_GRADIENT, PAINT_VAR_LINEAR_GRADIENT, PAINT_RADIAL_GRADIENT, PAINT_VAR_RADIAL_GRADIENT,
+		PAINT_SWEEP_GRADIENT, PAINT_VAR_SWEEP_GRADIENT:
+		offset, _ := getUint24(data[p : p+3])
+		if paint.ColorLine, err = readColorLine(data, pos+offset, isVarPaint(paint.Format)); err != nil {
+			return nil, err
+		}
+		p += 3
+		switch paint.Format {
+		case PAINT_LINEAR_GRADIENT, PAINT_VAR_LINEAR_GRADIENT:
+			paint.X0, paint.Y0 = getFWord(data[p:p+2]), getFWord(data[p+2:p+4])
+			paint.X1, paint.Y1 = getFWord(data[p+4:p+6]), getFWord(data[p+6:p+8])
+			paint.X2, paint.Y2 = getFWord(data[p+8:p+10]), getFWord(data[p+10:p+12])
+		case PAINT_RADIAL_GRADIENT, PAINT_VAR_RADIAL_GRADIENT:
+			paint.X0, paint.Y0, paint.Radius0 = getFWord(data[p:p+2]), getFWord(data[p+2:p+4]), getUFWord(data[p+4:p+6])
+			paint.X1, paint.Y1, paint.Radius1 = getFWord(data[p+6:p+8]), getFWord(data[p+8:p+10]), getUFWord(data[p+10:p+12])
+		default:
+			paint.CenterX, paint.CenterY = getFWord(data[p:p+2]), getFWord(data[p+2:p+4])
+			paint.StartAngle, paint.EndAngle = get2Dot14(data[p+4:p+6]), get2Dot14(data[p+6:p+8])
+		}
+	case PAINT_GLYPH:
+		if paint.Paint, err = child(p); err != nil {
+			return nil, err
+		}
+		paint.GlyphID = getUint16(data[p+3 : p+5])
+	case PAINT_COLR_GLYPH:
+		paint.GlyphID = getUint16(data[p : p+2])
+	case PAINT_COMPOSITE:
+		if paint.Paint, err = child(p); err != nil {
+			return nil, err
+		}
+		paint.CompositeMode = getUint8(data[p+3 : p+4])
+		if paint.Backdrop, err = child(p + 4); err != nil {
+			return nil, err
+		}
+	default:
+		// The transforms: a child paint followed by the transform values
+		if paint.Paint, err = child(p); err != nil {
+			return nil, err
+		}
+		p += 3
+		switch paint.Format {
+		case PAINT_TRANSFORM, PAINT_VAR_TRANSFORM:
+			offset, _ := getUint24(data[p : p+3])
+			if paint.Transform, err = readAffine(data, pos+offset, paint.Format == PAINT_VAR_TRANSFORM); err != nil {
+				return nil, err
+			}
+		case PAINT_TRANSLATE, PAINT_VAR_TRANSLATE:
+			paint.Dx, paint.Dy = getFWord(data[p:p+2]), getFWord(data[p+2:p+4])
+		case PAINT_SCALE, PAINT_VAR_SCALE, PAINT_SCALE_AROUND_CENTER, PAINT_VAR_SCALE_AROUND_CENTER:
+			paint.ScaleX, paint.ScaleY = get2Dot14(data[p:p+2]), get2Dot14(data[p+2:p+4])
+			p += 4
+		case PAINT_SCALE_UNIFORM, PAINT_VAR_SCALE_UNIFORM, PAINT_SCALE_UNIFORM_AROUND_CENTER, PAINT_VAR_SCALE_UNIFORM_AROUND_CENTER:
+			paint.ScaleX = get2Dot14(data[p : p+2])
+			p += 2
+		case PAINT_ROTATE, PAINT_VAR_ROTATE, PAINT_ROTATE_AROUND_CENTER, PAINT_VAR_ROTATE_AROUND_CENTER:
+			paint.Angle = get2Dot14(data[p : p+2])
+			p += 2
+		case PAINT_SKEW, PAINT_VAR_SKEW, PAINT_SKEW_AROUND_CENTER, PAINT_VAR_SKEW_AROUND_CENTER:
+			paint.SkewX, paint.SkewY = get2Dot14(data[p:p+2]), get2Dot14(data[p+2:p+4])
+			p += 4
+		}
+		if paintAroundCenter(paint.Format) {
+			paint.CenterX, paint.CenterY = getFWord(data[p:p+2]), getFWord(data[p+2:p+4])
+		}
+	}
+	paints[pos] = paint
+	return paint, nil
+}
+
+// paintAroundCenter reports whether a transform format carries a center
+func paintAroundCenter(format uint8) bool {
+	switch format {
+	case PAINT_SCALE_AROUND_CENTER, PAINT_VAR_SCALE_AROUND_CENTER,
+		PAINT_SCALE_UNIFORM_AROUND_CENTER, PAINT_VAR_SCALE_UNIFORM_AROUND_CENTER,
+		PAINT_ROTATE_AROUND_CENTER, PAINT_VAR_ROTATE_AROUND_CENTER,
+		PAINT_SKEW_AROUND_CENTER, PAINT_VAR_SKEW_AROUND_CENTER:
+		return true
+	}
+	return false
+}
+
+func readColorLine(data []byte, pos int, isVar bool) (colorLine *ColorLine, err error) {
+	if pos+3 > len(data) {
+		return nil, errColrTruncated
+	}
+	colorLine = &ColorLine{Extend: getUint8(data[pos : pos+1])}
+	num := int(getUint16(data[pos+1 : pos+3]))
+	stopSize := 6
+	if isVar {
+		stopSize = 10
+	}
+	pos += 3
+	if pos+num*stopSize > len(data) {
+		return nil, errColrTruncated
+	}
+	for i := 0; i < num; i++ {
+		stop := ColorStop{
+			StopOffset:   get2Dot14(data[pos : pos+2]),
+			PaletteIndex: getUint16(data[pos+2 : pos+4]),
+			Alpha:        get2Dot14(data[pos+4 : pos+6]),
+		}
+		if isVar {
+			stop.VarIndexBase = getUint32(data[pos+6 : pos+10])
+		}
+		colorLine.Stops = append(colorLine.Stops, stop)
+		pos += stopSize
+	}
+	return
+}
+
+func readAffine(data []byte, pos int, isVar bool) (*Affine2x3, error) {
+	size := 24
+	if isVar {
+		size = 28
+	}
+	if pos+size > len(data) {
+		return nil, errColrTruncated
+	}
+	affine := &Affine2x3{
+		XX: getFixed(data[pos : pos+4]),
+		YX: getFixed(data[pos+4 : pos+8]),
+		XY: getFixed(data[pos+8 : pos+12]),
+		YY: getFixed(data[pos+12 : pos+16]),
+		Dx: getFixed(data[pos+16 : pos+20]),
+		Dy: getFixed(data[pos+20 : pos+24]),
+	}
+	if isVar {
+		affine.VarIndexBase = getUint32(data[pos+24 : pos+28])
+	}
+	return affine, nil
+}
+
+// deltaSetIndexMapLength returns the byte length of the DeltaSetIndexMap at pos
+func deltaSetIndexMapLength(data []byte, pos int) (int, error) {
+	if pos+4 > len(data) {
+		return 0, errColrTruncated
+	}
+	format := getUint8(data[pos : pos+1])
+	entrySize := int(getUint8(data[pos+1:pos+2])>>4&0x3) + 1
+	header, mapCount := 4, int(getUint16(data[pos+2:pos+4]))
+	if format == 1 {
+		if pos+6 > len(data) {
+			return 0, errColrTruncated
+		}
+		header, mapCount = 6, int(getUint32(data[pos+2:pos+6]))
+	}
+	length := header + mapCount*entrySize
+	if pos+length > len(data) {
+		return 0, errColrTruncated
+	}
+	return length, nil
+}
+
+// itemVariationStoreLength returns the byte length of the
+// ItemVariationStore at pos: the end of its furthest subtable.
+func itemVariationStoreLength(data []byte, pos int) (int, error) {
+	if pos+8 > len(data) {
+		return 0, errColrTruncated
+	}
+	regionListOffset := int(getUint32(data[pos+2 : pos+6]))
+	count := int(getUint16(data[pos+6 : pos+8]))
+	end := 8 + count*4
+	if pos+end > len(data) {
+		return 0, errColrTruncated
+	}
+	if regionListOffset != 0 {
+		p := pos + regionListOffset
+		if p+4 > len(data) {
+			return 0, errColrTruncated
+		}
+		axisCount := int(getUint16(data[p : p+2]))
+		regionCount := int(getUint16(data[p+2 : p+4]))
+		if e := regionListOffset + 4 + axisCount*regionCount*6; e > end {
+			end = e
+		}
+	}
+	for i := 0; i < count; i++ {
+		offset := int(getUint32(data[pos+8+i*4 : pos+12+i*4]))
+		if offset == 0 {
+			continue
+		}
+		p := pos + offset
+		if p+6 > len(data) {
+			return 0, errColrTruncated
+		}
+		itemCount := int(getUint16(data[p : p+2]))
+		wordDeltaCount := int(getUint16(data[p+2 : p+4]))
+		regionIndexCount := int(getUint16(data[p+4 : p+6]))
+		wordCount := wordDeltaCount & 0x7FFF
+		rowSize := wordCount*2 + (regionIndexCount - wordCount)
+		if wordDeltaCount&0x8000 != 0 {
+			rowSize *= 2
+		}
+		if e := offset + 6 + regionIndexCount*2 + itemCount*rowSize; e > end {
+			end = e
+		}
+	}
+	if pos+end > len(data) {
+		return 0, errColrTruncated
+	}
+	return end, nil
+}
+
+// offsetWriter builds one node of a paintPool: a paint whose Offset24
+// fields point at other nodes.
+type offsetWriter struct {
+	head     []byte
+	refs     []int
+	children []int
+}
+
+// offset appends an Offset24 to node child, or a null offset when child is
+// negative
+func (w *offsetWriter) offset(child int) {
+	w.refs = append(w.refs, len(w.head))
+	w.children = append(w.children, child)
+	w.head = append(w.head, 0, 0, 0)
+}
+
+// paintPool lays out the paints, color lines and transforms of a COLR
+// table, each once: paints shared by pointer, and subtables that encode the
+// same way, become one node. A node is added after its children, so laying
+// out the nodes from the last to the first places every child after its
+// parents, as the unsigned Offset24s require.
+type paintPool struct {
+	nodes  []*offsetWriter
+	paints map[*Paint]int
+	keys   map[string]int
+}
+
+func newPaintPool() *paintPool {
+	return &paintPool{paints: make(map[*Paint]int), keys: make(map[string]int)}
+}
+
+// node returns the index of the node equal to w, adding w when there is none
+func (pool *paintPool) node(w *offsetWriter) int {
+	key := strconv.Itoa(len(w.head)) + ":" + string(w.head)
+	for _, child := range w.children {
+		key += "," + strconv.Itoa(child)
+	}
+	if index, ok := pool.keys[key]; ok {
+		return index
+	}
+	pool.keys[key] = len(pool.nodes)
+	pool.nodes = append(pool.nodes, w)
+	return len(pool.nodes) - 1
+}
+
+// layout returns the nodes with their offsets filled in and where each node
+// starts. It fails when an offset does not fit in an Offset24.
+func (pool *paintPool) layout() (data []byte, starts []int, err error) {
+	starts = make([]int, len(pool.nodes))
+	for i := len(pool.nodes) - 1; i >= 0; i-- {
+		starts[i] = len(data)
+		data = append(data, pool.nodes[i].head...)
+	}
+	for i, node := range pool.nodes {
+		for j, at := range node.refs {
+			child := node.children[j]
+			if child < 0 {
+				continue
+			}
+			offset := starts[child] - starts[i]
+			if offset > 0xFFFFFF {
+				return nil, nil, errors.New("COLR paint offset " + strconv.Itoa(offset) + " overflows Offset24")
+			}
+			copy(data[starts[i]+at:], writeUint24(offset))
+		}
+	}
+	return data, starts, nil
+}
+
+// paint adds paint and everything it references to the pool and returns
+// its node, or -1 when paint is nil
+func (pool *paintPool) paint(paint *Paint) int {
+	if paint == nil {
+		return -1
+	}
+	if index, ok := pool.paints[paint]; ok {
+		return index
+	}
+	w := &offsetWriter{head: []byte{paint.Format}}
+	switch paint.Format {
+	case PAINT_COLR_LAYERS:
+		w.head = append(w.head, paint.NumLayers)
+		w.head = append(w.head, writeUint32(paint.FirstLayerIndex)...)
+	case PAINT_SOLID, PAINT_VAR_SOLID:
+		w.head = append(w.head, writeUint16(paint.PaletteIndex)...)
+		w.head = append(w.head, write2Dot14(paint.Alpha)...)
+	case PAINT_LINEAR_GRADIENT, PAINT_VAR_LINEAR_GRADIENT, PAINT_RADIAL_GRADIENT, PAINT_VAR_RADIAL_GRADIENT,
+		PAINT_SWEEP_GRADIENT, PAINT_VAR_SWEEP_GRADIENT:
+		w.offset(pool.node(&offsetWriter{head: writeColorLine(paint.ColorLine, isVarPaint(paint.Format))}))
+		switch paint.Format {
+		case PAINT_LINEAR_GRADIENT, PAINT_VAR_LINEAR_GRADIENT:
+			for _, v := range []int16{paint.X0, paint.Y0, paint.X1, paint.Y1, paint.X2, paint.Y2} {
+				w.head = append(w.head, writeFWord(v)...)
+			}
+		case PAINT_RADIAL_GRADIENT, PAINT_VAR_RADIAL_GRADIENT:
+			w.head = append(w.head, writeFWord(paint.X0)...)
+			w.head = append(w.head, writeFWord(paint.Y0)...)
+			w.head = append(w.head, writeUFWord(paint.Radius0)...)
+			w.head = append(w.head, writeFWord(paint.X1)...)
+			w.head = append(w.head, writeFWord(paint.Y1)...)
+			w.head = append(w.head, writeUFWord(paint.Radius1)...)
+		default:
+			w.head = append(w.head, writeFWord(paint.CenterX)...)
+			w.head = append(w.head, writeFWord(paint.CenterY)...)
+			w.head = append(w.head, write2Dot14(paint.StartAngle)...)
+			w.head = append(w.head, write2Dot14(paint.EndAngle)...)
+		}
+	case PAINT_GLYPH:
+		w.offset(pool.paint(paint.Paint))
+		w.head = append(w.head, writeUint16(paint.GlyphID)...)
+	case PAINT_COLR_GLYPH:
+		w.head = append(w.head, writeUint16(paint.GlyphID)...)
+	case PAINT_COMPOSITE:
+		w.offset(pool.paint(paint.Paint))
+		w.head = append(w.head, paint.CompositeMode)
+		w.offset(pool.paint(paint.Backdrop))
+	default:
+		w.offset(pool.paint(paint.Paint))
+		switch paint.Format {
+		case PAINT_TRANSFORM, PAINT_VAR_TRANSFORM:
+			w.offset(pool.node(&offsetWriter{head: writeAffine(paint.Transform, paint.Format == PAINT_VAR_TRANSFORM)}))
+		case PAINT_TRANSLATE, PAINT_VAR_TRANSLATE:
+			w.head = append(w.head, writeFWord(paint.Dx)...)
+			w.head = append(w.head, writeFWord(paint.Dy)...)
+		case PAINT_SCALE, PAINT_VAR_SCALE, PAINT_SCALE_AROUND_CENTER, PAINT_VAR_SCALE_AROUND_CENTER:
+			w.head = append(w.head, write2Dot14(paint.ScaleX)...)
+			w.head = append(w.head, write2Dot14(paint.ScaleY)...)
+		case PAINT_SCALE_UNIFORM, PAINT_VAR_SCALE_UNIFORM, PAINT_SCALE_UNIFORM_AROUND_CENTER, PAINT_VAR_SCALE_UNIFORM_AROUND_CENTER:
+			w.head = append(w.head, write2Dot14(paint.ScaleX)...)
+		case PAINT_ROTATE, PAINT_VAR_ROTATE, PAINT_ROTATE_AROUND_CENTER, PAINT_VAR_ROTATE_AROUND_CENTER:
+			w.head = append(w.head, write2Dot14(paint.Angle)...)
+		case PAINT_SKEW, PAINT_VAR_SKEW, PAINT_SKEW_AROUND_CENTER, PAINT_VAR_SKEW_AROUND_CENTER:
+			w.head = append(w.head, write2Dot14(paint.SkewX)...)
+			w.head = append(w.head, write2Dot14(paint.SkewY)...)
+		}
+		if paintAroundCenter(paint.Format) {
+			w.head = append(w.head, writeFWord(paint.CenterX)...)
+			w.head = append(w.head, writeFWord(paint.CenterY)...)
+		}
+	}
+	if isVarPaint(paint.Format) && paint.Format != PAINT_VAR_TRANSFORM {
+		w.head = append(w.head, writeUint32(paint.VarIndexBase)...)
+	}
+	index := pool.node(w)
+	pool.paints[paint] = index
+	return index
+}
+
+func writeColorLine(colorLine *ColorLine, isVar bool) []byte {
+	if colorLine == nil {
+		colorLine = &ColorLine{}
+	}
+	data := []byte{colorLine.Extend}
+	data = append(data, writeUint16(uint16(len(colorLine.Stops)))...)
+	for _, stop := range colorLine.Stops {
+		data = append(data, write2Dot14(stop.StopOffset)...)
+		data = append(data, writeUint16(stop.PaletteIndex)...)
+		data = append(data, write2Dot14(stop.Alpha)...)
+		if isVar {
+			data = append(data, writeUint32(stop.VarIndexBase)...)
+		}
+	}
+	return data
+}
+
+func writeAffine(affine *Affine2x3, isVar bool) []byte {
+	if affine == nil {
+		affine = &Affine2x3{XX: 1, YY: 1}
+	}
+	data := []byte{}
+	for _, v := range []float64{affine.XX, affine.YX, affine.XY, affine.YY, affine.Dx, affine.Dy} {
+		data = append(data, writeFixed(v)...)
+	}
+	if isVar {
+		data = append(data, writeUint32(affine.VarIndexBase)...)
+	}
+	return data
+}
+
+// writeClipList writes the clip boxes, merging consecutive glyphs that share
+// a box into one clip and sharing identical boxes
+func writeClipList(clips map[uint16]*ClipBox) []byte {
+	glyphs := sortedGlyphKeys(clips)
+	type clipRange struct {
+		start, end uint16
+		box        []byte
+	}
+	var ranges []clipRange
+	for _, gid := range glyphs {
+		box := clips[gid]
+		boxData := []byte{box.Format}
+		for _, v := range []int16{box.XMin, box.YMin, box.XMax, box.YMax} {
+			boxData = append(boxData, writeFWord(v)...)
+		}
+		if box.Format == 2 {
+			boxData = append(boxData, writeUint32(box.VarIndexBase)...)
+		}
+		if n := len(ranges); n > 0 && ranges[n-1].end+1 == gid && string(ranges[n-1].box) == string(boxData) {
+			ranges[n-1].end = gid
+			continue
+		}
+		ranges = append(ranges, clipRange{gid, gid, boxData})
+	}
+
+	data := []byte{1}
+	data = append(data, writeUint32(uint32(len(ranges)))...)
+	var boxes []byte
+	boxOffsets := make(map[string]int)
+	boxStart := len(data) + len(ranges)*7
+	for _, r := range ranges {
+		offset, ok := boxOffsets[string(r.box)]
+		if !ok {
+			offset = boxStart + len(boxes)
+			boxOffsets[string(r.box)] = offset
+			boxes = append(boxes, r.box...)
+		}
+		data = append(data, writeUint16(r.start)...)
+		data = append(data, writeUint16(r.end)...)
+		data = append(data, writeUint24(offset)...)
+	}
+	return append(data, boxes...)
+}
+
+// writePaintList writes a count and one Offset32 per paint node (after the
+// glyph ID when glyphs is given). The paint pool starts poolOffset bytes
+// after the list.
+func writePaintList(nodes []int, starts []int, glyphs []uint16, poolOffset int) []byte {
+	data := writeUint32(uint32(len(nodes)))
+	for i, node := range nodes {
+		if glyphs != nil {
+			data = append(data, writeUint16(glyphs[i])...)
+		}
+		if node < 0 {
+			data = append(data, writeUint32(0)...)
+			continue
+		}
+		data = append(data, writeUint32(uint32(poolOffset+starts[node]))...)
+	}
+	return data
+}
+
+// WriteColr writes the COLR table. The version 1 paints, color lines and
+// transforms are laid out once each after the layer list, so paints shared
+// in the paint graph stay shared.
+func WriteColr(colr *Colr) ([]byte, error) {
+	headerSize := 14
+	if colr.Version >= 1 {
+		headerSize = 34
+	}
+
+	// Version 0 base glyph and layer records
+	baseGlyphs := sortedGlyphKeys(colr.BaseGlyphLayers)
+	var baseRecords, layerRecords []byte
+	numLayers := 0
+	for _, gid := range baseGlyphs {
+		layers := colr.BaseGlyphLayers[gid]
+		baseRecords = append(baseRecords, writeUint16(gid)...)
+		baseRecords = append(baseRecords, writeUint16(uint16(numLayers))...)
+		baseRecords = append(baseRecords, writeUint16(uint16(len(layers)))...)
+		for _, layer := range layers {
+			layerRecords = append(layerRecords, writeUint16(layer.GlyphID)...)
+			layerRecords = append(layerRecords, writeUint16(layer.PaletteIndex)...)
+		}
+		numLayers += len(layers)
+	}
+
+	// Version 1 subtables, in the order they follow the header
+	var subtables [5][]byte
+	if colr.Version >= 1 {
+		pool := newPaintPool()
+		paintGlyphs := sortedGlyphKeys(colr.BaseGlyphPaints)
+		baseNodes := make([]int, len(paintGlyphs))
+		for i, gid := range paintGlyphs {
+			baseNodes[i] = pool.paint(colr.BaseGlyphPaints[gid])
+		}
+		layerNodes := make([]int, len(colr.LayerList))
+		for i, paint := range colr.LayerList {
+			layerNodes[i] = pool.paint(paint)
+		}
+		paintData, starts, err := pool.layout()
+		if err != nil {
+			return nil, err
+		}
+		// the paints follow the layer list, or the base glyph list without one
+		var layerListSize int
+		if len(layerNodes) > 0 {
+			layerListSize = 4 + len(layerNodes)*4
+			subtables[1] = append(writePaintList(layerNodes, starts, nil, layerListSize), paintData...)
+		}
+		if len(baseNodes) > 0 {
+			baseListSize := 4 + len(baseNodes)*6
+			subtables[0] = writePaintList(baseNodes, starts, paintGlyphs, baseListSize+layerListSize)
+			if len(layerNodes) == 0 {
+				subtables[0] = append(subtables[0], paintData...)
+			}
+		}
+		if len(colr.Clips) > 0 {
+			subtables[2] = writeClipList(colr.Clips)
+		}
+		subtables[3] = colr.VarIndexMap
+		subtables[4] = colr.ItemVariationStore
+	}
+
+	data := []byte{}
+	data = append(data, writeUint16(colr.Version)...)
+	data = append(data, writeUint16(uint16(len(baseGlyphs)))...)
+	offset := headerSize
+	if len(baseRecords) > 0 {
+		data = append(data, writeUint32(uint32(offset))...)
+	} else {
+		data = append(data, writeUint32(0)...)
+	}
+	offset += len(baseRecords)
+	if len(layerRecords) > 0 {
+		data = append(data, writeUint32(uint32(offset))...)
+	} else {
+		data = append(data, writeUint32(0)...)
+	}
+	offset += len(layerRecords)
+	data = append(data, writeUint16(uint16(numLayers))...)
+	if colr.Version >= 1 {
+		for _, sub := range subtables {
+			if len(sub) == 0 {
+				data = append(data, writeUint32(0)...)
+				continue
+			}
+			data = append(data, writeUint32(uint32(offset))...)
+			offset += len(sub)
+		}
+	}
+	data = append(data, baseRecords...)
+	data = append(data, layerRecords...)
+	for _, sub := range subtables {
+		data = append(data, sub...)
+	}
+	return data, nil
+}
+
+// paintGlyphs calls visit for every glyph the paint draws: PAINT_GLYPH
+// outlines and PAINT_COLR_GLYPH color glyphs, following layer references.
+// Paints reached through several offsets are visited once.
+func (colr *Colr) paintGlyphs(paint *Paint, visit func(gid uint16), visited map[*Paint]bool) {
+	if paint == nil || visited[paint] {
+		return
+	}
+	visited[paint] = true
+	switch paint.Format {
+	case PAINT_GLYPH, PAINT_COLR_GLYPH:
+		visit(paint.GlyphID)
+	case PAINT_COLR_LAYERS:
+		for i := paint.FirstLayerIndex; i < paint.FirstLayerIndex+uint32(paint.NumLayers); i++ {
+			if int(i) >= len(colr.LayerList) {
+				break
+			}
+			colr.paintGlyphs(colr.LayerList[i], visit, visited)
+		}
+	}
+	colr.paintGlyphs(paint.Paint, visit, visited)
+	colr.paintGlyphs(paint.Backdrop, visit, visited)
+}
+
+// ColorGlyphs returns the glyphs that color glyph gid is drawn with: its
+// version 0 layers and every glyph its version 1 paint graph references.
+func (colr *Colr) ColorGlyphs(gid int) []int {
+	var glyphs []int
+	for _, layer := range colr.BaseGlyphLayers[uint16(gid)] {
+		glyphs = append(glyphs, int(layer.GlyphID))
+	}
+	if paint, ok := colr.BaseGlyphPaints[uint16(gid)]; ok {
+		colr.paintGlyphs(paint, func(g uint16) { glyphs = append(glyphs, int(g)) }, make(map[*Paint]bool))
+	}
+	return glyphs
+}
+
+// remapColr keeps the color glyphs whose base glyph is retained, renumbers
+// every glyph reference and rebuilds the layer list from the layers the
+// retained paint graphs still use.
+func remapColr(colr *Colr, oldToNew map[int]int) {
+	newGlyph := func(gid uint16) uint16 {
+		return uint16(oldToNew[int(gid)])
+	}
+
+	baseGlyphLayers := make(map[uint16][]LayerRecord)
+	for gid, layers := range colr.BaseGlyphLayers {
+		if _, ok := oldToNew[int(gid)]; !ok {
+			continue
+		}
+		remapped := make([]LayerRecord, len(layers))
+		for i, layer := range layers {
+			remapped[i] = LayerRecord{newGlyph(layer.GlyphID), layer.PaletteIndex}
+		}
+		baseGlyphLayers[newGlyph(gid)] = remapped
+	}
+	colr.BaseGlyphLayers = baseGlyphLayers
+
+	oldLayers := colr.LayerList
+	var newLayers []*Paint
+	layerStarts := make(map[[2]uint32]uint32)
+	remapped := make(map[*Paint]bool)
+	var remapPaint func(paint *Paint)
+	remapPaint = func(paint *Paint) {
+		if paint == nil || remapped[paint] {
+			return
+		}
+		remapped[paint] = true
+		switch paint.Format {
+		case PAINT_GLYPH, PAINT_COLR_GLYPH:
+			paint.GlyphID = newGlyph(paint.GlyphID)
+		case PAINT_COLR_LAYERS:
+			key := [2]uint32{paint.FirstLayerIndex, uint32(paint.NumLayers)}
+			if first, ok := layerStarts[key]; ok {
+				paint.FirstLayerIndex = first
+				break
+			}
+			first := uint32(len(newLayers))
+			layerStarts[key] = first
+			var layers []*Paint
+			for i := key[0]; i < key[0]+key[1] && int(i) < len(oldLayers); i++ {
+				layers = append(layers, oldLayers[i])
+			}
+			newLayers = append(newLayers, layers...)
+			paint.FirstLayerIndex = first
+			for _, layer := range layers {
+				remapPaint(layer)
+			}
+		}
+		remapPaint(paint.Paint)
+		remapPaint(paint.Backdrop)
+	}
+
+	oldPaints := colr.BaseGlyphPaints
+	paintGlyphs := sortedGlyphKeys(oldPaints)
+	colr.BaseGlyphPaints = make(map[uint16]*Paint)
+	for _, gid := range paintGlyphs {
+		if _, ok := oldToNew[int(gid)]; !ok {
+			continue
+		}
+		remapPaint(oldPaints[gid])
+		colr.BaseGlyphPaints[newGlyph(gid)] = oldPaints[gid]
+	}
+	colr.LayerList = newLayers
+
+	clips := make(map[uint16]*ClipBox)
+	for gid, box := range colr.Clips {
+		if _, ok := oldToNew[int(gid)]; ok {
+			clips[newGlyph(gid)] = box
+		}
+	}
+	colr.Clips = clips
+}
+
+// Cpal is the color palette table. Every palette holds NumPaletteEntries
+// colors. Version 1 adds palette types, palette name IDs and palette entry
+// name IDs; 0xFFFF marks a missing name.
+type Cpal struct {
+	Version            uint16          `json:"version"`
+	NumPaletteEntries  uint16          `json:"numPaletteEntries"`
+	Palettes           [][]color.NRGBA `json:"palettes"`
+	PaletteTypes       []uint32        `json:"paletteTypes,omitempty"`
+	PaletteLabels      []uint16        `json:"paletteLabels,omitempty"`
+	PaletteEntryLabels []uint16        `json:"paletteEntryLabels,omitempty"`
+}
+
+func GetCpal(data []byte, pos int) (cpal *Cpal, err error) {
+	start := pos
+	cpal = new(Cpal)
+	if pos+12 > len(data) {
+		err = errors.New("CPAL table truncated")
+		return
+	}
+	cpal.Version = getUint16(data[pos : pos+2])
+	cpal.NumPaletteEntries = getUint16(data[pos+2 : pos+4])
+	numPalettes := int(getUint16(data[pos+4 : pos+6]))
+	numColorRecords := int(getUint16(data[pos+6 : pos+8]))
+	colorRecordsPos := start + int(getUint32(data[pos+8:pos+12]))
+	pos += 12
+	if pos+numPalettes*2 > len(data) || colorRecordsPos+numColorRecords*4 > len(data) {
+		err = errors.New("CPAL table truncated")
+		return
+	}
+	numEntries := int(cpal.NumPaletteEntries)
+	for i := 0; i < numPalettes; i++ {
+		first := int(getUint16(data[pos : pos+2]))
+		pos += 2
+		if first+numEntries > numColorRecords {
+			err = errors.New("CPAL palette out of range")
+			return
+		}
+		palette := make([]color.NRGBA, numEntries)
+		for j := range palette {
+			p := colorRecordsPos + (first+j)*4
+			palette[j] = color.NRGBA{B: data[p], G: data[p+1], R: data[p+2], A: data[p+3]}
+		}
+		cpal.Palettes = append(cpal.Palettes, palette)
+	}
+	if cpal.Version == 0 {
+		return
+	}
+
+	if pos+12 > len(data) {
+		err = errors.New("CPAL table truncated")
+		return
+	}
+	typesOffset := int(getUint32(data[pos : pos+4]))
+	labelsOffset := int(getUint32(data[pos+4 : pos+8]))
+	entryLabelsOffset := int(getUint32(data[pos+8 : pos+12]))
+	if typesOffset != 0 {
+		p := start + typesOffset
+		if p+numPalettes*4 > len(data) {
+			err = errors.New("CPAL palette types truncated")
+			return
+		}
+		for i := 0; i < numPalettes; i++ {
+			cpal.PaletteTypes = append(cpal.PaletteTypes, getUint32(data[p+i*4:p+i*4+4]))
+		}
+	}
+	if labelsOffset != 0 {
+		p := start + labelsOffset
+		if p+numPalettes*2 > len(data) {
+			err = errors.New("CPAL palette labels truncated")
+			return
+		}
+		for i := 0; i < numPalettes; i++ {
+			cpal.PaletteLabels = append(cpal.PaletteLabels, getUint16(data[p+i*2:p+i*2+2]))
+		}
+	}
+	if entryLabelsOffset != 0 {
+		p := start + entryLabelsOffset
+		if p+numEntries*2 > len(data) {
+			err = errors.New("CPAL palette entry labels truncated")
+			return
+		}
+		for i := 0; i < numEntries; i++ {
+			cpal.PaletteEntryLabels = append(cpal.PaletteEntryLabels, getUint16(data[p+i*2:p+i*2+2]))
+		}
+	}
+	return
+}
+
+// WriteCpal writes the palettes, storing identical palettes once
+func WriteCpal(cpal *Cpal) []byte {
+	numEntries := int(cpal.NumPaletteEntries)
+	headerSize := 12 + 2*len(cpal.Palettes)
+	if cpal.Version >= 1 {
+		headerSize += 12
+	}
+
+	var colorRecords []byte
+	var indices []uint16
+	paletteStarts := make(map[string]uint16)
+	for _, palette := range cpal.Palettes {
+		var record []byte
+		for j := 0; j < numEntries; j++ {
+			var c color.NRGBA
+			if j < len(palette) {
+				c = palette[j]
+			}
+			record = append(record, c.B, c.G, c.R, c.A)
+		}
+		first, ok := paletteStarts[string(record)]
+		if !ok {
+			first = uint16(len(colorRecords) / 4)
+			paletteStarts[string(record)] = first
+			colorRecords = append(colorRecords, record...)
+		}
+		indices = append(indices, first)
+	}
+
+	data := []byte{}
+	data = append(data, writeUint16(cpal.Version)...)
+	data = append(data, writeUint16(cpal.NumPaletteEntries)...)
+	data = append(data, writeUint16(uint16(len(cpal.Palettes)))...)
+	data = append(data, writeUint16(uint16(len(colorRecords)/4))...)
+	data = append(data, writeUint32(uint32(headerSize))...)
+	for _, index := range indices {
+		data = append(data, writeUint16(index)...)
+	}
+	if cpal.Version == 0 {
+		return append(data, colorRecords...)
+	}
+
+	var arrays []byte
+	offset := headerSize + len(colorRecords)
+	writeArray := func(n int, item func(i int) []byte) {
+		if n == 0 {
+			data = append(data, writeUint32(0)...)
+			return
+		}
+		data = append(data, writeUint32(uint32(offset+len(arrays)))...)
+		for i := 0; i < n; i++ {
+			arrays = append(arrays, item(i)...)
+		}
+	}
+	writeArray(len(cpal.PaletteTypes), func(i int) []byte { return writeUint32(cpal.PaletteTypes[i]) })
+	writeArray(len(cpal.PaletteLabels), func(i int) []byte { return writeUint16(cpal.PaletteLabels[i]) })
+	writeArray(len(cpal.PaletteEntryLabels), func(i int) []byte { return writeUint16(cpal.PaletteEntryLabels[i]) })
+	data = append(data, colorRecords...)
+	return append(data, arrays...)
+}
@@ -0,0 +1,491 @@
+package font
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+)
+
+// HdmxRecord holds the advance widths, in whole pixels, of every glyph at
+// one size
+type HdmxRecord struct {
+	PixelSize uint8   `json:"pixelSize"`
+	MaxWidth  uint8   `json:"maxWidth"`
+	Widths    []uint8 `json:"widths"`
+}
+
+type Hdmx struct {
+	Version uint16       `json:"version"`
+	Records []HdmxRecord `json:"records"`
+}
+
+func GetHdmx(data []byte, pos int, numGlyphs int) (hdmx *Hdmx, err error) {
+	if pos+8 > len(data) {
+		return nil, errors.New("hdmx table truncated")
+	}
+	hdmx = &Hdmx{Version: getUint16(data[pos : pos+2])}
+	numRecords := int(getInt16(data[pos+2 : pos+4]))
+	recordSize := int(getUint32(data[pos+4 : pos+8]))
+	if numRecords < 0 || (numRecords > 0 && recordSize < numGlyphs+2) {
+		return nil, errors.New("hdmx record size " + strconv.Itoa(recordSize) + " too small")
+	}
+	pos += 8
+	if pos+numRecords*recordSize > len(data) {
+		return nil, errors.New("hdmx table truncated")
+	}
+	for i := 0; i < numRecords; i++ {
+		record := HdmxRecord{
+			PixelSize: data[pos],
+			MaxWidth:  data[pos+1],
+			Widths:    append([]uint8{}, data[pos+2:pos+2+numGlyphs]...),
+		}
+		hdmx.Records = append(hdmx.Records, record)
+		pos += recordSize
+	}
+	return hdmx, nil
+}
+
+// WriteHdmx writes the records sorted by size, each padded to a multiple
+// of four bytes. Widths are cut or zero-filled to numGlyphs.
+func WriteHdmx(hdmx *Hdmx, numGlyphs int) []byte {
+	records := append([]HdmxRecord{}, hdmx.Records...)
+	sort.SliceStable(records, func(i, j int) bool { return records[i].PixelSize < records[j].PixelSize })
+	recordSize := (numGlyphs + 2 + 3) &^ 3
+
+	data := []byte{}
+	data = append(data, writeUint16(hdmx.Version)...)
+	data = append(data, writeInt16(int16(len(records)))...)
+	data = append(data, writeUint32(uint32(recordSize))...)
+	for _, r := range records {
+		record := make([]byte, recordSize)
+		record[0] = r.PixelSize
+		record[1] = r.MaxWidth
+		copy(record[2:2+numGlyphs], r.Widths)
+		data = append(data, record...)
+	}
+	return data
+}
+
+// Width returns the advance width of glyph gid at ppem pixels per em
+func (hdmx *Hdmx) Width(gid int, ppem int) (width int, ok bool) {
+	for _, r := range hdmx.Records {
+		if int(r.PixelSize) == ppem && gid >= 0 && gid < len(r.Widths) {
+			return int(r.Widths[gid]), true
+		}
+	}
+	return 0, false
+}
+
+func remapHdmx(hdmx *Hdmx, oldIndices []int) {
+	for i := range hdmx.Records {
+		r := &hdmx.Records[i]
+		widths := make([]uint8, len(oldIndices))
+		r.MaxWidth = 0
+		for newIdx, oldIdx := range oldIndices {
+			if oldIdx < len(r.Widths) {
+				widths[newIdx] = r.Widths[oldIdx]
+			}
+			if widths[newIdx] > r.MaxWidth {
+				r.MaxWidth = widths[newIdx]
+			}
+		}
+		r.Widths = widths
+	}
+}
+
+// Ltsh holds, for every glyph, the size in pixels per em from which the
+// glyph's advance width scales linearly, so that hinting can be skipped
+// when only widths are needed
+type Ltsh struct {
+	Version uint16  `json:"version"`
+	YPels   []uint8 `json:"yPels"`
+}
+
+func GetLtsh(data []byte, pos int) (ltsh *Ltsh, err error) {
+	if pos+4 > len(data) {
+		return nil, errors.New("LTSH table truncated")
+	}
+	ltsh = &Ltsh{Version: getUint16(data[pos : pos+2])}
+	numGlyphs := int(getUint16(data[pos+2 : pos+4]))
+	pos += 4
+	if pos+numGlyphs > len(data) {
+		return nil, errors.New("LTSH table truncated")
+	}
+	ltsh.YPels = append([]uint8{}, data[pos:pos+numGlyphs]...)
+	return ltsh, nil
+}
+
+func WriteLtsh(ltsh *Ltsh) []byte {
+	data := []byte{}
+	data = append(data, writeUint16(ltsh.Version)...)
+	data = append(data, writeUint16(uint16(len(ltsh.YPels)))...)
+	data = append(data, ltsh.YPels...)
+	return data
+}
+
+func remapLtsh(ltsh *Ltsh, oldIndices []int) {
+	yPels := make([]uint8, len(oldIndices))
+	for newIdx, oldIdx := range oldIndices {
+		yPels[newIdx] = 1
+		if oldIdx < len(ltsh.YPels) {
+			yPels[newIdx] = ltsh.YPels[oldIdx]
+		}
+	}
+	ltsh.YPels = yPels
+}
+
+// VDMX ratio character sets: the group was computed from all glyphs or
+// from the Windows ANSI character set only
+const (
+	VDMX_CHARSET_ALL  uint8 = 0
+	VDMX_CHARSET_ANSI uint8 = 1
+)
+
+// VdmxRatio selects the group used for an aspect ratio between
+// XRatio:YStartRatio and XRatio:YEndRatio. A ratio of 0:0:0 matches every
+// aspect ratio.
+type VdmxRatio struct {
+	CharSet     uint8 `json:"charSet"`
+	XRatio      uint8 `json:"xRatio"`
+	YStartRatio uint8 `json:"yStartRatio"`
+	YEndRatio   uint8 `json:"yEndRatio"`
+	// Group indexes Vdmx.Groups
+	Group int `json:"group"`
+}
+
+// VdmxRecord is the pixel extent of the glyphs at one pixel height
+type VdmxRecord struct {
+	YPelHeight uint16 `json:"yPelHeight"`
+	YMax       int16  `json:"yMax"`
+	YMin       int16  `json:"yMin"`
+}
+
+type VdmxGroup struct {
+	StartSize uint8        `json:"startSize"`
+	EndSize   uint8        `json:"endSize"`
+	Records   []VdmxRecord `json:"records"`
+}
+
+type Vdmx struct {
+	Version uint16      `json:"version"`
+	Ratios  []VdmxRatio `json:"ratios"`
+	Groups  []VdmxGroup `json:"groups"`
+}
+
+func GetVdmx(data []byte, pos int) (vdmx *Vdmx, err error) {
+	start := pos
+	if pos+6 > len(data) {
+		return nil, errors.New("VDMX table truncated")
+	}
+	vdmx = &Vdmx{Version: getUint16(data[pos : pos+2])}
+	numRatios := int(getUint16(data[pos+4 : pos+6]))
+	pos += 6
+	if pos+numRatios*6 > len(data) {
+		return nil, errors.New("VDMX table truncated")
+	}
+	// ratios refer to groups by offset; groups are kept in offset order
+	groupIndex := make(map[int]int)
+	offsets := make([]int, numRatios)
+	for i := 0; i < numRatios; i++ {
+		offsets[i] = int(getUint16(data[pos+numRatios*4+i*2 : pos+numRatios*4+i*2+2]))
+		groupIndex[offsets[i]] = 0
+	}
+	sorted := make([]int, 0, len(groupIndex))
+	for offset := range groupIndex {
+		sorted = append(sorted, offset)
+	}
+	sort.Ints(sorted)
+	for i, offset := range sorted {
+		groupIndex[offset] = i
+		p := start + offset
+		if p+4 > len(data) {
+			return nil, errors.New("VDMX group truncated")
+		}
+		numRecs := int(getUint16(data[p : p+2]))
+		group := VdmxGroup{StartSize: data[p+2], EndSize: data[p+3]}
+		p += 4
+		if p+numRecs*6 > len(data) {
+			return nil, errors.New("VDMX group truncated")
+		}
+		for j := 0; j < numRecs; j++ {
+			group.Records = append(group.Records, VdmxRecord{
+				YPelHeight: getUint16(data[p : p+2]),
+				YMax:       getInt16(data[p+2 : p+4]),
+				YMin:       getInt16(data[p+4 : p+6]),
+			})
+			p += 6
+		}
+		vdmx.Groups = append(vdmx.Groups, group)
+	}
+	for i := 0; i < numRatios; i++ {
+		vdmx.Ratios = append(vdmx.Ratios, VdmxRatio{
+			CharSet:     data[pos+i*4],
+			XRatio:      data[pos+i*4+1],
+			YStartRatio: data[pos+i*4+2],
+			YEndRatio:   data[pos+i*4+3],
+			Group:       groupIndex[offsets[i]],
+		})
+	}
+	return vdmx, nil
+}
+
+// WriteVdmx writes the ratios in order and each group once, however many
+// ratios use it
+func WriteVdmx(vdmx *Vdmx) []byte {
+	numRatios := len(vdmx.Ratios)
+	data := []byte{}
+	data = append(data, writeUint16(vdmx.Version)...)
+	data = append(data, writeUint16(uint16(len(vdmx.Groups)))...)
+	data = append(data, writeUint16(uint16(numRatios))...)
+	for _, r := range vdmx.Ratios {
+		data = append(data, r.CharSet, r.XRatio, r.YStartRatio, r.YEndRatio)
+	}
+
+	groupOffsets := make([]int, len(vdmx.Groups))
+	groups := []byte{}
+	groupStart := len(data) + numRatios*2
+	for i, g := range vdmx.Groups {
+		groupOffsets[i] = groupStart + len(groups)
+		groups = append(groups, writeUint16(uint16(len(g.Records)))...)
+		groups = append(groups, g.StartSize, g.EndSize)
+		for _, r := range g.Records {
+			groups = append(groups, writeUint16(r.YPelHeight)...)
+			groups = append(groups, writeInt16(r.YMax)...)
+			groups = append(groups, writeInt16(r.YMin)...)
+		}
+	}
+	for _, r := range vdmx.Ratios {
+		offset := 0
+		if r.Group >= 0 && r.Group < len(groupOffsets) {
+			offset = groupOffsets[r.Group]
+		}
+		data = append(data, writeUint16(uint16(offset))...)
+	}
+	return append(data, groups...)
+}
+
+// Extent returns the pixel extent recorded for ppem at the aspect ratio
+// x:y, or ok false when no ratio matches or the group has no record for
+// ppem
+func (vdmx *Vdmx) Extent(ppem int, x, y int) (yMax, yMin int, ok bool) {
+	for _, r := range vdmx.Ratios {
+		matches := r.XRatio == 0 && r.YStartRatio == 0 && r.YEndRatio == 0
+		if !matches && r.XRatio != 0 {
+			// compare y/x against the ratio range without dividing
+			matches = y*int(r.XRatio) >= x*int(r.YStartRatio) && y*int(r.XRatio) <= x*int(r.YEndRatio)
+		}
+		if !matches || r.Group < 0 || r.Group >= len(vdmx.Groups) {
+			continue
+		}
+		for _, rec := range vdmx.Groups[r.Group].Records {
+			if int(rec.YPelHeight) == ppem {
+				return int(rec.YMax), int(rec.YMin), true
+			}
+		}
+		return 0, 0, false
+	}
+	return 0, 0, false
+}
+
+// scaledWidth scales an advance to whole pixels without hinting
+func scaledWidth(advance int, ppem int, unitsPerEm int) int {
+	return int(roundDiv(int64(advance)*int64(ppem), int64(unitsPerEm)))
+}
+
+// deviceWidths returns the advance width of every glyph in whole pixels at
+// ppem, grid-fitted by the glyph programs when hinted is set
+func (f *Font) deviceWidths(ppem int, hinted bool) ([]int, error) {
+	tables := f.fontInfo.Tables
+	numGlyphs := f.numGlyphs()
+	widths := make([]int, numGlyphs)
+	if !hinted {
+		if tables.Hmtx == nil || tables.Head == nil || tables.Head.UnitsPerEm == 0 {
+			return nil, errors.New("hmtx and head are needed to scale widths")
+		}
+		for gid := range widths {
+			advance, _ := tables.Hmtx.Metric(gid)
+			widths[gid] = scaledWidth(int(advance), ppem, int(tables.Head.UnitsPerEm))
+		}
+		return widths, nil
+	}
+	h, err := f.NewHinter(ppem)
+	if err != nil {
+		return nil, err
+	}
+	for gid := range widths {
+		g, err := h.Glyph(gid)
+		if err != nil {
+			return nil, err
+		}
+		widths[gid] = int(roundPixel(g.AdvanceWidth) >> 6)
+	}
+	return widths, nil
+}
+
+// GenerateHdmx replaces the hdmx table with the advance widths of every
+// glyph at each size in ppems, either grid-fitted by the hinting
+// interpreter or, when hinted is false, scaled linearly and rounded
+func (f *Font) GenerateHdmx(ppems []int, hinted bool) error {
+	if f.fontInfo == nil {
+		return errors.New("fontInfo is nil, call GetFontInfo first")
+	}
+	hdmx := &Hdmx{}
+	seen := make(map[int]bool)
+	for _, ppem := range ppems {
+		if ppem < 1 || ppem > 255 {
+			return errors.New("hdmx size " + strconv.Itoa(ppem) + " out of range")
+		}
+		if seen[ppem] {
+			continue
+		}
+		seen[ppem] = true
+		widths, err := f.deviceWidths(ppem, hinted)
+		if err != nil {
+			return err
+		}
+		record := HdmxRecord{PixelSize: uint8(ppem), Widths: make([]uint8, len(widths))}
+		for gid, w := range widths {
+			if w < 0 || w > 255 {
+				return errors.New("glyph " + strconv.Itoa(gid) + " is " + strconv.Itoa(w) + " pixels wide at " + strconv.Itoa(ppem) + " ppem")
+			}
+			record.Widths[gid] = uint8(w)
+			if record.Widths[gid] > record.MaxWidth {
+				record.MaxWidth = record.Widths[gid]
+			}
+		}
+		hdmx.Records = append(hdmx.Records, record)
+	}
+	sort.Slice(hdmx.Records, func(i, j int) bool { return hdmx.Records[i].PixelSize < hdmx.Records[j].PixelSize })
+	f.fontInfo.Tables.Hdmx = hdmx
+	return nil
+}
+
+// linearWidth reports whether a hinted width is close enough to the
+// linearly scaled one. Above 50 ppem the two may differ by 2%, as Windows
+// allows.
+func linearWidth(hinted, linear int, ppem int) bool {
+	if hinted == linear {
+		return true
+	}
+	d := hinted - linear
+	if d < 0 {
+		d = -d
+	}
+	return ppem >= 50 && d*50 <= linear
+}
+
+// GenerateLtsh replaces the LTSH table by hinting every glyph at each size
+// from 1 to 254 ppem. A glyph's threshold is the size above the largest one
+// where its hinted advance differs from the linearly scaled advance; it is 1
+// when the two always agree and 255 when they differ at 254 ppem.
+func (f *Font) GenerateLtsh() error {
+	if f.fontInfo == nil {
+		return errors.New("fontInfo is nil, call GetFontInfo first")
+	}
+	numGlyphs := f.numGlyphs()
+	ltsh := &Ltsh{YPels: make([]uint8, numGlyphs)}
+	for gid := range ltsh.YPels {
+		ltsh.YPels[gid] = 1
+	}
+	settled := make([]bool, numGlyphs)
+	for ppem := 254; ppem >= 1; ppem-- {
+		hinted, err := f.deviceWidths(ppem, true)
+		if err != nil {
+			return err
+		}
+		linear, err := f.deviceWidths(ppem, false)
+		if err != nil {
+			return err
+		}
+		for gid := range hinted {
+			if !settled[gid] && !linearWidth(hinted[gid], linear[gid], ppem) {
+				ltsh.YPels[gid] = uint8(ppem + 1)
+				settled[gid] = true
+			}
+		}
+	}
+	f.fontInfo.Tables.Ltsh = ltsh
+	return nil
+}
+
+// GenerateVdmx replaces the VDMX table with the highest and lowest pixel
+// rows any glyph reaches at each size from startSize to endSize ppem,
+// either grid-fitted by the hinting interpreter or, when hinted is false,
+// scaled from the glyph bounding boxes. The one group applies to a 1:1
+// aspect ratio and, through a 0:0:0 ratio, to every other.
+func (f *Font) GenerateVdmx(startSize, endSize int, hinted bool) error {
+	if f.fontInfo == nil {
+		return errors.New("fontInfo is nil, call GetFontInfo first")
+	}
+	if startSize < 1 || endSize > 255 || startSize > endSize {
+		return errors.New("VDMX sizes " + strconv.Itoa(startSize) + "-" + strconv.Itoa(endSize) + " out of range")
+	}
+	tables := f.fontInfo.Tables
+	if tables.Head == nil || tables.Head.UnitsPerEm == 0 {
+		return errors.New("head is needed to scale extents")
+	}
+	upem := int64(tables.Head.UnitsPerEm)
+	numGlyphs := f.numGlyphs()
+	group := VdmxGroup{StartSize: uint8(startSize), EndSize: uint8(endSize)}
+	for ppem := startSize; ppem <= endSize; ppem++ {
+		var yMax, yMin int
+		if hinted {
+			h, err := f.NewHinter(ppem)
+			if err != nil {
+				return err
+			}
+			for gid := 0; gid < numGlyphs; gid++ {
+				g, err := h.Glyph(gid)
+				if err != nil {
+					return err
+				}
+				for _, p := range g.Points {
+					// round outward to whole pixel rows
+					if top := int((p.Y + 63) >> 6); top > yMax {
+						yMax = top
+					}
+					if bottom := int(p.Y >> 6); bottom < yMin {
+						yMin = bottom
+					}
+				}
+			}
+		} else {
+			ceil := func(v int64) int {
+				n := v * int64(ppem)
+				if n > 0 {
+					return int((n + upem - 1) / upem)
+				}
+				return int(n / upem)
+			}
+			floor := func(v int64) int { return -ceil(-v) }
+			boxes := [][2]int16{{tables.Head.YMax, tables.Head.YMin}}
+			if glyphs := f.fontInfo.Glyphs; glyphs != nil {
+				boxes = boxes[:0]
+				for _, s := range glyphs.Simples {
+					boxes = append(boxes, [2]int16{s.YMax, s.YMin})
+				}
+				for _, c := range glyphs.Compounds {
+					boxes = append(boxes, [2]int16{c.YMax, c.YMin})
+				}
+			}
+			for _, b := range boxes {
+				if top := ceil(int64(b[0])); top > yMax {
+					yMax = top
+				}
+				if bottom := floor(int64(b[1])); bottom < yMin {
+					yMin = bottom
+				}
+			}
+		}
+		group.Records = append(group.Records, VdmxRecord{YPelHeight: uint16(ppem), YMax: int16(yMax), YMin: int16(yMin)})
+	}
+	tables.Vdmx = &Vdmx{
+		Version: 1,
+		Ratios: []VdmxRatio{
+			{CharSet: VDMX_CHARSET_ALL, XRatio: 1, YStartRatio: 1, YEndRatio: 1},
+			{CharSet: VDMX_CHARSET_ALL},
+		},
+		Groups: []VdmxGroup{group},
+	}
+	return nil
+}
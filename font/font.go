@@ -3,9 +3,12 @@ package font
 import (
 	"errors"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 type TagItem struct {
@@ -15,19 +18,41 @@ type TagItem struct {
 }
 
 type Tables struct {
-	Head *Head      `json:"head"`
-	Maxp *Maxp      `json:"maxp"`
-	Loca []int      `json:"loca"`
-	Cmap *Cmap      `json:"cmap,omitempty"`
-	Name *NameTable `json:"name,omitempty"`
-	Hhea *Hhea      `json:"hhea,omitempty"`
-	Hmtx *Hmtx      `json:"hmtx,omitempty"`
-	Kern *Kern      `json:"kern,omitempty"`
-	Os2  *OS2       `json:"os2"`
-	Post *Post      `json:"post"`
-	Fvar *Fvar      `json:"fvar"`
-	Ltag *Ltag      `json:"ltag,omitempty"`
-	Meta *Meta      `json:"meta"`
+	Head *Head         `json:"head"`
+	Maxp *Maxp         `json:"maxp"`
+	Loca []int         `json:"loca"`
+	Cmap *Cmap         `json:"cmap,omitempty"`
+	Name *NameTable    `json:"name,omitempty"`
+	Hhea *Hhea         `json:"hhea,omitempty"`
+	Hmtx *Hmtx         `json:"hmtx,omitempty"`
+	Vhea *Vhea         `json:"vhea,omitempty"`
+	Vmtx *Vmtx         `json:"vmtx,omitempty"`
+	Vorg *Vorg         `json:"vorg,omitempty"`
+	Colr *Colr         `json:"colr,omitempty"`
+	Cpal *Cpal         `json:"cpal,omitempty"`
+	Cbdt *BitmapTables `json:"cbdt,omitempty"`
+	Sbix *Sbix         `json:"sbix,omitempty"`
+	Svg  *Svg          `json:"svg,omitempty"`
+	Ebdt *BitmapTables `json:"ebdt,omitempty"`
+	Ebsc *Ebsc         `json:"ebsc,omitempty"`
+	Cvt  []int16       `json:"cvt,omitempty"`
+	Fpgm []uint8       `json:"fpgm,omitempty"`
+	Prep []uint8       `json:"prep,omitempty"`
+	Gasp *Gasp         `json:"gasp,omitempty"`
+	Hdmx *Hdmx         `json:"hdmx,omitempty"`
+	Ltsh *Ltsh         `json:"ltsh,omitempty"`
+	Vdmx *Vdmx         `json:"vdmx,omitempty"`
+	Kern *Kern         `json:"kern,omitempty"`
+	Os2  *OS2          `json:"os2"`
+	Post *Post         `json:"post"`
+	Fvar *Fvar         `json:"fvar"`
+	Avar *Avar         `json:"avar,omitempty"`
+	Gvar *Gvar         `json:"gvar,omitempty"`
+	Hvar *Hvar         `json:"hvar,omitempty"`
+	Ltag *Ltag         `json:"ltag,omitempty"`
+	Meta *Meta         `json:"meta"`
+	Gdef *Gdef         `json:"gdef,omitempty"`
+	Gpos *Gpos         `json:"gpos,omitempty"`
 }
 
 type FontInfo struct {
@@ -41,6 +66,37 @@ type Font struct {
 	fileByte []byte
 	filePath string
 	fontInfo *FontInfo
+	// names caches the glyph names for GlyphName and GlyphByName
+	names *glyphNameCache
+}
+
+// glyphNameCache holds the names of every glyph and the post, cmap and
+// glyph count they were derived from.
+type glyphNameCache struct {
+	post      *Post
+	cmap      *Cmap
+	numGlyphs int
+	names     []string
+	byName    map[string]int
+}
+
+// cachedGlyphNames returns the cached glyph names, deriving them again when the
+// post or cmap table or the glyph count has changed. Methods that edit post
+// or cmap in place reset f.names.
+func (f *Font) cachedGlyphNames() *glyphNameCache {
+	tables := f.fontInfo.Tables
+	numGlyphs := f.numGlyphs()
+	if c := f.names; c != nil && c.post == tables.Post && c.cmap == tables.Cmap && c.numGlyphs == numGlyphs {
+		return c
+	}
+	c := &glyphNameCache{post: tables.Post, cmap: tables.Cmap, numGlyphs: numGlyphs}
+	c.names = glyphNames(tables.Post, tables.Cmap, numGlyphs)
+	c.byName = make(map[string]int, len(c.names))
+	for gid := len(c.names) - 1; gid >= 0; gid-- {
+		c.byName[c.names[gid]] = gid
+	}
+	f.names = c
+	return c
 }
 
 func ReadFontFile(filePath string) (f *Font, err error) {
@@ -67,12 +123,36 @@ func (f *Font) GetFontInfo() (fontInfo *FontInfo, err error) {
 	nameInfo, existName := tableContent["name"]
 	hheaInfo, existHhea := tableContent["hhea"]
 	hmtxInfo, existHmtx := tableContent["hmtx"]
+	vheaInfo, existVhea := tableContent["vhea"]
+	vmtxInfo, existVmtx := tableContent["vmtx"]
+	vorgInfo, existVorg := tableContent["VORG"]
+	colrInfo, existColr := tableContent["COLR"]
+	cpalInfo, existCpal := tableContent["CPAL"]
+	cblcInfo, existCblc := tableContent["CBLC"]
+	cbdtInfo, existCbdt := tableContent["CBDT"]
+	sbixInfo, existSbix := tableContent["sbix"]
+	svgInfo, existSvg := tableContent["SVG "]
+	eblcInfo, existEblc := tableContent["EBLC"]
+	ebdtInfo, existEbdt := tableContent["EBDT"]
+	ebscInfo, existEbsc := tableContent["EBSC"]
+	cvtInfo, existCvt := tableContent["cvt "]
+	fpgmInfo, existFpgm := tableContent["fpgm"]
+	prepInfo, existPrep := tableContent["prep"]
+	gaspInfo, existGasp := tableContent["gasp"]
+	hdmxInfo, existHdmx := tableContent["hdmx"]
+	ltshInfo, existLtsh := tableContent["LTSH"]
+	vdmxInfo, existVdmx := tableContent["VDMX"]
 	kernInfo, existKern := tableContent["kern"]
 	os2Info, existOs2 := tableContent["OS/2"]
 	postInfo, existPost := tableContent["post"]
 	fvarInfo, existFvar := tableContent["fvar"]
+	avarInfo, existAvar := tableContent["avar"]
+	gvarInfo, existGvar := tableContent["gvar"]
+	hvarInfo, existHvar := tableContent["HVAR"]
 	itagInfo, existLtag := tableContent["Ltag"]
 	metaInfo, existMeta := tableContent["meta"]
+	gdefInfo, existGdef := tableContent["GDEF"]
+	gposInfo, existGpos := tableContent["GPOS"]
 	// add test
 
 	// tables content
@@ -102,8 +182,121 @@ func (f *Font) GetFontInfo() (fontInfo *FontInfo, err error) {
 		tables.Hmtx = GetHmtx(fileByte, int(hmtxInfo.Offset), int(tables.Hhea.NumOfLongHorMetrics), int(tables.Maxp.NumGlyphs))
 	}
 
+	if existVhea {
+		vhea, vheaErr := GetVhea(fileByte, int(vheaInfo.Offset))
+		if vheaErr == nil {
+			tables.Vhea = vhea
+		}
+	}
+
+	if existVmtx && tables.Vhea != nil && existMaxp {
+		vmtx, vmtxErr := GetVmtx(fileByte, int(vmtxInfo.Offset), int(tables.Vhea.NumOfLongVerMetrics), int(tables.Maxp.NumGlyphs))
+		if vmtxErr == nil {
+			tables.Vmtx = vmtx
+		}
+	}
+
+	if existVorg {
+		vorg, vorgErr := GetVorg(fileByte, int(vorgInfo.Offset))
+		if vorgErr == nil {
+			tables.Vorg = vorg
+		}
+	}
+
+	if existColr {
+		colr, colrErr := GetColr(fileByte, int(colrInfo.Offset))
+		if colrErr == nil {
+			tables.Colr = colr
+		}
+	}
+
+	if existCpal {
+		cpal, cpalErr := GetCpal(fileByte, int(cpalInfo.Offset))
+		if cpalErr == nil {
+			tables.Cpal = cpal
+		}
+	}
+
+	if existCblc && existCbdt {
+		cbdt, cbdtErr := GetCbdt(fileByte, int(cblcInfo.Offset), int(cbdtInfo.Offset))
+		if cbdtErr == nil {
+			tables.Cbdt = cbdt
+		}
+	}
+
+	if existSbix && tables.Maxp != nil {
+		sbix, sbixErr := GetSbix(fileByte, int(sbixInfo.Offset), int(tables.Maxp.NumGlyphs))
+		if sbixErr == nil {
+			tables.Sbix = sbix
+		}
+	}
+
+	if existSvg {
+		svg, svgErr := GetSvg(fileByte, int(svgInfo.Offset))
+		if svgErr == nil {
+			tables.Svg = svg
+		}
+	}
+
+	if existEblc && existEbdt {
+		ebdt, ebdtErr := GetEbdt(fileByte, int(eblcInfo.Offset), int(ebdtInfo.Offset))
+		if ebdtErr == nil {
+			tables.Ebdt = ebdt
+		}
+	}
+
+	if existEbsc {
+		ebsc, ebscErr := GetEbsc(fileByte, int(ebscInfo.Offset))
+		if ebscErr == nil {
+			tables.Ebsc = ebsc
+		}
+	}
+
+	if existCvt {
+		tables.Cvt = GetCvt(fileByte, int(cvtInfo.Offset), int(cvtInfo.Length))
+	}
+
+	if existFpgm {
+		tables.Fpgm = GetProgram(fileByte, int(fpgmInfo.Offset), int(fpgmInfo.Length))
+	}
+
+	if existPrep {
+		tables.Prep = GetProgram(fileByte, int(prepInfo.Offset), int(prepInfo.Length))
+	}
+
+	if existGasp {
+		gasp, gaspErr := GetGasp(fileByte, int(gaspInfo.Offset))
+		if gaspErr == nil {
+			tables.Gasp = gasp
+		}
+	}
+
+	if existHdmx && tables.Maxp != nil {
+		hdmx, hdmxErr := GetHdmx(fileByte, int(hdmxInfo.Offset), int(tables.Maxp.NumGlyphs))
+		if hdmxErr == nil {
+			tables.Hdmx = hdmx
+		}
+	}
+
+	if existLtsh {
+		ltsh, ltshErr := GetLtsh(fileByte, int(ltshInfo.Offset))
+		if ltshErr == nil {
+			tables.Ltsh = ltsh
+		}
+	}
+
+	if existVdmx {
+		vdmx, vdmxErr := GetVdmx(fileByte, int(vdmxInfo.Offset))
+		if vdmxErr == nil {
+			tables.Vdmx = vdmx
+		}
+	}
+
 	if existKern {
-		tables.Kern, err = GetKern(fileByte, int(kernInfo.Offset))
+		kern, kernErr := GetKern(fileByte, int(kernInfo.Offset))
+		if kernErr == nil {
+			tables.Kern = kern
+		}
 	}
 
 	if existOs2 {
@@ -118,6 +311,27 @@ func (f *Font) GetFontInfo() (fontInfo *FontInfo, err error) {
 		tables.Fvar = GetFvar(fileByte, int(fvarInfo.Offset))
 	}
 
+	if existAvar {
+		avar, avarErr := GetAvar(fileByte, int(avarInfo.Offset))
+		if avarErr == nil {
+			tables.Avar = avar
+		}
+	}
+
+	if existGvar {
+		gvar, gvarErr := GetGvar(fileByte, int(gvarInfo.Offset))
+		if gvarErr == nil {
+			tables.Gvar = gvar
+		}
+	}
+
+	if existHvar {
+		hvar, hvarErr := GetHvar(fileByte, int(hvarInfo.Offset))
+		if hvarErr == nil {
+			tables.Hvar = hvar
+		}
+	}
+
 	if existLtag {
 		ltag, ltagErr := GetLtag(fileByte, int(itagInfo.Offset))
 		if ltagErr == nil {
@@ -133,6 +347,20 @@ func (f *Font) GetFontInfo() (fontInfo *FontInfo, err error) {
 		}
 	}
 
+	if existGdef {
+		gdef, gdefErr := GetGdef(fileByte, int(gdefInfo.Offset))
+		if gdefErr == nil {
+			tables.Gdef = gdef
+		}
+	}
+
+	if existGpos {
+		gpos, gposErr := GetGpos(fileByte, int(gposInfo.Offset))
+		if gposErr == nil {
+			tables.Gpos = gpos
+		}
+	}
+
 	fontInfo = new(FontInfo)
 
 	fontInfo.OffsetTable = offsetTable
@@ -142,17 +370,42 @@ func (f *Font) GetFontInfo() (fontInfo *FontInfo, err error) {
 	fontInfo.Glyphs = GetGlyphs(fileByte, int(glyfStart), tables.Loca, int(tables.Maxp.NumGlyphs))
 
 	f.fontInfo = fontInfo
+	f.names = nil
 
 	return
 }
 
-func (f *Font) Write(filePath string) (err error) {
+// WriteOptions controls Write.
+type WriteOptions struct {
+	// RecalculateMetrics runs RecalculateMetrics before the tables are
+	// written.
+	RecalculateMetrics bool
+	// StripHinting runs StripHinting before the tables are written.
+	StripHinting bool
+}
+
+func (f *Font) Write(filePath string, opts ...*WriteOptions) (err error) {
 	ext := filepath.Ext(filePath)
 	if ext != ".ttf" {
 		err = errors.New("Not support format!")
 		return
 	}
-	supportTable := []string{"cmap", "fvar", "glyf", "head", "hhea", "hmtx", "kern", "Ltag", "loca", "maxp", "meta", "name", "OS/2", "post"}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.StripHinting {
+			if err = f.StripHinting(); err != nil {
+				return
+			}
+		}
+		if opt.RecalculateMetrics {
+			if err = f.RecalculateMetrics(); err != nil {
+				return
+			}
+		}
+	}
+	supportTable := []string{"avar", "CBDT", "CBLC", "cmap", "COLR", "CPAL", "cvt ", "EBDT", "EBLC", "EBSC", "fpgm", "fvar", "gasp", "GDEF", "glyf", "GPOS", "gvar", "hdmx", "head", "hhea", "hmtx", "HVAR", "kern", "Ltag", "loca", "LTSH", "maxp", "meta", "name", "OS/2", "post", "prep", "sbix", "SVG ", "VDMX", "vhea", "vmtx", "VORG"}
 
 	fontInfo := f.fontInfo
 	data := []byte{}
@@ -190,6 +443,30 @@ func (f *Font) Write(filePath string) (err error) {
 				continue
 			}
 			td = WriteFvar(fontInfo.Tables.Fvar)
+		case "avar":
+			if fontInfo.Tables.Avar == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteAvar(fontInfo.Tables.Avar)
+		case "gvar":
+			if fontInfo.Tables.Gvar == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteGvar(fontInfo.Tables.Gvar, f.numGlyphs())
+		case "HVAR":
+			if fontInfo.Tables.Hvar == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteHvar(fontInfo.Tables.Hvar)
+		case "GDEF":
+			if fontInfo.Tables.Gdef == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteGdef(fontInfo.Tables.Gdef)
 		case "glyf":
 			if fontInfo.Glyphs == nil {
 				log.Printf("[WARN] table %s data missing, continue", tag)
@@ -201,6 +478,12 @@ func (f *Font) Write(filePath string) (err error) {
 				log.Printf("[WARN] table %s write failed: %v", tag, err)
 				continue
 			}
+		case "GPOS":
+			if fontInfo.Tables.Gpos == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteGpos(fontInfo.Tables.Gpos)
 		case "head":
 			if fontInfo.Tables.Head == nil {
 				log.Printf("[WARN] table %s data missing, continue", tag)
@@ -214,7 +497,12 @@ func (f *Font) Write(filePath string) (err error) {
 				log.Printf("[WARN] table %s data missing, continue", tag)
 				continue
 			}
-			td = WriteHhea(fontInfo.Tables.Hhea)
+			hhea := *fontInfo.Tables.Hhea
+			if fontInfo.Tables.Hmtx != nil {
+				// Match the long metrics WriteHmtx keeps
+				hhea.NumOfLongHorMetrics = uint16(fontInfo.Tables.Hmtx.NumberOfHMetrics())
+			}
+			td = WriteHhea(&hhea)
 		case "hmtx":
 			if fontInfo.Tables.Hmtx == nil {
 				log.Printf("[WARN] table %s data missing, continue", tag)
@@ -273,6 +561,129 @@ func (f *Font) Write(filePath string) (err error) {
 				continue
 			}
 			td = WritePost(fontInfo.Tables.Post)
+		case "vhea":
+			if fontInfo.Tables.Vhea == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			vhea := *fontInfo.Tables.Vhea
+			if fontInfo.Tables.Vmtx != nil {
+				// Match the long metrics WriteVmtx keeps
+				vhea.NumOfLongVerMetrics = uint16(fontInfo.Tables.Vmtx.NumberOfVMetrics())
+			}
+			td = WriteVhea(&vhea)
+		case "vmtx":
+			if fontInfo.Tables.Vmtx == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteVmtx(fontInfo.Tables.Vmtx)
+		case "VORG":
+			if fontInfo.Tables.Vorg == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteVorg(fontInfo.Tables.Vorg)
+		case "COLR":
+			if fontInfo.Tables.Colr == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td, err = WriteColr(fontInfo.Tables.Colr)
+			if err != nil {
+				log.Printf("[WARN] table %s write failed: %v", tag, err)
+				continue
+			}
+		case "CPAL":
+			if fontInfo.Tables.Cpal == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteCpal(fontInfo.Tables.Cpal)
+		case "CBDT":
+			if fontInfo.Tables.Cbdt == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteCbdt(fontInfo.Tables.Cbdt)
+		case "CBLC":
+			if fontInfo.Tables.Cbdt == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteCblc(fontInfo.Tables.Cbdt)
+		case "sbix":
+			if fontInfo.Tables.Sbix == nil || fontInfo.Tables.Maxp == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteSbix(fontInfo.Tables.Sbix, int(fontInfo.Tables.Maxp.NumGlyphs))
+		case "SVG ":
+			if fontInfo.Tables.Svg == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteSvg(fontInfo.Tables.Svg)
+		case "EBDT":
+			if fontInfo.Tables.Ebdt == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteEbdt(fontInfo.Tables.Ebdt)
+		case "EBLC":
+			if fontInfo.Tables.Ebdt == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteEblc(fontInfo.Tables.Ebdt)
+		case "EBSC":
+			if fontInfo.Tables.Ebsc == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteEbsc(fontInfo.Tables.Ebsc)
+		case "cvt ":
+			if fontInfo.Tables.Cvt == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteCvt(fontInfo.Tables.Cvt)
+		case "fpgm":
+			if fontInfo.Tables.Fpgm == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteProgram(fontInfo.Tables.Fpgm)
+		case "prep":
+			if fontInfo.Tables.Prep == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteProgram(fontInfo.Tables.Prep)
+		case "gasp":
+			if fontInfo.Tables.Gasp == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteGasp(fontInfo.Tables.Gasp)
+		case "hdmx":
+			if fontInfo.Tables.Hdmx == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteHdmx(fontInfo.Tables.Hdmx, f.numGlyphs())
+		case "LTSH":
+			if fontInfo.Tables.Ltsh == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteLtsh(fontInfo.Tables.Ltsh)
+		case "VDMX":
+			if fontInfo.Tables.Vdmx == nil {
+				log.Printf("[WARN] table %s data missing, continue", tag)
+				continue
+			}
+			td = WriteVdmx(fontInfo.Tables.Vdmx)
 		default:
 			log.Printf("[WARN] table %s not handled, continue", tag)
 			continue
@@ -347,6 +758,7 @@ func (f *Font) Subset(chars []string) error {
 		return errors.New("fontInfo is nil, call GetFontInfo first")
 	}
 	fontInfo := f.fontInfo
+	f.names = nil
 
 	if fontInfo.Tables.Cmap == nil || fontInfo.Tables.Cmap.WindowsCode == nil {
 		return errors.New("cmap table or WindowsCode is nil")
@@ -360,14 +772,23 @@ func (f *Font) Subset(chars []string) error {
 	for _, char := range chars {
 		for _, r := range char {
 			unicode := int(r)
-			if glyphIndex, ok := fontInfo.Tables.Cmap.WindowsCode[unicode]; ok {
+			if glyphIndex, ok := fontInfo.Tables.Cmap.GlyphIndex(unicode); ok {
 				neededGlyphSet[glyphIndex] = true
 			}
+			// Keep the variant glyphs of every variation sequence on this base
+			for _, selector := range fontInfo.Tables.Cmap.UVS {
+				if glyphIndex, ok := selector.NonDefaultUVS[unicode]; ok {
+					neededGlyphSet[glyphIndex] = true
+				}
+			}
 		}
 	}
 
-	// Step 2: Resolve compound glyph dependencies
-	// Compound glyphs reference other glyphs, we need to include those too
+	// Step 2: Resolve compound glyph and color glyph dependencies
+	// Compound glyphs reference other glyphs, COLR color glyphs draw with
+	// layer and paint glyphs, composite EBDT bitmaps are built from other
+	// glyphs' bitmaps and sbix images can be dupes of other glyphs' images,
+	// we need to include those too
 	compoundMap := make(map[int]*GlyphCompound)
 	for i := range fontInfo.Glyphs.Compounds {
 		c := &fontInfo.Glyphs.Compounds[i]
@@ -379,13 +800,25 @@ func (f *Font) Subset(chars []string) error {
 	for changed {
 		changed = false
 		for glyphIdx := range neededGlyphSet {
+			var refs []int
 			if compound, ok := compoundMap[glyphIdx]; ok {
 				for _, comp := range compound.Component {
-					refIdx := int(comp.GlyphIndex)
-					if !neededGlyphSet[refIdx] {
-						neededGlyphSet[refIdx] = true
-						changed = true
-					}
+					refs = append(refs, int(comp.GlyphIndex))
+				}
+			}
+			if fontInfo.Tables.Colr != nil {
+				refs = append(refs, fontInfo.Tables.Colr.ColorGlyphs(glyphIdx)...)
+			}
+			if fontInfo.Tables.Ebdt != nil {
+				refs = append(refs, fontInfo.Tables.Ebdt.ComponentGlyphs(glyphIdx)...)
+			}
+			if fontInfo.Tables.Sbix != nil {
+				refs = append(refs, fontInfo.Tables.Sbix.DupeGlyphs(glyphIdx)...)
+			}
+			for _, refIdx := range refs {
+				if !neededGlyphSet[refIdx] {
+					neededGlyphSet[refIdx] = true
+					changed = true
 				}
 			}
 		}
@@ -439,34 +872,36 @@ func (f *Font) Subset(chars []string) error {
 	}
 	fontInfo.Tables.Cmap.WindowsCode = newWindowsCode
 
-	// Rebuild cmap subtables for format 4 and 12
-	if err := rebuildCmapSubtables(fontInfo.Tables.Cmap, newWindowsCode); err != nil {
+	// Re-encode every cmap subtable for the new glyph order
+	if err := rebuildCmapSubtables(fontInfo.Tables.Cmap, oldToNew, newWindowsCode); err != nil {
 		return err
 	}
 
-	// Step 6: Build new hmtx
+	// Rebuild the format 14 subtable for the retained variation sequences
+	remapCmapUVS(fontInfo.Tables.Cmap, oldToNew)
+	syncCmapFormat14(fontInfo.Tables.Cmap)
+
+	// Step 6: Build new hmtx and vmtx
 	if fontInfo.Tables.Hmtx != nil {
 		oldHmtx := fontInfo.Tables.Hmtx
 		newHmtx := &Hmtx{}
-
 		for _, oldIdx := range oldIndices {
-			if oldIdx < len(oldHmtx.HMetrics) {
-				newHmtx.HMetrics = append(newHmtx.HMetrics, oldHmtx.HMetrics[oldIdx])
-			} else {
-				// Use last advanceWidth with LeftSideBearing from array
-				lsbIdx := oldIdx - len(oldHmtx.HMetrics)
-				if lsbIdx < len(oldHmtx.LeftSideBearing) {
-					lastMetric := oldHmtx.HMetrics[len(oldHmtx.HMetrics)-1]
-					newHmtx.HMetrics = append(newHmtx.HMetrics, &LongHorMetric{
-						AdvanceWidth:    lastMetric.AdvanceWidth,
-						LeftSideBearing: oldHmtx.LeftSideBearing[lsbIdx],
-					})
-				}
-			}
+			advance, lsb := oldHmtx.Metric(oldIdx)
+			newHmtx.HMetrics = append(newHmtx.HMetrics, &LongHorMetric{AdvanceWidth: advance, LeftSideBearing: lsb})
 		}
+		newHmtx.compact()
 		fontInfo.Tables.Hmtx = newHmtx
-		// Clear LeftSideBearing since all metrics are now in HMetrics
-		fontInfo.Tables.Hmtx.LeftSideBearing = nil
+	}
+
+	if fontInfo.Tables.Vmtx != nil {
+		oldVmtx := fontInfo.Tables.Vmtx
+		newVmtx := &Vmtx{}
+		for _, oldIdx := range oldIndices {
+			advance, tsb := oldVmtx.Metric(oldIdx)
+			newVmtx.VMetrics = append(newVmtx.VMetrics, &LongVerMetric{AdvanceHeight: advance, TopSideBearing: tsb})
+		}
+		newVmtx.compact()
+		fontInfo.Tables.Vmtx = newVmtx
 	}
 
 	// Step 7: Update maxp.NumGlyphs
@@ -474,243 +909,785 @@ func (f *Font) Subset(chars []string) error {
 		fontInfo.Tables.Maxp.NumGlyphs = uint16(len(oldIndices))
 	}
 
-	// Step 8: Update hhea.NumOfLongHorMetrics
+	// Step 8: Update hhea.NumOfLongHorMetrics and vhea.NumOfLongVerMetrics
 	if fontInfo.Tables.Hhea != nil && fontInfo.Tables.Hmtx != nil {
 		fontInfo.Tables.Hhea.NumOfLongHorMetrics = uint16(len(fontInfo.Tables.Hmtx.HMetrics))
 	}
+	if fontInfo.Tables.Vhea != nil && fontInfo.Tables.Vmtx != nil {
+		fontInfo.Tables.Vhea.NumOfLongVerMetrics = uint16(len(fontInfo.Tables.Vmtx.VMetrics))
+	}
 
 	// Step 9: Rebuild loca (will be done during Write)
 	fontInfo.Tables.Loca = nil
 
+	// Step 10: Remap GDEF glyph classes, attachment points and caret lists
+	if fontInfo.Tables.Gdef != nil {
+		remapGdef(fontInfo.Tables.Gdef, oldToNew)
+	}
+
+	// Step 11: Remap kerning pairs and class tables
+	if fontInfo.Tables.Kern != nil {
+		remapKern(fontInfo.Tables.Kern, oldToNew, len(oldIndices))
+	}
+
+	// Step 12: Remap GPOS subtables
+	if fontInfo.Tables.Gpos != nil {
+		if err := remapGpos(fontInfo.Tables.Gpos, oldToNew); err != nil {
+			return err
+		}
+	}
+
+	// Step 13: Reorder glyph names
+	if fontInfo.Tables.Post != nil {
+		remapPost(fontInfo.Tables.Post, oldIndices)
+	}
+
+	// Step 14: Remap vertical origins
+	if fontInfo.Tables.Vorg != nil {
+		remapVorg(fontInfo.Tables.Vorg, oldToNew)
+	}
+
+	// Step 15: Remap color glyphs; CPAL palettes are not glyph indexed
+	if fontInfo.Tables.Colr != nil {
+		remapColr(fontInfo.Tables.Colr, oldToNew)
+	}
+
+	// Step 16: Remap bitmap glyph images
+	if fontInfo.Tables.Cbdt != nil {
+		remapBitmapStrikes(fontInfo.Tables.Cbdt, oldToNew)
+	}
+	if fontInfo.Tables.Ebdt != nil {
+		remapBitmapStrikes(fontInfo.Tables.Ebdt, oldToNew)
+	}
+	if fontInfo.Tables.Sbix != nil {
+		remapSbix(fontInfo.Tables.Sbix, oldToNew)
+	}
+
+	// Step 17: Rewrite SVG documents to the retained glyph elements
+	if fontInfo.Tables.Svg != nil {
+		remapSvg(fontInfo.Tables.Svg, oldToNew)
+	}
+
+	// Step 18: Reorder device widths and linear thresholds; VDMX extents
+	// are not glyph indexed and still bound the remaining glyphs
+	if fontInfo.Tables.Hdmx != nil {
+		remapHdmx(fontInfo.Tables.Hdmx, oldIndices)
+	}
+	if fontInfo.Tables.Ltsh != nil {
+		remapLtsh(fontInfo.Tables.Ltsh, oldIndices)
+	}
+
+	// Step 19: Reorder glyph variations and give HVAR rows to the
+	// retained glyphs
+	if fontInfo.Tables.Gvar != nil {
+		remapGvar(fontInfo.Tables.Gvar, oldIndices)
+	}
+	if fontInfo.Tables.Hvar != nil {
+		remapHvar(fontInfo.Tables.Hvar, oldIndices)
+	}
+
 	return nil
 }
 
-// rebuildCmapSubtables rebuilds cmap subtables based on the new unicode->glyph mapping
-func rebuildCmapSubtables(cmap *Cmap, unicodeToGlyph map[int]int) error {
-	// Collect all unicode code points and sort them
-	unicodes := make([]int, 0, len(unicodeToGlyph))
-	for u := range unicodeToGlyph {
-		unicodes = append(unicodes, u)
+// RecalculateMetrics derives the metrics that edits and subsetting leave
+// stale from the glyph outlines, hmtx, vmtx and cmap: every glyph's bounding
+// box, the head bounding box, the hhea and vhea extremes, the maxp outline
+// limits, and OS/2 xAvgCharWidth, first and last character index, Unicode
+// and code page ranges and usMaxContext, which only ever grows since GSUB
+// is not inspected. Left side bearings of outlined glyphs are set to their
+// xMin.
+func (f *Font) RecalculateMetrics() error {
+	if f.fontInfo == nil {
+		return errors.New("fontInfo is nil, call GetFontInfo first")
+	}
+	tables := f.fontInfo.Tables
+	glyphs := f.fontInfo.Glyphs
+	if glyphs == nil {
+		glyphs = &Glyphs{}
 	}
-	sort.Ints(unicodes)
+	numGlyphs := f.numGlyphs()
+	gs := newGlyphSet(glyphs)
 
-	// Only keep format 4 and 12 subtables, rebuild them
-	newSubTables := make([]map[string]interface{}, 0)
-	hasFormat4 := false
-	hasFormat12 := false
+	xMin, yMin, xMax, yMax, _ := recalcGlyphBounds(glyphs, gs)
+	if tables.Head != nil {
+		tables.Head.XMin, tables.Head.YMin, tables.Head.XMax, tables.Head.YMax = xMin, yMin, xMax, yMax
+	}
+	if tables.Maxp != nil {
+		recalcMaxp(tables.Maxp, glyphs, gs)
+	}
+	if tables.Hhea != nil && tables.Hmtx != nil {
+		recalcHhea(tables.Hhea, tables.Hmtx, numGlyphs, gs)
+	}
+	if tables.Vhea != nil && tables.Vmtx != nil {
+		recalcVhea(tables.Vhea, tables.Vmtx, numGlyphs, gs)
+	}
 
-	for _, subTable := range cmap.SubTables {
-		format, ok := subTable["format"].(uint16)
-		if !ok {
-			continue
+	if os2 := tables.Os2; os2 != nil {
+		if tables.Hmtx != nil {
+			os2.XAvgCharWidth = avgCharWidth(os2.Version, tables.Hmtx, numGlyphs, tables.Cmap)
+		}
+		if tables.Cmap != nil {
+			codes := sortedCmapCodes(tables.Cmap.WindowsCode, 0x110000)
+			os2.FsFirstCharIndex, os2.FsLastCharIndex = 0, 0
+			if len(codes) > 0 {
+				os2.FsFirstCharIndex = uint16(codes[0])
+				os2.FsLastCharIndex = 0xFFFF
+				if last := codes[len(codes)-1]; last < 0xFFFF {
+					os2.FsLastCharIndex = uint16(last)
+				}
+				if codes[0] > 0xFFFF {
+					os2.FsFirstCharIndex = 0xFFFF
+				}
+			}
+			os2.UlUnicodeRange = unicodeRanges(codes)
+			if os2.Version >= 1 {
+				os2.UlCodePageRange = codePageRanges(codes)
+			}
+		}
+		if os2.Version >= 2 {
+			os2.UsMaxContext = maxContext(tables.Gpos, os2.UsMaxContext)
+		}
+	}
+	return nil
+}
+
+// SynthesizeVerticalMetrics adds vhea and vmtx tables to a font that lacks
+// them. Every glyph advances by the OS/2 typographic ascender minus
+// descender (hhea ascent and descent without OS/2), with its vertical origin
+// on the ascender, so the top side bearing is the ascender minus the glyph's
+// yMax. Existing vertical metrics are left alone.
+func (f *Font) SynthesizeVerticalMetrics() error {
+	if f.fontInfo == nil {
+		return errors.New("fontInfo is nil, call GetFontInfo first")
+	}
+	tables := f.fontInfo.Tables
+	if tables.Vmtx != nil {
+		return nil
+	}
+	var ascender, descender, lineGap int
+	switch {
+	case tables.Os2 != nil:
+		ascender, descender, lineGap = int(tables.Os2.STypoAscender), int(tables.Os2.STypoDescender), int(tables.Os2.STypoLineGap)
+	case tables.Hhea != nil:
+		ascender, descender, lineGap = int(tables.Hhea.Ascent), int(tables.Hhea.Descent), int(tables.Hhea.LineGap)
+	default:
+		return errors.New("OS/2 and hhea tables are nil")
+	}
+	advance := uint16(ascender - descender)
+
+	numGlyphs := f.numGlyphs()
+	gs := newGlyphSet(f.fontInfo.Glyphs)
+	vmtx := &Vmtx{}
+	for gid := 0; gid < numGlyphs; gid++ {
+		metric := &LongVerMetric{AdvanceHeight: advance}
+		if _, _, _, yMax, ok := gs.outline(gid, 0).bounds(); ok {
+			metric.TopSideBearing = int16(ascender - int(yMax))
 		}
+		vmtx.VMetrics = append(vmtx.VMetrics, metric)
+	}
+	vmtx.compact()
+
+	vhea := &Vhea{
+		Version:             1.0,
+		Ascent:              int16(advance / 2),
+		Descent:             -int16(advance / 2),
+		LineGap:             int16(lineGap),
+		CaretSlopeRun:       1,
+		NumOfLongVerMetrics: uint16(len(vmtx.VMetrics)),
+	}
+	recalcVhea(vhea, vmtx, numGlyphs, gs)
+	tables.Vhea, tables.Vmtx = vhea, vmtx
+	return nil
+}
+
+// StripHinting removes all TrueType hinting: the cvt, fpgm and prep
+// tables, every glyph's instructions and the maxp limits that only the
+// instructions use, along with the hdmx, LTSH and VDMX device metrics
+// measured from hinted outlines. The head flag saying instructions may
+// alter advance widths is cleared. A gasp table is kept but reduced to one
+// range asking for smoothing at all sizes, since grid-fitting no longer
+// applies.
+func (f *Font) StripHinting() error {
+	if f.fontInfo == nil {
+		return errors.New("fontInfo is nil, call GetFontInfo first")
+	}
+	tables := f.fontInfo.Tables
+	tables.Cvt = nil
+	tables.Fpgm = nil
+	tables.Prep = nil
+	tables.Hdmx = nil
+	tables.Ltsh = nil
+	tables.Vdmx = nil
+	if tables.Head != nil {
+		tables.Head.Flags &^= HEAD_INSTRUCTIONS_ALTER_ADVANCE
+	}
 
-		switch format {
-		case 4:
-			if !hasFormat4 {
-				newSubTables = append(newSubTables, buildCmapFormat4(subTable, unicodeToGlyph, unicodes))
-				hasFormat4 = true
+	if glyphs := f.fontInfo.Glyphs; glyphs != nil {
+		for i := range glyphs.Simples {
+			glyphs.Simples[i].InstructionLength = 0
+			glyphs.Simples[i].Instructions = nil
+		}
+		for i := range glyphs.Compounds {
+			compound := &glyphs.Compounds[i]
+			compound.InstructionLength = 0
+			compound.Instructions = nil
+			for j := range compound.Component {
+				compound.Component[j].Flags &^= WE_HAVE_INSTRUCTIONS
 			}
-		case 12:
-			if !hasFormat12 {
-				newSubTables = append(newSubTables, buildCmapFormat12(subTable, unicodeToGlyph, unicodes))
-				hasFormat12 = true
+		}
+	}
+
+	if maxp := tables.Maxp; maxp != nil && maxp.Version == "1.0" {
+		maxp.MaxZones = 1
+		maxp.MaxTwilightPoints = 0
+		maxp.MaxStorage = 0
+		maxp.MaxFunctionDefs = 0
+		maxp.MaxInstructionDefs = 0
+		maxp.MaxStackElements = 0
+		maxp.MaxSizeOfInstructions = 0
+	}
+
+	if tables.Gasp != nil {
+		tables.Gasp.Version = 1
+		tables.Gasp.Ranges = []GaspRange{{0xFFFF, GASP_DOGRAY | GASP_SYMMETRIC_SMOOTHING}}
+	}
+	return nil
+}
+
+// VerticalOrigin returns the y coordinate of the vertical origin of glyph
+// gid, the point placed on the line in vertical layout. It comes from VORG
+// when the font has one, otherwise from the vmtx top side bearing plus the
+// glyph's yMax, and without vmtx from the ascender, as browsers do.
+func (f *Font) VerticalOrigin(gid int) int {
+	if f.fontInfo == nil {
+		return 0
+	}
+	tables := f.fontInfo.Tables
+	if tables.Vorg != nil {
+		return int(tables.Vorg.OriginY(gid))
+	}
+	if tables.Vmtx != nil {
+		_, tsb := tables.Vmtx.Metric(gid)
+		var yMax int16
+		if common := f.fontInfo.Glyphs.common(gid); common != nil {
+			yMax = common.YMax
+		}
+		return int(tsb) + int(yMax)
+	}
+	if tables.Os2 != nil {
+		return int(tables.Os2.STypoAscender)
+	}
+	if tables.Hhea != nil {
+		return int(tables.Hhea.Ascent)
+	}
+	return 0
+}
+
+// GlyphClass returns the GDEF glyph class (GDEF_CLASS_BASE, GDEF_CLASS_LIGATURE,
+// GDEF_CLASS_MARK or GDEF_CLASS_COMPONENT) of glyph gid, or 0 when the font has
+// no GDEF class definition for it.
+func (f *Font) GlyphClass(gid int) uint16 {
+	if f.fontInfo == nil || f.fontInfo.Tables.Gdef == nil {
+		return 0
+	}
+	return f.fontInfo.Tables.Gdef.GlyphClassDef[uint16(gid)]
+}
+
+// Kerning returns the horizontal kerning adjustment between the left and
+// right glyph IDs, resolved across all kern subtables (formats 0, 2 and 3).
+func (f *Font) Kerning(left, right int) int16 {
+	if f.fontInfo == nil || f.fontInfo.Tables.Kern == nil {
+		return 0
+	}
+	return f.fontInfo.Tables.Kern.Kerning(uint16(left), uint16(right))
+}
+
+// GlyphIndex returns the glyph ID the cmap maps r to, resolving symbol fonts
+// through their 0xF000 range. ok is false when r is not mapped.
+func (f *Font) GlyphIndex(r rune) (glyph int, ok bool) {
+	if f.fontInfo == nil || f.fontInfo.Tables.Cmap == nil {
+		return
+	}
+	return f.fontInfo.Tables.Cmap.GlyphIndex(int(r))
+}
+
+// GlyphForVariation returns the glyph for the variation sequence base +
+// selector, such as an Ideographic Variation Sequence. ok is false when the
+// font does not define the sequence; callers usually fall back to the glyph
+// of base alone.
+func (f *Font) GlyphForVariation(base, selector rune) (glyph int, ok bool) {
+	if f.fontInfo == nil || f.fontInfo.Tables.Cmap == nil {
+		return
+	}
+	return f.fontInfo.Tables.Cmap.GlyphForVariation(base, selector)
+}
+
+// numGlyphs returns the glyph count from maxp, or from the post names when
+// the font has no maxp table.
+func (f *Font) numGlyphs() int {
+	tables := f.fontInfo.Tables
+	if tables.Maxp != nil {
+		return int(tables.Maxp.NumGlyphs)
+	}
+	if tables.Post != nil {
+		return len(tables.Post.Names)
+	}
+	return 0
+}
+
+// GlyphName returns the name of glyph gid. Glyphs the post table does not
+// name, as in every format 3 font, get a name derived from the cmap (see
+// UnicodeToGlyphName) or glyphN. It returns "" for IDs outside the font.
+// The names of all glyphs are derived on first use and cached until the
+// post or cmap table or the glyph count changes.
+func (f *Font) GlyphName(gid int) string {
+	if f.fontInfo == nil || gid < 0 || gid >= f.numGlyphs() {
+		return ""
+	}
+	if name, ok := f.fontInfo.Tables.Post.GlyphName(gid); ok {
+		return name
+	}
+	return f.cachedGlyphNames().names[gid]
+}
+
+// GlyphByName returns the glyph called name. Names the font does not use are
+// resolved through the cmap by their Adobe Glyph List meaning, so "uni4E00"
+// or "Aacute" find the glyph for that character in any font.
+func (f *Font) GlyphByName(name string) (glyph int, ok bool) {
+	if f.fontInfo == nil {
+		return
+	}
+	tables := f.fontInfo.Tables
+	if gid, ok := f.cachedGlyphNames().byName[name]; ok {
+		return gid, true
+	}
+	if runes := GlyphNameToUnicode(name); len(runes) == 1 && tables.Cmap != nil {
+		return tables.Cmap.GlyphIndex(int(runes[0]))
+	}
+	return
+}
+
+// GlyphUnicodes returns the code points the cmap maps to glyph gid in
+// ascending order. For glyphs without a cmap entry, such as ligatures and
+// alternates, it returns the sequence their name stands for under the Adobe
+// Glyph List rules, e.g. U+0066 U+0069 for "f_i".
+func (f *Font) GlyphUnicodes(gid int) []rune {
+	if f.fontInfo == nil {
+		return nil
+	}
+	var runes []rune
+	if cmap := f.fontInfo.Tables.Cmap; cmap != nil {
+		for c, g := range cmap.WindowsCode {
+			if g == gid {
+				runes = append(runes, rune(c))
 			}
 		}
 	}
+	if len(runes) > 0 {
+		sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+		return runes
+	}
+	return GlyphNameToUnicode(f.GlyphName(gid))
+}
+
+// RegenerateGlyphNames switches the post table to format 2 and names every
+// glyph, keeping the names the table already has and deriving the others
+// from the cmap as uniXXXX or uXXXXX names.
+func (f *Font) RegenerateGlyphNames() error {
+	if f.fontInfo == nil {
+		return errors.New("fontInfo is nil, call GetFontInfo first")
+	}
+	tables := f.fontInfo.Tables
+	if tables.Post == nil {
+		return errors.New("post table is nil")
+	}
+	f.names = nil
+	names := glyphNames(tables.Post, tables.Cmap, f.numGlyphs())
+	tables.Post.Format = 2
+	tables.Post.Names = names
+	tables.Post.NumberOfGlyphs = uint16(len(names))
+	tables.Post.GlyphNameIndex = nil
+	tables.Post.Offset = nil
+	return nil
+}
+
+// RenameOptions controls RenameFamily.
+type RenameOptions struct {
+	// Style is the new style name, such as "Bold Italic" or "Light". Empty
+	// keeps the font's current typographic or legacy subfamily name.
+	Style string
+	// UniqueID replaces the unique font identifier (name ID 3). Empty
+	// derives "revision;vendor;PostScriptName".
+	UniqueID string
+}
+
+// RenameFamily renames the font to newFamily on every platform and language:
+// family and subfamily (IDs 1 and 2, or 16 and 17 for styles beyond Regular,
+// Italic, Bold and Bold Italic), full name, PostScript name, unique ID and,
+// where present, the Mac compatible full name and WWS names. It also brings
+// OS/2 fsSelection, head macStyle and the post italic angle in line with the
+// style. An upright style clears the italic angle and an italic one left at
+// zero takes the slant of the hhea caret, if any. An Oblique style is marked
+// ITALIC and, from OS/2 version 4 on, OBLIQUE; older OS/2 tables have no
+// oblique bit, so there it becomes plain ITALIC. A standard weight word such
+// as Light, SemiBold or Black sets the OS/2 weight class to its value, so
+// "Condensed Black Oblique" gives 900. Otherwise Bold and Regular swap a
+// weight class of 400 and 700 and other weights are left alone.
+func (f *Font) RenameFamily(newFamily string, opts *RenameOptions) error {
+	if f.fontInfo == nil {
+		return errors.New("fontInfo is nil, call GetFontInfo first")
+	}
+	tables := f.fontInfo.Tables
+	if tables.Name == nil {
+		return errors.New("name table is nil")
+	}
+	family := strings.TrimSpace(newFamily)
+	if family == "" {
+		return errors.New("family name is empty")
+	}
+	if opts == nil {
+		opts = &RenameOptions{}
+	}
+	nameTable := tables.Name
 
-	// If no format 4 exists, create one for BMP characters
-	if !hasFormat4 && len(unicodes) > 0 {
-		defaultSubTable := map[string]interface{}{
-			"platformID":         uint16(3), // Windows
-			"platformSpecificID": uint16(1), // Unicode BMP
-			"language":           uint16(0),
+	style := strings.Join(strings.Fields(opts.Style), " ")
+	if style == "" {
+		style = nameText(nameTable, 17)
+	}
+	if style == "" {
+		style = nameText(nameTable, 2)
+	}
+	if style == "" {
+		style = "Regular"
+	}
+
+	italic, bold, oblique := styleFlags(style)
+	ribbi := legacyStyle(italic, bold)
+	fullName := family
+	if !strings.EqualFold(style, "Regular") {
+		fullName += " " + style
+	}
+	psName := postScriptName(family + "-" + style)
+	uniqueID := opts.UniqueID
+	if uniqueID == "" {
+		revision, vendor := 1.0, "NONE"
+		if tables.Head != nil {
+			revision = tables.Head.FontRevision
 		}
-		newSubTables = append(newSubTables, buildCmapFormat4(defaultSubTable, unicodeToGlyph, unicodes))
+		if tables.Os2 != nil && strings.TrimSpace(tables.Os2.AchVendID) != "" {
+			vendor = strings.TrimSpace(tables.Os2.AchVendID)
+		}
+		uniqueID = strconv.FormatFloat(revision, 'f', 3, 64) + ";" + vendor + ";" + psName
 	}
 
-	cmap.SubTables = newSubTables
-	cmap.NumberSubtables = uint16(len(newSubTables))
+	names := map[uint16]string{
+		1: family, 2: ribbi, 3: uniqueID, 4: fullName, 6: psName,
+		16: family, 17: style, 18: fullName, 21: family, 22: style,
+	}
+	extra := styleWithout(style, "Regular", "Italic", "Bold")
+	typographic := extra != ""
+	if typographic {
+		// Styles beyond RIBBI go into the legacy family name
+		names[1] = family + " " + extra
+	} else {
+		delete(names, 16)
+		delete(names, 17)
+		nameTable.DeleteName(16, "")
+		nameTable.DeleteName(17, "")
+	}
 
+	for _, nr := range append([]*NameRecord(nil), nameTable.NameRecord...) {
+		if text, ok := names[nr.NameID]; ok {
+			nameTable.setRecordText(nr.PlatformID, nr.PlatformSpecificID, nr.LanguageID, nr.NameID, text)
+		}
+		if typographic && nr.NameID == 1 {
+			nameTable.setRecordText(nr.PlatformID, nr.PlatformSpecificID, nr.LanguageID, 16, family)
+			nameTable.setRecordText(nr.PlatformID, nr.PlatformSpecificID, nr.LanguageID, 17, style)
+		}
+	}
+	// Every font needs the Windows English records of the core names
+	required := []uint16{1, 2, 3, 4, 6}
+	if typographic {
+		required = append(required, 16, 17)
+	}
+	for _, nameID := range required {
+		if !hasWindowsName(nameTable, nameID) {
+			if err := nameTable.SetName(nameID, "en", names[nameID]); err != nil {
+				return err
+			}
+		}
+	}
+	nameTable.sortRecords()
+
+	if tables.Os2 != nil {
+		fsSelection := tables.Os2.FsSelection &^ (FS_SELECTION_ITALIC | FS_SELECTION_BOLD | FS_SELECTION_REGULAR | FS_SELECTION_OBLIQUE)
+		if italic {
+			fsSelection |= FS_SELECTION_ITALIC
+		}
+		if bold {
+			fsSelection |= FS_SELECTION_BOLD
+		}
+		if !italic && !bold {
+			fsSelection |= FS_SELECTION_REGULAR
+		}
+		if oblique && tables.Os2.Version >= 4 {
+			fsSelection |= FS_SELECTION_OBLIQUE
+		}
+		tables.Os2.FsSelection = fsSelection
+	}
+	if tables.Head != nil {
+		macStyle := tables.Head.MacStyle &^ (MAC_STYLE_BOLD | MAC_STYLE_ITALIC)
+		if italic {
+			macStyle |= MAC_STYLE_ITALIC
+		}
+		if bold {
+			macStyle |= MAC_STYLE_BOLD
+		}
+		tables.Head.MacStyle = macStyle
+	}
+	if weight, ok := styleWeight(style); ok && tables.Os2 != nil {
+		tables.Os2.UsWeightClass = weight
+	} else if tables.Os2 != nil && !typographic {
+		switch {
+		case bold && tables.Os2.UsWeightClass == 400:
+			tables.Os2.UsWeightClass = 700
+		case !bold && tables.Os2.UsWeightClass == 700:
+			tables.Os2.UsWeightClass = 400
+		}
+	}
+	if post := tables.Post; post != nil {
+		if !italic {
+			post.ItalicAngle = 0
+		} else if hhea := tables.Hhea; post.ItalicAngle == 0 && hhea != nil && hhea.CaretSlopeRise != 0 {
+			// a caret leaning right gives a negative angle
+			angle := -math.Atan2(float64(hhea.CaretSlopeRun), float64(hhea.CaretSlopeRise)) * 180 / math.Pi
+			post.ItalicAngle = math.Round(angle*65536) / 65536
+		}
+	}
 	return nil
 }
 
-// buildCmapFormat4 builds a format 4 cmap subtable
-func buildCmapFormat4(oldSubTable map[string]interface{}, unicodeToGlyph map[int]int, unicodes []int) map[string]interface{} {
-	// Filter to BMP only (0-0xFFFF) for format 4
-	bmpUnicodes := make([]int, 0)
-	for _, u := range unicodes {
-		if u <= 0xFFFF {
-			bmpUnicodes = append(bmpUnicodes, u)
-		}
-	}
-
-	// Build segments - use idRangeOffset method for non-contiguous glyph mappings
-	type segment struct {
-		startCode     uint16
-		endCode       uint16
-		idDelta       int16
-		idRangeOffset uint16
-		glyphIdArray  []uint16 // only used when idRangeOffset != 0
-	}
-	var segments []segment
-
-	i := 0
-	for i < len(bmpUnicodes) {
-		segStart := bmpUnicodes[i]
-		segEnd := bmpUnicodes[i]
-		firstGlyph := unicodeToGlyph[segStart]
-
-		// Try to extend segment with consecutive unicodes that have consecutive glyph indices
-		canUseDelta := true
-		j := i + 1
-		for j < len(bmpUnicodes) {
-			u := bmpUnicodes[j]
-			g := unicodeToGlyph[u]
-
-			if u != segEnd+1 {
-				// Unicode not consecutive, end segment
-				break
-			}
-
-			expectedGlyph := firstGlyph + (u - segStart)
-			if g != expectedGlyph {
-				// Glyph not consecutive, can't use simple delta
-				canUseDelta = false
-				break
-			}
-
-			segEnd = u
-			j++
-		}
-
-		if canUseDelta {
-			// Use idDelta method
-			delta := int16(firstGlyph - segStart)
-			segments = append(segments, segment{
-				startCode:     uint16(segStart),
-				endCode:       uint16(segEnd),
-				idDelta:       delta,
-				idRangeOffset: 0,
-			})
-			i = j
-		} else {
-			// Single character segment with idDelta
-			delta := int16(firstGlyph - segStart)
-			segments = append(segments, segment{
-				startCode:     uint16(segStart),
-				endCode:       uint16(segStart),
-				idDelta:       delta,
-				idRangeOffset: 0,
-			})
-			i++
-		}
-	}
-
-	// Add terminating segment
-	segments = append(segments, segment{
-		startCode:     0xFFFF,
-		endCode:       0xFFFF,
-		idDelta:       1,
-		idRangeOffset: 0,
-	})
+// nameText returns the text of name nameID, preferring US English.
+func nameText(nameTable *NameTable, nameID uint16) string {
+	texts := nameTable.Info[nameProperty(nameID)]
+	for _, language := range []string{"en-US", "en"} {
+		if text, ok := texts[language]; ok && text != "" {
+			return text
+		}
+	}
+	languages := make([]string, 0, len(texts))
+	for language := range texts {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	for _, language := range languages {
+		if texts[language] != "" {
+			return texts[language]
+		}
+	}
+	return ""
+}
 
-	segCount := len(segments)
-	segCountX2 := uint16(segCount * 2)
+func hasWindowsName(nameTable *NameTable, nameID uint16) bool {
+	for _, nr := range nameTable.NameRecord {
+		if nr.PlatformID == 3 && nr.NameID == nameID {
+			return true
+		}
+	}
+	return false
+}
 
-	// Calculate searchRange, entrySelector, rangeShift
-	maxPow2 := 1
-	entrySelector := uint16(0)
-	for maxPow2*2 <= segCount {
-		maxPow2 *= 2
-		entrySelector++
+// styleFlags reads the italic, bold and oblique traits from a style name.
+// Only the word Bold counts as bold; SemiBold or ExtraBold do not.
+func styleFlags(style string) (italic, bold, oblique bool) {
+	for _, word := range strings.Fields(strings.ToLower(style)) {
+		switch word {
+		case "italic":
+			italic = true
+		case "oblique":
+			italic, oblique = true, true
+		case "bold":
+			bold = true
+		}
+	}
+	return
+}
+
+// styleWeight returns the weight class of the first standard weight word in
+// style other than Regular and Bold, as weightStyle names them. Words may be
+// split, as in "Extra Light".
+func styleWeight(style string) (uint16, bool) {
+	words := strings.Fields(strings.ToLower(style))
+	for i := range words {
+		candidates := []string{words[i]}
+		if i+1 < len(words) {
+			candidates = append(candidates, words[i]+words[i+1])
+		}
+		for _, word := range candidates {
+			for weight := 100; weight <= 900; weight += 100 {
+				if weight == 400 || weight == 700 {
+					continue
+				}
+				if word == strings.ToLower(weightStyle(float64(weight))) {
+					return uint16(weight), true
+				}
+			}
+		}
 	}
-	searchRange := uint16(maxPow2 * 2)
-	rangeShift := segCountX2 - searchRange
-
-	// Build arrays
-	endCode := make([]uint16, segCount)
-	startCode := make([]uint16, segCount)
-	idDelta := make([]uint16, segCount)
-	idRangeOffset := make([]uint16, segCount)
-
-	for idx, seg := range segments {
-		endCode[idx] = seg.endCode
-		startCode[idx] = seg.startCode
-		idDelta[idx] = uint16(seg.idDelta)
-		idRangeOffset[idx] = seg.idRangeOffset
-	}
-
-	// Calculate length
-	length := uint16(16 + segCount*8) // header + 4 arrays * segCount * 2 bytes
-
-	newSubTable := make(map[string]interface{})
-	newSubTable["platformID"] = oldSubTable["platformID"]
-	newSubTable["platformSpecificID"] = oldSubTable["platformSpecificID"]
-	newSubTable["format"] = uint16(4)
-	newSubTable["length"] = length
-	newSubTable["language"] = oldSubTable["language"]
-	newSubTable["segCountX2"] = segCountX2
-	newSubTable["searchRange"] = searchRange
-	newSubTable["entrySelector"] = entrySelector
-	newSubTable["rangeShift"] = rangeShift
-	newSubTable["endCode"] = endCode
-	newSubTable["reservedPad"] = uint16(0)
-	newSubTable["startCode"] = startCode
-	newSubTable["idDelta"] = idDelta
-	newSubTable["idRangeOffset"] = idRangeOffset
-	newSubTable["glyphIndexArray"] = []uint16{}
-
-	return newSubTable
-}
-
-// buildCmapFormat12 builds a format 12 cmap subtable
-func buildCmapFormat12(oldSubTable map[string]interface{}, unicodeToGlyph map[int]int, unicodes []int) map[string]interface{} {
-	// Build groups
-	var groups []*CmapFormat8nGroup
-
-	if len(unicodes) > 0 {
-		segStart := uint32(unicodes[0])
-		segEnd := uint32(unicodes[0])
-		firstGlyph := uint32(unicodeToGlyph[unicodes[0]])
-
-		for i := 1; i < len(unicodes); i++ {
-			u := uint32(unicodes[i])
-			g := uint32(unicodeToGlyph[unicodes[i]])
-			expectedGlyph := firstGlyph + (u - segStart)
-
-			if u == segEnd+1 && g == expectedGlyph {
-				segEnd = u
-			} else {
-				groups = append(groups, &CmapFormat8nGroup{
-					StartCharCode:  segStart,
-					EndCharCode:    segEnd,
-					StartGlyphCode: firstGlyph,
-				})
-				segStart = u
-				segEnd = u
-				firstGlyph = g
-			}
-		}
-		groups = append(groups, &CmapFormat8nGroup{
-			StartCharCode:  segStart,
-			EndCharCode:    segEnd,
-			StartGlyphCode: firstGlyph,
-		})
-	}
-
-	// Calculate length: 16 (header) + nGroups * 12
-	length := uint32(16 + len(groups)*12)
-
-	newSubTable := make(map[string]interface{})
-	newSubTable["platformID"] = oldSubTable["platformID"]
-	newSubTable["platformSpecificID"] = oldSubTable["platformSpecificID"]
-	newSubTable["format"] = uint16(12)
-	newSubTable["reserved"] = uint16(0)
-	newSubTable["length"] = length
-	newSubTable["language"] = oldSubTable["language"]
-	newSubTable["nGroups"] = uint32(len(groups))
-	newSubTable["groups"] = groups
-
-	return newSubTable
+	return 0, false
+}
+
+// legacyStyle returns the RIBBI subfamily name for the style traits.
+func legacyStyle(italic, bold bool) string {
+	switch {
+	case bold && italic:
+		return "Bold Italic"
+	case bold:
+		return "Bold"
+	case italic:
+		return "Italic"
+	}
+	return "Regular"
+}
+
+// styleWithout returns style without the given words, compared case
+// insensitively.
+func styleWithout(style string, words ...string) string {
+	var kept []string
+	for _, word := range strings.Fields(style) {
+		drop := false
+		for _, w := range words {
+			if strings.EqualFold(word, w) {
+				drop = true
+			}
+		}
+		if !drop {
+			kept = append(kept, word)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// postScriptName reduces name to the printable ASCII a PostScript name may
+// hold, without spaces or the characters [](){}<>/%, at most 63 long.
+func postScriptName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r < 33 || r > 126 || strings.ContainsRune("[](){}<>/%", r) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	psName := b.String()
+	if len(psName) > 63 {
+		psName = psName[:63]
+	}
+	return psName
+}
+
+// ConvertKernToGpos adds the kern table's horizontal pairs to GPOS as a
+// 'kern' feature, creating the GPOS table when the font has none. It fails
+// when GPOS already has a 'kern' feature, as the pairs would then apply twice.
+func (f *Font) ConvertKernToGpos() error {
+	if f.fontInfo == nil {
+		return errors.New("fontInfo is nil, call GetFontInfo first")
+	}
+	tables := f.fontInfo.Tables
+	if tables.Kern == nil {
+		return errors.New("kern table is nil")
+	}
+	gpos := KernToGpos(tables.Kern)
+	if gpos == nil {
+		return errors.New("kern table has no horizontal kerning pairs")
+	}
+	if tables.Gpos == nil {
+		tables.Gpos = gpos
+		return nil
+	}
+	if len(tables.Gpos.featureLookups("kern")) > 0 {
+		return errors.New("GPOS table already has a kern feature")
+	}
+	addKernFeature(tables.Gpos, gpos.Lookups[0])
+	return nil
+}
+
+// ConvertGposToKern replaces the kern table with a format 0 table flattened
+// from the pair adjustments of the GPOS 'kern' feature, for renderers that
+// only read the legacy table.
+func (f *Font) ConvertGposToKern() error {
+	if f.fontInfo == nil {
+		return errors.New("fontInfo is nil, call GetFontInfo first")
+	}
+	tables := f.fontInfo.Tables
+	if tables.Gpos == nil || tables.Maxp == nil {
+		return errors.New("GPOS or maxp table is nil")
+	}
+	kern := GposToKern(tables.Gpos, int(tables.Maxp.NumGlyphs))
+	if kern == nil {
+		return errors.New("GPOS table has no kern pair adjustments")
+	}
+	tables.Kern = kern
+	return nil
+}
+
+// rebuildCmapSubtables remaps every cmap subtable onto the subset glyph
+// order and re-encodes it in the smallest format its platform and encoding
+// allow. unicodeToGlyph is the remapped WindowsCode, used to add a (3, 1)
+// subtable when neither a BMP Unicode nor a symbol subtable survives.
+func rebuildCmapSubtables(cmap *Cmap, oldToNew map[int]int, unicodeToGlyph map[int]int) error {
+	newSubTables := make([]map[string]interface{}, 0, len(cmap.SubTables)+1)
+	hasUnicode := false
+
+	for _, subTable := range cmap.SubTables {
+		format, ok := subTable["format"].(uint16)
+		if !ok {
+			continue
+		}
+		if format == 14 {
+			newSubTables = append(newSubTables, subTable)
+			continue
+		}
+		if format == 13 {
+			newSubTables = append(newSubTables, remapCmapFormat13(subTable, func(g int) (int, bool) {
+				newGlyph, ok := oldToNew[g]
+				return newGlyph, ok
+			}))
+			continue
+		}
+		platformID, _ := subTable["platformID"].(uint16)
+		platformSpecificID, _ := subTable["platformSpecificID"].(uint16)
+
+		codes := make(map[int]int)
+		for c, oldGlyph := range decodeCmapSubtable(subTable) {
+			if newGlyph, ok := oldToNew[oldGlyph]; ok && newGlyph != 0 {
+				codes[c] = newGlyph
+			}
+		}
+		newSubTables = append(newSubTables, encodeBestCmapSubtable(platformID, platformSpecificID, cmapLanguage(subTable), format, codes))
+		if rank := cmapSubtableRank(platformID, platformSpecificID, format); rank == CMAP_RANK_UNICODE_BMP || rank == CMAP_RANK_SYMBOL {
+			hasUnicode = true
+		}
+	}
+
+	// If no BMP Unicode or symbol subtable exists, create one from the
+	// Windows mapping
+	if !hasUnicode && len(unicodeToGlyph) > 0 {
+		newSubTables = append(newSubTables, encodeBestCmapSubtable(3, 1, 0, 4, unicodeToGlyph))
+	}
+	sort.SliceStable(newSubTables, func(i, j int) bool {
+		pi, _ := newSubTables[i]["platformID"].(uint16)
+		pj, _ := newSubTables[j]["platformID"].(uint16)
+		if pi != pj {
+			return pi < pj
+		}
+		ei, _ := newSubTables[i]["platformSpecificID"].(uint16)
+		ej, _ := newSubTables[j]["platformSpecificID"].(uint16)
+		return ei < ej
+	})
+
+	cmap.SubTables = newSubTables
+	cmap.NumberSubtables = uint16(len(newSubTables))
+	cmap.LastResort = readLastResort(newSubTables)
+
+	return nil
 }
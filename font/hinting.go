@@ -0,0 +1,1822 @@
+package font
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// The hinting interpreter works in 26.6 fixed point pixels, 64 units to
+// the pixel, and keeps vectors as 2.14 fixed point unit vectors.
+
+const (
+	// maxHintingSteps bounds the instructions one program may execute, so
+	// that a looping program in a broken font cannot hang the caller
+	maxHintingSteps = 1 << 22
+	// maxCallDepth bounds nested CALL, LOOPCALL and IDEF invocations
+	maxCallDepth = 64
+	// hintingVersion is the rasterizer version GETINFO reports
+	hintingVersion = 35
+)
+
+// round states of the graphics state
+const (
+	roundToHalfGrid = iota
+	roundToGrid
+	roundToDoubleGrid
+	roundDownToGrid
+	roundUpToGrid
+	roundOff
+	roundSuper
+	roundSuper45
+)
+
+// point flags of a zone
+const (
+	pointOnCurve uint8 = 1 << iota
+	pointTouchedX
+	pointTouchedY
+)
+
+type hintPoint struct {
+	x, y int32
+}
+
+// zone is the twilight zone or the points of a glyph. A glyph zone ends with
+// the four phantom points that carry its metrics. cur holds the hinted
+// positions and orig the original ones; orus holds the original positions
+// in font units and is nil for the twilight zone.
+type zone struct {
+	cur, orig, orus []hintPoint
+	flags           []uint8
+	ends            []uint16
+}
+
+func newZone(n int) *zone {
+	return &zone{
+		cur:   make([]hintPoint, n),
+		orig:  make([]hintPoint, n),
+		orus:  make([]hintPoint, n),
+		flags: make([]uint8, n),
+	}
+}
+
+func newTwilightZone(n int) *zone {
+	z := newZone(n)
+	z.orus = nil
+	return z
+}
+
+func (z *zone) clone() *zone {
+	c := &zone{
+		cur:   append([]hintPoint{}, z.cur...),
+		orig:  append([]hintPoint{}, z.orig...),
+		flags: append([]uint8{}, z.flags...),
+		ends:  append([]uint16{}, z.ends...),
+	}
+	if z.orus != nil {
+		c.orus = append([]hintPoint{}, z.orus...)
+	}
+	return c
+}
+
+type graphicsState struct {
+	pv, fv, dv [2]int32
+	rp         [3]int32
+	zp         [3]int32
+	loop       int32
+	minDist    int32
+	cvtCutIn   int32
+	// singleWidth is in pixels, singleWidthCutIn too
+	singleWidth      int32
+	singleWidthCutIn int32
+	deltaBase        int32
+	deltaShift       int32
+	autoFlip         bool
+	roundState       int
+	roundPeriod      int32
+	roundPhase       int32
+	roundThreshold   int32
+}
+
+var defaultGraphicsState = graphicsState{
+	pv:         [2]int32{0x4000, 0},
+	fv:         [2]int32{0x4000, 0},
+	dv:         [2]int32{0x4000, 0},
+	zp:         [3]int32{1, 1, 1},
+	loop:       1,
+	minDist:    64,
+	cvtCutIn:   68,
+	deltaBase:  9,
+	deltaShift: 3,
+	autoFlip:   true,
+	roundState: roundToGrid,
+}
+
+// resetForProgram sets the parts of the state every program starts with;
+// the rest is carried over from prep
+func (gs *graphicsState) resetForProgram() {
+	gs.pv, gs.fv, gs.dv = defaultGraphicsState.pv, defaultGraphicsState.fv, defaultGraphicsState.dv
+	gs.zp = defaultGraphicsState.zp
+	gs.loop = 1
+	gs.roundState = roundToGrid
+}
+
+// definition is a function or instruction defined by FDEF or IDEF; the body
+// runs from start up to its ENDF
+type definition struct {
+	code  []uint8
+	start int
+}
+
+// HintedPoint is one point of a grid-fitted outline, in 26.6 fixed point
+// pixels with y pointing up
+type HintedPoint struct {
+	X       int32 `json:"x"`
+	Y       int32 `json:"y"`
+	OnCurve bool  `json:"onCurve"`
+}
+
+// HintedGlyph is a glyph outline grid-fitted at one size. The origin is the
+// hinted left side bearing point, so AdvanceWidth is where the next glyph
+// starts.
+type HintedGlyph struct {
+	Points           []HintedPoint `json:"points"`
+	EndPtsOfContours []uint16      `json:"endPtsOfContours"`
+	AdvanceWidth     int32         `json:"advanceWidth"`
+}
+
+// Hinter runs the TrueType instructions of a font at one size. It runs fpgm
+// and prep once; each glyph program then starts from the CVT, storage,
+// twilight zone and graphics state prep left behind, and its changes do not
+// carry over to other glyphs.
+type Hinter struct {
+	font   *Font
+	tables *Tables
+	glyphs *Glyphs
+	set    *glyphSet
+	ppem   int32
+	// scaleFactor converts font units to 26.6 pixels in 16.16 fixed point
+	scaleFactor int32
+
+	functions    []definition
+	instructions map[uint8]definition
+	instructCtrl int32
+
+	gs       graphicsState
+	cvt      []int32
+	storage  []int32
+	twilight *zone
+	// loaded keeps every hinted glyph, since glyphs do not affect each
+	// other and components are shared by many compound glyphs
+	loaded map[int]*zone
+}
+
+// NewHinter prepares hinting at ppem pixels per em by running the font
+// program and the CVT program
+func (f *Font) NewHinter(ppem int) (*Hinter, error) {
+	if f.fontInfo == nil {
+		return nil, errors.New("fontInfo is nil, call GetFontInfo first")
+	}
+	tables := f.fontInfo.Tables
+	if tables.Maxp == nil || tables.Maxp.Version != "1.0" || tables.Head == nil {
+		return nil, errors.New("font has no TrueType outlines")
+	}
+	if ppem <= 0 {
+		return nil, errors.New("ppem must be positive")
+	}
+	maxp := tables.Maxp
+	h := &Hinter{
+		font:         f,
+		tables:       tables,
+		glyphs:       f.fontInfo.Glyphs,
+		set:          newGlyphSet(f.fontInfo.Glyphs),
+		ppem:         int32(ppem),
+		functions:    make([]definition, maxp.MaxFunctionDefs),
+		instructions: make(map[uint8]definition),
+		loaded:       make(map[int]*zone),
+		gs:           defaultGraphicsState,
+		storage:      make([]int32, maxp.MaxStorage),
+	}
+	if tables.Head.UnitsPerEm == 0 {
+		return nil, errors.New("unitsPerEm is 0")
+	}
+	h.scaleFactor = int32(roundDiv(int64(ppem)<<22, int64(tables.Head.UnitsPerEm)))
+	twilight := 0
+	if maxp.MaxZones > 1 {
+		twilight = int(maxp.MaxTwilightPoints)
+	}
+	h.twilight = newTwilightZone(twilight)
+	// the cvt is scaled in 26.6 font units with a coarser factor, which
+	// rounds differently from scale and matters to programs that compare
+	// cvt values against each other
+	for _, v := range tables.Cvt {
+		h.cvt = append(h.cvt, mulFix(int32(v)*64, h.scaleFactor>>6))
+	}
+
+	e := &hintExec{h: h, gs: defaultGraphicsState, cvt: h.cvt, storage: h.storage, zones: [2]*zone{h.twilight, newZone(0)}}
+	if err := e.run(tables.Fpgm); err != nil {
+		return nil, errors.New("fpgm: " + err.Error())
+	}
+	e.gs = defaultGraphicsState
+	e.prep = true
+	if err := e.run(tables.Prep); err != nil {
+		return nil, errors.New("prep: " + err.Error())
+	}
+	h.gs = e.gs
+	return h, nil
+}
+
+// HintedGlyph grid-fits glyph gid at ppem pixels per em. Use NewHinter to
+// hint many glyphs at one size.
+func (f *Font) HintedGlyph(gid int, ppem int) (*HintedGlyph, error) {
+	h, err := f.NewHinter(ppem)
+	if err != nil {
+		return nil, err
+	}
+	return h.Glyph(gid)
+}
+
+// Glyph grid-fits glyph gid
+func (h *Hinter) Glyph(gid int) (*HintedGlyph, error) {
+	if gid < 0 || gid >= int(h.tables.Maxp.NumGlyphs) {
+		return nil, errors.New("glyph " + strconv.Itoa(gid) + " out of range")
+	}
+	z, err := h.load(gid, 0)
+	if err != nil {
+		return nil, err
+	}
+	n := len(z.cur) - 4
+	originX := z.cur[n].x
+	glyph := &HintedGlyph{
+		EndPtsOfContours: append([]uint16{}, z.ends...),
+		AdvanceWidth:     z.cur[n+1].x - originX,
+	}
+	for i := 0; i < n; i++ {
+		glyph.Points = append(glyph.Points, HintedPoint{z.cur[i].x - originX, z.cur[i].y, z.flags[i]&pointOnCurve != 0})
+	}
+	return glyph, nil
+}
+
+// scale converts font units to 26.6 pixels. It multiplies by a 16.16
+// scale factor, as FreeType does, so that outlines match its rasterizer.
+func (h *Hinter) scale(v int32) int32 {
+	return mulFix(v, h.scaleFactor)
+}
+
+// mulFix multiplies by a 16.16 fixed point factor, rounding half away from
+// zero
+func mulFix(a, b int32) int32 {
+	return int32(roundDiv(int64(a)*int64(b), 0x10000))
+}
+
+// mulFix14 multiplies by a 2.14 fixed point factor
+func mulFix14(a, b int32) int32 {
+	return int32(roundDiv(int64(a)*int64(b), 0x4000))
+}
+
+func roundDiv(a, b int64) int64 {
+	if b < 0 {
+		a, b = -a, -b
+	}
+	if a < 0 {
+		return -((-a + b/2) / b)
+	}
+	return (a + b/2) / b
+}
+
+func roundPixel(v int32) int32 {
+	return (v + 32) &^ 63
+}
+
+// load returns the hinted zone of glyph gid, phantom points included. The
+// zone must not be modified.
+func (h *Hinter) load(gid int, nesting int) (*zone, error) {
+	if z, ok := h.loaded[gid]; ok {
+		return z, nil
+	}
+	z, err := h.hint(gid, nesting)
+	if err != nil {
+		return nil, err
+	}
+	h.loaded[gid] = z
+	return z, nil
+}
+
+// hint loads and grid-fits glyph gid
+func (h *Hinter) hint(gid int, nesting int) (*zone, error) {
+	if nesting > maxComponentNesting {
+		return nil, errors.New("components nest too deeply")
+	}
+	phantom := h.font.phantomPoints(gid)
+	var phantomCur [4]hintPoint
+	for i, p := range phantom {
+		phantomCur[i] = hintPoint{h.scale(p.x), h.scale(p.y)}
+	}
+	var z *zone
+	var instructions []uint8
+	compound, isCompound := h.set.compounds[gid]
+	if simple, ok := h.set.simples[gid]; ok {
+		xs, ys := simpleCoordinates(simple)
+		z = newZone(len(xs) + 4)
+		for i := range xs {
+			z.orus[i] = hintPoint{int32(xs[i]), int32(ys[i])}
+			z.cur[i] = hintPoint{h.scale(int32(xs[i])), h.scale(int32(ys[i]))}
+			if simple.Points[i].Flag != nil && simple.Points[i].Flag.OnCurve {
+				z.flags[i] = pointOnCurve
+			}
+		}
+		z.ends = append([]uint16{}, simple.EndPtsOfContours...)
+		instructions = simple.Instructions
+	} else if isCompound {
+		var err error
+		if z, err = h.loadCompound(compound, &phantom, &phantomCur, nesting); err != nil {
+			return nil, err
+		}
+		instructions = compound.Instructions
+	} else {
+		z = newZone(4)
+	}
+
+	// the original positions are the unhinted points of a simple glyph and
+	// the hinted components of a compound one
+	n := len(z.cur) - 4
+	copy(z.orus[n:], phantom[:])
+	copy(z.cur[n:], phantomCur[:])
+	copy(z.orig, z.cur)
+	z.cur[n].x = roundPixel(z.cur[n].x)
+	z.cur[n+1].x = roundPixel(z.cur[n+1].x)
+	z.cur[n+2].y = roundPixel(z.cur[n+2].y)
+	z.cur[n+3].y = roundPixel(z.cur[n+3].y)
+
+	if len(instructions) == 0 || h.instructCtrl&1 != 0 {
+		return z, nil
+	}
+	for i := range z.flags {
+		z.flags[i] &^= pointTouchedX | pointTouchedY
+	}
+	e := &hintExec{
+		h:       h,
+		gs:      h.gs,
+		cvt:     append([]int32{}, h.cvt...),
+		storage: append([]int32{}, h.storage...),
+		zones:   [2]*zone{h.twilight.clone(), z},
+	}
+	if isCompound {
+		// the instructions of a compound glyph refer to the hinted
+		// components alone, not to the font units behind them
+		copy(z.orus, z.cur)
+		e.unscaled = true
+	}
+	if h.instructCtrl&2 != 0 {
+		e.gs = defaultGraphicsState
+	}
+	if err := e.run(instructions); err != nil {
+		return nil, errors.New("glyph " + strconv.Itoa(gid) + ": " + err.Error())
+	}
+	return z, nil
+}
+
+// loadCompound places the hinted components of a compound glyph. A
+// USE_MY_METRICS component replaces the phantom points.
+func (h *Hinter) loadCompound(compound *GlyphCompound, phantom, phantomCur *[4]hintPoint, nesting int) (*zone, error) {
+	var cur, orus []hintPoint
+	var flags []uint8
+	var ends []uint16
+	for _, comp := range compound.Component {
+		child, err := h.load(int(comp.GlyphIndex), nesting+1)
+		if err != nil {
+			return nil, err
+		}
+		n := len(child.cur) - 4
+		childCur := append([]hintPoint{}, child.cur[:n]...)
+		childOrus := append([]hintPoint{}, child.orus[:n]...)
+		if comp.Flags&(WE_HAVE_A_SCALE|WE_HAVE_AN_X_AND_Y_SCALE|WE_HAVE_A_TWO_BY_TWO) != 0 {
+			a, b, c, d := float64(comp.Xscale), float64(comp.Scale01), float64(comp.Scale10), float64(comp.Yscale)
+			transform := func(points []hintPoint) {
+				for i, p := range points {
+					x, y := float64(p.x), float64(p.y)
+					points[i] = hintPoint{int32(math.Round(a*x + c*y)), int32(math.Round(b*x + d*y))}
+				}
+			}
+			transform(childCur)
+			transform(childOrus)
+		}
+		var dx, dy, udx, udy int32
+		if comp.Flags&ARGS_ARE_XY_VALUES != 0 {
+			udx, udy = int32(comp.Argument1), int32(comp.Argument2)
+			dx, dy = h.scale(udx), h.scale(udy)
+			if comp.Flags&ROUND_XY_TO_GRID != 0 {
+				dx, dy = roundPixel(dx), roundPixel(dy)
+			}
+		} else {
+			p1, p2 := comp.Argument1, comp.Argument2
+			if p1 < 0 || p1 >= len(cur) || p2 < 0 || p2 >= n {
+				return nil, errors.New("component point " + strconv.Itoa(p1) + " or " + strconv.Itoa(p2) + " out of range")
+			}
+			dx, dy = cur[p1].x-childCur[p2].x, cur[p1].y-childCur[p2].y
+			udx, udy = orus[p1].x-childOrus[p2].x, orus[p1].y-childOrus[p2].y
+		}
+		for _, end := range child.ends {
+			ends = append(ends, end+uint16(len(cur)))
+		}
+		for i := 0; i < n; i++ {
+			cur = append(cur, hintPoint{childCur[i].x + dx, childCur[i].y + dy})
+			orus = append(orus, hintPoint{childOrus[i].x + udx, childOrus[i].y + udy})
+			flags = append(flags, child.flags[i]&pointOnCurve)
+		}
+		if comp.Flags&USE_MY_METRICS != 0 {
+			copy(phantom[:], child.orus[n:])
+			copy(phantomCur[:], child.cur[n:])
+		}
+	}
+	z := newZone(len(cur) + 4)
+	copy(z.cur, cur)
+	copy(z.orus, orus)
+	copy(z.flags, flags)
+	z.ends = ends
+	return z, nil
+}
+
+// hintExec is the state of one running program
+type hintExec struct {
+	h       *Hinter
+	gs      graphicsState
+	cvt     []int32
+	storage []int32
+	zones   [2]*zone
+	stack   []int32
+	// args backs the slices pop returns, which are read before the next
+	// pop, so that instructions do not allocate
+	args [8]int32
+	// prep is set while running the CVT program, the only place INSTCTRL
+	// has an effect
+	prep bool
+	// unscaled is set for compound glyph programs, whose orus positions
+	// are pixels already
+	unscaled bool
+}
+
+func (e *hintExec) push(v int32) error {
+	if len(e.stack) >= int(e.h.tables.Maxp.MaxStackElements) {
+		return errors.New("stack overflow")
+	}
+	e.stack = append(e.stack, v)
+	return nil
+}
+
+// pop removes the top n values; the returned slice holds them in stack
+// order, the former top last
+func (e *hintExec) pop(n int) ([]int32, error) {
+	if len(e.stack) < n {
+		return nil, errors.New("stack underflow")
+	}
+	var args []int32
+	if n <= len(e.args) {
+		args = e.args[:n]
+	} else {
+		args = make([]int32, n)
+	}
+	copy(args, e.stack[len(e.stack)-n:])
+	e.stack = e.stack[:len(e.stack)-n]
+	return args, nil
+}
+
+func (e *hintExec) pop1() (int32, error) {
+	if len(e.stack) == 0 {
+		return 0, errors.New("stack underflow")
+	}
+	v := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+	return v, nil
+}
+
+// point returns the zone zp[k] points to and checks that it holds point i
+func (e *hintExec) point(k int, i int32) (*zone, int, error) {
+	z := e.zones[e.gs.zp[k]]
+	if i < 0 || int(i) >= len(z.cur) {
+		return nil, 0, errors.New("point " + strconv.Itoa(int(i)) + " out of range")
+	}
+	return z, int(i), nil
+}
+
+func (e *hintExec) cvtIndex(i int32) (int, error) {
+	if i < 0 || int(i) >= len(e.cvt) {
+		return 0, errors.New("CVT index " + strconv.Itoa(int(i)) + " out of range")
+	}
+	return int(i), nil
+}
+
+func dotVector(v [2]int32, dx, dy int32) int32 {
+	return int32((int64(dx)*int64(v[0]) + int64(dy)*int64(v[1]) + 0x2000) >> 14)
+}
+
+// project measures a vector along the projection vector
+func (e *hintExec) project(dx, dy int32) int32 {
+	return dotVector(e.gs.pv, dx, dy)
+}
+
+// dualProject measures a vector of original positions along the dual
+// projection vector
+func (e *hintExec) dualProject(dx, dy int32) int32 {
+	return dotVector(e.gs.dv, dx, dy)
+}
+
+// originalDistance measures the original distance from point b of zb to
+// point a of za. Outside the twilight zone it is measured in font units and
+// then scaled, which keeps it free of the rounding of the scaled points.
+func (e *hintExec) originalDistance(za *zone, a int, zb *zone, b int) int32 {
+	if za.orus == nil || zb.orus == nil {
+		return e.dualProject(za.orig[a].x-zb.orig[b].x, za.orig[a].y-zb.orig[b].y)
+	}
+	d := e.dualProject(za.orus[a].x-zb.orus[b].x, za.orus[a].y-zb.orus[b].y)
+	if e.unscaled {
+		return d
+	}
+	return e.h.scale(d)
+}
+
+func unitVector(dx, dy int32) [2]int32 {
+	if dx == 0 && dy == 0 {
+		return [2]int32{0x4000, 0}
+	}
+	l := math.Hypot(float64(dx), float64(dy))
+	return [2]int32{int32(math.Round(float64(dx) / l * 0x4000)), int32(math.Round(float64(dy) / l * 0x4000))}
+}
+
+// displacement turns a distance along the projection vector into a move
+// along the freedom vector
+func (e *hintExec) displacement(d int32) (dx, dy int32, ok bool) {
+	fx, fy := int64(e.gs.fv[0]), int64(e.gs.fv[1])
+	dot := (fx*int64(e.gs.pv[0]) + fy*int64(e.gs.pv[1])) >> 14
+	if abs64(dot) < 0x400 {
+		// nearly perpendicular vectors would move points far away
+		dot = 0x4000
+	}
+	return int32(roundDiv(int64(d)*fx, dot)), int32(roundDiv(int64(d)*fy, dot)), true
+}
+
+// shift moves point i of z and marks it touched on the axes the freedom
+// vector moves it along
+func (e *hintExec) shift(z *zone, i int, dx, dy int32, touch bool) {
+	if e.gs.fv[0] != 0 {
+		z.cur[i].x += dx
+		if touch {
+			z.flags[i] |= pointTouchedX
+		}
+	}
+	if e.gs.fv[1] != 0 {
+		z.cur[i].y += dy
+		if touch {
+			z.flags[i] |= pointTouchedY
+		}
+	}
+}
+
+// move moves point i of z so that its projection grows by d
+func (e *hintExec) move(z *zone, i int, d int32) {
+	if dx, dy, ok := e.displacement(d); ok {
+		e.shift(z, i, dx, dy, true)
+	}
+}
+
+// round rounds a distance by the round state, keeping its sign
+func (e *hintExec) round(d int32) int32 {
+	gs := &e.gs
+	var f func(v int32) int32
+	switch gs.roundState {
+	case roundOff:
+		return d
+	case roundToHalfGrid:
+		f = func(v int32) int32 { return v&^63 + 32 }
+	case roundToGrid:
+		f = func(v int32) int32 { return (v + 32) &^ 63 }
+	case roundToDoubleGrid:
+		f = func(v int32) int32 { return (v + 16) &^ 31 }
+	case roundDownToGrid:
+		f = func(v int32) int32 { return v &^ 63 }
+	case roundUpToGrid:
+		f = func(v int32) int32 { return (v + 63) &^ 63 }
+	case roundSuper, roundSuper45:
+		period := gs.roundPeriod
+		if period <= 0 {
+			return d
+		}
+		// S45ROUND periods are no power of two, so its grid is found by
+		// division rather than masking
+		f = func(v int32) int32 {
+			if gs.roundState == roundSuper45 {
+				return v / period * period
+			}
+			return v &^ (period - 1)
+		}
+		if d >= 0 {
+			if r := f(d+gs.roundThreshold-gs.roundPhase) + gs.roundPhase; r >= 0 {
+				return r
+			}
+			return gs.roundPhase
+		}
+		if r := -f(gs.roundThreshold-gs.roundPhase-d) - gs.roundPhase; r <= 0 {
+			return r
+		}
+		return -gs.roundPhase
+	}
+	if d >= 0 {
+		if r := f(d); r > 0 {
+			return r
+		}
+		return 0
+	}
+	if r := -f(-d); r < 0 {
+		return r
+	}
+	return 0
+}
+
+// setSuperRound decodes the SROUND and S45ROUND argument for a grid period
+// of gridPeriod, given in 2.14 fixed point pixels
+func (gs *graphicsState) setSuperRound(n int32, gridPeriod int32) {
+	switch (n >> 6) & 3 {
+	case 0:
+		gs.roundPeriod = gridPeriod / 2
+	case 2:
+		gs.roundPeriod = gridPeriod * 2
+	default:
+		gs.roundPeriod = gridPeriod
+	}
+	gs.roundPhase = gs.roundPeriod * ((n >> 4) & 3) / 4
+	if n&15 == 0 {
+		gs.roundThreshold = gs.roundPeriod - 1
+	} else {
+		gs.roundThreshold = (n&15 - 4) * gs.roundPeriod / 8
+	}
+	gs.roundPeriod >>= 8
+	gs.roundPhase >>= 8
+	gs.roundThreshold >>= 8
+}
+
+// instructionEnd returns the position after the instruction at pc
+func instructionEnd(code []uint8, pc int) (int, error) {
+	count, width, start, isPush := pushCount(code, pc)
+	if !isPush {
+		return pc + 1, nil
+	}
+	if start == 0 || start+count*width > len(code) {
+		return 0, errors.New("push runs past the end of the program")
+	}
+	return start + count*width, nil
+}
+
+// skipDefinition returns the position of the ENDF closing the FDEF or IDEF
+// at pc
+func skipDefinition(code []uint8, pc int) (int, error) {
+	for i, err := instructionEnd(code, pc); ; i, err = instructionEnd(code, i) {
+		if err != nil {
+			return 0, err
+		}
+		if i >= len(code) {
+			return 0, errors.New("missing ENDF")
+		}
+		switch code[i] {
+		case OP_ENDF:
+			return i, nil
+		case OP_FDEF, OP_IDEF:
+			return 0, errors.New("nested definition")
+		}
+	}
+}
+
+// skipBranch returns the position of the ELSE or EIF ending the branch
+// that starts after pc; ELSE is only accepted when elseEnds is set
+func skipBranch(code []uint8, pc int, elseEnds bool) (int, error) {
+	depth := 0
+	for i, err := instructionEnd(code, pc); ; i, err = instructionEnd(code, i) {
+		if err != nil {
+			return 0, err
+		}
+		if i >= len(code) {
+			return 0, errors.New("missing EIF")
+		}
+		switch code[i] {
+		case OP_IF:
+			depth++
+		case OP_ELSE:
+			if depth == 0 && elseEnds {
+				return i, nil
+			}
+		case OP_EIF:
+			if depth == 0 {
+				return i, nil
+			}
+			depth--
+		}
+	}
+}
+
+type callFrame struct {
+	code  []uint8
+	ret   int
+	start int
+	count int32
+}
+
+// run executes a program
+func (e *hintExec) run(code []uint8) error {
+	e.gs.resetForProgram()
+	var calls []callFrame
+	for pc, steps := 0, 0; ; steps++ {
+		if pc >= len(code) {
+			if len(calls) > 0 {
+				return errors.New("missing ENDF")
+			}
+			return nil
+		}
+		if steps > maxHintingSteps {
+			return errors.New("too many instructions executed")
+		}
+		op := code[pc]
+		next, err := instructionEnd(code, pc)
+		if err == nil {
+			switch op {
+			case OP_FDEF, OP_IDEF:
+				next, err = e.define(code, pc)
+			case OP_ENDF:
+				if len(calls) == 0 {
+					err = errors.New("ENDF outside a definition")
+					break
+				}
+				frame := &calls[len(calls)-1]
+				if frame.count--; frame.count > 0 {
+					next = frame.start
+				} else {
+					code, next = frame.code, frame.ret
+					calls = calls[:len(calls)-1]
+				}
+			case OP_CALL, OP_LOOPCALL:
+				var args []int32
+				count := int32(1)
+				if op == OP_LOOPCALL {
+					args, err = e.pop(2)
+					if err == nil {
+						count = args[0]
+						args = args[1:]
+					}
+				} else {
+					args, err = e.pop(1)
+				}
+				if err != nil || count <= 0 {
+					break
+				}
+				f := args[0]
+				if f < 0 || int(f) >= len(e.h.functions) || e.h.functions[f].code == nil {
+					err = errors.New("function " + strconv.Itoa(int(f)) + " is not defined")
+					break
+				}
+				if len(calls) >= maxCallDepth {
+					err = errors.New("calls nest too deeply")
+					break
+				}
+				def := e.h.functions[f]
+				calls = append(calls, callFrame{code, next, def.start, count})
+				code, next = def.code, def.start
+			case OP_IF:
+				var cond int32
+				if cond, err = e.pop1(); err == nil && cond == 0 {
+					next, err = skipBranch(code, pc, true)
+					next++
+				}
+			case OP_ELSE:
+				next, err = skipBranch(code, pc, false)
+				next++
+			case 0x1C, 0x78, 0x79: // JMPR, JROT, JROF
+				var args []int32
+				if op == 0x1C {
+					args, err = e.pop(1)
+				} else if args, err = e.pop(2); err == nil {
+					if (args[1] != 0) != (op == 0x78) {
+						break
+					}
+				}
+				if err == nil {
+					next = pc + int(args[0])
+					if next < 0 || next > len(code) {
+						err = errors.New("jump out of range")
+					}
+				}
+			default:
+				if def, ok := e.h.instructions[op]; ok {
+					if len(calls) >= maxCallDepth {
+						err = errors.New("calls nest too deeply")
+						break
+					}
+					calls = append(calls, callFrame{code, next, def.start, 1})
+					code, next = def.code, def.start
+					break
+				}
+				err = e.execute(op, code, pc)
+			}
+		}
+		if err != nil {
+			return errors.New(InstructionName(code[pc]) + " at " + strconv.Itoa(pc) + ": " + err.Error())
+		}
+		pc = next
+	}
+}
+
+// define records the FDEF or IDEF at pc and returns the position after its
+// ENDF
+func (e *hintExec) define(code []uint8, pc int) (int, error) {
+	n, err := e.pop1()
+	if err != nil {
+		return 0, err
+	}
+	end, err := skipDefinition(code, pc)
+	if err != nil {
+		return 0, err
+	}
+	def := definition{code, pc + 1}
+	if code[pc] == OP_FDEF {
+		if n < 0 || int(n) >= len(e.h.functions) {
+			return 0, errors.New("function " + strconv.Itoa(int(n)) + " exceeds maxFunctionDefs")
+		}
+		e.h.functions[n] = def
+	} else {
+		if n < 0 || n > 0xFF {
+			return 0, errors.New("opcode " + strconv.Itoa(int(n)) + " out of range")
+		}
+		_, exists := e.h.instructions[uint8(n)]
+		if !exists && len(e.h.instructions) >= int(e.h.tables.Maxp.MaxInstructionDefs) {
+			return 0, errors.New("instruction definitions exceed maxInstructionDefs")
+		}
+		e.h.instructions[uint8(n)] = def
+	}
+	return end + 1, nil
+}
+
+// loopPoints pops the points of an instruction repeated by SLOOP
+func (e *hintExec) loopPoints() ([]int32, error) {
+	n := e.gs.loop
+	e.gs.loop = 1
+	return e.pop(int(n))
+}
+
+// execute runs every instruction that does not change the flow of control
+func (e *hintExec) execute(op uint8, code []uint8, pc int) error {
+	gs := &e.gs
+	switch {
+	case op >= OP_PUSHB && op < OP_PUSHB+16 || op == OP_NPUSHB || op == OP_NPUSHW:
+		count, width, start, _ := pushCount(code, pc)
+		for i := 0; i < count; i++ {
+			var v int32
+			if width == 1 {
+				v = int32(code[start+i])
+			} else {
+				v = int32(int16(getUint16(code[start+2*i : start+2*i+2])))
+			}
+			if err := e.push(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case op >= OP_MDRP:
+		return e.moveRelative(op)
+	}
+
+	switch op {
+	case 0x00, 0x01, 0x02, 0x03, 0x04, 0x05: // SVTCA, SPVTCA, SFVTCA
+		v := [2]int32{0, 0x4000}
+		if op&1 == 1 {
+			v = [2]int32{0x4000, 0}
+		}
+		if op < 0x04 {
+			gs.pv, gs.dv = v, v
+		}
+		if op < 0x02 || op >= 0x04 {
+			gs.fv = v
+		}
+	case 0x06, 0x07, 0x08, 0x09, 0x86, 0x87: // SPVTL, SFVTL, SDPVTL
+		args, err := e.pop(2)
+		if err != nil {
+			return err
+		}
+		z1, p1, err := e.point(1, args[0])
+		if err != nil {
+			return err
+		}
+		z2, p2, err := e.point(2, args[1])
+		if err != nil {
+			return err
+		}
+		vector := func(a, b hintPoint) [2]int32 {
+			dx, dy := a.x-b.x, a.y-b.y
+			if op&1 == 1 {
+				dx, dy = -dy, dx
+			}
+			return unitVector(dx, dy)
+		}
+		switch {
+		case op >= 0x86:
+			gs.dv = vector(z1.orig[p1], z2.orig[p2])
+			gs.pv = vector(z1.cur[p1], z2.cur[p2])
+		case op < 0x08:
+			gs.pv = vector(z1.cur[p1], z2.cur[p2])
+			gs.dv = gs.pv
+		default:
+			gs.fv = vector(z1.cur[p1], z2.cur[p2])
+		}
+	case 0x0A, 0x0B: // SPVFS, SFVFS
+		args, err := e.pop(2)
+		if err != nil {
+			return err
+		}
+		v := unitVector(int32(int16(args[0])), int32(int16(args[1])))
+		if op == 0x0A {
+			gs.pv, gs.dv = v, v
+		} else {
+			gs.fv = v
+		}
+	case 0x0C, 0x0D: // GPV, GFV
+		v := gs.pv
+		if op == 0x0D {
+			v = gs.fv
+		}
+		if err := e.push(v[0]); err != nil {
+			return err
+		}
+		return e.push(v[1])
+	case 0x0E: // SFVTPV
+		gs.fv = gs.pv
+	case OP_EIF:
+		// the end of a taken branch
+	case 0x0F: // ISECT
+		return e.intersect()
+	case 0x10, 0x11, 0x12: // SRP0, SRP1, SRP2
+		p, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		gs.rp[op-0x10] = p
+	case 0x13, 0x14, 0x15, 0x16: // SZP0, SZP1, SZP2, SZPS
+		n, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		if n != 0 && n != 1 {
+			return errors.New("invalid zone " + strconv.Itoa(int(n)))
+		}
+		if op == 0x16 {
+			gs.zp = [3]int32{n, n, n}
+		} else {
+			gs.zp[op-0x13] = n
+		}
+	case 0x17: // SLOOP
+		n, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return errors.New("negative loop count")
+		}
+		gs.loop = n
+	case 0x18:
+		gs.roundState = roundToGrid
+	case 0x19:
+		gs.roundState = roundToHalfGrid
+	case 0x3D:
+		gs.roundState = roundToDoubleGrid
+	case 0x7A:
+		gs.roundState = roundOff
+	case 0x7C:
+		gs.roundState = roundUpToGrid
+	case 0x7D:
+		gs.roundState = roundDownToGrid
+	case 0x76, 0x77: // SROUND, S45ROUND
+		n, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		if op == 0x76 {
+			gs.roundState = roundSuper
+			gs.setSuperRound(n, 0x4000)
+		} else {
+			gs.roundState = roundSuper45
+			// a grid period of the square root of 2 over 2 pixels
+			gs.setSuperRound(n, 0x2D41)
+		}
+	case 0x1A, 0x1D, 0x1E, 0x1F, 0x5E, 0x5F: // SMD, SCVTCI, SSWCI, SSW, SDB, SDS
+		n, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case 0x1A:
+			gs.minDist = n
+		case 0x1D:
+			gs.cvtCutIn = n
+		case 0x1E:
+			gs.singleWidthCutIn = n
+		case 0x1F:
+			gs.singleWidth = e.h.scale(n)
+		case 0x5E:
+			gs.deltaBase = n
+		case 0x5F:
+			if n < 0 || n > 6 {
+				return errors.New("invalid delta shift " + strconv.Itoa(int(n)))
+			}
+			gs.deltaShift = n
+		}
+	case 0x4D, 0x4E: // FLIPON, FLIPOFF
+		gs.autoFlip = op == 0x4D
+	case 0x20: // DUP
+		n, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		e.stack = append(e.stack, n)
+		return e.push(n)
+	case 0x21, 0x4F, 0x7E, 0x7F, 0x85, 0x8D: // POP, DEBUG, SANGW, AA, SCANCTRL, SCANTYPE
+		_, err := e.pop(1)
+		return err
+	case 0x22: // CLEAR
+		e.stack = e.stack[:0]
+	case 0x23: // SWAP
+		args, err := e.pop(2)
+		if err != nil {
+			return err
+		}
+		e.stack = append(e.stack, args[1], args[0])
+	case 0x24: // DEPTH
+		return e.push(int32(len(e.stack)))
+	case 0x25, 0x26: // CINDEX, MINDEX
+		k, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		if k <= 0 || int(k) > len(e.stack) {
+			return errors.New("stack index " + strconv.Itoa(int(k)) + " out of range")
+		}
+		i := len(e.stack) - int(k)
+		v := e.stack[i]
+		if op == 0x26 {
+			e.stack = append(e.stack[:i], e.stack[i+1:]...)
+		}
+		return e.push(v)
+	case 0x8A: // ROLL
+		args, err := e.pop(3)
+		if err != nil {
+			return err
+		}
+		e.stack = append(e.stack, args[1], args[2], args[0])
+	case 0x27: // ALIGNPTS
+		args, err := e.pop(2)
+		if err != nil {
+			return err
+		}
+		z1, p1, err := e.point(1, args[0])
+		if err != nil {
+			return err
+		}
+		z0, p2, err := e.point(0, args[1])
+		if err != nil {
+			return err
+		}
+		d := e.project(z0.cur[p2].x-z1.cur[p1].x, z0.cur[p2].y-z1.cur[p1].y) / 2
+		e.move(z1, p1, d)
+		e.move(z0, p2, -d)
+	case 0x29: // UTP
+		p, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		z, i, err := e.point(0, p)
+		if err != nil {
+			return err
+		}
+		if gs.fv[0] != 0 {
+			z.flags[i] &^= pointTouchedX
+		}
+		if gs.fv[1] != 0 {
+			z.flags[i] &^= pointTouchedY
+		}
+	case 0x2E, 0x2F: // MDAP
+		p, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		z, i, err := e.point(0, p)
+		if err != nil {
+			return err
+		}
+		var d int32
+		if op == 0x2F {
+			cur := e.project(z.cur[i].x, z.cur[i].y)
+			d = e.round(cur) - cur
+		}
+		e.move(z, i, d)
+		gs.rp[0], gs.rp[1] = p, p
+	case 0x3E, 0x3F: // MIAP
+		args, err := e.pop(2)
+		if err != nil {
+			return err
+		}
+		z, i, err := e.point(0, args[0])
+		if err != nil {
+			return err
+		}
+		c, err := e.cvtIndex(args[1])
+		if err != nil {
+			return err
+		}
+		d := e.cvt[c]
+		if gs.zp[0] == 0 {
+			z.orig[i] = hintPoint{mulFix14(d, gs.fv[0]), mulFix14(d, gs.fv[1])}
+			z.cur[i] = z.orig[i]
+		}
+		cur := e.project(z.cur[i].x, z.cur[i].y)
+		if op == 0x3F {
+			if abs32(d-cur) > gs.cvtCutIn {
+				d = cur
+			}
+			d = e.round(d)
+		}
+		e.move(z, i, d-cur)
+		gs.rp[0], gs.rp[1] = args[0], args[0]
+	case 0x30, 0x31: // IUP
+		e.interpolateUntouched(op == 0x31)
+	case 0x32, 0x33, 0x34, 0x35, 0x36, 0x37: // SHP, SHC, SHZ
+		return e.shiftPoints(op)
+	case 0x38: // SHPIX
+		d, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		points, err := e.loopPoints()
+		if err != nil {
+			return err
+		}
+		dx, dy := mulFix14(d, gs.fv[0]), mulFix14(d, gs.fv[1])
+		for _, p := range points {
+			z, i, err := e.point(2, p)
+			if err != nil {
+				return err
+			}
+			e.shift(z, i, dx, dy, true)
+		}
+	case 0x39: // IP
+		return e.interpolatePoints()
+	case 0x3A, 0x3B: // MSIRP
+		args, err := e.pop(2)
+		if err != nil {
+			return err
+		}
+		z0, r, err := e.point(0, gs.rp[0])
+		if err != nil {
+			return err
+		}
+		z, i, err := e.point(1, args[0])
+		if err != nil {
+			return err
+		}
+		if gs.zp[1] == 0 {
+			z.orig[i] = z0.orig[r]
+			z.cur[i] = z0.cur[r]
+		}
+		d := e.project(z.cur[i].x-z0.cur[r].x, z.cur[i].y-z0.cur[r].y)
+		e.move(z, i, args[1]-d)
+		gs.rp[1], gs.rp[2] = gs.rp[0], args[0]
+		if op == 0x3B {
+			gs.rp[0] = args[0]
+		}
+	case 0x3C: // ALIGNRP
+		points, err := e.loopPoints()
+		if err != nil {
+			return err
+		}
+		z0, r, err := e.point(0, gs.rp[0])
+		if err != nil {
+			return err
+		}
+		for _, p := range points {
+			z, i, err := e.point(1, p)
+			if err != nil {
+				return err
+			}
+			e.move(z, i, -e.project(z.cur[i].x-z0.cur[r].x, z.cur[i].y-z0.cur[r].y))
+		}
+	case 0x42: // WS
+		args, err := e.pop(2)
+		if err != nil {
+			return err
+		}
+		if args[0] < 0 || int(args[0]) >= len(e.storage) {
+			return errors.New("storage index " + strconv.Itoa(int(args[0])) + " exceeds maxStorage")
+		}
+		e.storage[args[0]] = args[1]
+	case 0x43: // RS
+		n, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		if n < 0 || int(n) >= len(e.storage) {
+			return errors.New("storage index " + strconv.Itoa(int(n)) + " exceeds maxStorage")
+		}
+		return e.push(e.storage[n])
+	case 0x44, 0x70: // WCVTP, WCVTF
+		args, err := e.pop(2)
+		if err != nil {
+			return err
+		}
+		c, err := e.cvtIndex(args[0])
+		if err != nil {
+			return err
+		}
+		if op == 0x70 {
+			args[1] = e.h.scale(args[1])
+		}
+		e.cvt[c] = args[1]
+	case 0x45: // RCVT
+		n, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		c, err := e.cvtIndex(n)
+		if err != nil {
+			return err
+		}
+		return e.push(e.cvt[c])
+	case 0x46, 0x47: // GC
+		p, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		z, i, err := e.point(2, p)
+		if err != nil {
+			return err
+		}
+		if op == 0x46 {
+			return e.push(e.project(z.cur[i].x, z.cur[i].y))
+		}
+		return e.push(e.dualProject(z.orig[i].x, z.orig[i].y))
+	case 0x48: // SCFS
+		args, err := e.pop(2)
+		if err != nil {
+			return err
+		}
+		z, i, err := e.point(2, args[0])
+		if err != nil {
+			return err
+		}
+		e.move(z, i, args[1]-e.project(z.cur[i].x, z.cur[i].y))
+		if gs.zp[2] == 0 {
+			z.orig[i] = z.cur[i]
+		}
+	case 0x49, 0x4A: // MD
+		// Implementations measure the grid-fitted outline for 0x49 and
+		// the original one for 0x4A
+		args, err := e.pop(2)
+		if err != nil {
+			return err
+		}
+		z0, p1, err := e.point(0, args[0])
+		if err != nil {
+			return err
+		}
+		z1, p2, err := e.point(1, args[1])
+		if err != nil {
+			return err
+		}
+		if op == 0x49 {
+			return e.push(e.project(z0.cur[p1].x-z1.cur[p2].x, z0.cur[p1].y-z1.cur[p2].y))
+		}
+		return e.push(e.originalDistance(z0, p1, z1, p2))
+	case 0x4B, 0x4C: // MPPEM, MPS
+		return e.push(e.h.ppem)
+	case 0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x5A, 0x5B, 0x60, 0x61, 0x62, 0x63, 0x8B, 0x8C:
+		args, err := e.pop(2)
+		if err != nil {
+			return err
+		}
+		return e.push(binaryOp(op, args[0], args[1], &err))
+	case 0x56, 0x57, 0x5C, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6A, 0x6B, 0x6C, 0x6D, 0x6E, 0x6F:
+		n, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		switch {
+		case op == 0x56:
+			n = boolValue(e.round(n)&127 == 64)
+		case op == 0x57:
+			n = boolValue(e.round(n)&127 == 0)
+		case op == 0x5C:
+			n = boolValue(n == 0)
+		case op == 0x64:
+			n = abs32(n)
+		case op == 0x65:
+			n = -n
+		case op == 0x66:
+			n &^= 63
+		case op == 0x67:
+			n = (n + 63) &^ 63
+		case op <= 0x6B:
+			n = e.round(n)
+		}
+		return e.push(n)
+	case 0x5D, 0x71, 0x72, 0x73, 0x74, 0x75: // DELTAP1-3, DELTAC1-3
+		return e.delta(op)
+	case 0x80: // FLIPPT
+		points, err := e.loopPoints()
+		if err != nil {
+			return err
+		}
+		for _, p := range points {
+			z, i, err := e.point(0, p)
+			if err != nil {
+				return err
+			}
+			z.flags[i] ^= pointOnCurve
+		}
+	case 0x81, 0x82: // FLIPRGON, FLIPRGOFF
+		args, err := e.pop(2)
+		if err != nil {
+			return err
+		}
+		z := e.zones[1]
+		if args[0] < 0 || args[1] < args[0] || int(args[1]) >= len(z.cur) {
+			return errors.New("point range out of range")
+		}
+		for i := args[0]; i <= args[1]; i++ {
+			if op == 0x81 {
+				z.flags[i] |= pointOnCurve
+			} else {
+				z.flags[i] &^= pointOnCurve
+			}
+		}
+	case 0x88: // GETINFO
+		selector, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		var info int32
+		if selector&1 != 0 {
+			info = hintingVersion
+		}
+		if selector&32 != 0 {
+			// grayscale rendering
+			info |= 1 << 12
+		}
+		return e.push(info)
+	case 0x8E: // INSTCTRL
+		args, err := e.pop(2)
+		if err != nil {
+			return err
+		}
+		if args[1] < 1 || args[1] > 3 {
+			return errors.New("invalid INSTCTRL selector " + strconv.Itoa(int(args[1])))
+		}
+		if e.prep {
+			bit := int32(1) << uint(args[1]-1)
+			e.h.instructCtrl &^= bit
+			if args[0] != 0 {
+				e.h.instructCtrl |= bit
+			}
+		}
+	case 0x91: // GETVARIATION
+		if e.h.tables.Fvar == nil {
+			return errors.New("font has no variations")
+		}
+		// hinting always runs at the default instance
+		for range e.h.tables.Fvar.Axis {
+			if err := e.push(0); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("undefined instruction")
+	}
+	return nil
+}
+
+func abs32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func boolValue(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// binaryOp applies a comparison, logic or arithmetic instruction to a, the
+// deeper value, and b, the former top of the stack
+func binaryOp(op uint8, a, b int32, err *error) int32 {
+	switch op {
+	case 0x50:
+		return boolValue(a < b)
+	case 0x51:
+		return boolValue(a <= b)
+	case 0x52:
+		return boolValue(a > b)
+	case 0x53:
+		return boolValue(a >= b)
+	case 0x54:
+		return boolValue(a == b)
+	case 0x55:
+		return boolValue(a != b)
+	case 0x5A:
+		return boolValue(a != 0 && b != 0)
+	case 0x5B:
+		return boolValue(a != 0 || b != 0)
+	case 0x60:
+		return a + b
+	case 0x61:
+		return a - b
+	case 0x62:
+		if b == 0 {
+			*err = errors.New("division by zero")
+			return 0
+		}
+		return int32(int64(a) * 64 / int64(b))
+	case 0x63:
+		return int32(roundDiv(int64(a)*int64(b), 64))
+	case 0x8B:
+		if a > b {
+			return a
+		}
+		return b
+	default:
+		if a < b {
+			return a
+		}
+		return b
+	}
+}
+
+// moveRelative runs MDRP and MIRP
+func (e *hintExec) moveRelative(op uint8) error {
+	gs := &e.gs
+	indirect := op >= OP_MIRP
+	var p, cvtDist int32
+	if indirect {
+		args, err := e.pop(2)
+		if err != nil {
+			return err
+		}
+		p = args[0]
+		if args[1] != -1 {
+			c, err := e.cvtIndex(args[1])
+			if err != nil {
+				return err
+			}
+			cvtDist = e.cvt[c]
+		}
+	} else {
+		n, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		p = n
+	}
+	z0, r, err := e.point(0, gs.rp[0])
+	if err != nil {
+		return err
+	}
+	z, i, err := e.point(1, p)
+	if err != nil {
+		return err
+	}
+	singleWidth := func(d int32) int32 {
+		if abs32(d-gs.singleWidth) < gs.singleWidthCutIn {
+			if d >= 0 {
+				return gs.singleWidth
+			}
+			return -gs.singleWidth
+		}
+		return d
+	}
+
+	var dist, orgDist int32
+	if indirect {
+		cvtDist = singleWidth(cvtDist)
+		if gs.zp[1] == 0 {
+			z.orig[i] = hintPoint{z0.orig[r].x + mulFix14(cvtDist, gs.fv[0]), z0.orig[r].y + mulFix14(cvtDist, gs.fv[1])}
+			z.cur[i] = z.orig[i]
+		}
+		orgDist = e.dualProject(z.orig[i].x-z0.orig[r].x, z.orig[i].y-z0.orig[r].y)
+		if gs.autoFlip && (orgDist^cvtDist) < 0 {
+			cvtDist = -cvtDist
+		}
+		dist = cvtDist
+		if op&0x04 != 0 {
+			if gs.zp[0] == gs.zp[1] && abs32(cvtDist-orgDist) > gs.cvtCutIn {
+				dist = orgDist
+			}
+			dist = e.round(dist)
+		}
+	} else {
+		orgDist = singleWidth(e.originalDistance(z, i, z0, r))
+		dist = orgDist
+		if op&0x04 != 0 {
+			dist = e.round(dist)
+		}
+	}
+	if op&0x08 != 0 {
+		if orgDist >= 0 {
+			if dist < gs.minDist {
+				dist = gs.minDist
+			}
+		} else if dist > -gs.minDist {
+			dist = -gs.minDist
+		}
+	}
+	curDist := e.project(z.cur[i].x-z0.cur[r].x, z.cur[i].y-z0.cur[r].y)
+	e.move(z, i, dist-curDist)
+	gs.rp[1], gs.rp[2] = gs.rp[0], p
+	if op&0x10 != 0 {
+		gs.rp[0] = p
+	}
+	return nil
+}
+
+// shiftPoints runs SHP, SHC and SHZ, which move points by the distance the
+// reference point has moved
+func (e *hintExec) shiftPoints(op uint8) error {
+	gs := &e.gs
+	k, ref := 1, gs.rp[2]
+	if op&1 == 1 {
+		k, ref = 0, gs.rp[1]
+	}
+	zr, r, err := e.point(k, ref)
+	if err != nil {
+		return err
+	}
+	d := e.project(zr.cur[r].x-zr.orig[r].x, zr.cur[r].y-zr.orig[r].y)
+	dx, dy, ok := e.displacement(d)
+	switch op &^ 1 {
+	case 0x32: // SHP
+		points, err := e.loopPoints()
+		if err != nil {
+			return err
+		}
+		for _, p := range points {
+			z, i, err := e.point(2, p)
+			if err != nil {
+				return err
+			}
+			if ok {
+				e.shift(z, i, dx, dy, true)
+			}
+		}
+	case 0x34: // SHC
+		c, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		z := e.zones[gs.zp[2]]
+		if c < 0 || int(c) >= len(z.ends) {
+			return errors.New("contour " + strconv.Itoa(int(c)) + " out of range")
+		}
+		start := 0
+		if c > 0 {
+			start = int(z.ends[c-1]) + 1
+		}
+		for i := start; i <= int(z.ends[c]) && i < len(z.cur); i++ {
+			if ok && (z != zr || i != r) {
+				e.shift(z, i, dx, dy, true)
+			}
+		}
+	case 0x36: // SHZ
+		n, err := e.pop1()
+		if err != nil {
+			return err
+		}
+		if n != 0 && n != 1 {
+			return errors.New("invalid zone " + strconv.Itoa(int(n)))
+		}
+		z := e.zones[n]
+		limit := len(z.cur)
+		if n == 1 && limit >= 4 {
+			// the phantom points stay in place
+			limit -= 4
+		}
+		for i := 0; i < limit; i++ {
+			if ok && (z != zr || i != r) {
+				e.shift(z, i, dx, dy, false)
+			}
+		}
+	}
+	return nil
+}
+
+// interpolatePoints runs IP, which keeps the points' relative position
+// between rp1 and rp2
+func (e *hintExec) interpolatePoints() error {
+	gs := &e.gs
+	points, err := e.loopPoints()
+	if err != nil {
+		return err
+	}
+	z1, r1, err := e.point(0, gs.rp[1])
+	if err != nil {
+		return err
+	}
+	z2, r2, err := e.point(1, gs.rp[2])
+	if err != nil {
+		return err
+	}
+	// outside the twilight zone the original positions are compared in
+	// font units
+	original := func(z *zone) []hintPoint { return z.orus }
+	if e.zones[gs.zp[0]].orus == nil || e.zones[gs.zp[1]].orus == nil || e.zones[gs.zp[2]].orus == nil {
+		original = func(z *zone) []hintPoint { return z.orig }
+	}
+	base, curBase := original(z1)[r1], z1.cur[r1]
+	orgRange := e.dualProject(original(z2)[r2].x-base.x, original(z2)[r2].y-base.y)
+	curRange := e.project(z2.cur[r2].x-curBase.x, z2.cur[r2].y-curBase.y)
+	for _, p := range points {
+		z, i, err := e.point(2, p)
+		if err != nil {
+			return err
+		}
+		orgDist := e.dualProject(original(z)[i].x-base.x, original(z)[i].y-base.y)
+		curDist := e.project(z.cur[i].x-curBase.x, z.cur[i].y-curBase.y)
+		newDist := orgDist
+		if orgDist != 0 && orgRange != 0 {
+			newDist = int32(roundDiv(int64(orgDist)*int64(curRange), int64(orgRange)))
+		}
+		e.move(z, i, newDist-curDist)
+	}
+	return nil
+}
+
+// intersect runs ISECT, which moves a point to where two lines cross
+func (e *hintExec) intersect() error {
+	args, err := e.pop(5)
+	if err != nil {
+		return err
+	}
+	z, p, err := e.point(2, args[0])
+	if err != nil {
+		return err
+	}
+	var ends [4]hintPoint
+	for j, k := range []int{1, 1, 0, 0} {
+		zk, i, err := e.point(k, args[j+1])
+		if err != nil {
+			return err
+		}
+		ends[j] = zk.cur[i]
+	}
+	a0, a1, b0, b1 := ends[0], ends[1], ends[2], ends[3]
+	dax, day := int64(a1.x-a0.x), int64(a1.y-a0.y)
+	dbx, dby := int64(b1.x-b0.x), int64(b1.y-b0.y)
+	dx, dy := int64(b0.x-a0.x), int64(b0.y-a0.y)
+	discriminant := (dax*-dby + day*dbx) / 64
+	dotProduct := (dax*dbx + day*dby) / 64
+	if discriminant != 0 && 19*abs64(discriminant) > abs64(dotProduct) {
+		v := (dx*-dby + dy*dbx) / 64
+		z.cur[p] = hintPoint{a0.x + int32(roundDiv(v*dax, discriminant)), a0.y + int32(roundDiv(v*day, discriminant))}
+	} else {
+		// nearly parallel lines meet in the middle
+		z.cur[p] = hintPoint{(a0.x + a1.x + b0.x + b1.x) / 4, (a0.y + a1.y + b0.y + b1.y) / 4}
+	}
+	z.flags[p] |= pointTouchedX | pointTouchedY
+	return nil
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// delta runs DELTAP1-3, which move points, and DELTAC1-3, which change CVT
+// entries, at the sizes their arguments name
+func (e *hintExec) delta(op uint8) error {
+	gs := &e.gs
+	n, err := e.pop1()
+	if err != nil {
+		return err
+	}
+	base := gs.deltaBase
+	switch op {
+	case 0x71, 0x74:
+		base += 16
+	case 0x72, 0x75:
+		base += 32
+	}
+	for ; n > 0; n-- {
+		args, err := e.pop(2)
+		if err != nil {
+			return err
+		}
+		target, arg := args[1], args[0]
+		if base+(arg>>4)&15 != e.h.ppem {
+			continue
+		}
+		step := arg&15 - 8
+		if step >= 0 {
+			step++
+		}
+		d := step * 64 >> uint(gs.deltaShift)
+		if op == 0x5D || op == 0x71 || op == 0x72 {
+			z, i, err := e.point(0, target)
+			if err != nil {
+				return err
+			}
+			e.move(z, i, d)
+		} else {
+			c, err := e.cvtIndex(target)
+			if err != nil {
+				return err
+			}
+			e.cvt[c] += d
+		}
+	}
+	return nil
+}
+
+// interpolateUntouched runs IUP, which moves the points not touched along
+// one axis in proportion to the touched points around them in their contour
+func (e *hintExec) interpolateUntouched(xAxis bool) {
+	z := e.zones[1]
+	flag := pointTouchedY
+	coord := func(p *hintPoint) *int32 { return &p.y }
+	if xAxis {
+		flag = pointTouchedX
+		coord = func(p *hintPoint) *int32 { return &p.x }
+	}
+	// interpolate moves the points from lo to hi between touched points
+	// r1 and r2. Points outside the two keep their distance to the nearer
+	// one; points between them are placed in proportion, measured in font
+	// units.
+	interpolate := func(lo, hi, r1, r2 int) {
+		if lo > hi {
+			return
+		}
+		if *coord(&z.orus[r1]) > *coord(&z.orus[r2]) {
+			r1, r2 = r2, r1
+		}
+		u1, u2 := *coord(&z.orus[r1]), *coord(&z.orus[r2])
+		o1, o2 := *coord(&z.orig[r1]), *coord(&z.orig[r2])
+		c1, c2 := *coord(&z.cur[r1]), *coord(&z.cur[r2])
+		var scale int64
+		if u1 != u2 {
+			scale = roundDiv(int64(c2-c1)<<16, int64(u2-u1))
+		}
+		for i := lo; i <= hi; i++ {
+			o := *coord(&z.orig[i])
+			switch {
+			case o <= o1:
+				o += c1 - o1
+			case o >= o2:
+				o += c2 - o2
+			case c1 == c2 || u1 == u2:
+				o = c1
+			default:
+				o = c1 + int32(roundDiv(int64(*coord(&z.orus[i])-u1)*scale, 0x10000))
+			}
+			*coord(&z.cur[i]) = o
+		}
+	}
+	start := 0
+	for _, last := range z.ends {
+		end := int(last)
+		if end >= len(z.cur)-4 {
+			break
+		}
+		first := -1
+		for i := start; i <= end; i++ {
+			if z.flags[i]&flag != 0 {
+				first = i
+				break
+			}
+		}
+		if first >= 0 {
+			prev := first
+			for i := first + 1; i <= end; i++ {
+				if z.flags[i]&flag != 0 {
+					interpolate(prev+1, i-1, prev, i)
+					prev = i
+				}
+			}
+			if prev == first {
+				// a single touched point shifts the whole contour
+				d := *coord(&z.cur[first]) - *coord(&z.orig[first])
+				for i := start; i <= end; i++ {
+					if i != first {
+						*coord(&z.cur[i]) = *coord(&z.orig[i]) + d
+					}
+				}
+			} else {
+				interpolate(prev+1, end, prev, first)
+				interpolate(start, first-1, prev, first)
+			}
+		}
+		start = end + 1
+	}
+}
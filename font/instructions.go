@@ -0,0 +1,533 @@
+package font
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TrueType instruction opcodes that take inline data or shape the layout
+// of disassembled code
+const (
+	OP_ELSE     uint8 = 0x1B
+	OP_LOOPCALL uint8 = 0x2A
+	OP_CALL     uint8 = 0x2B
+	OP_FDEF     uint8 = 0x2C
+	OP_ENDF     uint8 = 0x2D
+	OP_NPUSHB   uint8 = 0x40
+	OP_NPUSHW   uint8 = 0x41
+	OP_IF       uint8 = 0x58
+	OP_EIF      uint8 = 0x59
+	OP_IDEF     uint8 = 0x89
+	OP_PUSHB    uint8 = 0xB0
+	OP_PUSHW    uint8 = 0xB8
+	OP_MDRP     uint8 = 0xC0
+	OP_MIRP     uint8 = 0xE0
+)
+
+// instructionGroup names a run of opcodes that differ in their low flag
+// bits; modifiers lists the flag names of an opcode given those bits
+type instructionGroup struct {
+	base      uint8
+	count     int
+	name      string
+	modifiers func(bits uint8) []string
+}
+
+func axisModifier(bits uint8) []string {
+	if bits&1 == 1 {
+		return []string{"x"}
+	}
+	return []string{"y"}
+}
+
+func lineModifier(bits uint8) []string {
+	if bits&1 == 1 {
+		return []string{"perp"}
+	}
+	return []string{"par"}
+}
+
+func outlineModifier(bits uint8) []string {
+	if bits&1 == 1 {
+		return []string{"orig"}
+	}
+	return []string{"cur"}
+}
+
+func refPointModifier(bits uint8) []string {
+	if bits&1 == 1 {
+		return []string{"rp1"}
+	}
+	return []string{"rp2"}
+}
+
+func flagModifier(name string) func(bits uint8) []string {
+	return func(bits uint8) []string {
+		if bits&1 == 1 {
+			return []string{name}
+		}
+		return nil
+	}
+}
+
+var distanceTypes = []string{"grey", "black", "white", "dt3"}
+
+func distanceModifier(bits uint8) []string {
+	return []string{distanceTypes[bits&3]}
+}
+
+// moveModifier names the flags of MDRP and MIRP: reset rp0, keep the
+// minimum distance, round, and the distance type
+func moveModifier(bits uint8) []string {
+	var mods []string
+	if bits&0x10 != 0 {
+		mods = append(mods, "rp0")
+	}
+	if bits&0x08 != 0 {
+		mods = append(mods, "min")
+	}
+	if bits&0x04 != 0 {
+		mods = append(mods, "rnd")
+	}
+	return append(mods, distanceTypes[bits&3])
+}
+
+var instructionGroups = []instructionGroup{
+	{0x00, 2, "SVTCA", axisModifier},
+	{0x02, 2, "SPVTCA", axisModifier},
+	{0x04, 2, "SFVTCA", axisModifier},
+	{0x06, 2, "SPVTL", lineModifier},
+	{0x08, 2, "SFVTL", lineModifier},
+	{0x0A, 1, "SPVFS", nil},
+	{0x0B, 1, "SFVFS", nil},
+	{0x0C, 1, "GPV", nil},
+	{0x0D, 1, "GFV", nil},
+	{0x0E, 1, "SFVTPV", nil},
+	{0x0F, 1, "ISECT", nil},
+	{0x10, 1, "SRP0", nil},
+	{0x11, 1, "SRP1", nil},
+	{0x12, 1, "SRP2", nil},
+	{0x13, 1, "SZP0", nil},
+	{0x14, 1, "SZP1", nil},
+	{0x15, 1, "SZP2", nil},
+	{0x16, 1, "SZPS", nil},
+	{0x17, 1, "SLOOP", nil},
+	{0x18, 1, "RTG", nil},
+	{0x19, 1, "RTHG", nil},
+	{0x1A, 1, "SMD", nil},
+	{0x1B, 1, "ELSE", nil},
+	{0x1C, 1, "JMPR", nil},
+	{0x1D, 1, "SCVTCI", nil},
+	{0x1E, 1, "SSWCI", nil},
+	{0x1F, 1, "SSW", nil},
+	{0x20, 1, "DUP", nil},
+	{0x21, 1, "POP", nil},
+	{0x22, 1, "CLEAR", nil},
+	{0x23, 1, "SWAP", nil},
+	{0x24, 1, "DEPTH", nil},
+	{0x25, 1, "CINDEX", nil},
+	{0x26, 1, "MINDEX", nil},
+	{0x27, 1, "ALIGNPTS", nil},
+	{0x29, 1, "UTP", nil},
+	{0x2A, 1, "LOOPCALL", nil},
+	{0x2B, 1, "CALL", nil},
+	{0x2C, 1, "FDEF", nil},
+	{0x2D, 1, "ENDF", nil},
+	{0x2E, 2, "MDAP", flagModifier("rnd")},
+	{0x30, 2, "IUP", axisModifier},
+	{0x32, 2, "SHP", refPointModifier},
+	{0x34, 2, "SHC", refPointModifier},
+	{0x36, 2, "SHZ", refPointModifier},
+	{0x38, 1, "SHPIX", nil},
+	{0x39, 1, "IP", nil},
+	{0x3A, 2, "MSIRP", flagModifier("rp0")},
+	{0x3C, 1, "ALIGNRP", nil},
+	{0x3D, 1, "RTDG", nil},
+	{0x3E, 2, "MIAP", flagModifier("rnd")},
+	{0x40, 1, "NPUSHB", nil},
+	{0x41, 1, "NPUSHW", nil},
+	{0x42, 1, "WS", nil},
+	{0x43, 1, "RS", nil},
+	{0x44, 1, "WCVTP", nil},
+	{0x45, 1, "RCVT", nil},
+	{0x46, 2, "GC", outlineModifier},
+	{0x48, 1, "SCFS", nil},
+	{0x49, 2, "MD", outlineModifier},
+	{0x4B, 1, "MPPEM", nil},
+	{0x4C, 1, "MPS", nil},
+	{0x4D, 1, "FLIPON", nil},
+	{0x4E, 1, "FLIPOFF", nil},
+	{0x4F, 1, "DEBUG", nil},
+	{0x50, 1, "LT", nil},
+	{0x51, 1, "LTEQ", nil},
+	{0x52, 1, "GT", nil},
+	{0x53, 1, "GTEQ", nil},
+	{0x54, 1, "EQ", nil},
+	{0x55, 1, "NEQ", nil},
+	{0x56, 1, "ODD", nil},
+	{0x57, 1, "EVEN", nil},
+	{0x58, 1, "IF", nil},
+	{0x59, 1, "EIF", nil},
+	{0x5A, 1, "AND", nil},
+	{0x5B, 1, "OR", nil},
+	{0x5C, 1, "NOT", nil},
+	{0x5D, 1, "DELTAP1", nil},
+	{0x5E, 1, "SDB", nil},
+	{0x5F, 1, "SDS", nil},
+	{0x60, 1, "ADD", nil},
+	{0x61, 1, "SUB", nil},
+	{0x62, 1, "DIV", nil},
+	{0x63, 1, "MUL", nil},
+	{0x64, 1, "ABS", nil},
+	{0x65, 1, "NEG", nil},
+	{0x66, 1, "FLOOR", nil},
+	{0x67, 1, "CEILING", nil},
+	{0x68, 4, "ROUND", distanceModifier},
+	{0x6C, 4, "NROUND", distanceModifier},
+	{0x70, 1, "WCVTF", nil},
+	{0x71, 1, "DELTAP2", nil},
+	{0x72, 1, "DELTAP3", nil},
+	{0x73, 1, "DELTAC1", nil},
+	{0x74, 1, "DELTAC2", nil},
+	{0x75, 1, "DELTAC3", nil},
+	{0x76, 1, "SROUND", nil},
+	{0x77, 1, "S45ROUND", nil},
+	{0x78, 1, "JROT", nil},
+	{0x79, 1, "JROF", nil},
+	{0x7A, 1, "ROFF", nil},
+	{0x7C, 1, "RUTG", nil},
+	{0x7D, 1, "RDTG", nil},
+	{0x7E, 1, "SANGW", nil},
+	{0x7F, 1, "AA", nil},
+	{0x80, 1, "FLIPPT", nil},
+	{0x81, 1, "FLIPRGON", nil},
+	{0x82, 1, "FLIPRGOFF", nil},
+	{0x85, 1, "SCANCTRL", nil},
+	{0x86, 2, "SDPVTL", lineModifier},
+	{0x88, 1, "GETINFO", nil},
+	{0x89, 1, "IDEF", nil},
+	{0x8A, 1, "ROLL", nil},
+	{0x8B, 1, "MAX", nil},
+	{0x8C, 1, "MIN", nil},
+	{0x8D, 1, "SCANTYPE", nil},
+	{0x8E, 1, "INSTCTRL", nil},
+	{0x91, 1, "GETVARIATION", nil},
+	{0xB0, 8, "PUSHB", nil},
+	{0xB8, 8, "PUSHW", nil},
+	{0xC0, 32, "MDRP", moveModifier},
+	{0xE0, 32, "MIRP", moveModifier},
+}
+
+// instructionInfo is the decoded name and modifiers of one opcode
+type instructionInfo struct {
+	name      string
+	modifiers []string
+	defined   bool
+}
+
+var (
+	instructionTable [256]instructionInfo
+	// instructionOpcodes lists the opcodes sharing a mnemonic
+	instructionOpcodes = make(map[string][]uint8)
+)
+
+func init() {
+	for _, group := range instructionGroups {
+		for i := 0; i < group.count; i++ {
+			op := group.base + uint8(i)
+			info := instructionInfo{name: group.name, defined: true}
+			if group.modifiers != nil {
+				info.modifiers = group.modifiers(uint8(i))
+			}
+			instructionTable[op] = info
+			instructionOpcodes[group.name] = append(instructionOpcodes[group.name], op)
+		}
+	}
+}
+
+// InstructionName returns the mnemonic of an opcode with its modifiers,
+// e.g. "MIRP[rp0,rnd,grey]", or "UNDEF[0x83]" for an undefined opcode
+func InstructionName(op uint8) string {
+	info := instructionTable[op]
+	if !info.defined {
+		return "UNDEF[0x" + strings.ToUpper(strconv.FormatUint(uint64(op), 16)) + "]"
+	}
+	return info.name + "[" + strings.Join(info.modifiers, ",") + "]"
+}
+
+// pushCount returns how many values a push instruction at code[pos]
+// carries, how wide each is and where they start
+func pushCount(code []uint8, pos int) (count int, width int, start int, ok bool) {
+	op := code[pos]
+	switch {
+	case op == OP_NPUSHB || op == OP_NPUSHW:
+		if pos+1 >= len(code) {
+			return 0, 0, 0, true
+		}
+		count, start = int(code[pos+1]), pos+2
+		if op == OP_NPUSHW {
+			width = 2
+		} else {
+			width = 1
+		}
+	case op >= OP_PUSHB && op < OP_PUSHB+8:
+		count, width, start = int(op-OP_PUSHB)+1, 1, pos+1
+	case op >= OP_PUSHW && op < OP_PUSHW+8:
+		count, width, start = int(op-OP_PUSHW)+1, 2, pos+1
+	default:
+		return 0, 0, 0, false
+	}
+	return count, width, start, true
+}
+
+// Disassemble turns TrueType bytecode into one instruction per line.
+// Pushed values follow their push instruction, FDEF, IF and IDEF bodies are
+// indented, and function definitions and calls whose function number was
+// pushed just before are annotated with a "; function N" comment. Assemble
+// reads the text back.
+func Disassemble(code []uint8) (string, error) {
+	var b strings.Builder
+	indent := 0
+	// known holds the values left on the stack by the pushes since the last
+	// instruction with an unknown stack effect
+	var known []int
+	type definition struct {
+		label string
+		known []int
+	}
+	var definitions []definition
+	popKnown := func() (int, bool) {
+		if len(known) == 0 {
+			return 0, false
+		}
+		v := known[len(known)-1]
+		known = known[:len(known)-1]
+		return v, true
+	}
+
+	for pos := 0; pos < len(code); {
+		op := code[pos]
+		line := InstructionName(op)
+		comment := ""
+		lineIndent := indent
+		next := pos + 1
+
+		if count, width, start, isPush := pushCount(code, pos); isPush {
+			if start == 0 || start+count*width > len(code) {
+				return "", errors.New("push at offset " + strconv.Itoa(pos) + " runs past the end of the code")
+			}
+			for i := 0; i < count; i++ {
+				var v int
+				if width == 2 {
+					v = int(getInt16(code[start+i*2 : start+i*2+2]))
+				} else {
+					v = int(code[start+i])
+				}
+				line += " " + strconv.Itoa(v)
+				known = append(known, v)
+			}
+			next = start + count*width
+		} else {
+			switch op {
+			case OP_FDEF, OP_IDEF:
+				var def definition
+				if v, ok := popKnown(); ok {
+					if op == OP_FDEF {
+						def.label = "function " + strconv.Itoa(v)
+					} else {
+						def.label = "instruction " + InstructionName(uint8(v))
+					}
+					comment = def.label
+				}
+				def.known = known
+				definitions = append(definitions, def)
+				known = nil
+				indent++
+			case OP_ENDF:
+				if n := len(definitions); n > 0 {
+					if definitions[n-1].label != "" {
+						comment = "end " + definitions[n-1].label
+					}
+					known = definitions[n-1].known
+					definitions = definitions[:n-1]
+				} else {
+					known = nil
+				}
+				if indent > 0 {
+					indent--
+				}
+				lineIndent = indent
+			case OP_CALL, OP_LOOPCALL:
+				if v, ok := popKnown(); ok {
+					comment = "function " + strconv.Itoa(v)
+				}
+				known = nil
+			case OP_IF:
+				known = nil
+				indent++
+			case OP_ELSE:
+				known = nil
+				lineIndent = indent - 1
+			case OP_EIF:
+				known = nil
+				if indent > 0 {
+					indent--
+				}
+				lineIndent = indent
+			default:
+				known = nil
+			}
+		}
+
+		if lineIndent < 0 {
+			lineIndent = 0
+		}
+		b.WriteString(strings.Repeat("  ", lineIndent))
+		b.WriteString(line)
+		if comment != "" {
+			b.WriteString("  ; " + comment)
+		}
+		b.WriteByte('\n')
+		pos = next
+	}
+	return b.String(), nil
+}
+
+// parseModifiers matches the modifiers written between the brackets of a
+// mnemonic against the opcodes that share it. Modifiers may come in any
+// order, or as the flag bits in binary, e.g. "MIRP[10100]".
+func parseModifiers(name string, text string) (uint8, error) {
+	ops, ok := instructionOpcodes[name]
+	if !ok {
+		return 0, errors.New("unknown instruction " + name)
+	}
+	var mods []string
+	for _, m := range strings.Split(text, ",") {
+		if m = strings.ToLower(strings.TrimSpace(m)); m != "" {
+			mods = append(mods, m)
+		}
+	}
+	if len(ops) == 1 && len(mods) == 0 {
+		return ops[0], nil
+	}
+	if len(mods) == 1 && strings.Trim(mods[0], "01") == "" && len(ops) > 1 {
+		if bits, err := strconv.ParseUint(mods[0], 2, 8); err == nil && int(bits) < len(ops) {
+			return ops[0] + uint8(bits), nil
+		}
+	}
+	sort.Strings(mods)
+	for _, op := range ops {
+		want := append([]string{}, instructionTable[op].modifiers...)
+		sort.Strings(want)
+		if strings.Join(want, ",") == strings.Join(mods, ",") {
+			return op, nil
+		}
+	}
+	return 0, errors.New("unknown modifiers [" + text + "] for " + name)
+}
+
+// Assemble turns text in the form Disassemble writes back into bytecode.
+// Every line holds one instruction, optionally followed by its pushed
+// values; everything after a ';' is a comment. The brackets may be left out
+// of mnemonics without modifiers.
+func Assemble(text string) ([]uint8, error) {
+	var code []uint8
+	for n, line := range strings.Split(text, "\n") {
+		lineErr := func(msg string) error {
+			return errors.New("line " + strconv.Itoa(n+1) + ": " + msg)
+		}
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		mnemonic, args := line, ""
+		if i := strings.IndexByte(line, ']'); i >= 0 {
+			mnemonic, args = line[:i+1], line[i+1:]
+		} else if i := strings.IndexAny(line, " \t"); i >= 0 {
+			mnemonic, args = line[:i], line[i:]
+		}
+		name, mods := mnemonic, ""
+		if i := strings.IndexByte(mnemonic, '['); i >= 0 {
+			if !strings.HasSuffix(mnemonic, "]") {
+				return nil, lineErr("unterminated modifiers")
+			}
+			name, mods = strings.TrimSpace(mnemonic[:i]), mnemonic[i+1:len(mnemonic)-1]
+		}
+		name = strings.ToUpper(name)
+
+		if name == "UNDEF" {
+			op, err := strconv.ParseUint(strings.TrimSpace(mods), 0, 8)
+			if err != nil {
+				return nil, lineErr("bad opcode " + mods)
+			}
+			code = append(code, uint8(op))
+			continue
+		}
+
+		var values []int
+		for _, field := range strings.Fields(args) {
+			v, err := strconv.ParseInt(field, 0, 32)
+			if err != nil {
+				return nil, lineErr("bad value " + field)
+			}
+			values = append(values, int(v))
+		}
+
+		switch name {
+		case "PUSHB", "PUSHW", "NPUSHB", "NPUSHW":
+			if strings.TrimSpace(mods) != "" {
+				return nil, lineErr(name + " takes no modifiers")
+			}
+			word := name == "PUSHW" || name == "NPUSHW"
+			switch {
+			case name == "PUSHB" || name == "PUSHW":
+				if len(values) < 1 || len(values) > 8 {
+					return nil, lineErr(name + " pushes 1 to 8 values")
+				}
+				if word {
+					code = append(code, OP_PUSHW+uint8(len(values)-1))
+				} else {
+					code = append(code, OP_PUSHB+uint8(len(values)-1))
+				}
+			default:
+				if len(values) > 255 {
+					return nil, lineErr(name + " pushes at most 255 values")
+				}
+				if word {
+					code = append(code, OP_NPUSHW, uint8(len(values)))
+				} else {
+					code = append(code, OP_NPUSHB, uint8(len(values)))
+				}
+			}
+			for _, v := range values {
+				if word {
+					if v < -0x8000 || v > 0xFFFF {
+						return nil, lineErr("word value out of range " + strconv.Itoa(v))
+					}
+					code = append(code, writeUint16(uint16(v))...)
+				} else {
+					if v < 0 || v > 0xFF {
+						return nil, lineErr("byte value out of range " + strconv.Itoa(v))
+					}
+					code = append(code, uint8(v))
+				}
+			}
+		default:
+			if len(values) > 0 {
+				return nil, lineErr(name + " takes no values")
+			}
+			op, err := parseModifiers(name, mods)
+			if err != nil {
+				return nil, lineErr(err.Error())
+			}
+			code = append(code, op)
+		}
+	}
+	return code, nil
+}
@@ -92,7 +92,7 @@ func getWindowsFormat2KernData() []byte {
 		// Subtable header (starts at byte 4)
 		0x00, 0x00, // subtable version = 0
 		0x00, 0x26, // length = 38 bytes
-		0x00, 0x02, // coverage = 0x0002 (horizontal, format 2)
+		0x02, 0x01, // coverage = 0x0201 (horizontal, format 2)
 		0x00, 0x04, // rowWidth = 4 bytes per row
 		0x00, 0x0E, // leftOffsetTable = 14 (from subtable start)
 		0x00, 0x16, // rightOffsetTable = 22 (from subtable start)
@@ -174,3 +174,43 @@ func getMacFormat3KernData() []byte {
 		0x00, 0x01, 0x01, 0x00, // kernIndex[0..3]
 	}
 }
+
+// Windows kern table with three subtables test data
+func getWindowsMultiKernData() []byte {
+	// Kern table version 0 (Windows), nTables=3
+	// subtable 0: format 0, horizontal, pairs A-V=-50, F-.=-30
+	// subtable 1: format 0, horizontal, pair A-V=-10 (accumulates with subtable 0)
+	// subtable 2: format 0, cross-stream, pair A-V=99 (ignored by Kerning)
+	return []byte{
+		0x00, 0x00, // version = 0 (Windows)
+		0x00, 0x03, // nTables = 3
+		// Subtable 0
+		0x00, 0x00, // subtable version = 0
+		0x00, 0x1A, // length = 26
+		0x00, 0x01, // coverage = 0x0001 (horizontal, format 0)
+		0x00, 0x02, // nPairs = 2
+		0x00, 0x0C, // searchRange = 12
+		0x00, 0x01, // entrySelector = 1
+		0x00, 0x00, // rangeShift = 0
+		0x00, 0x41, 0x00, 0x56, 0xFF, 0xCE, // A-V = -50
+		0x00, 0x46, 0x00, 0x2E, 0xFF, 0xE2, // F-. = -30
+		// Subtable 1
+		0x00, 0x00, // subtable version = 0
+		0x00, 0x14, // length = 20
+		0x00, 0x01, // coverage = 0x0001 (horizontal, format 0)
+		0x00, 0x01, // nPairs = 1
+		0x00, 0x06, // searchRange = 6
+		0x00, 0x00, // entrySelector = 0
+		0x00, 0x00, // rangeShift = 0
+		0x00, 0x41, 0x00, 0x56, 0xFF, 0xF6, // A-V = -10
+		// Subtable 2
+		0x00, 0x00, // subtable version = 0
+		0x00, 0x14, // length = 20
+		0x00, 0x05, // coverage = 0x0005 (horizontal, cross-stream, format 0)
+		0x00, 0x01, // nPairs = 1
+		0x00, 0x06, // searchRange = 6
+		0x00, 0x00, // entrySelector = 0
+		0x00, 0x00, // rangeShift = 0
+		0x00, 0x41, 0x00, 0x56, 0x00, 0x63, // A-V = 99
+	}
+}
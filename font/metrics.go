@@ -0,0 +1,583 @@
+package font
+
+import (
+	"math"
+	"sort"
+)
+
+// maxComponentNesting bounds compound glyph recursion so that a component
+// cycle in a broken font cannot loop forever.
+const maxComponentNesting = 64
+
+// glyphOutline is a glyph flattened to absolute coordinates, with every
+// component transformed into place.
+type glyphOutline struct {
+	xs, ys   []float64
+	contours int
+	// depth is the component nesting depth, 0 for simple glyphs
+	depth int
+}
+
+// bounds returns the rounded bounding box of the outline; ok is false when
+// the outline has no points.
+func (o *glyphOutline) bounds() (xMin, yMin, xMax, yMax int16, ok bool) {
+	if o == nil || len(o.xs) == 0 {
+		return 0, 0, 0, 0, false
+	}
+	minX, minY, maxX, maxY := o.xs[0], o.ys[0], o.xs[0], o.ys[0]
+	for i := range o.xs {
+		minX = math.Min(minX, o.xs[i])
+		maxX = math.Max(maxX, o.xs[i])
+		minY = math.Min(minY, o.ys[i])
+		maxY = math.Max(maxY, o.ys[i])
+	}
+	round := func(v float64) int16 { return int16(math.Floor(v + 0.5)) }
+	return round(minX), round(minY), round(maxX), round(maxY), true
+}
+
+// glyphSet indexes Glyphs by glyph ID and resolves compound outlines.
+type glyphSet struct {
+	simples   map[int]*GlyphSimple
+	compounds map[int]*GlyphCompound
+	outlines  map[int]*glyphOutline
+}
+
+func newGlyphSet(glyphs *Glyphs) *glyphSet {
+	gs := &glyphSet{
+		simples:   make(map[int]*GlyphSimple),
+		compounds: make(map[int]*GlyphCompound),
+		outlines:  make(map[int]*glyphOutline),
+	}
+	if glyphs == nil {
+		return gs
+	}
+	for i := range glyphs.Simples {
+		gs.simples[glyphs.Simples[i].Index] = &glyphs.Simples[i]
+	}
+	for i := range glyphs.Compounds {
+		gs.compounds[glyphs.Compounds[i].Index] = &glyphs.Compounds[i]
+	}
+	return gs
+}
+
+// simpleCoordinates returns the absolute point coordinates of a simple
+// glyph. Points store the deltas as they appear in the glyf table.
+func simpleCoordinates(simple *GlyphSimple) (xs, ys []int) {
+	x, y := 0, 0
+	for _, p := range simple.Points {
+		x += int(int16(p.X))
+		y += int(int16(p.Y))
+		xs = append(xs, x)
+		ys = append(ys, y)
+	}
+	return
+}
+
+// setSimpleCoordinates stores absolute point coordinates in a simple glyph
+// as deltas, with flags that encode each delta in as few bytes as possible
+func setSimpleCoordinates(simple *GlyphSimple, xs, ys []int) {
+	x, y := 0, 0
+	for i, p := range simple.Points {
+		dx, dy := xs[i]-x, ys[i]-y
+		x, y = xs[i], ys[i]
+		flag := &Flag{OnCurve: p.Flag != nil && p.Flag.OnCurve}
+		// a short vector's sign lives in the same bit as "same as before"
+		flag.XShortVector = dx >= -255 && dx <= 255 && dx != 0
+		flag.XSame = dx == 0 || (flag.XShortVector && dx > 0)
+		flag.YShortVector = dy >= -255 && dy <= 255 && dy != 0
+		flag.YSame = dy == 0 || (flag.YShortVector && dy > 0)
+		p.X, p.Y, p.Flag = dx, dy, flag
+	}
+}
+
+// phantomPoints returns the phantom points of glyph gid in font units: the
+// horizontal origin and advance, then the vertical origin and advance
+func (f *Font) phantomPoints(gid int) [4]hintPoint {
+	tables := f.fontInfo.Tables
+	var xMin int16
+	if common := f.fontInfo.Glyphs.common(gid); common != nil {
+		xMin = common.XMin
+	}
+	var advance uint16
+	var lsb int16
+	if tables.Hmtx != nil {
+		advance, lsb = tables.Hmtx.Metric(gid)
+	}
+	top := int32(f.VerticalOrigin(gid))
+	var advanceHeight int32
+	if tables.Vmtx != nil {
+		ah, _ := tables.Vmtx.Metric(gid)
+		advanceHeight = int32(ah)
+	} else if tables.Hhea != nil {
+		advanceHeight = int32(tables.Hhea.Ascent) - int32(tables.Hhea.Descent)
+	}
+	x := int32(xMin) - int32(lsb)
+	return [4]hintPoint{
+		{x, 0},
+		{x + int32(advance), 0},
+		{0, top},
+		{0, top - advanceHeight},
+	}
+}
+
+// outline returns the flattened outline of glyph gid. Empty glyphs give an
+// empty outline; nil is returned only when components nest too deeply.
+func (gs *glyphSet) outline(gid int, nesting int) *glyphOutline {
+	if o, ok := gs.outlines[gid]; ok {
+		return o
+	}
+	if nesting > maxComponentNesting {
+		return nil
+	}
+	o := &glyphOutline{}
+	if simple, ok := gs.simples[gid]; ok {
+		xs, ys := simpleCoordinates(simple)
+		for i := range xs {
+			o.xs = append(o.xs, float64(xs[i]))
+			o.ys = append(o.ys, float64(ys[i]))
+		}
+		o.contours = len(simple.EndPtsOfContours)
+	} else if compound, ok := gs.compounds[gid]; ok {
+		for _, comp := range compound.Component {
+			child := gs.outline(int(comp.GlyphIndex), nesting+1)
+			if child == nil {
+				return nil
+			}
+			a, b, c, d := 1.0, 0.0, 0.0, 1.0
+			if comp.Flags&(WE_HAVE_A_SCALE|WE_HAVE_AN_X_AND_Y_SCALE|WE_HAVE_A_TWO_BY_TWO) != 0 {
+				a, b, c, d = float64(comp.Xscale), float64(comp.Scale01), float64(comp.Scale10), float64(comp.Yscale)
+			}
+			var dx, dy float64
+			if comp.Flags&ARGS_ARE_XY_VALUES != 0 {
+				dx, dy = float64(comp.Argument1), float64(comp.Argument2)
+			} else if p1, p2 := comp.Argument1, comp.Argument2; p1 < len(o.xs) && p2 < len(child.xs) {
+				// Point matching: move the child's point onto the parent's
+				dx = o.xs[p1] - (a*child.xs[p2] + c*child.ys[p2])
+				dy = o.ys[p1] - (b*child.xs[p2] + d*child.ys[p2])
+			}
+			for i := range child.xs {
+				o.xs = append(o.xs, a*child.xs[i]+c*child.ys[i]+dx)
+				o.ys = append(o.ys, b*child.xs[i]+d*child.ys[i]+dy)
+			}
+			o.contours += child.contours
+			if child.depth+1 > o.depth {
+				o.depth = child.depth + 1
+			}
+		}
+	}
+	gs.outlines[gid] = o
+	return o
+}
+
+// recalcGlyphBounds brings the bounding box of every glyph in line with its
+// points and returns the union of all non-empty boxes.
+func recalcGlyphBounds(glyphs *Glyphs, gs *glyphSet) (xMin, yMin, xMax, yMax int16, ok bool) {
+	update := func(common *GlyphCommon) {
+		x0, y0, x1, y1, has := gs.outline(common.Index, 0).bounds()
+		common.XMin, common.YMin, common.XMax, common.YMax = x0, y0, x1, y1
+		if !has {
+			return
+		}
+		if !ok {
+			xMin, yMin, xMax, yMax, ok = x0, y0, x1, y1, true
+			return
+		}
+		if x0 < xMin {
+			xMin = x0
+		}
+		if y0 < yMin {
+			yMin = y0
+		}
+		if x1 > xMax {
+			xMax = x1
+		}
+		if y1 > yMax {
+			yMax = y1
+		}
+	}
+	for i := range glyphs.Simples {
+		glyphs.Simples[i].NumberOfContours = int16(len(glyphs.Simples[i].EndPtsOfContours))
+		update(&glyphs.Simples[i].GlyphCommon)
+	}
+	for i := range glyphs.Compounds {
+		update(&glyphs.Compounds[i].GlyphCommon)
+	}
+	return
+}
+
+// recalcMaxp sets the outline limits of a version 1.0 maxp table from the
+// glyphs. Hinting limits are left alone.
+func recalcMaxp(maxp *Maxp, glyphs *Glyphs, gs *glyphSet) {
+	if maxp.Version != "1.0" {
+		return
+	}
+	var maxPoints, maxContours, maxCompPoints, maxCompContours, maxElements, maxDepth int
+	for i := range glyphs.Simples {
+		simple := &glyphs.Simples[i]
+		if n := len(simple.Points); n > maxPoints {
+			maxPoints = n
+		}
+		if n := len(simple.EndPtsOfContours); n > maxContours {
+			maxContours = n
+		}
+	}
+	for i := range glyphs.Compounds {
+		compound := &glyphs.Compounds[i]
+		if n := len(compound.Component); n > maxElements {
+			maxElements = n
+		}
+		o := gs.outline(compound.Index, 0)
+		if o == nil {
+			continue
+		}
+		if len(o.xs) > maxCompPoints {
+			maxCompPoints = len(o.xs)
+		}
+		if o.contours > maxCompContours {
+			maxCompContours = o.contours
+		}
+		if o.depth > maxDepth {
+			maxDepth = o.depth
+		}
+	}
+	maxp.MaxPoints = uint16(maxPoints)
+	maxp.MaxContours = uint16(maxContours)
+	maxp.MaxComponentPoints = uint16(maxCompPoints)
+	maxp.MaxComponentContours = uint16(maxCompContours)
+	maxp.MaxComponentElements = uint16(maxElements)
+	maxp.MaxComponentDepth = uint16(maxDepth)
+}
+
+// recalcHhea sets the horizontal extremes of hhea from the glyph bounding
+// boxes and hmtx, and aligns each outlined glyph's left side bearing with
+// its xMin.
+func recalcHhea(hhea *Hhea, hmtx *Hmtx, numGlyphs int, gs *glyphSet) {
+	var advanceMax uint16
+	minLSB, minRSB, maxExtent := math.MaxInt32, math.MaxInt32, math.MinInt32
+	for gid := 0; gid < numGlyphs; gid++ {
+		advance, _ := hmtx.Metric(gid)
+		if advance > advanceMax {
+			advanceMax = advance
+		}
+		xMin, _, xMax, _, ok := gs.outline(gid, 0).bounds()
+		if !ok {
+			continue
+		}
+		hmtx.setLeftSideBearing(gid, xMin)
+		lsb, width := int(xMin), int(xMax)-int(xMin)
+		if lsb < minLSB {
+			minLSB = lsb
+		}
+		if rsb := int(advance) - lsb - width; rsb < minRSB {
+			minRSB = rsb
+		}
+		if extent := lsb + width; extent > maxExtent {
+			maxExtent = extent
+		}
+	}
+	hhea.AdvanceWidthMax = advanceMax
+	if maxExtent == math.MinInt32 {
+		// No glyph has an outline
+		minLSB, minRSB, maxExtent = 0, 0, 0
+	}
+	hhea.MinLeftSideBearing = int16(minLSB)
+	hhea.MinRightSideBearing = int16(minRSB)
+	hhea.XMaxExtent = int16(maxExtent)
+}
+
+// recalcVhea sets the vertical extremes of vhea from the glyph bounding
+// boxes and vmtx.
+func recalcVhea(vhea *Vhea, vmtx *Vmtx, numGlyphs int, gs *glyphSet) {
+	var advanceMax uint16
+	minTSB, minBSB, maxExtent := math.MaxInt32, math.MaxInt32, math.MinInt32
+	for gid := 0; gid < numGlyphs; gid++ {
+		advance, tsb := vmtx.Metric(gid)
+		if advance > advanceMax {
+			advanceMax = advance
+		}
+		_, yMin, _, yMax, ok := gs.outline(gid, 0).bounds()
+		if !ok {
+			continue
+		}
+		height := int(yMax) - int(yMin)
+		if int(tsb) < minTSB {
+			minTSB = int(tsb)
+		}
+		if bsb := int(advance) - int(tsb) - height; bsb < minBSB {
+			minBSB = bsb
+		}
+		if extent := int(tsb) + height; extent > maxExtent {
+			maxExtent = extent
+		}
+	}
+	vhea.AdvanceHeightMax = advanceMax
+	if maxExtent == math.MinInt32 {
+		// No glyph has an outline
+		minTSB, minBSB, maxExtent = 0, 0, 0
+	}
+	vhea.MinTopSideBearing = int16(minTSB)
+	vhea.MinBottomSideBearing = int16(minBSB)
+	vhea.YMaxExtent = int16(maxExtent)
+}
+
+// avgCharWidthWeights are the lowercase letter frequencies, per thousand,
+// that OS/2 versions before 3 use to weight xAvgCharWidth.
+var avgCharWidthWeights = map[rune]int{
+	'a': 64, 'b': 14, 'c': 27, 'd': 35, 'e': 100, 'f': 20, 'g': 14, 'h': 42, 'i': 63,
+	'j': 3, 'k': 6, 'l': 35, 'm': 20, 'n': 56, 'o': 56, 'p': 17, 'q': 4, 'r': 49,
+	's': 56, 't': 71, 'u': 31, 'v': 10, 'w': 18, 'x': 3, 'y': 18, 'z': 2, ' ': 166,
+}
+
+// avgCharWidth computes OS/2 xAvgCharWidth: the average of all non-zero
+// advance widths, or for versions before 3 the weighted average of the
+// lowercase Latin letters and space when the font maps all of them.
+func avgCharWidth(version uint16, hmtx *Hmtx, numGlyphs int, cmap *Cmap) int16 {
+	if version < 3 && cmap != nil {
+		total, complete := 0, true
+		for r, weight := range avgCharWidthWeights {
+			gid, ok := cmap.GlyphIndex(int(r))
+			if !ok {
+				complete = false
+				break
+			}
+			advance, _ := hmtx.Metric(gid)
+			total += int(advance) * weight
+		}
+		if complete {
+			return int16(math.Floor(float64(total)/1000 + 0.5))
+		}
+	}
+	total, count := 0, 0
+	for gid := 0; gid < numGlyphs; gid++ {
+		if advance, _ := hmtx.Metric(gid); advance > 0 {
+			total += int(advance)
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return int16(math.Floor(float64(total)/float64(count) + 0.5))
+}
+
+// unicodeRangeBlock is one block counted by an OS/2 ulUnicodeRange bit
+type unicodeRangeBlock struct {
+	bit        uint
+	start, end int
+}
+
+// unicodeRangeBlocks lists the blocks behind each OS/2 ulUnicodeRange bit.
+// Bit 57 (non-plane 0) is handled separately.
+var unicodeRangeBlocks = []unicodeRangeBlock{
+	{0, 0x0000, 0x007F}, {1, 0x0080, 0x00FF}, {2, 0x0100, 0x017F}, {3, 0x0180, 0x024F},
+	{4, 0x0250, 0x02AF}, {4, 0x1D00, 0x1D7F}, {4, 0x1D80, 0x1DBF},
+	{5, 0x02B0, 0x02FF}, {5, 0xA700, 0xA71F},
+	{6, 0x0300, 0x036F}, {6, 0x1DC0, 0x1DFF},
+	{7, 0x0370, 0x03FF}, {8, 0x2C80, 0x2CFF},
+	{9, 0x0400, 0x04FF}, {9, 0x0500, 0x052F}, {9, 0x2DE0, 0x2DFF}, {9, 0xA640, 0xA69F},
+	{10, 0x0530, 0x058F}, {11, 0x0590, 0x05FF}, {12, 0xA500, 0xA63F},
+	{13, 0x0600, 0x06FF}, {13, 0x0750, 0x077F},
+	{14, 0x07C0, 0x07FF}, {15, 0x0900, 0x097F}, {16, 0x0980, 0x09FF}, {17, 0x0A00, 0x0A7F},
+	{18, 0x0A80, 0x0AFF}, {19, 0x0B00, 0x0B7F}, {20, 0x0B80, 0x0BFF}, {21, 0x0C00, 0x0C7F},
+	{22, 0x0C80, 0x0CFF}, {23, 0x0D00, 0x0D7F}, {24, 0x0E00, 0x0E7F}, {25, 0x0E80, 0x0EFF},
+	{26, 0x10A0, 0x10FF}, {26, 0x2D00, 0x2D2F},
+	{27, 0x1B00, 0x1B7F}, {28, 0x1100, 0x11FF},
+	{29, 0x1E00, 0x1EFF}, {29, 0x2C60, 0x2C7F}, {29, 0xA720, 0xA7FF},
+	{30, 0x1F00, 0x1FFF},
+	{31, 0x2000, 0x206F}, {31, 0x2E00, 0x2E7F},
+	{32, 0x2070, 0x209F}, {33, 0x20A0, 0x20CF}, {34, 0x20D0, 0x20FF}, {35, 0x2100, 0x214F},
+	{36, 0x2150, 0x218F},
+	{37, 0x2190, 0x21FF}, {37, 0x27F0, 0x27FF}, {37, 0x2900, 0x297F}, {37, 0x2B00, 0x2BFF},
+	{38, 0x2200, 0x22FF}, {38, 0x2A00, 0x2AFF}, {38, 0x27C0, 0x27EF}, {38, 0x2980, 0x29FF},
+	{39, 0x2300, 0x23FF}, {40, 0x2400, 0x243F}, {41, 0x2440, 0x245F}, {42, 0x2460, 0x24FF},
+	{43, 0x2500, 0x257F}, {44, 0x2580, 0x259F}, {45, 0x25A0, 0x25FF}, {46, 0x2600, 0x26FF},
+	{47, 0x2700, 0x27BF}, {48, 0x3000, 0x303F}, {49, 0x3040, 0x309F},
+	{50, 0x30A0, 0x30FF}, {50, 0x31F0, 0x31FF},
+	{51, 0x3100, 0x312F}, {51, 0x31A0, 0x31BF},
+	{52, 0x3130, 0x318F}, {53, 0xA840, 0xA87F}, {54, 0x3200, 0x32FF}, {55, 0x3300, 0x33FF},
+	{56, 0xAC00, 0xD7AF}, {58, 0x10900, 0x1091F},
+	{59, 0x4E00, 0x9FFF}, {59, 0x2E80, 0x2EFF}, {59, 0x2F00, 0x2FDF}, {59, 0x2FF0, 0x2FFF},
+	{59, 0x3400, 0x4DBF}, {59, 0x20000, 0x2A6DF}, {59, 0x3190, 0x319F},
+	{60, 0xE000, 0xF8FF},
+	{61, 0x31C0, 0x31EF}, {61, 0xF900, 0xFAFF}, {61, 0x2F800, 0x2FA1F},
+	{62, 0xFB00, 0xFB4F}, {63, 0xFB50, 0xFDFF}, {64, 0xFE20, 0xFE2F},
+	{65, 0xFE10, 0xFE1F}, {65, 0xFE30, 0xFE4F},
+	{66, 0xFE50, 0xFE6F}, {67, 0xFE70, 0xFEFF}, {68, 0xFF00, 0xFFEF}, {69, 0xFFF0, 0xFFFF},
+	{70, 0x0F00, 0x0FFF}, {71, 0x0700, 0x074F}, {72, 0x0780, 0x07BF}, {73, 0x0D80, 0x0DFF},
+	{74, 0x1000, 0x109F},
+	{75, 0x1200, 0x137F}, {75, 0x1380, 0x139F}, {75, 0x2D80, 0x2DDF},
+	{76, 0x13A0, 0x13FF}, {77, 0x1400, 0x167F}, {78, 0x1680, 0x169F}, {79, 0x16A0, 0x16FF},
+	{80, 0x1780, 0x17FF}, {80, 0x19E0, 0x19FF},
+	{81, 0x1800, 0x18AF}, {82, 0x2800, 0x28FF},
+	{83, 0xA000, 0xA48F}, {83, 0xA490, 0xA4CF},
+	{84, 0x1700, 0x171F}, {84, 0x1720, 0x173F}, {84, 0x1740, 0x175F}, {84, 0x1760, 0x177F},
+	{85, 0x10300, 0x1032F}, {86, 0x10330, 0x1034F}, {87, 0x10400, 0x1044F},
+	{88, 0x1D000, 0x1D0FF}, {88, 0x1D100, 0x1D1FF}, {88, 0x1D200, 0x1D24F},
+	{89, 0x1D400, 0x1D7FF},
+	{90, 0xF0000, 0xFFFFD}, {90, 0x100000, 0x10FFFD},
+	{91, 0xFE00, 0xFE0F}, {91, 0xE0100, 0xE01EF},
+	{92, 0xE0000, 0xE007F}, {93, 0x1900, 0x194F}, {94, 0x1950, 0x197F}, {95, 0x1980, 0x19DF},
+	{96, 0x1A00, 0x1A1F}, {97, 0x2C00, 0x2C5F}, {98, 0x2D30, 0x2D7F}, {99, 0x4DC0, 0x4DFF},
+	{100, 0xA800, 0xA82F},
+	{101, 0x10000, 0x1007F}, {101, 0x10080, 0x100FF}, {101, 0x10100, 0x1013F},
+	{102, 0x10140, 0x1018F}, {103, 0x10380, 0x1039F}, {104, 0x103A0, 0x103DF},
+	{105, 0x10450, 0x1047F}, {106, 0x10480, 0x104AF}, {107, 0x10800, 0x1083F},
+	{108, 0x10A00, 0x10A5F}, {109, 0x1D300, 0x1D35F},
+	{110, 0x12000, 0x123FF}, {110, 0x12400, 0x1247F},
+	{111, 0x1D360, 0x1D37F}, {112, 0x1B80, 0x1BBF}, {113, 0x1C00, 0x1C4F}, {114, 0x1C50, 0x1C7F},
+	{115, 0xA880, 0xA8DF}, {116, 0xA900, 0xA92F}, {117, 0xA930, 0xA95F}, {118, 0xAA00, 0xAA5F},
+	{119, 0x10190, 0x101CF}, {120, 0x101D0, 0x101FF},
+	{121, 0x102A0, 0x102DF}, {121, 0x10280, 0x1029F}, {121, 0x10920, 0x1093F},
+	{122, 0x1F030, 0x1F09F}, {122, 0x1F000, 0x1F02F},
+}
+
+// unicodeRanges returns the four OS/2 ulUnicodeRange words for the given
+// code points.
+func unicodeRanges(codes []int) []uint32 {
+	blocks := append([]unicodeRangeBlock(nil), unicodeRangeBlocks...)
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].start < blocks[j].start })
+	ranges := make([]uint32, 4)
+	set := func(bit uint) { ranges[bit/32] |= 1 << (bit % 32) }
+	for _, code := range codes {
+		if code > 0xFFFF {
+			set(57)
+		}
+		i := sort.Search(len(blocks), func(i int) bool { return blocks[i].end >= code })
+		if i < len(blocks) && blocks[i].start <= code {
+			set(blocks[i].bit)
+		}
+	}
+	return ranges
+}
+
+// codePageRanges returns the two OS/2 ulCodePageRange words for the given
+// code points. A code page is claimed when the font maps a character
+// characteristic of it, plus printable ASCII or box drawing where the code
+// page needs them. Latin 1 is claimed when nothing else is, so that the
+// font stays usable in applications that filter on code pages.
+func codePageRanges(codes []int) []uint32 {
+	has := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		has[code] = true
+	}
+	ascii := true
+	for c := 0x20; c < 0x7E; c++ {
+		if !has[c] {
+			ascii = false
+			break
+		}
+	}
+	lineArt := has['┤']
+	bits := make(map[uint]bool)
+	for code := range has {
+		switch {
+		case code == 'Þ' && ascii:
+			bits[0] = true // Latin 1
+		case code == 'Ľ' && ascii:
+			bits[1] = true // Latin 2: Eastern Europe
+			if lineArt {
+				bits[58] = true // Latin 2
+			}
+		case code == 'Б':
+			bits[2] = true // Cyrillic
+			if has['Ѕ'] && lineArt {
+				bits[57] = true // IBM Cyrillic
+			}
+			if has['╜'] && lineArt {
+				bits[49] = true // MS-DOS Russian
+			}
+		case code == 'Ά':
+			bits[3] = true // Greek
+			if lineArt && has['½'] {
+				bits[48] = true // IBM Greek
+			}
+			if lineArt && has['√'] {
+				bits[60] = true // Greek, former 437 G
+			}
+		case code == 'İ' && ascii:
+			bits[4] = true // Turkish
+			if lineArt {
+				bits[56] = true // IBM Turkish
+			}
+		case code == 'א':
+			bits[5] = true // Hebrew
+			if lineArt && has['√'] {
+				bits[53] = true // Hebrew
+			}
+		case code == 'ر':
+			bits[6] = true // Arabic
+			if has['√'] {
+				bits[51] = true // Arabic
+			}
+			if lineArt {
+				bits[61] = true // Arabic; ASMO 708
+			}
+		case code == 'ŗ' && ascii:
+			bits[7] = true // Windows Baltic
+			if lineArt {
+				bits[59] = true // MS-DOS Baltic
+			}
+		case code == '₫' && ascii:
+			bits[8] = true // Vietnamese
+		case code == 'ๅ':
+			bits[16] = true // Thai
+		case code == 'エ':
+			bits[17] = true // JIS/Japan
+		case code == 'ㄅ':
+			bits[18] = true // Chinese: Simplified
+		case code == 'ㄱ':
+			bits[19] = true // Korean Wansung
+		case code == '央':
+			bits[20] = true // Chinese: Traditional
+		case code == '곴':
+			bits[21] = true // Korean Johab
+		case code == '♥' && ascii:
+			bits[30] = true // OEM character set
+		case code == 'þ' && ascii && lineArt:
+			bits[54] = true // MS-DOS Icelandic
+		case code == '╚' && ascii:
+			bits[62] = true // WE/Latin 1
+			bits[63] = true // US
+		case ascii && lineArt && has['√']:
+			switch code {
+			case 'Å':
+				bits[50] = true // MS-DOS Nordic
+			case 'é':
+				bits[52] = true // MS-DOS Canadian French
+			case 'õ':
+				bits[55] = true // MS-DOS Portuguese
+			}
+		}
+	}
+	if ascii && has['‰'] && has['∑'] {
+		bits[29] = true // Macintosh character set (US Roman)
+	}
+	if len(bits) == 0 {
+		bits[0] = true
+	}
+	ranges := make([]uint32, 2)
+	for bit := range bits {
+		ranges[bit/32] |= 1 << (bit % 32)
+	}
+	return ranges
+}
+
+// maxContext returns the largest number of glyphs any GPOS lookup looks at,
+// but never less than current: GSUB and contextual GPOS lookups are not
+// decoded, so the current value stands for their contexts.
+func maxContext(gpos *Gpos, current uint16) uint16 {
+	ctx := current
+	if gpos == nil {
+		return ctx
+	}
+	for _, lookup := range gpos.Lookups {
+		n := uint16(2)
+		if lookup.LookupType == 1 {
+			n = 1
+		}
+		if n > ctx {
+			ctx = n
+		}
+	}
+	return ctx
+}
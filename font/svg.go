@@ -0,0 +1,316 @@
+package font
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SvgDocument is one SVG document record, covering glyphs StartGlyphID to
+// EndGlyphID. Document is always the uncompressed text; Compressed makes
+// Write gzip it.
+type SvgDocument struct {
+	StartGlyphID uint16 `json:"startGlyphId"`
+	EndGlyphID   uint16 `json:"endGlyphId"`
+	Document     string `json:"document"`
+	Compressed   bool   `json:"compressed"`
+	// raw keeps the stored bytes of rawText so unchanged documents are
+	// written back as they were read
+	raw     []byte
+	rawText string
+}
+
+type Svg struct {
+	Version   uint16         `json:"version"`
+	Documents []*SvgDocument `json:"documents"`
+}
+
+func isGzip(data []byte) bool {
+	return len(data) >= 3 && data[0] == 0x1F && data[1] == 0x8B && data[2] == 0x08
+}
+
+func GetSvg(data []byte, pos int) (svg *Svg, err error) {
+	start := pos
+	if pos+10 > len(data) {
+		return nil, errors.New("SVG table truncated")
+	}
+	svg = &Svg{Version: getUint16(data[pos : pos+2])}
+	listPos := start + int(getUint32(data[pos+2:pos+6]))
+	if listPos+2 > len(data) {
+		return nil, errors.New("SVG document list truncated")
+	}
+	num := int(getUint16(data[listPos : listPos+2]))
+	if listPos+2+num*12 > len(data) {
+		return nil, errors.New("SVG document list truncated")
+	}
+	for i := 0; i < num; i++ {
+		p := listPos + 2 + i*12
+		docPos := listPos + int(getUint32(data[p+4:p+8]))
+		docLen := int(getUint32(data[p+8 : p+12]))
+		if docPos+docLen > len(data) {
+			return nil, errors.New("SVG document out of range")
+		}
+		doc := &SvgDocument{
+			StartGlyphID: getUint16(data[p : p+2]),
+			EndGlyphID:   getUint16(data[p+2 : p+4]),
+			raw:          append([]byte{}, data[docPos:docPos+docLen]...),
+		}
+		text := doc.raw
+		if isGzip(doc.raw) {
+			doc.Compressed = true
+			reader, gzErr := gzip.NewReader(bytes.NewReader(doc.raw))
+			if gzErr != nil {
+				return nil, gzErr
+			}
+			if text, err = ioutil.ReadAll(reader); err != nil {
+				return nil, err
+			}
+		}
+		doc.Document = string(text)
+		doc.rawText = doc.Document
+		svg.Documents = append(svg.Documents, doc)
+	}
+	return svg, nil
+}
+
+// encode returns the bytes stored for the document
+func (doc *SvgDocument) encode() []byte {
+	if doc.raw != nil && doc.Document == doc.rawText && isGzip(doc.raw) == doc.Compressed {
+		return doc.raw
+	}
+	if !doc.Compressed {
+		return []byte(doc.Document)
+	}
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	writer.Write([]byte(doc.Document))
+	writer.Close()
+	return buf.Bytes()
+}
+
+// WriteSvg writes the document records sorted by glyph range. Records with
+// identical documents share one copy.
+func WriteSvg(svg *Svg) []byte {
+	docs := append([]*SvgDocument{}, svg.Documents...)
+	sort.SliceStable(docs, func(i, j int) bool { return docs[i].StartGlyphID < docs[j].StartGlyphID })
+
+	data := []byte{}
+	data = append(data, writeUint16(svg.Version)...)
+	data = append(data, writeUint32(10)...)
+	data = append(data, writeUint32(0)...)
+	data = append(data, writeUint16(uint16(len(docs)))...)
+	var body []byte
+	offsets := make(map[string]int)
+	bodyStart := 2 + len(docs)*12
+	for _, doc := range docs {
+		encoded := doc.encode()
+		offset, ok := offsets[string(encoded)]
+		if !ok {
+			offset = bodyStart + len(body)
+			offsets[string(encoded)] = offset
+			body = append(body, encoded...)
+		}
+		data = append(data, writeUint16(doc.StartGlyphID)...)
+		data = append(data, writeUint16(doc.EndGlyphID)...)
+		data = append(data, writeUint32(uint32(offset))...)
+		data = append(data, writeUint32(uint32(len(encoded)))...)
+	}
+	return append(data, body...)
+}
+
+// GlyphDocument returns the document holding glyph gid, or nil
+func (svg *Svg) GlyphDocument(gid int) *SvgDocument {
+	for _, doc := range svg.Documents {
+		if int(doc.StartGlyphID) <= gid && gid <= int(doc.EndGlyphID) {
+			return doc
+		}
+	}
+	return nil
+}
+
+// svgTag is one start or end tag of an SVG document
+type svgTag struct {
+	start, end  int
+	name        string
+	closing     bool
+	selfClosing bool
+	glyph       int // N of an id="glyphN" attribute, -1 without one
+}
+
+var svgGlyphID = regexp.MustCompile(`\sid\s*=\s*["']glyph(\d+)["']`)
+
+// scanSvgTags lists the element tags of doc, skipping comments, CDATA
+// sections, processing instructions and declarations
+func scanSvgTags(doc string) []svgTag {
+	var tags []svgTag
+	for i := 0; i < len(doc); {
+		lt := strings.IndexByte(doc[i:], '<')
+		if lt < 0 {
+			break
+		}
+		i += lt
+		rest := doc[i:]
+		skipTo := func(end string) {
+			if n := strings.Index(rest, end); n >= 0 {
+				i += n + len(end)
+			} else {
+				i = len(doc)
+			}
+		}
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			skipTo("-->")
+			continue
+		case strings.HasPrefix(rest, "<![CDATA["):
+			skipTo("]]>")
+			continue
+		case strings.HasPrefix(rest, "<?"):
+			skipTo("?>")
+			continue
+		case strings.HasPrefix(rest, "<!"):
+			skipTo(">")
+			continue
+		}
+
+		// find the closing '>' outside quoted attribute values
+		end := -1
+		var quote byte
+		for j := 1; j < len(rest); j++ {
+			c := rest[j]
+			if quote != 0 {
+				if c == quote {
+					quote = 0
+				}
+			} else if c == '"' || c == '\'' {
+				quote = c
+			} else if c == '>' {
+				end = j + 1
+				break
+			}
+		}
+		if end < 0 {
+			break
+		}
+		text := rest[:end]
+		tag := svgTag{start: i, end: i + end, glyph: -1}
+		body := strings.TrimPrefix(text[1:len(text)-1], "/")
+		tag.closing = strings.HasPrefix(text, "</")
+		tag.selfClosing = strings.HasSuffix(text, "/>")
+		if n := strings.IndexAny(body, " \t\r\n/"); n >= 0 {
+			tag.name = body[:n]
+		} else {
+			tag.name = body
+		}
+		if m := svgGlyphID.FindStringSubmatch(text); m != nil && !tag.closing {
+			tag.glyph, _ = strconv.Atoi(m[1])
+		}
+		tags = append(tags, tag)
+		i += end
+	}
+	return tags
+}
+
+var svgGlyphRef = regexp.MustCompile(`(\sid\s*=\s*["']glyph|#glyph)(\d+)\b`)
+
+// subsetSvgDocument removes the glyph elements of glyphs that are not
+// retained and renames the ids of the others, and references to them, to
+// the new glyph indices
+func subsetSvgDocument(doc string, oldToNew map[int]int) string {
+	tags := scanSvgTags(doc)
+	var cuts [][2]int
+	for i := 0; i < len(tags); i++ {
+		tag := tags[i]
+		if tag.closing || tag.glyph < 0 {
+			continue
+		}
+		if _, ok := oldToNew[tag.glyph]; ok {
+			continue
+		}
+		end := tag.end
+		if !tag.selfClosing {
+			// find the matching end tag and continue after it
+			depth := 0
+			for j := i + 1; j < len(tags); j++ {
+				if tags[j].name != tag.name || tags[j].selfClosing {
+					continue
+				}
+				if !tags[j].closing {
+					depth++
+					continue
+				}
+				if depth == 0 {
+					end = tags[j].end
+					i = j
+					break
+				}
+				depth--
+			}
+		}
+		cuts = append(cuts, [2]int{tag.start, end})
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, cut := range cuts {
+		b.WriteString(doc[last:cut[0]])
+		last = cut[1]
+	}
+	b.WriteString(doc[last:])
+
+	return svgGlyphRef.ReplaceAllStringFunc(b.String(), func(ref string) string {
+		m := svgGlyphRef.FindStringSubmatch(ref)
+		old, _ := strconv.Atoi(m[2])
+		if newGid, ok := oldToNew[old]; ok {
+			return m[1] + strconv.Itoa(newGid)
+		}
+		return ref
+	})
+}
+
+// remapSvg keeps the documents that hold retained glyphs, rewritten to the
+// retained glyph elements. Since glyphs keep their order, the retained
+// glyphs of a record still form one range of new indices.
+func remapSvg(svg *Svg, oldToNew map[int]int) {
+	var docs []*SvgDocument
+	rewritten := make(map[string]string)
+	for _, doc := range svg.Documents {
+		first, last := -1, -1
+		for gid := int(doc.StartGlyphID); gid <= int(doc.EndGlyphID); gid++ {
+			if newGid, ok := oldToNew[gid]; ok {
+				if first < 0 {
+					first = newGid
+				}
+				last = newGid
+			}
+		}
+		if first < 0 {
+			continue
+		}
+		text, ok := rewritten[doc.Document]
+		if !ok {
+			text = subsetSvgDocument(doc.Document, oldToNew)
+			rewritten[doc.Document] = text
+		}
+		doc.StartGlyphID, doc.EndGlyphID = uint16(first), uint16(last)
+		doc.Document = text
+		docs = append(docs, doc)
+	}
+	svg.Documents = docs
+}
+
+// GlyphSVG returns the SVG document that draws glyph gid. The glyph is the
+// element with id "glyph" followed by gid.
+func (f *Font) GlyphSVG(gid int) (doc string, ok bool) {
+	if f.fontInfo == nil || f.fontInfo.Tables.Svg == nil {
+		return "", false
+	}
+	if document := f.fontInfo.Tables.Svg.GlyphDocument(gid); document != nil {
+		return document.Document, true
+	}
+	return "", false
+}
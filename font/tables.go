@@ -231,6 +231,31 @@ type Glyphs struct {
 
 const GLYPH_TYPE_SIMPLE, GLYPH_TYPE_COMPOUND = "simple", "compound"
 
+// common returns the header of glyph gid, or nil for an empty glyph. Glyphs
+// are normally kept in glyph ID order, which allows a binary search.
+func (glyphs *Glyphs) common(gid int) *GlyphCommon {
+	if glyphs == nil {
+		return nil
+	}
+	if i := sort.Search(len(glyphs.Simples), func(i int) bool { return glyphs.Simples[i].Index >= gid }); i < len(glyphs.Simples) && glyphs.Simples[i].Index == gid {
+		return &glyphs.Simples[i].GlyphCommon
+	}
+	if i := sort.Search(len(glyphs.Compounds), func(i int) bool { return glyphs.Compounds[i].Index >= gid }); i < len(glyphs.Compounds) && glyphs.Compounds[i].Index == gid {
+		return &glyphs.Compounds[i].GlyphCommon
+	}
+	for i := range glyphs.Simples {
+		if glyphs.Simples[i].Index == gid {
+			return &glyphs.Simples[i].GlyphCommon
+		}
+	}
+	for i := range glyphs.Compounds {
+		if glyphs.Compounds[i].Index == gid {
+			return &glyphs.Compounds[i].GlyphCommon
+		}
+	}
+	return nil
+}
+
 func GetGlyphSimple(data []byte, pos int, index int) (simple *GlyphSimple) {
 	simple = new(GlyphSimple)
 	simple.GlyphCommon.Index = index
@@ -637,19 +662,19 @@ func WriteGlyphs(glyphs *Glyphs, numGlyphs int) (data []byte, loca []int, err er
 	currentOffset := 0
 	for i := 0; i < numGlyphs; i++ {
 		loca[i] = currentOffset
+		// missing glyphs have an empty body and share the next offset
+		var glyphBytes []byte
 		if s, ok := simpleMap[i]; ok {
-			glyphBytes := WriteGlyphSimple(s)
-			data = append(data, glyphBytes...)
-			currentOffset += len(glyphBytes)
-			continue
+			glyphBytes = WriteGlyphSimple(s)
+		} else if c, ok := compoundMap[i]; ok {
+			glyphBytes = WriteGlyphCompound(c)
 		}
-		if c, ok := compoundMap[i]; ok {
-			glyphBytes := WriteGlyphCompound(c)
-			data = append(data, glyphBytes...)
-			currentOffset += len(glyphBytes)
-			continue
+		if len(glyphBytes)%2 == 1 {
+			// keep offsets even so the short loca format can hold them
+			glyphBytes = append(glyphBytes, 0)
 		}
-		// missing glyph: empty body, only advance loca with same offset
+		data = append(data, glyphBytes...)
+		currentOffset += len(glyphBytes)
 	}
 	loca[numGlyphs] = currentOffset
 
@@ -732,6 +757,85 @@ func WriteMaxp(maxp *Maxp) []byte {
 	return data
 }
 
+// gasp range behavior flags
+const (
+	GASP_GRIDFIT             uint16 = 0x0001
+	GASP_DOGRAY              uint16 = 0x0002
+	GASP_SYMMETRIC_GRIDFIT   uint16 = 0x0004
+	GASP_SYMMETRIC_SMOOTHING uint16 = 0x0008
+)
+
+// GaspRange sets the rasterizer behavior for sizes up to RangeMaxPPEM
+type GaspRange struct {
+	RangeMaxPPEM      uint16 `json:"rangeMaxPPEM"`
+	RangeGaspBehavior uint16 `json:"rangeGaspBehavior"`
+}
+
+type Gasp struct {
+	Version uint16      `json:"version"`
+	Ranges  []GaspRange `json:"ranges"`
+}
+
+func GetGasp(data []byte, pos int) (gasp *Gasp, err error) {
+	if pos+4 > len(data) {
+		return nil, errors.New("gasp table truncated")
+	}
+	gasp = &Gasp{Version: getUint16(data[pos : pos+2])}
+	numRanges := int(getUint16(data[pos+2 : pos+4]))
+	pos += 4
+	if pos+numRanges*4 > len(data) {
+		return nil, errors.New("gasp table truncated")
+	}
+	for i := 0; i < numRanges; i++ {
+		gasp.Ranges = append(gasp.Ranges, GaspRange{getUint16(data[pos : pos+2]), getUint16(data[pos+2 : pos+4])})
+		pos += 4
+	}
+	return gasp, nil
+}
+
+func WriteGasp(gasp *Gasp) []byte {
+	data := []byte{}
+	data = append(data, writeUint16(gasp.Version)...)
+	data = append(data, writeUint16(uint16(len(gasp.Ranges)))...)
+	for _, r := range gasp.Ranges {
+		data = append(data, writeUint16(r.RangeMaxPPEM)...)
+		data = append(data, writeUint16(r.RangeGaspBehavior)...)
+	}
+	return data
+}
+
+// GetCvt reads the control values of the cvt table
+func GetCvt(data []byte, pos int, length int) []int16 {
+	if pos+length > len(data) {
+		length = len(data) - pos
+	}
+	cvt := make([]int16, 0, length/2)
+	for i := 0; i+2 <= length; i += 2 {
+		cvt = append(cvt, getFWord(data[pos+i:pos+i+2]))
+	}
+	return cvt
+}
+
+func WriteCvt(cvt []int16) []byte {
+	data := make([]byte, 0, len(cvt)*2)
+	for _, v := range cvt {
+		data = append(data, writeFWord(v)...)
+	}
+	return data
+}
+
+// GetProgram reads the instruction stream of the fpgm or prep table
+func GetProgram(data []byte, pos int, length int) []uint8 {
+	if pos+length > len(data) {
+		length = len(data) - pos
+	}
+	return append([]uint8{}, data[pos:pos+length]...)
+}
+
+func WriteProgram(program []uint8) []byte {
+	return append([]byte{}, program...)
+}
+
 func GetLoca(data []byte, pos int, numGlyphs uint16, indexToLocFormat int16) (locations []int) {
 	// long version:  otf, ttf is different
 	offsetFn := func(data []byte, pos int) (offset int, nextPos int) {
@@ -797,6 +901,20 @@ type Cmap struct {
 	NumberSubtables uint16                   `json:"numberSubtables"`
 	SubTables       []map[string]interface{} `json:"subTables"`
 	WindowsCode     map[int]int
+	// LastResort holds the format 13 groups, kept as ranges since one
+	// group may span every code point. A code WindowsCode lacks maps to
+	// the glyph of the group that covers it.
+	LastResort []*CmapFormat8nGroup `json:"lastResort,omitempty"`
+	UVS        map[int]*UVSSelector `json:"uvs,omitempty"`
+}
+
+// UVSSelector holds the variation sequences defined for one variation
+// selector by a format 14 subtable. DefaultUVS lists, in ascending order,
+// the base characters that keep their ordinary cmap glyph; NonDefaultUVS
+// maps base characters to the glyph ID of their variant.
+type UVSSelector struct {
+	DefaultUVS    []int       `json:"defaultUVS,omitempty"`
+	NonDefaultUVS map[int]int `json:"nonDefaultUVS,omitempty"`
 }
 
 type CmapChild struct {
@@ -915,11 +1033,61 @@ type CmapFormatNonDefaultUVS struct {
 	VarSelector  int    `json:"varSelector"`
 }
 
+// Ranks of the subtables readWindowsCode merges into WindowsCode. A higher
+// rank overrides the mappings of a lower one.
+const (
+	CMAP_RANK_NONE = iota
+	CMAP_RANK_MAC
+	CMAP_RANK_LEGACY
+	CMAP_RANK_LAST_RESORT
+	CMAP_RANK_SYMBOL
+	CMAP_RANK_UNICODE_BMP
+	CMAP_RANK_UNICODE_FULL
+)
+
+// cmapSubtableRank classifies a subtable by what its character codes mean.
+// https://learn.microsoft.com/en-us/typography/opentype/spec/cmap#platform-ids
+func cmapSubtableRank(platformID, platformSpecificID, format uint16) int {
+	switch platformID {
+	case 0:
+		switch platformSpecificID {
+		case 0, 1, 2, 3:
+			return CMAP_RANK_UNICODE_BMP
+		case 4:
+			return CMAP_RANK_UNICODE_FULL
+		case 6:
+			return CMAP_RANK_LAST_RESORT
+		}
+	case 1:
+		if format == 0 || format == 6 {
+			return CMAP_RANK_MAC
+		}
+	case 3:
+		switch platformSpecificID {
+		case 0:
+			return CMAP_RANK_SYMBOL
+		case 1:
+			return CMAP_RANK_UNICODE_BMP
+		case 10:
+			return CMAP_RANK_UNICODE_FULL
+		case 2, 3, 4, 5, 6:
+			return CMAP_RANK_LEGACY
+		}
+	}
+	return CMAP_RANK_NONE
+}
+
+// readWindowsCode resolves the subtables to one character -> glyph ID map.
+// Unicode subtables of platforms 0 and 3 are merged, full repertoire ones
+// taking precedence over BMP ones. Without them the font is read through its
+// Windows Symbol subtable, whose codes stay in the 0xF000 range (see
+// Cmap.GlyphIndex), then through a Windows legacy encoding or a Mac 8-bit
+// encoding converted to Unicode.
 func readWindowsCode(subTables []map[string]interface{}, maxpNumGlyphs int) (code map[int]int, err error) {
 	code = make(map[int]int)
 
-	var format0, format2, format4, format12, format14 map[string]interface{}
-
+	layers := make(map[int][]map[string]interface{})
+	best := CMAP_RANK_NONE
 	for _, val := range subTables {
 		formatSource, exist := val["format"]
 		platformIDSource, exist2 := val["platformID"]
@@ -930,187 +1098,252 @@ func readWindowsCode(subTables []map[string]interface{}, maxpNumGlyphs int) (cod
 			return
 		}
 		format := formatSource.(uint16)
-		platformID := platformIDSource.(uint16)
-		platformSpecificID := platformSpecificIDSource.(uint16)
-		// https://learn.microsoft.com/en-us/typography/opentype/spec/recom#cmap-table
-		if format == 0 {
-			format0 = val
-		} else if format == 2 && platformID == 3 && platformSpecificID == 3 {
-			format2 = val
-		} else if format == 4 && platformID == 3 && platformSpecificID == 1 {
-			format4 = val
-		} else if format == 12 && platformID == 3 && platformSpecificID == 10 {
-			format12 = val
-		} else if format == 14 && platformID == 0 && platformSpecificID == 5 {
-			format14 = val
-		}
-	}
-
-	if len(format0) > 0 {
-		g, exist := format0["glyphIndexArray"]
-		if exist {
-			glyphIndexArray := g.([]uint8)
-			for ind, val := range glyphIndexArray {
-				if int(val) != 0 {
-					code[ind] = int(val)
-				}
-			}
+		rank := cmapSubtableRank(platformIDSource.(uint16), platformSpecificIDSource.(uint16), format)
+		if rank == CMAP_RANK_NONE || format == 14 {
+			continue
+		}
+		layers[rank] = append(layers[rank], val)
+		if rank > best {
+			best = rank
 		}
 	}
 
-	if len(format14) > 0 {
-		groupsSource, exist := format14["groups"]
-		if exist {
-			groups := groupsSource.([]interface{})
-			for _, sVal := range groups {
-				val := sVal.([]interface{})
-				typeVal := val[0].(int)
-				if typeVal == 1 {
-					nonDefaultUVS := val[1].(CmapFormatNonDefaultUVS)
-					code[nonDefaultUVS.UnicodeValue] = int(nonDefaultUVS.GlyphID)
+	switch {
+	case best >= CMAP_RANK_UNICODE_BMP:
+		// The last resort mapping only fills what the Unicode subtables
+		// miss; its format 13 ranges are kept in Cmap.LastResort
+		for _, rank := range []int{CMAP_RANK_LAST_RESORT, CMAP_RANK_UNICODE_BMP, CMAP_RANK_UNICODE_FULL} {
+			for _, subTable := range layers[rank] {
+				for c, g := range decodeCmapSubtable(subTable) {
+					code[c] = g
 				}
 			}
 		}
-	}
-
-	if len(format12) > 0 {
-		gSource, exist := format12["nGroups"]
-		if !exist {
-			err = errors.New("Read format12 nGroups error")
-			return
-		}
-		groups := gSource.([]*CmapFormat8nGroup)
-		for _, val := range groups {
-			startCharCode := int(val.StartCharCode)
-			endCharCode := int(val.EndCharCode)
-			startGlyphCode := int(val.StartGlyphCode)
-
-			for startCharCode <= endCharCode {
-				code[startCharCode] = startGlyphCode
-				startCharCode++
-				startGlyphCode++
-			}
-		}
-	} else if len(format4) > 0 {
-		segCountX2, exist1 := format4["segCountX2"]
-		startCodeSource, exist2 := format4["startCode"]
-		endCodeSource, exist3 := format4["endCode"]
-		idRangeOffsetSource, exist4 := format4["idRangeOffset"]
-		idDeltaSource, exist5 := format4["idDelta"]
-		glyphIndexArraySource, exist6 := format4["glyphIndexArray"]
-		glyphIndexArrayOffsetSource, exist7 := format4["glyphIndexArrayOffset"]
-		idRangeOffsetOffsetSource, exist8 := format4["idRangeOffsetOffset"]
-
-		if !exist1 || !exist2 || !exist3 || !exist4 || !exist5 || !exist6 || !exist7 || !exist8 {
-			err = errors.New("Read format4 map error")
-			return
+	case best == CMAP_RANK_MAC:
+		for _, subTable := range layers[CMAP_RANK_MAC] {
+			platformSpecificID, _ := subTable["platformSpecificID"].(uint16)
+			language := cmapLanguage(subTable)
+			for c, g := range decodeCmapSubtable(subTable) {
+				if r, ok := macCharToUnicode(c, int(platformSpecificID), int(language)-1); ok {
+					code[int(r)] = g
+				}
+			}
 		}
-
-		segCount := int(segCountX2.(uint16)) / 2
-		startCode := startCodeSource.([]uint16)
-		endCode := endCodeSource.([]uint16)
-		idRangeOffset := idRangeOffsetSource.([]uint16)
-		idDelta := idDeltaSource.([]uint16)
-		glyphIndexArray := glyphIndexArraySource.([]uint16)
-		glyphIndexArrayOffset := glyphIndexArrayOffsetSource.(int)
-		idRangeOffsetOffset := idRangeOffsetOffsetSource.(int)
-
-		// Calculate graphIdArrayIndexOffset like in JavaScript
-		graphIdArrayIndexOffset := (glyphIndexArrayOffset - idRangeOffsetOffset) / 2
-
-		for i := 0; i < segCount; i++ {
-			for start, end := int(startCode[i]), int(endCode[i]); start <= end; start++ {
-				if int(idRangeOffset[i]) == 0 {
-					code[start] = (start + int(idDelta[i])) % 0x10000
-				} else {
-					// Calculate index exactly as in JavaScript reference code
-					index := i + int(idRangeOffset[i])/2 + (start - int(startCode[i])) - graphIdArrayIndexOffset
-
-					// Boundary check to prevent panic
-					if index >= 0 && index < len(glyphIndexArray) {
-						glyphIndex := int(glyphIndexArray[index])
-
-						if glyphIndex != 0 {
-							code[start] = (glyphIndex + int(idDelta[i])) % 0x10000
-						} else {
-							code[start] = 0
-						}
-					}
+	default:
+		for _, subTable := range layers[best] {
+			for c, g := range decodeCmapSubtable(subTable) {
+				if g < maxpNumGlyphs {
+					code[c] = g
 				}
 			}
 		}
+	}
 
-		// wip 65535
-		delete(code, 65535)
-	} else if len(format2) > 0 {
-		subHeaderKeysS, exist1 := format2["subHeaderKeys"]
-		subHeadersS, exist2 := format2["subHeaders"]
-		glyphIndexArrayS, exist3 := format2["glyphIndexArray"]
-		maxPosS, exist4 := format2["maxPos"]
+	return
+}
 
-		if !exist1 || !exist2 || !exist3 || !exist4 {
-			err = errors.New("Read format2 error")
-			return
+// isSymbol reports whether the character codes come from a Windows Symbol
+// subtable rather than a Unicode one.
+func (cmap *Cmap) isSymbol() bool {
+	rank := CMAP_RANK_NONE
+	for _, subTable := range cmap.SubTables {
+		format, _ := subTable["format"].(uint16)
+		platformID, _ := subTable["platformID"].(uint16)
+		platformSpecificID, _ := subTable["platformSpecificID"].(uint16)
+		if r := cmapSubtableRank(platformID, platformSpecificID, format); r > rank {
+			rank = r
 		}
+	}
+	return rank == CMAP_RANK_SYMBOL
+}
 
-		subHeaderKeys := subHeaderKeysS.([]uint16)
-		subHeaders := subHeadersS.([]*CmapFormat2SubHeader)
-		glyphIndexArray := glyphIndexArrayS.([]uint16)
-		maxPos := maxPosS.(int)
-
-		index := 0
+// GlyphIndex returns the glyph ID that the character code maps to. In symbol
+// fonts the codes U+0020..U+00FF also resolve through the 0xF020..0xF0FF
+// range the symbol subtable is conventionally encoded in, and the other way
+// round for fonts that encode it at 0x20..0xFF.
+func (cmap *Cmap) GlyphIndex(code int) (glyph int, ok bool) {
+	if glyph, ok = cmap.WindowsCode[code]; ok {
+		return
+	}
+	for _, group := range cmap.LastResort {
+		if code >= int(group.StartCharCode) && code <= int(group.EndCharCode) {
+			return int(group.StartGlyphCode), true
+		}
+	}
+	if !cmap.isSymbol() {
+		return
+	}
+	if code <= 0xFF {
+		glyph, ok = cmap.WindowsCode[0xF000|code]
+	} else if code >= 0xF000 && code <= 0xF0FF {
+		glyph, ok = cmap.WindowsCode[code&0xFF]
+	}
+	return
+}
 
-		for i := 0; i < 256; i++ {
-			if i >= len(subHeaderKeys) {
-				break
+// decodeCmapSubtable returns the character code -> glyph ID mapping of one
+// parsed subtable, in the subtable's own encoding. Codes mapped to glyph 0
+// are left out. Format 13 is kept as ranges by cmapFormat13Groups and
+// format 14 is read by getCmapUVS; both yield nothing here.
+func decodeCmapSubtable(subTable map[string]interface{}) map[int]int {
+	code := make(map[int]int)
+	format, _ := subTable["format"].(uint16)
+	switch format {
+	case 0:
+		glyphIndexArray, _ := subTable["glyphIndexArray"].([]uint8)
+		for c, g := range glyphIndexArray {
+			if g != 0 {
+				code[c] = int(g)
 			}
-			k := int(subHeaderKeys[i])
-			if k == 0 {
-				if len(subHeaders) == 0 {
-					continue
+		}
+	case 2:
+		subHeaderKeys, _ := subTable["subHeaderKeys"].([]uint16)
+		subHeaders, _ := subTable["subHeaders"].([]*CmapFormat2SubHeader)
+		glyphIndexArray, _ := subTable["glyphIndexArray"].([]uint16)
+		n := len(subHeaders)
+		// idRangeOffset counts bytes from the subheader's own idRangeOffset
+		// field, which sits 6 + 8*j bytes into the subheader array, to the
+		// first glyph of its range.
+		glyphFor := func(j int, low int) int {
+			subHeader := subHeaders[j]
+			first := int(subHeader.FirstCode)
+			if low < first || low >= first+int(subHeader.EntryCount) {
+				return 0
+			}
+			idx := (int(subHeader.IdRangeOffset)+6+8*j-8*n)/2 + low - first
+			if idx < 0 || idx >= len(glyphIndexArray) || glyphIndexArray[idx] == 0 {
+				return 0
+			}
+			return (int(glyphIndexArray[idx]) + int(subHeader.IdDelta)) & 0xFFFF
+		}
+		for high := 0; high < len(subHeaderKeys) && high < 256; high++ {
+			j := int(subHeaderKeys[high]) / 8
+			if j >= n {
+				continue
+			}
+			if j == 0 {
+				if g := glyphFor(0, high); g != 0 {
+					code[high] = g
 				}
-
-				idxPos := int(subHeaders[0].IdRangeOffset) + (i - int(subHeaders[0].FirstCode))
-				if i >= maxPos || i < int(subHeaders[0].FirstCode) || i >= int(subHeaders[0].FirstCode+subHeaders[0].EntryCount) || idxPos < 0 || idxPos >= len(glyphIndexArray) {
-					index = 0
+				continue
+			}
+			first := int(subHeaders[j].FirstCode)
+			for low := first; low < first+int(subHeaders[j].EntryCount); low++ {
+				if g := glyphFor(j, low); g != 0 {
+					code[high<<8|low] = g
+				}
+			}
+		}
+	case 4:
+		startCode, _ := subTable["startCode"].([]uint16)
+		endCode, _ := subTable["endCode"].([]uint16)
+		idDelta, _ := subTable["idDelta"].([]uint16)
+		idRangeOffset, _ := subTable["idRangeOffset"].([]uint16)
+		glyphIndexArray, _ := subTable["glyphIndexArray"].([]uint16)
+		segCount := len(endCode)
+		if len(startCode) < segCount || len(idDelta) < segCount || len(idRangeOffset) < segCount {
+			break
+		}
+		for i := 0; i < segCount; i++ {
+			for c := int(startCode[i]); c <= int(endCode[i]) && c < 0xFFFF; c++ {
+				g := 0
+				if idRangeOffset[i] == 0 {
+					g = (c + int(idDelta[i])) & 0xFFFF
 				} else {
-					index = int(glyphIndexArray[idxPos])
-					if index != 0 {
-						index = index + int(subHeaders[0].IdDelta)
+					// glyphIndexArray starts right after the segCount idRangeOffset entries
+					idx := i + int(idRangeOffset[i])/2 + (c - int(startCode[i])) - segCount
+					if idx >= 0 && idx < len(glyphIndexArray) && glyphIndexArray[idx] != 0 {
+						g = (int(glyphIndexArray[idx]) + int(idDelta[i])) & 0xFFFF
 					}
 				}
-
-				if index != 0 && index < maxpNumGlyphs {
-					code[i] = index
+				if g != 0 {
+					code[c] = g
 				}
-
-			} else {
-				if k >= len(subHeaders) {
-					continue
+			}
+		}
+	case 6:
+		firstCode, _ := subTable["firstCode"].(uint16)
+		glyphIndexArray, _ := subTable["glyphIndexArray"].([]uint16)
+		for i, g := range glyphIndexArray {
+			if g != 0 {
+				code[int(firstCode)+i] = int(g)
+			}
+		}
+	case 10:
+		startCharCode, _ := subTable["startCharCode"].(uint32)
+		glyphs, _ := subTable["glyphs"].([]uint16)
+		for i, g := range glyphs {
+			if g != 0 {
+				code[int(startCharCode)+i] = int(g)
+			}
+		}
+	case 8, 12:
+		groups, _ := subTable["groups"].([]*CmapFormat8nGroup)
+		for _, group := range groups {
+			for c := int(group.StartCharCode); c <= int(group.EndCharCode); c++ {
+				if g := int(group.StartGlyphCode) + c - int(group.StartCharCode); g != 0 {
+					code[c] = g
 				}
-				entryCount := int(subHeaders[k].EntryCount)
-				for j := 0; j < entryCount; j++ {
-					idxPos := int(subHeaders[k].IdRangeOffset) + j
-
-					if idxPos < 0 || idxPos >= len(glyphIndexArray) {
-						index = 0
-					} else {
-						index = int(glyphIndexArray[idxPos])
-						if index != 0 {
-							index = index + int(subHeaders[k].IdDelta)
-						}
-					}
+			}
+		}
+	}
+	return code
+}
 
-					if index != 0 && index < maxpNumGlyphs {
-						unicode := ((i << 8) | (j + int(subHeaders[k].FirstCode))) % 0xffff
-						code[unicode] = index
-					}
+// cmapFormat13Groups returns the groups of a format 13 subtable with their
+// glyphs passed through glyph, sorted and with neighbouring groups of the
+// same glyph merged. Groups whose glyph is rejected or 0 are left out.
+func cmapFormat13Groups(subTable map[string]interface{}, glyph func(g int) (int, bool)) []*CmapFormat8nGroup {
+	groups, _ := subTable["groups"].([]*CmapFormat8nGroup)
+	var kept []*CmapFormat8nGroup
+	for _, group := range groups {
+		g, ok := glyph(int(group.StartGlyphCode))
+		if ok && g != 0 && group.StartCharCode <= group.EndCharCode {
+			kept = append(kept, &CmapFormat8nGroup{group.StartCharCode, group.EndCharCode, uint32(g)})
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i].StartCharCode < kept[j].StartCharCode
+	})
+	var merged []*CmapFormat8nGroup
+	for _, group := range kept {
+		if len(merged) > 0 {
+			last := merged[len(merged)-1]
+			if group.StartCharCode <= last.EndCharCode {
+				// overlapping codes keep the first group's glyph
+				if group.EndCharCode <= last.EndCharCode {
+					continue
 				}
+				group = &CmapFormat8nGroup{last.EndCharCode + 1, group.EndCharCode, group.StartGlyphCode}
+			}
+			if group.StartCharCode == last.EndCharCode+1 && group.StartGlyphCode == last.StartGlyphCode {
+				last.EndCharCode = group.EndCharCode
+				continue
 			}
 		}
+		merged = append(merged, group)
+	}
+	return merged
+}
+
+// readLastResort collects the format 13 groups of the subtables for
+// Cmap.LastResort.
+func readLastResort(subTables []map[string]interface{}) []*CmapFormat8nGroup {
+	var groups []*CmapFormat8nGroup
+	for _, subTable := range subTables {
+		if format, _ := subTable["format"].(uint16); format == 13 {
+			groups = append(groups, cmapFormat13Groups(subTable, func(g int) (int, bool) { return g, true })...)
+		}
 	}
+	return groups
+}
 
-	return
+// remapCmapFormat13 rebuilds a format 13 subtable with its glyphs passed
+// through glyph.
+func remapCmapFormat13(subTable map[string]interface{}, glyph func(g int) (int, bool)) map[string]interface{} {
+	platformID, _ := subTable["platformID"].(uint16)
+	platformSpecificID, _ := subTable["platformSpecificID"].(uint16)
+	return cmapGroupsSubtable(13, platformID, platformSpecificID, cmapLanguage(subTable), cmapFormat13Groups(subTable, glyph))
 }
 
 func GetCmap(data []byte, pos int, maxpNumGlyphs int) (cmap *Cmap, err error) {
@@ -1178,7 +1411,7 @@ func GetCmap(data []byte, pos int, maxpNumGlyphs int) (cmap *Cmap, err error) {
 			subTable["maxPos"] = maxPos
 
 			var subHeaders []*CmapFormat2SubHeader
-			for k := 0; k < maxSubHeaderKey; k++ {
+			for k := 0; k <= maxSubHeaderKey; k++ {
 				subHeaders = append(subHeaders, &CmapFormat2SubHeader{
 					getUint16(data[curPos : curPos+2]),
 					getUint16(data[curPos+2 : curPos+4]),
@@ -1433,14 +1666,268 @@ func GetCmap(data []byte, pos int, maxpNumGlyphs int) (cmap *Cmap, err error) {
 
 	// Read Windows support
 	cmap.WindowsCode, err = readWindowsCode(cmap.SubTables, maxpNumGlyphs)
+	cmap.LastResort = readLastResort(cmap.SubTables)
+
+	// Variation sequences live beside the code point mapping
+	for _, subTable := range cmap.SubTables {
+		if format, _ := subTable["format"].(uint16); format == 14 {
+			groups, _ := subTable["groups"].([]interface{})
+			cmap.UVS = getCmapUVS(groups)
+			break
+		}
+	}
+
+	return
+}
+
+// getCmapUVS folds the default and non-default groups read from a format 14
+// subtable into UVSSelector records keyed by variation selector.
+func getCmapUVS(groups []interface{}) map[int]*UVSSelector {
+	uvs := make(map[int]*UVSSelector)
+	selector := func(varSelector int) *UVSSelector {
+		record, ok := uvs[varSelector]
+		if !ok {
+			record = &UVSSelector{}
+			uvs[varSelector] = record
+		}
+		return record
+	}
+	for _, g := range groups {
+		groupItem, ok := g.([]interface{})
+		if !ok || len(groupItem) != 2 {
+			continue
+		}
+		switch item := groupItem[1].(type) {
+		case *CmapFormatDefaultUVS:
+			record := selector(item.VarSelector)
+			for u := item.StartUnicode; u <= item.EndUnicode; u++ {
+				record.DefaultUVS = append(record.DefaultUVS, u)
+			}
+		case *CmapFormatNonDefaultUVS:
+			record := selector(item.VarSelector)
+			if record.NonDefaultUVS == nil {
+				record.NonDefaultUVS = make(map[int]int)
+			}
+			record.NonDefaultUVS[item.UnicodeValue] = int(item.GlyphID)
+		}
+	}
+	for _, record := range uvs {
+		sort.Ints(record.DefaultUVS)
+	}
+	return uvs
+}
+
+// cmapFormat14Groups converts UVSSelector records back into the group list
+// GetCmap produces for format 14, merging consecutive default bases into
+// ranges of at most 256 characters.
+func cmapFormat14Groups(uvs map[int]*UVSSelector) []interface{} {
+	selectors := make([]int, 0, len(uvs))
+	for varSelector := range uvs {
+		selectors = append(selectors, varSelector)
+	}
+	sort.Ints(selectors)
+
+	var groups []interface{}
+	for _, varSelector := range selectors {
+		record := uvs[varSelector]
+		sorted := append([]int(nil), record.DefaultUVS...)
+		sort.Ints(sorted)
+		var bases []int
+		for _, base := range sorted {
+			if len(bases) == 0 || bases[len(bases)-1] != base {
+				bases = append(bases, base)
+			}
+		}
+		for i := 0; i < len(bases); {
+			j := i
+			for j+1 < len(bases) && bases[j+1] == bases[j]+1 && bases[j+1]-bases[i] < 256 {
+				j++
+			}
+			groups = append(groups, []interface{}{0, &CmapFormatDefaultUVS{bases[i], bases[j], varSelector}})
+			i = j + 1
+		}
+		nonDefault := make([]int, 0, len(record.NonDefaultUVS))
+		for base := range record.NonDefaultUVS {
+			nonDefault = append(nonDefault, base)
+		}
+		sort.Ints(nonDefault)
+		for _, base := range nonDefault {
+			groups = append(groups, []interface{}{1, &CmapFormatNonDefaultUVS{base, uint16(record.NonDefaultUVS[base]), varSelector}})
+		}
+	}
+	return groups
+}
+
+// writeCmapFormat14 encodes the variation sequences as a complete format 14
+// subtable.
+func writeCmapFormat14(uvs map[int]*UVSSelector) []byte {
+	type selectorRecord struct {
+		defaultUVS    []*CmapFormatDefaultUVS
+		nonDefaultUVS []*CmapFormatNonDefaultUVS
+	}
+	records := make(map[int]*selectorRecord)
+	var selectors []int
+	for _, g := range cmapFormat14Groups(uvs) {
+		groupItem := g.([]interface{})
+		var varSelector int
+		switch item := groupItem[1].(type) {
+		case *CmapFormatDefaultUVS:
+			varSelector = item.VarSelector
+		case *CmapFormatNonDefaultUVS:
+			varSelector = item.VarSelector
+		}
+		record, ok := records[varSelector]
+		if !ok {
+			record = &selectorRecord{}
+			records[varSelector] = record
+			selectors = append(selectors, varSelector)
+		}
+		switch item := groupItem[1].(type) {
+		case *CmapFormatDefaultUVS:
+			record.defaultUVS = append(record.defaultUVS, item)
+		case *CmapFormatNonDefaultUVS:
+			record.nonDefaultUVS = append(record.nonDefaultUVS, item)
+		}
+	}
+
+	headerSize := 10 + len(selectors)*11
+	var selectorData []byte
+	var uvsTableData []byte
+	for _, varSelector := range selectors {
+		record := records[varSelector]
+		var defaultUVSOffset, nonDefaultUVSOffset uint32
+		if len(record.defaultUVS) > 0 {
+			defaultUVSOffset = uint32(headerSize + len(uvsTableData))
+			uvsTableData = append(uvsTableData, writeUint32(uint32(len(record.defaultUVS)))...)
+			for _, r := range record.defaultUVS {
+				uvsTableData = append(uvsTableData, writeUint24(r.StartUnicode)...)
+				uvsTableData = append(uvsTableData, writeUint8(uint8(r.EndUnicode-r.StartUnicode))...)
+			}
+		}
+		if len(record.nonDefaultUVS) > 0 {
+			nonDefaultUVSOffset = uint32(headerSize + len(uvsTableData))
+			uvsTableData = append(uvsTableData, writeUint32(uint32(len(record.nonDefaultUVS)))...)
+			for _, m := range record.nonDefaultUVS {
+				uvsTableData = append(uvsTableData, writeUint24(m.UnicodeValue)...)
+				uvsTableData = append(uvsTableData, writeUint16(m.GlyphID)...)
+			}
+		}
+		selectorData = append(selectorData, writeUint24(varSelector)...)
+		selectorData = append(selectorData, writeUint32(defaultUVSOffset)...)
+		selectorData = append(selectorData, writeUint32(nonDefaultUVSOffset)...)
+	}
+
+	data := []byte{}
+	data = append(data, writeUint16(14)...)
+	data = append(data, writeUint32(uint32(headerSize+len(uvsTableData)))...)
+	data = append(data, writeUint32(uint32(len(selectors)))...)
+	data = append(data, selectorData...)
+	data = append(data, uvsTableData...)
+	return data
+}
+
+// syncCmapFormat14 regenerates the format 14 subtable from cmap.UVS, adding
+// a Unicode (0, 5) subtable in encoding record order when the font had none
+// and removing it once no sequences are left. A nil UVS leaves the subtables
+// as they are.
+func syncCmapFormat14(cmap *Cmap) {
+	if cmap.UVS == nil {
+		return
+	}
+	for varSelector, record := range cmap.UVS {
+		if record == nil || (len(record.DefaultUVS) == 0 && len(record.NonDefaultUVS) == 0) {
+			delete(cmap.UVS, varSelector)
+		}
+	}
+
+	var format14 map[string]interface{}
+	if len(cmap.UVS) > 0 {
+		format14 = map[string]interface{}{
+			"platformID":            uint16(0),
+			"platformSpecificID":    uint16(5),
+			"format":                uint16(14),
+			"length":                uint32(len(writeCmapFormat14(cmap.UVS))),
+			"numVarSelectorRecords": uint32(len(cmap.UVS)),
+			"groups":                cmapFormat14Groups(cmap.UVS),
+		}
+	}
+
+	subTables := make([]map[string]interface{}, 0, len(cmap.SubTables)+1)
+	for _, subTable := range cmap.SubTables {
+		if format, _ := subTable["format"].(uint16); format == 14 {
+			continue
+		}
+		platformID, _ := subTable["platformID"].(uint16)
+		platformSpecificID, _ := subTable["platformSpecificID"].(uint16)
+		if format14 != nil && (platformID > 0 || platformSpecificID > 5) {
+			subTables = append(subTables, format14)
+			format14 = nil
+		}
+		subTables = append(subTables, subTable)
+	}
+	if format14 != nil {
+		subTables = append(subTables, format14)
+	}
+	cmap.SubTables = subTables
+	cmap.NumberSubtables = uint16(len(subTables))
+}
 
+// GlyphForVariation returns the glyph a variation sequence (a base
+// character followed by a variation selector) maps to. Default sequences
+// resolve to the base character's ordinary glyph. ok is false when the font
+// does not define the sequence.
+func (cmap *Cmap) GlyphForVariation(base, selector rune) (glyph int, ok bool) {
+	record, exist := cmap.UVS[int(selector)]
+	if !exist || record == nil {
+		return
+	}
+	if glyph, ok = record.NonDefaultUVS[int(base)]; ok {
+		return
+	}
+	if i := sort.SearchInts(record.DefaultUVS, int(base)); i < len(record.DefaultUVS) && record.DefaultUVS[i] == int(base) {
+		glyph, ok = cmap.GlyphIndex(int(base))
+	}
 	return
 }
 
+// remapCmapUVS keeps the variation sequences whose base character is still
+// in cmap.WindowsCode and rewrites variant glyph IDs through oldToNew,
+// dropping variants whose glyph is not retained.
+func remapCmapUVS(cmap *Cmap, oldToNew map[int]int) {
+	if cmap.UVS == nil {
+		return
+	}
+	uvs := make(map[int]*UVSSelector)
+	for varSelector, record := range cmap.UVS {
+		kept := &UVSSelector{}
+		for _, base := range record.DefaultUVS {
+			if _, ok := cmap.WindowsCode[base]; ok {
+				kept.DefaultUVS = append(kept.DefaultUVS, base)
+			}
+		}
+		for base, glyph := range record.NonDefaultUVS {
+			if _, ok := cmap.WindowsCode[base]; !ok {
+				continue
+			}
+			if newGlyph, ok := oldToNew[glyph]; ok {
+				if kept.NonDefaultUVS == nil {
+					kept.NonDefaultUVS = make(map[int]int)
+				}
+				kept.NonDefaultUVS[base] = newGlyph
+			}
+		}
+		if len(kept.DefaultUVS) > 0 || len(kept.NonDefaultUVS) > 0 {
+			uvs[varSelector] = kept
+		}
+	}
+	cmap.UVS = uvs
+}
+
 func WriteCmap(cmap *Cmap) (data []byte, err error) {
 	if cmap == nil {
 		return nil, errors.New("cmap is nil")
 	}
+	syncCmapFormat14(cmap)
 
 	writeSubTable := func(subTable map[string]interface{}) ([]byte, error) {
 		buf := []byte{}
@@ -1536,72 +2023,8 @@ func WriteCmap(cmap *Cmap) (data []byte, err error) {
 				buf = append(buf, writeUint32(item.StartGlyphCode)...)
 			}
 		case 14:
-			buf = append(buf, writeUint32(subTable["length"].(uint32))...)
-			buf = append(buf, writeUint32(subTable["numVarSelectorRecords"].(uint32))...)
-			groups := subTable["groups"].([]interface{})
-			varSelectors := make(map[int]struct {
-				defaultUVS    []*CmapFormatDefaultUVS
-				nonDefaultUVS []*CmapFormatNonDefaultUVS
-			})
-			for _, g := range groups {
-				groupItem := g.([]interface{})
-				typeVal := groupItem[0].(int)
-				if typeVal == 0 {
-					defaultUVS := groupItem[1].(*CmapFormatDefaultUVS)
-					varSel := defaultUVS.VarSelector
-					rec := varSelectors[varSel]
-					rec.defaultUVS = append(rec.defaultUVS, defaultUVS)
-					varSelectors[varSel] = rec
-				} else if typeVal == 1 {
-					nonDefaultUVS := groupItem[1].(*CmapFormatNonDefaultUVS)
-					varSel := nonDefaultUVS.VarSelector
-					rec := varSelectors[varSel]
-					rec.nonDefaultUVS = append(rec.nonDefaultUVS, nonDefaultUVS)
-					varSelectors[varSel] = rec
-				}
-			}
-			var varSelectorKeys []int
-			for k := range varSelectors {
-				varSelectorKeys = append(varSelectorKeys, k)
-			}
-			sort.Ints(varSelectorKeys)
-			headerSize := 4 + 4 + len(varSelectorKeys)*11
-			currentOffset := headerSize
-			var varSelectorData []byte
-			var uvsTableData []byte
-			for _, varSel := range varSelectorKeys {
-				rec := varSelectors[varSel]
-				varSelectorData = append(varSelectorData, writeUint24(varSel)...)
-				defaultUVSOffset := uint32(0)
-				nonDefaultUVSOffset := uint32(0)
-				var defaultUVSData []byte
-				if len(rec.defaultUVS) > 0 {
-					defaultUVSOffset = uint32(currentOffset)
-					defaultUVSData = append(defaultUVSData, writeUint32(uint32(len(rec.defaultUVS)))...)
-					for _, uvs := range rec.defaultUVS {
-						defaultUVSData = append(defaultUVSData, writeUint24(uvs.StartUnicode)...)
-						additionalCount := uint8(uvs.EndUnicode - uvs.StartUnicode)
-						defaultUVSData = append(defaultUVSData, writeUint8(additionalCount)...)
-					}
-					currentOffset += len(defaultUVSData)
-				}
-				var nonDefaultUVSData []byte
-				if len(rec.nonDefaultUVS) > 0 {
-					nonDefaultUVSOffset = uint32(currentOffset)
-					nonDefaultUVSData = append(nonDefaultUVSData, writeUint32(uint32(len(rec.nonDefaultUVS)))...)
-					for _, uvs := range rec.nonDefaultUVS {
-						nonDefaultUVSData = append(nonDefaultUVSData, writeUint24(uvs.UnicodeValue)...)
-						nonDefaultUVSData = append(nonDefaultUVSData, writeUint16(uvs.GlyphID)...)
-					}
-					currentOffset += len(nonDefaultUVSData)
-				}
-				varSelectorData = append(varSelectorData, writeUint32(defaultUVSOffset)...)
-				varSelectorData = append(varSelectorData, writeUint32(nonDefaultUVSOffset)...)
-				uvsTableData = append(uvsTableData, defaultUVSData...)
-				uvsTableData = append(uvsTableData, nonDefaultUVSData...)
-			}
-			buf = append(buf, varSelectorData...)
-			buf = append(buf, uvsTableData...)
+			groups, _ := subTable["groups"].([]interface{})
+			buf = writeCmapFormat14(getCmapUVS(groups))
 		default:
 			return nil, errors.New("cmap format " + strconv.Itoa(int(format)) + " not supported for write")
 		}
@@ -1620,33 +2043,473 @@ func WriteCmap(cmap *Cmap) (data []byte, err error) {
 	data = append(data, writeUint16(cmap.Version)...)
 	data = append(data, writeUint16(uint16(numSubtables))...)
 
+	// Records whose subtables encode to the same bytes share one copy
 	currentOffset := uint32(4 + numSubtables*8)
+	offsets := make(map[string]uint32)
+	var payload []byte
 	for i, subTable := range cmap.SubTables {
+		offset, shared := offsets[string(subTableData[i])]
+		if !shared {
+			offset = currentOffset
+			offsets[string(subTableData[i])] = offset
+			payload = append(payload, subTableData[i]...)
+			currentOffset += uint32(len(subTableData[i]))
+		}
 		data = append(data, writeUint16(subTable["platformID"].(uint16))...)
 		data = append(data, writeUint16(subTable["platformSpecificID"].(uint16))...)
-		data = append(data, writeUint32(currentOffset)...)
-		currentOffset += uint32(len(subTableData[i]))
+		data = append(data, writeUint32(offset)...)
 	}
+	data = append(data, payload...)
 
-	for _, subData := range subTableData {
-		data = append(data, subData...)
+	return
+}
+
+// cmapLanguage returns the language field of a parsed subtable, which is a
+// uint16 for formats 0-6 and a uint32 for formats 8-13.
+func cmapLanguage(subTable map[string]interface{}) uint32 {
+	switch language := subTable["language"].(type) {
+	case uint16:
+		return uint32(language)
+	case uint32:
+		return language
 	}
+	return 0
+}
 
-	return
+// cmapSubtableLength returns the encoded size recorded in a subtable's
+// length field.
+func cmapSubtableLength(subTable map[string]interface{}) int {
+	switch length := subTable["length"].(type) {
+	case uint16:
+		return int(length)
+	case uint32:
+		return int(length)
+	}
+	return 0
 }
 
-type NameRecord struct {
-	PlatformID         uint16 `json:"platformId"`
-	PlatformSpecificID uint16 `json:"platformSpecificId"`
-	LanguageID         uint16 `json:"languageId"`
-	NameID             uint16 `json:"nameId"`
-	Length             uint16 `json:"length"`
-	Offset             uint16 `json:"offset"`
+// sortedCmapCodes returns the codes of a code -> glyph map in ascending
+// order, keeping only those below limit.
+func sortedCmapCodes(codes map[int]int, limit int) []int {
+	sorted := make([]int, 0, len(codes))
+	for c := range codes {
+		if c < limit {
+			sorted = append(sorted, c)
+		}
+	}
+	sort.Ints(sorted)
+	return sorted
 }
 
-type LangTagRecord struct {
-	Length uint16 `json:"length"`
-	Offset uint16 `json:"offset"`
+func encodeCmapFormat0(platformID, platformSpecificID uint16, language uint32, codes map[int]int) (subTable map[string]interface{}, ok bool) {
+	glyphIndexArray := make([]uint8, 256)
+	for c, g := range codes {
+		if c > 0xFF || g > 0xFF {
+			return nil, false
+		}
+		glyphIndexArray[c] = uint8(g)
+	}
+	return map[string]interface{}{
+		"platformID":         platformID,
+		"platformSpecificID": platformSpecificID,
+		"format":             uint16(0),
+		"length":             uint16(262),
+		"language":           uint16(language),
+		"glyphIndexArray":    glyphIndexArray,
+	}, true
+}
+
+// encodeCmapFormat2 builds a high-byte mapping subtable for mixed 8/16-bit
+// encodings. It fails when a single-byte code is also used as a lead byte.
+func encodeCmapFormat2(platformID, platformSpecificID uint16, language uint32, codes map[int]int) (subTable map[string]interface{}, ok bool) {
+	for c := range codes {
+		if c > 0xFFFF {
+			return nil, false
+		}
+	}
+	lows := make(map[int][]int)
+	for _, c := range sortedCmapCodes(codes, 0x10000) {
+		if c > 0xFF {
+			lows[c>>8] = append(lows[c>>8], c&0xFF)
+		}
+	}
+	var singles []int
+	for _, c := range sortedCmapCodes(codes, 0x100) {
+		if _, lead := lows[c]; lead {
+			return nil, false
+		}
+		singles = append(singles, c)
+	}
+
+	highs := make([]int, 0, len(lows))
+	for high := range lows {
+		highs = append(highs, high)
+	}
+	sort.Ints(highs)
+
+	// subheader 0 covers the single-byte codes, then one per lead byte
+	type rangeDef struct {
+		high, first, count int
+	}
+	ranges := []rangeDef{{-1, 0, 0}}
+	if len(singles) > 0 {
+		ranges[0] = rangeDef{-1, singles[0], singles[len(singles)-1] - singles[0] + 1}
+	}
+	for _, high := range highs {
+		l := lows[high]
+		ranges = append(ranges, rangeDef{high, l[0], l[len(l)-1] - l[0] + 1})
+	}
+
+	n := len(ranges)
+	subHeaderKeys := make([]uint16, 256)
+	var subHeaders []*CmapFormat2SubHeader
+	var glyphIndexArray []uint16
+	for j, r := range ranges {
+		if r.high >= 0 {
+			subHeaderKeys[r.high] = uint16(j * 8)
+		}
+		idRangeOffset := 8*n - 6 - 8*j + 2*len(glyphIndexArray)
+		subHeaders = append(subHeaders, &CmapFormat2SubHeader{uint16(r.first), uint16(r.count), 0, uint16(idRangeOffset)})
+		for low := r.first; low < r.first+r.count; low++ {
+			c := low
+			if r.high >= 0 {
+				c = r.high<<8 | low
+			}
+			glyphIndexArray = append(glyphIndexArray, uint16(codes[c]))
+		}
+	}
+	length := 6 + 512 + n*8 + len(glyphIndexArray)*2
+	if length > 0xFFFF {
+		return nil, false
+	}
+	return map[string]interface{}{
+		"platformID":         platformID,
+		"platformSpecificID": platformSpecificID,
+		"format":             uint16(2),
+		"length":             uint16(length),
+		"language":           uint16(language),
+		"subHeaderKeys":      subHeaderKeys,
+		"subHeaders":         subHeaders,
+		"glyphIndexArray":    glyphIndexArray,
+	}, true
+}
+
+// encodeCmapFormat4 builds a segment mapping subtable for the BMP codes.
+// Runs of consecutive codes with consecutive glyphs become idDelta
+// segments; nearby short runs are merged into idRangeOffset segments whose
+// glyphs are listed in glyphIndexArray, whichever is cheaper.
+func encodeCmapFormat4(platformID, platformSpecificID uint16, language uint32, codes map[int]int) (subTable map[string]interface{}, ok bool) {
+	sorted := sortedCmapCodes(codes, 0xFFFF)
+
+	type run struct {
+		start, end, glyph int
+	}
+	var runs []run
+	for _, c := range sorted {
+		g := codes[c]
+		if len(runs) > 0 {
+			last := &runs[len(runs)-1]
+			if c == last.end+1 && g == last.glyph+c-last.start {
+				last.end = c
+				continue
+			}
+		}
+		runs = append(runs, run{c, c, g})
+	}
+
+	// best[j] is the smallest size of segments covering runs[:j]; a segment
+	// costs 8 bytes plus 2 per code when it goes through glyphIndexArray.
+	const window = 32
+	best := make([]int, len(runs)+1)
+	from := make([]int, len(runs)+1)
+	for j := 1; j <= len(runs); j++ {
+		best[j] = best[j-1] + 8
+		from[j] = j - 1
+		for i := j - 2; i >= 0 && i >= j-window; i-- {
+			span := runs[j-1].end - runs[i].start + 1
+			if cost := best[i] + 8 + span*2; cost < best[j] {
+				best[j] = cost
+				from[j] = i
+			}
+		}
+	}
+	type segment struct {
+		start, end int
+		delta      int
+		glyphs     []uint16
+	}
+	var segments []segment
+	for j := len(runs); j > 0; j = from[j] {
+		i := from[j]
+		if i == j-1 {
+			r := runs[i]
+			segments = append(segments, segment{r.start, r.end, r.glyph - r.start, nil})
+			continue
+		}
+		seg := segment{start: runs[i].start, end: runs[j-1].end}
+		for c := seg.start; c <= seg.end; c++ {
+			seg.glyphs = append(seg.glyphs, uint16(codes[c]))
+		}
+		segments = append(segments, seg)
+	}
+	for l, r := 0, len(segments)-1; l < r; l, r = l+1, r-1 {
+		segments[l], segments[r] = segments[r], segments[l]
+	}
+	segments = append(segments, segment{0xFFFF, 0xFFFF, 1, nil})
+
+	segCount := len(segments)
+	endCode := make([]uint16, segCount)
+	startCode := make([]uint16, segCount)
+	idDelta := make([]uint16, segCount)
+	idRangeOffset := make([]uint16, segCount)
+	glyphIndexArray := []uint16{}
+	for i, seg := range segments {
+		endCode[i] = uint16(seg.end)
+		startCode[i] = uint16(seg.start)
+		if seg.glyphs == nil {
+			idDelta[i] = uint16(seg.delta & 0xFFFF)
+			continue
+		}
+		idRangeOffset[i] = uint16(2 * (segCount - i + len(glyphIndexArray)))
+		glyphIndexArray = append(glyphIndexArray, seg.glyphs...)
+	}
+
+	length := 16 + segCount*8 + len(glyphIndexArray)*2
+	if length > 0xFFFF {
+		return nil, false
+	}
+	entrySelector := log2(segCount)
+	searchRange := 2 << uint(entrySelector)
+	return map[string]interface{}{
+		"platformID":         platformID,
+		"platformSpecificID": platformSpecificID,
+		"format":             uint16(4),
+		"length":             uint16(length),
+		"language":           uint16(language),
+		"segCountX2":         uint16(segCount * 2),
+		"searchRange":        uint16(searchRange),
+		"entrySelector":      uint16(entrySelector),
+		"rangeShift":         uint16(segCount*2 - searchRange),
+		"endCode":            endCode,
+		"reservedPad":        uint16(0),
+		"startCode":          startCode,
+		"idDelta":            idDelta,
+		"idRangeOffset":      idRangeOffset,
+		"glyphIndexArray":    glyphIndexArray,
+	}, true
+}
+
+func encodeCmapFormat6(platformID, platformSpecificID uint16, language uint32, codes map[int]int) (subTable map[string]interface{}, ok bool) {
+	sorted := sortedCmapCodes(codes, 0x10000)
+	glyphIndexArray := []uint16{}
+	firstCode := 0
+	if len(sorted) > 0 {
+		firstCode = sorted[0]
+		glyphIndexArray = make([]uint16, sorted[len(sorted)-1]-firstCode+1)
+		for _, c := range sorted {
+			glyphIndexArray[c-firstCode] = uint16(codes[c])
+		}
+	}
+	length := 10 + len(glyphIndexArray)*2
+	if length > 0xFFFF {
+		return nil, false
+	}
+	return map[string]interface{}{
+		"platformID":         platformID,
+		"platformSpecificID": platformSpecificID,
+		"format":             uint16(6),
+		"length":             uint16(length),
+		"language":           uint16(language),
+		"firstCode":          uint16(firstCode),
+		"entryCount":         uint16(len(glyphIndexArray)),
+		"glyphIndexArray":    glyphIndexArray,
+	}, true
+}
+
+func encodeCmapFormat10(platformID, platformSpecificID uint16, language uint32, codes map[int]int) (subTable map[string]interface{}, ok bool) {
+	sorted := sortedCmapCodes(codes, 0x110000)
+	glyphs := []uint16{}
+	startCharCode := 0
+	if len(sorted) > 0 {
+		startCharCode = sorted[0]
+		glyphs = make([]uint16, sorted[len(sorted)-1]-startCharCode+1)
+		for _, c := range sorted {
+			glyphs[c-startCharCode] = uint16(codes[c])
+		}
+	}
+	return map[string]interface{}{
+		"platformID":         platformID,
+		"platformSpecificID": platformSpecificID,
+		"format":             uint16(10),
+		"reserved":           uint16(0),
+		"length":             uint32(20 + len(glyphs)*2),
+		"language":           language,
+		"startCharCode":      uint32(startCharCode),
+		"numChars":           uint32(len(glyphs)),
+		"glyphs":             glyphs,
+	}, true
+}
+
+// encodeCmapGroups builds a format 12 or 13 subtable. Format 12 groups need
+// consecutive glyphs, format 13 groups map every code to the same glyph.
+func encodeCmapGroups(format, platformID, platformSpecificID uint16, language uint32, codes map[int]int) (subTable map[string]interface{}, ok bool) {
+	var groups []*CmapFormat8nGroup
+	for _, c := range sortedCmapCodes(codes, 0x110000) {
+		g := codes[c]
+		if len(groups) > 0 {
+			last := groups[len(groups)-1]
+			next := int(last.StartGlyphCode)
+			if format == 12 {
+				next += c - int(last.StartCharCode)
+			}
+			if c == int(last.EndCharCode)+1 && g == next {
+				last.EndCharCode = uint32(c)
+				continue
+			}
+		}
+		groups = append(groups, &CmapFormat8nGroup{uint32(c), uint32(c), uint32(g)})
+	}
+	return cmapGroupsSubtable(format, platformID, platformSpecificID, language, groups), true
+}
+
+// cmapGroupsSubtable returns the format 12 or 13 subtable holding groups.
+func cmapGroupsSubtable(format, platformID, platformSpecificID uint16, language uint32, groups []*CmapFormat8nGroup) map[string]interface{} {
+	return map[string]interface{}{
+		"platformID":         platformID,
+		"platformSpecificID": platformSpecificID,
+		"format":             format,
+		"reserved":           uint16(0),
+		"length":             uint32(16 + len(groups)*12),
+		"language":           language,
+		"nGroups":            uint32(len(groups)),
+		"groups":             groups,
+	}
+}
+
+func encodeCmapSubtable(format, platformID, platformSpecificID uint16, language uint32, codes map[int]int) (map[string]interface{}, bool) {
+	switch format {
+	case 0:
+		return encodeCmapFormat0(platformID, platformSpecificID, language, codes)
+	case 2:
+		return encodeCmapFormat2(platformID, platformSpecificID, language, codes)
+	case 4:
+		return encodeCmapFormat4(platformID, platformSpecificID, language, codes)
+	case 6:
+		return encodeCmapFormat6(platformID, platformSpecificID, language, codes)
+	case 10:
+		return encodeCmapFormat10(platformID, platformSpecificID, language, codes)
+	case 12, 13:
+		return encodeCmapGroups(format, platformID, platformSpecificID, language, codes)
+	}
+	return nil, false
+}
+
+// cmapCandidateFormats lists the subtable formats that are valid for a
+// platform/encoding pair, in order of preference. Format 13 keeps its
+// many-to-one meaning and is never swapped for another format.
+func cmapCandidateFormats(platformID, platformSpecificID, format uint16) []uint16 {
+	if format == 13 {
+		return []uint16{13}
+	}
+	switch platformID {
+	case 0:
+		switch {
+		case platformSpecificID <= 3:
+			return []uint16{4, 6}
+		case platformSpecificID == 4:
+			return []uint16{12, 10}
+		}
+	case 1:
+		if platformSpecificID == 0 {
+			return []uint16{0, 6}
+		}
+		return []uint16{0, 2, 6}
+	case 3:
+		switch {
+		case platformSpecificID <= 1:
+			return []uint16{4}
+		case platformSpecificID <= 6:
+			return []uint16{2, 4}
+		case platformSpecificID == 10:
+			return []uint16{12}
+		}
+	}
+	return []uint16{format}
+}
+
+// encodeBestCmapSubtable encodes codes in the smallest format valid for the
+// platform/encoding pair, falling back to format (the subtable's current
+// format) when none of the candidates can hold the mapping.
+func encodeBestCmapSubtable(platformID, platformSpecificID uint16, language uint32, format uint16, codes map[int]int) map[string]interface{} {
+	var best map[string]interface{}
+	for _, candidate := range cmapCandidateFormats(platformID, platformSpecificID, format) {
+		subTable, ok := encodeCmapSubtable(candidate, platformID, platformSpecificID, language, codes)
+		if ok && (best == nil || cmapSubtableLength(subTable) < cmapSubtableLength(best)) {
+			best = subTable
+		}
+	}
+	if best == nil {
+		if subTable, ok := encodeCmapSubtable(format, platformID, platformSpecificID, language, codes); ok {
+			return subTable
+		}
+		best, _ = encodeCmapGroups(12, platformID, platformSpecificID, language, codes)
+	}
+	return best
+}
+
+// OptimizeCmap re-encodes every subtable except format 14 in the smallest
+// format valid for its platform and encoding, and drops encoding records
+// that repeat an earlier platform, encoding and language. WriteCmap stores
+// identical subtables once, so a (0, 3) and a (3, 1) record with the same
+// mapping share their data.
+func OptimizeCmap(cmap *Cmap) {
+	type recordKey struct {
+		platformID, platformSpecificID uint16
+		language                       uint32
+	}
+	seen := make(map[recordKey]bool)
+	subTables := make([]map[string]interface{}, 0, len(cmap.SubTables))
+	for _, subTable := range cmap.SubTables {
+		format, _ := subTable["format"].(uint16)
+		platformID, _ := subTable["platformID"].(uint16)
+		platformSpecificID, _ := subTable["platformSpecificID"].(uint16)
+		key := recordKey{platformID, platformSpecificID, cmapLanguage(subTable)}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if format == 14 {
+			subTables = append(subTables, subTable)
+			continue
+		}
+		if format == 13 {
+			subTables = append(subTables, remapCmapFormat13(subTable, func(g int) (int, bool) { return g, true }))
+			continue
+		}
+		codes := decodeCmapSubtable(subTable)
+		subTables = append(subTables, encodeBestCmapSubtable(platformID, platformSpecificID, key.language, format, codes))
+	}
+	cmap.SubTables = subTables
+	cmap.NumberSubtables = uint16(len(subTables))
+}
+
+type NameRecord struct {
+	PlatformID         uint16 `json:"platformId"`
+	PlatformSpecificID uint16 `json:"platformSpecificId"`
+	LanguageID         uint16 `json:"languageId"`
+	NameID             uint16 `json:"nameId"`
+	Length             uint16 `json:"length"`
+	Offset             uint16 `json:"offset"`
+	// Raw holds the string bytes as read. WriteName writes them back as
+	// they are unless the record's text in Info has been edited.
+	Raw []byte `json:"-"`
+	// infoText is the record's Info text when the table was read
+	infoText string
+}
+
+type LangTagRecord struct {
+	Length uint16 `json:"length"`
+	Offset uint16 `json:"offset"`
 }
 
 type NameTable struct {
@@ -1656,7 +2519,49 @@ type NameTable struct {
 	NameRecord    []*NameRecord                `json:"nameRecord"`
 	LangTagCount  uint16                       `json:"langTagCount"`
 	LangTagRecord []*LangTagRecord             `json:"langTagRecord,omitempty"`
+	LangTags      []string                     `json:"langTags,omitempty"`
 	Info          map[string]map[string]string `json:"info"`
+	// readLangTags are the language tags as read, to tell whether the
+	// original string storage layout can be kept
+	readLangTags []string
+	// readCount is the number of name records as read
+	readCount int
+}
+
+// languageTag returns the language a record's text is keyed by in Info.
+// Language IDs from 0x8000 index the format 1 language-tag strings.
+func (nameTable *NameTable) languageTag(platformID, languageID uint16) string {
+	if languageID >= 0x8000 {
+		if i := int(languageID - 0x8000); i < len(nameTable.LangTags) {
+			return nameTable.LangTags[i]
+		}
+		return ""
+	}
+	return getLangCode(int(platformID), int(languageID))
+}
+
+// readNameLangTags decodes the format 1 language-tag strings, which are
+// UTF-16BE like the Unicode names. It returns nil when the records are
+// truncated; GetName reports that when it reads them.
+func readNameLangTags(data []byte, pos int, stringOffset int) []string {
+	if pos+2 > len(data) {
+		return nil
+	}
+	langTagCount := int(getUint16(data[pos : pos+2]))
+	pos += 2
+	if pos+langTagCount*4 > len(data) {
+		return nil
+	}
+	tags := make([]string, langTagCount)
+	for i := range tags {
+		length := int(getUint16(data[pos : pos+2]))
+		offset := stringOffset + int(getUint16(data[pos+2:pos+4]))
+		pos += 4
+		if offset+length <= len(data) {
+			tags[i] = DecodeUTF16(data, offset, length)
+		}
+	}
+	return tags
 }
 
 // macos languages
@@ -2194,6 +3099,15 @@ var windowsLang = map[int]string{
 	31954: "ku-Arab",        // 0x7c92
 }
 
+// nameProperty returns the NameTable.Info key for a name ID: its entry in
+// nameTableNames, or the decimal ID for font-specific and newer name IDs.
+func nameProperty(nameID uint16) string {
+	if int(nameID) < len(nameTableNames) {
+		return nameTableNames[nameID]
+	}
+	return strconv.Itoa(int(nameID))
+}
+
 // NameIDs for the name table.
 var nameTableNames = [23]string{
 	"copyright",              // 0
@@ -2327,21 +3241,30 @@ func GetName(data []byte, pos int) (nameTable *NameTable) {
 	}
 
 	stringOffset := start + int(nameTable.StringOffset)
+	if nameTable.Format == 1 {
+		nameTable.LangTags = readNameLangTags(data, pos+count*12, stringOffset)
+		nameTable.readLangTags = append([]string(nil), nameTable.LangTags...)
+	}
 	info := make(map[string]map[string]string)
 	for i := 0; i < count; i++ {
 		nameRecord := &NameRecord{
-			getUint16(data[pos : pos+2]),
-			getUint16(data[pos+2 : pos+4]),
-			getUint16(data[pos+4 : pos+6]),
-			getUint16(data[pos+6 : pos+8]),
-			getUint16(data[pos+8 : pos+10]),
-			getUint16(data[pos+10 : pos+12]),
+			PlatformID:         getUint16(data[pos : pos+2]),
+			PlatformSpecificID: getUint16(data[pos+2 : pos+4]),
+			LanguageID:         getUint16(data[pos+4 : pos+6]),
+			NameID:             getUint16(data[pos+6 : pos+8]),
+			Length:             getUint16(data[pos+8 : pos+10]),
+			Offset:             getUint16(data[pos+10 : pos+12]),
 		}
 		nameTable.NameRecord = append(nameTable.NameRecord, nameRecord)
 		pos += 12
 
-		property := nameTableNames[int(nameRecord.NameID)]
-		language := getLangCode(int(nameRecord.PlatformID), int(nameRecord.LanguageID))
+		// Keep the bytes of every record, including those we cannot decode
+		if rawStart := stringOffset + int(nameRecord.Offset); rawStart+int(nameRecord.Length) <= len(data) {
+			nameRecord.Raw = append([]byte{}, data[rawStart:rawStart+int(nameRecord.Length)]...)
+		}
+
+		property := nameProperty(nameRecord.NameID)
+		language := nameTable.languageTag(nameRecord.PlatformID, nameRecord.LanguageID)
 		platformSpecifi := getPlatformSpecific(int(nameRecord.PlatformID), int(nameRecord.PlatformSpecificID), int(nameRecord.LanguageID))
 
 		if platformSpecifi != "" && language != "" {
@@ -2370,6 +3293,10 @@ func GetName(data []byte, pos int) (nameTable *NameTable) {
 		}
 	}
 	nameTable.Info = info
+	for _, nr := range nameTable.NameRecord {
+		nr.infoText = info[nameProperty(nr.NameID)][nameTable.languageTag(nr.PlatformID, nr.LanguageID)]
+	}
+	nameTable.readCount = len(nameTable.NameRecord)
 
 	if int(nameTable.Format) == 1 {
 		// Format 1 includes langTagRecord array
@@ -2404,45 +3331,85 @@ func GetName(data []byte, pos int) (nameTable *NameTable) {
 
 func WriteName(nameTable *NameTable) []byte {
 	// Rebuild name records and string storage from Info, respecting encodings
-	// implied by platformID/platformSpecificID.
-	var stringData []byte
+	// implied by platformID/platformSpecificID. Records whose text has not
+	// been edited since the table was read keep their original bytes.
 	count := len(nameTable.NameRecord)
 	nameTable.Count = uint16(count)
 
 	// Compute stringOffset: header (6 bytes) + name records (12*count).
 	stringOffset := 6 + 12*count
 	if nameTable.Format == 1 {
-		// Add langTagCount field (2 bytes); here we do not emit langTag strings,
-		// so we only reserve the count field.
-		stringOffset += 2
-		nameTable.LangTagCount = 0
-		nameTable.LangTagRecord = nil
+		// Add langTagCount and the language-tag records (4 bytes each)
+		stringOffset += 2 + 4*len(nameTable.LangTags)
 	}
 
-	// Prepare name records with updated Length/Offset based on encoded strings.
-	var recordsBuf []byte
-	for _, nr := range nameTable.NameRecord {
-		property := ""
-		if int(nr.NameID) < len(nameTableNames) {
-			property = nameTableNames[int(nr.NameID)]
+	encodedNames := make([][]byte, count)
+	unchanged := count == nameTable.readCount
+	for i, nr := range nameTable.NameRecord {
+		property := nameProperty(nr.NameID)
+		lang := nameTable.languageTag(nr.PlatformID, nr.LanguageID)
+		text := nameTable.Info[property][lang]
+		if nr.Raw != nil && text == nr.infoText {
+			encodedNames[i] = nr.Raw
+			continue
 		}
-		lang := getLangCode(int(nr.PlatformID), int(nr.LanguageID))
+		unchanged = false
 		platformSpec := getPlatformSpecific(int(nr.PlatformID), int(nr.PlatformSpecificID), int(nr.LanguageID))
+		encodedNames[i] = encodeNameString(text, platformSpec)
+	}
+	encodedTags := make([][]byte, len(nameTable.LangTags))
+	for i, tag := range nameTable.LangTags {
+		encodedTags[i] = encodeNameString(tag, eumnUtf16)
+	}
+	if nameTable.Format == 1 {
+		unchanged = unchanged && strings.Join(nameTable.LangTags, "\x00") == strings.Join(nameTable.readLangTags, "\x00") &&
+			len(nameTable.LangTagRecord) == len(nameTable.LangTags)
+	}
 
-		text := ""
-		if property != "" {
-			if langMap, ok := nameTable.Info[property]; ok {
-				if t, ok2 := langMap[lang]; ok2 {
-					text = t
-				}
+	var stringData []byte
+	if unchanged {
+		// Nothing was edited: keep the original string storage layout
+		place := func(offset uint16, b []byte) {
+			if end := int(offset) + len(b); end > len(stringData) {
+				stringData = append(stringData, make([]byte, end-len(stringData))...)
 			}
+			copy(stringData[offset:], b)
 		}
+		for i, nr := range nameTable.NameRecord {
+			place(nr.Offset, encodedNames[i])
+		}
+		if nameTable.Format == 1 {
+			for i, langTagRecord := range nameTable.LangTagRecord {
+				place(langTagRecord.Offset, encodedTags[i])
+			}
+		}
+	} else {
+		// Lay strings out in record order, sharing identical byte strings
+		offsets := make(map[string]uint16)
+		add := func(b []byte) uint16 {
+			if offset, ok := offsets[string(b)]; ok {
+				return offset
+			}
+			offset := uint16(len(stringData))
+			offsets[string(b)] = offset
+			stringData = append(stringData, b...)
+			return offset
+		}
+		for i, nr := range nameTable.NameRecord {
+			nr.Offset = add(encodedNames[i])
+		}
+		if nameTable.Format == 1 {
+			nameTable.LangTagRecord = nil
+			for _, encoded := range encodedTags {
+				nameTable.LangTagRecord = append(nameTable.LangTagRecord, &LangTagRecord{Offset: add(encoded)})
+			}
+		}
+	}
 
-		encoded := encodeNameString(text, platformSpec)
-		nr.Length = uint16(len(encoded))
-		nr.Offset = uint16(len(stringData))
-		stringData = append(stringData, encoded...)
-
+	// Prepare name records with updated Length/Offset
+	var recordsBuf []byte
+	for i, nr := range nameTable.NameRecord {
+		nr.Length = uint16(len(encodedNames[i]))
 		recordsBuf = append(recordsBuf, writeUint16(nr.PlatformID)...)
 		recordsBuf = append(recordsBuf, writeUint16(nr.PlatformSpecificID)...)
 		recordsBuf = append(recordsBuf, writeUint16(nr.LanguageID)...)
@@ -2451,6 +3418,18 @@ func WriteName(nameTable *NameTable) []byte {
 		recordsBuf = append(recordsBuf, writeUint16(nr.Offset)...)
 	}
 
+	// Format 1: language-tag strings live in string storage too
+	var langTagBuf []byte
+	if nameTable.Format == 1 {
+		for i, langTagRecord := range nameTable.LangTagRecord {
+			langTagRecord.Length = uint16(len(encodedTags[i]))
+			langTagBuf = append(langTagBuf, writeUint16(langTagRecord.Length)...)
+			langTagBuf = append(langTagBuf, writeUint16(langTagRecord.Offset)...)
+		}
+		nameTable.LangTagCount = uint16(len(nameTable.LangTags))
+	}
+	nameTable.StringOffset = uint16(stringOffset)
+
 	// Write header
 	data := []byte{}
 	data = append(data, writeUint16(nameTable.Format)...)
@@ -2460,9 +3439,9 @@ func WriteName(nameTable *NameTable) []byte {
 	// Records
 	data = append(data, recordsBuf...)
 
-	// Format 1: lang tag count (no tags emitted here)
 	if nameTable.Format == 1 {
 		data = append(data, writeUint16(nameTable.LangTagCount)...)
+		data = append(data, langTagBuf...)
 	}
 
 	// String storage
@@ -2472,8 +3451,8 @@ func WriteName(nameTable *NameTable) []byte {
 
 // encodeNameString encodes text according to platform-specific encoding.
 // If encoding is utf-16, we emit big-endian UTF-16 without BOM.
-// If encoding is a Mac 8-bit codepage, we try to map each rune back to the
-// codepage; unmapped runes fall back to '?'.
+// If encoding is a Mac 8-bit codepage or one of the double-byte CJK ones, we
+// try to map each rune back to the codepage; unmapped runes fall back to '?'.
 func encodeNameString(text string, platformSpec string) []byte {
 	if platformSpec == eumnUtf16 || platformSpec == "" {
 		// Default to UTF-16BE
@@ -2486,6 +3465,10 @@ func encodeNameString(text string, platformSpec string) []byte {
 		return buf
 	}
 
+	if enc, ok := doubleByteMacEncodings[platformSpec]; ok {
+		return enc.encode(text)
+	}
+
 	// Mac 8-bit
 	table, ok := eightBitMacEncodings[platformSpec]
 	if !ok {
@@ -2494,8 +3477,8 @@ func encodeNameString(text string, platformSpec string) []byte {
 	}
 
 	// Build reverse map once per call (small tables)
-	rev := make(map[rune]byte, len(table))
-	for i, r := range table {
+	rev := make(map[rune]byte, 128)
+	for i, r := range []rune(table) {
 		rev[r] = byte(0x80 + i)
 	}
 
@@ -2514,6 +3497,232 @@ func encodeNameString(text string, platformSpec string) []byte {
 	return out
 }
 
+// NameEntry is one name record together with its text.
+type NameEntry struct {
+	NameID             uint16 `json:"nameId"`
+	PlatformID         uint16 `json:"platformId"`
+	PlatformSpecificID uint16 `json:"platformSpecificId"`
+	LanguageID         uint16 `json:"languageId"`
+	Language           string `json:"language"`
+	Text               string `json:"text"`
+}
+
+// Names lists the name records in table order with their text.
+func (nameTable *NameTable) Names() []*NameEntry {
+	entries := make([]*NameEntry, 0, len(nameTable.NameRecord))
+	for _, nr := range nameTable.NameRecord {
+		language := nameTable.languageTag(nr.PlatformID, nr.LanguageID)
+		entries = append(entries, &NameEntry{
+			NameID:             nr.NameID,
+			PlatformID:         nr.PlatformID,
+			PlatformSpecificID: nr.PlatformSpecificID,
+			LanguageID:         nr.LanguageID,
+			Language:           language,
+			Text:               nameTable.Info[nameProperty(nr.NameID)][language],
+		})
+	}
+	return entries
+}
+
+// windowsLanguageIDs resolves BCP 47 tags, in lower case, to the LCID the
+// name table uses for them. Where windowsLang lists several IDs for a tag
+// the specific locale wins, and a bare language gets its default region.
+var windowsLanguageIDs = func() map[string]uint16 {
+	ids := make(map[string]uint16)
+	specific := func(lcid int) bool { return lcid >= 0x400 && lcid < 0x7000 }
+	for lcid, tag := range windowsLang {
+		tag = strings.ToLower(tag)
+		current, exist := ids[tag]
+		if !exist || (specific(lcid) && (!specific(int(current)) || lcid < int(current))) {
+			ids[tag] = uint16(lcid)
+		}
+	}
+	for tag, lcid := range ids {
+		if lcid < 0x400 {
+			if _, ok := windowsLang[int(lcid)|0x400]; ok {
+				ids[tag] = lcid | 0x400
+			}
+		}
+	}
+	// Conventional IDs the rules above do not pick: Simplified Chinese is
+	// zh-CN rather than the zh-TW default, and Spanish the modern sort
+	ids["zh-hans"] = 0x0804
+	ids["zh-hant"] = 0x0404
+	ids["es"] = 0x0C0A
+	return ids
+}()
+
+// WindowsLanguageID returns the Windows LCID for a BCP 47 language tag such
+// as "en", "fr-CA" or "zh-Hant".
+func WindowsLanguageID(languageTag string) (lcid uint16, ok bool) {
+	lcid, ok = windowsLanguageIDs[strings.ToLower(languageTag)]
+	return
+}
+
+// macLanguageID returns the Mac language code for a BCP 47 tag, trying the
+// bare language when the full tag has no Mac code.
+func macLanguageID(languageTag string) (code uint16, ok bool) {
+	tag := strings.ToLower(languageTag)
+	for {
+		for id, macTag := range macLang {
+			if strings.ToLower(macTag) == tag {
+				return uint16(id), true
+			}
+		}
+		i := strings.LastIndexByte(tag, '-')
+		if i < 0 {
+			return
+		}
+		tag = tag[:i]
+	}
+}
+
+// macRomanText reports whether text survives encoding for a Mac Roman
+// platform record of the given language.
+func macRomanText(text string, languageID uint16) bool {
+	encoding := getPlatformSpecific(1, 0, int(languageID))
+	table, ok := eightBitMacEncodings[encoding]
+	if !ok {
+		return false
+	}
+	for _, r := range text {
+		if r > 0x7F && !strings.ContainsRune(table, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// SetName sets the text of name nameID for a BCP 47 language tag. It updates
+// or adds the Windows Unicode (3, 1) record and, when the table already
+// carries Macintosh names and the text fits the language's Mac encoding,
+// the Mac Roman (1, 0) record too. Records stay sorted as the spec requires.
+func (nameTable *NameTable) SetName(nameID uint16, languageTag string, text string) error {
+	lcid, ok := WindowsLanguageID(languageTag)
+	if !ok {
+		if lcid, ok = nameTable.langTagID(languageTag); !ok {
+			return errors.New("no Windows language ID for tag " + languageTag)
+		}
+	}
+	nameTable.setRecordText(3, 1, lcid, nameID, text)
+
+	hasMac := false
+	for _, nr := range nameTable.NameRecord {
+		if nr.PlatformID == 1 {
+			hasMac = true
+			break
+		}
+	}
+	if macID, ok := macLanguageID(languageTag); ok && hasMac && macRomanText(text, macID) {
+		nameTable.setRecordText(1, 0, macID, nameID, text)
+	}
+	nameTable.sortRecords()
+	return nil
+}
+
+// langTagID returns the language ID of a format 1 language tag, adding the
+// tag and switching the table to format 1 when needed. Tags without a
+// Windows LCID are stored this way.
+func (nameTable *NameTable) langTagID(languageTag string) (uint16, bool) {
+	if languageTag == "" {
+		return 0, false
+	}
+	for i, tag := range nameTable.LangTags {
+		if strings.EqualFold(tag, languageTag) {
+			return uint16(0x8000 + i), true
+		}
+	}
+	if len(nameTable.LangTags) >= 0x7FFF {
+		return 0, false
+	}
+	nameTable.Format = 1
+	nameTable.LangTags = append(nameTable.LangTags, languageTag)
+	return uint16(0x8000 + len(nameTable.LangTags) - 1), true
+}
+
+// setRecordText stores text for the records of platform and language,
+// adding a record with the given encoding when there is none.
+func (nameTable *NameTable) setRecordText(platformID, platformSpecificID, languageID, nameID uint16, text string) {
+	found := false
+	for _, nr := range nameTable.NameRecord {
+		if nr.PlatformID == platformID && nr.LanguageID == languageID && nr.NameID == nameID {
+			found = true
+		}
+	}
+	if !found {
+		nameTable.NameRecord = append(nameTable.NameRecord, &NameRecord{
+			PlatformID:         platformID,
+			PlatformSpecificID: platformSpecificID,
+			LanguageID:         languageID,
+			NameID:             nameID,
+		})
+	}
+
+	if nameTable.Info == nil {
+		nameTable.Info = make(map[string]map[string]string)
+	}
+	property := nameProperty(nameID)
+	if nameTable.Info[property] == nil {
+		nameTable.Info[property] = make(map[string]string)
+	}
+	nameTable.Info[property][nameTable.languageTag(platformID, languageID)] = text
+}
+
+// DeleteName removes the Windows and Mac records of name nameID for a BCP 47
+// language tag, or for every language when languageTag is empty.
+func (nameTable *NameTable) DeleteName(nameID uint16, languageTag string) {
+	lcid, windows := WindowsLanguageID(languageTag)
+	macID, mac := macLanguageID(languageTag)
+	if !windows {
+		for i, tag := range nameTable.LangTags {
+			if strings.EqualFold(tag, languageTag) {
+				lcid, windows = uint16(0x8000+i), true
+			}
+		}
+	}
+
+	property := nameProperty(nameID)
+	records := nameTable.NameRecord[:0]
+	for _, nr := range nameTable.NameRecord {
+		matches := nr.NameID == nameID && (languageTag == "" ||
+			(nr.PlatformID == 3 && windows && nr.LanguageID == lcid) ||
+			(nr.PlatformID == 1 && mac && nr.LanguageID == macID))
+		if !matches {
+			records = append(records, nr)
+			continue
+		}
+		delete(nameTable.Info[property], nameTable.languageTag(nr.PlatformID, nr.LanguageID))
+	}
+	nameTable.NameRecord = records
+	if len(nameTable.Info[property]) == 0 {
+		delete(nameTable.Info, property)
+	}
+	nameTable.sortRecords()
+}
+
+// sortRecords orders the records by platform, encoding, language and name
+// ID, and brings Count and StringOffset in line with them.
+func (nameTable *NameTable) sortRecords() {
+	sort.SliceStable(nameTable.NameRecord, func(i, j int) bool {
+		a, b := nameTable.NameRecord[i], nameTable.NameRecord[j]
+		if a.PlatformID != b.PlatformID {
+			return a.PlatformID < b.PlatformID
+		}
+		if a.PlatformSpecificID != b.PlatformSpecificID {
+			return a.PlatformSpecificID < b.PlatformSpecificID
+		}
+		if a.LanguageID != b.LanguageID {
+			return a.LanguageID < b.LanguageID
+		}
+		return a.NameID < b.NameID
+	})
+	nameTable.Count = uint16(len(nameTable.NameRecord))
+	nameTable.StringOffset = uint16(6 + 12*len(nameTable.NameRecord))
+	if nameTable.Format == 1 {
+		nameTable.StringOffset += uint16(2 + 4*len(nameTable.LangTags))
+	}
+}
+
 type Hhea struct {
 	Version             float64 `json:"version"`
 	Ascent              int16   `json:"ascent"`
@@ -2607,44 +3816,359 @@ func GetHmtx(data []byte, pos int, numOfLongHorMetrics int, numGlyph int) (hmtx
 	return
 }
 
+// WriteHmtx writes the horizontal metrics with the trailing run of glyphs
+// that share the last advance width collapsed into the leftSideBearing
+// array. The matching hhea numberOfHMetrics is NumberOfHMetrics.
 func WriteHmtx(hmtx *Hmtx) []byte {
 	data := []byte{}
-
-	// Write all LongHorMetric entries
-	for _, metric := range hmtx.HMetrics {
-		data = append(data, writeUint16(metric.AdvanceWidth)...)
-		data = append(data, writeInt16(metric.LeftSideBearing)...)
-	}
-
-	// Write additional LeftSideBearing entries
-	for _, lsb := range hmtx.LeftSideBearing {
+	numHMetrics := hmtx.NumberOfHMetrics()
+	for gid := 0; gid < hmtx.numGlyphs(); gid++ {
+		advance, lsb := hmtx.Metric(gid)
+		if gid < numHMetrics {
+			data = append(data, writeUint16(advance)...)
+		}
 		data = append(data, writeInt16(lsb)...)
 	}
-
 	return data
 }
 
-type nPairs struct {
-	Left  uint16 `json:"left"`
-	Right uint16 `json:"right"`
-	Value int16  `json:"value"`
+func (hmtx *Hmtx) numGlyphs() int {
+	return len(hmtx.HMetrics) + len(hmtx.LeftSideBearing)
 }
 
-// KernFormat2ClassTable represents a class mapping table for format 2
-type KernFormat2ClassTable struct {
-	FirstGlyph uint16   `json:"firstGlyph"`
-	NGlyphs    uint16   `json:"nGlyphs"`
-	Offsets    []uint16 `json:"offsets"`
+// NumberOfHMetrics returns the number of long metrics WriteHmtx writes: every
+// glyph up to the start of the trailing run with equal advance widths.
+func (hmtx *Hmtx) NumberOfHMetrics() int {
+	n := hmtx.numGlyphs()
+	if n == 0 {
+		return 0
+	}
+	last, _ := hmtx.Metric(n - 1)
+	for n > 1 {
+		if advance, _ := hmtx.Metric(n - 2); advance != last {
+			break
+		}
+		n--
+	}
+	return n
 }
 
-// KernFormat2 represents a format 2 kern subtable (simple n×m array)
-type KernFormat2 struct {
+// compact moves the trailing run of equal advance widths into the
+// leftSideBearing array, the layout WriteHmtx produces.
+func (hmtx *Hmtx) compact() {
+	n := hmtx.NumberOfHMetrics()
+	if n > len(hmtx.HMetrics) {
+		return
+	}
+	var lsbs []int16
+	for gid := n; gid < hmtx.numGlyphs(); gid++ {
+		_, lsb := hmtx.Metric(gid)
+		lsbs = append(lsbs, lsb)
+	}
+	hmtx.HMetrics = hmtx.HMetrics[:n]
+	hmtx.LeftSideBearing = lsbs
+}
+
+// Metric returns the advance width and left side bearing of glyph gid.
+// Glyphs past the last long metric share its advance width.
+func (hmtx *Hmtx) Metric(gid int) (advance uint16, lsb int16) {
+	if gid < 0 || len(hmtx.HMetrics) == 0 {
+		return 0, 0
+	}
+	if gid < len(hmtx.HMetrics) {
+		return hmtx.HMetrics[gid].AdvanceWidth, hmtx.HMetrics[gid].LeftSideBearing
+	}
+	advance = hmtx.HMetrics[len(hmtx.HMetrics)-1].AdvanceWidth
+	if i := gid - len(hmtx.HMetrics); i < len(hmtx.LeftSideBearing) {
+		lsb = hmtx.LeftSideBearing[i]
+	}
+	return
+}
+
+// setLeftSideBearing sets the left side bearing of glyph gid
+func (hmtx *Hmtx) setLeftSideBearing(gid int, lsb int16) {
+	if gid < 0 {
+		return
+	}
+	if gid < len(hmtx.HMetrics) {
+		hmtx.HMetrics[gid].LeftSideBearing = lsb
+	} else if i := gid - len(hmtx.HMetrics); i < len(hmtx.LeftSideBearing) {
+		hmtx.LeftSideBearing[i] = lsb
+	}
+}
+
+type Vhea struct {
+	Version              float64 `json:"version"`
+	Ascent               int16   `json:"ascent"`
+	Descent              int16   `json:"descent"`
+	LineGap              int16   `json:"lineGap"`
+	AdvanceHeightMax     uint16  `json:"advanceHeightMax"`
+	MinTopSideBearing    int16   `json:"minTopSideBearing"`
+	MinBottomSideBearing int16   `json:"minBottomSideBearing"`
+	YMaxExtent           int16   `json:"yMaxExtent"`
+	CaretSlopeRise       int16   `json:"caretSlopeRise"`
+	CaretSlopeRun        int16   `json:"caretSlopeRun"`
+	CaretOffset          int16   `json:"caretOffset"`
+	Reserved1            int16   `json:"reserved1"`
+	Reserved2            int16   `json:"reserved2"`
+	Reserved3            int16   `json:"reserved3"`
+	Reserved4            int16   `json:"reserved4"`
+	MetricDataFormat     int16   `json:"metricDataFormat"`
+	NumOfLongVerMetrics  uint16  `json:"numOfLongVerMetrics"`
+}
+
+func GetVhea(data []byte, pos int) (vhea *Vhea, err error) {
+	if pos < 0 || pos+36 > len(data) {
+		err = errors.New("vhea table truncated")
+		return
+	}
+	vhea = &Vhea{
+		getFixed(data[pos : pos+4]),
+		getFWord(data[pos+4 : pos+6]),
+		getFWord(data[pos+6 : pos+8]),
+		getFWord(data[pos+8 : pos+10]),
+		getUFWord(data[pos+10 : pos+12]),
+		getFWord(data[pos+12 : pos+14]),
+		getFWord(data[pos+14 : pos+16]),
+		getFWord(data[pos+16 : pos+18]),
+		getInt16(data[pos+18 : pos+20]),
+		getInt16(data[pos+20 : pos+22]),
+		getFWord(data[pos+22 : pos+24]),
+		getInt16(data[pos+24 : pos+26]),
+		getInt16(data[pos+26 : pos+28]),
+		getInt16(data[pos+28 : pos+30]),
+		getInt16(data[pos+30 : pos+32]),
+		getInt16(data[pos+32 : pos+34]),
+		getUint16(data[pos+34 : pos+36]),
+	}
+	return
+}
+
+func WriteVhea(vhea *Vhea) []byte {
+	data := []byte{}
+	data = append(data, writeFixed(vhea.Version)...)
+	data = append(data, writeFWord(vhea.Ascent)...)
+	data = append(data, writeFWord(vhea.Descent)...)
+	data = append(data, writeFWord(vhea.LineGap)...)
+	data = append(data, writeUFWord(vhea.AdvanceHeightMax)...)
+	data = append(data, writeFWord(vhea.MinTopSideBearing)...)
+	data = append(data, writeFWord(vhea.MinBottomSideBearing)...)
+	data = append(data, writeFWord(vhea.YMaxExtent)...)
+	data = append(data, writeInt16(vhea.CaretSlopeRise)...)
+	data = append(data, writeInt16(vhea.CaretSlopeRun)...)
+	data = append(data, writeInt16(vhea.CaretOffset)...)
+	data = append(data, writeInt16(vhea.Reserved1)...)
+	data = append(data, writeInt16(vhea.Reserved2)...)
+	data = append(data, writeInt16(vhea.Reserved3)...)
+	data = append(data, writeInt16(vhea.Reserved4)...)
+	data = append(data, writeInt16(vhea.MetricDataFormat)...)
+	data = append(data, writeUint16(vhea.NumOfLongVerMetrics)...)
+	return data
+}
+
+type LongVerMetric struct {
+	AdvanceHeight  uint16 `json:"advanceHeight"`
+	TopSideBearing int16  `json:"topSideBearing"`
+}
+
+type Vmtx struct {
+	VMetrics       []*LongVerMetric `json:"vMetrics"`
+	TopSideBearing []int16          `json:"topSideBearing"`
+}
+
+func GetVmtx(data []byte, pos int, numOfLongVerMetrics int, numGlyph int) (vmtx *Vmtx, err error) {
+	vmtx = new(Vmtx)
+	size := numOfLongVerMetrics * 4
+	if numGlyph > numOfLongVerMetrics {
+		size += (numGlyph - numOfLongVerMetrics) * 2
+	}
+	if pos < 0 || pos+size > len(data) {
+		err = errors.New("vmtx table truncated")
+		return
+	}
+
+	for i := 0; i < numOfLongVerMetrics; i++ {
+		vmtx.VMetrics = append(vmtx.VMetrics, &LongVerMetric{
+			getUint16(data[pos : pos+2]),
+			getInt16(data[pos+2 : pos+4]),
+		})
+		pos += 4
+	}
+
+	for i := 0; i < (numGlyph - numOfLongVerMetrics); i++ {
+		vmtx.TopSideBearing = append(vmtx.TopSideBearing, getInt16(data[pos:pos+2]))
+		pos += 2
+	}
+	return
+}
+
+// WriteVmtx writes the vertical metrics with the trailing run of glyphs
+// that share the last advance height collapsed into the topSideBearing
+// array. The matching vhea numOfLongVerMetrics is NumberOfVMetrics.
+func WriteVmtx(vmtx *Vmtx) []byte {
+	data := []byte{}
+	numVMetrics := vmtx.NumberOfVMetrics()
+	for gid := 0; gid < vmtx.numGlyphs(); gid++ {
+		advance, tsb := vmtx.Metric(gid)
+		if gid < numVMetrics {
+			data = append(data, writeUint16(advance)...)
+		}
+		data = append(data, writeInt16(tsb)...)
+	}
+	return data
+}
+
+func (vmtx *Vmtx) numGlyphs() int {
+	return len(vmtx.VMetrics) + len(vmtx.TopSideBearing)
+}
+
+// NumberOfVMetrics returns the number of long metrics WriteVmtx writes: every
+// glyph up to the start of the trailing run with equal advance heights.
+func (vmtx *Vmtx) NumberOfVMetrics() int {
+	n := vmtx.numGlyphs()
+	if n == 0 {
+		return 0
+	}
+	last, _ := vmtx.Metric(n - 1)
+	for n > 1 {
+		if advance, _ := vmtx.Metric(n - 2); advance != last {
+			break
+		}
+		n--
+	}
+	return n
+}
+
+// compact moves the trailing run of equal advance heights into the
+// topSideBearing array, the layout WriteVmtx produces.
+func (vmtx *Vmtx) compact() {
+	n := vmtx.NumberOfVMetrics()
+	if n > len(vmtx.VMetrics) {
+		return
+	}
+	var tsbs []int16
+	for gid := n; gid < vmtx.numGlyphs(); gid++ {
+		_, tsb := vmtx.Metric(gid)
+		tsbs = append(tsbs, tsb)
+	}
+	vmtx.VMetrics = vmtx.VMetrics[:n]
+	vmtx.TopSideBearing = tsbs
+}
+
+// Metric returns the advance height and top side bearing of glyph gid.
+// Glyphs past the last long metric share its advance height.
+func (vmtx *Vmtx) Metric(gid int) (advance uint16, tsb int16) {
+	if gid < 0 || len(vmtx.VMetrics) == 0 {
+		return 0, 0
+	}
+	if gid < len(vmtx.VMetrics) {
+		return vmtx.VMetrics[gid].AdvanceHeight, vmtx.VMetrics[gid].TopSideBearing
+	}
+	advance = vmtx.VMetrics[len(vmtx.VMetrics)-1].AdvanceHeight
+	if i := gid - len(vmtx.VMetrics); i < len(vmtx.TopSideBearing) {
+		tsb = vmtx.TopSideBearing[i]
+	}
+	return
+}
+
+// Vorg is the CFF vertical origin table: the y coordinate of the vertical
+// origin of each glyph, with a default for glyphs not listed.
+type Vorg struct {
+	MajorVersion       uint16           `json:"majorVersion"`
+	MinorVersion       uint16           `json:"minorVersion"`
+	DefaultVertOriginY int16            `json:"defaultVertOriginY"`
+	VertOriginY        map[uint16]int16 `json:"vertOriginY,omitempty"`
+}
+
+func GetVorg(data []byte, pos int) (vorg *Vorg, err error) {
+	vorg = new(Vorg)
+	if pos+8 > len(data) {
+		err = errors.New("VORG table truncated")
+		return
+	}
+	vorg.MajorVersion = getUint16(data[pos : pos+2])
+	vorg.MinorVersion = getUint16(data[pos+2 : pos+4])
+	if vorg.MajorVersion != 1 {
+		err = errors.New("unsupported VORG table version")
+		return
+	}
+	vorg.DefaultVertOriginY = getInt16(data[pos+4 : pos+6])
+	num := int(getUint16(data[pos+6 : pos+8]))
+	pos += 8
+	if pos+num*4 > len(data) {
+		err = errors.New("VORG metrics truncated")
+		return
+	}
+	vorg.VertOriginY = make(map[uint16]int16, num)
+	for i := 0; i < num; i++ {
+		vorg.VertOriginY[getUint16(data[pos:pos+2])] = getInt16(data[pos+2 : pos+4])
+		pos += 4
+	}
+	return
+}
+
+func WriteVorg(vorg *Vorg) []byte {
+	glyphs := make([]int, 0, len(vorg.VertOriginY))
+	for gid := range vorg.VertOriginY {
+		glyphs = append(glyphs, int(gid))
+	}
+	sort.Ints(glyphs)
+
+	data := []byte{}
+	data = append(data, writeUint16(vorg.MajorVersion)...)
+	data = append(data, writeUint16(vorg.MinorVersion)...)
+	data = append(data, writeInt16(vorg.DefaultVertOriginY)...)
+	data = append(data, writeUint16(uint16(len(glyphs)))...)
+	for _, gid := range glyphs {
+		data = append(data, writeUint16(uint16(gid))...)
+		data = append(data, writeInt16(vorg.VertOriginY[uint16(gid)])...)
+	}
+	return data
+}
+
+// OriginY returns the vertical origin y coordinate of glyph gid
+func (vorg *Vorg) OriginY(gid int) int16 {
+	if y, ok := vorg.VertOriginY[uint16(gid)]; ok {
+		return y
+	}
+	return vorg.DefaultVertOriginY
+}
+
+func remapVorg(vorg *Vorg, oldToNew map[int]int) {
+	remapped := make(map[uint16]int16)
+	for gid, y := range vorg.VertOriginY {
+		if newGid, ok := oldToNew[int(gid)]; ok && y != vorg.DefaultVertOriginY {
+			remapped[uint16(newGid)] = y
+		}
+	}
+	vorg.VertOriginY = remapped
+}
+
+type nPairs struct {
+	Left  uint16 `json:"left"`
+	Right uint16 `json:"right"`
+	Value int16  `json:"value"`
+}
+
+// KernFormat2ClassTable represents a class mapping table for format 2
+type KernFormat2ClassTable struct {
+	FirstGlyph uint16   `json:"firstGlyph"`
+	NGlyphs    uint16   `json:"nGlyphs"`
+	Offsets    []uint16 `json:"offsets"`
+}
+
+// KernFormat2 represents a format 2 kern subtable (simple n×m array).
+// Left class values are byte offsets from the subtable start to a row and
+// right class values are byte offsets within a row, so a pair's value sits
+// at KernValues[(left+right-ArrayOffset)/2].
+type KernFormat2 struct {
 	RowWidth         uint16                 `json:"rowWidth"`
 	LeftOffsetTable  uint16                 `json:"leftOffsetTable"`
 	RightOffsetTable uint16                 `json:"rightOffsetTable"`
 	ArrayOffset      uint16                 `json:"arrayOffset"`
 	LeftClassTable   *KernFormat2ClassTable `json:"leftClassTable,omitempty"`
 	RightClassTable  *KernFormat2ClassTable `json:"rightClassTable,omitempty"`
+	KernValues       []int16                `json:"kernValues,omitempty"`
 }
 
 // KernFormat3 represents a format 3 kern subtable (simple n×m index array)
@@ -2660,163 +4184,280 @@ type KernFormat3 struct {
 	KernIndex       []uint8 `json:"kernIndex"`
 }
 
-func getWindowsKernTable(data []byte, pos int) (subHeaders map[string]int, kernPairs []*nPairs, format2 *KernFormat2) {
-	subHeaders = make(map[string]int)
+// KernCoverage is the decoded coverage field of a kern subtable. The
+// Windows (version 0) and Apple (version 1) tables lay these bits out
+// differently; GetKern and WriteKern translate between the two.
+type KernCoverage struct {
+	Horizontal  bool `json:"horizontal"`
+	Minimum     bool `json:"minimum,omitempty"`
+	CrossStream bool `json:"crossStream,omitempty"`
+	Override    bool `json:"override,omitempty"`
+	Variation   bool `json:"variation,omitempty"`
+}
+
+// KernSubtable is one subtable of a kern table. SubHeaders keeps the header
+// fields as they were read (version, length, coverage, format, tupleIndex,
+// nPairs, ...); on write the coverage field is rebuilt from Coverage and
+// SubHeaders["format"].
+type KernSubtable struct {
+	Coverage   KernCoverage   `json:"coverage"`
+	SubHeaders map[string]int `json:"subHeaders"`
+	Pairs      []*nPairs      `json:"pairs,omitempty"`
+	Format2    *KernFormat2   `json:"format2,omitempty"`
+	Format3    *KernFormat3   `json:"format3,omitempty"`
+}
+
+func decodeKernCoverage(coverage int, isMac bool) (format int, kc KernCoverage) {
+	if isMac {
+		format = coverage & 0x00FF
+		kc.Horizontal = coverage&0x8000 == 0
+		kc.CrossStream = coverage&0x4000 != 0
+		kc.Variation = coverage&0x2000 != 0
+		return
+	}
+	format = coverage >> 8
+	kc.Horizontal = coverage&0x0001 != 0
+	kc.Minimum = coverage&0x0002 != 0
+	kc.CrossStream = coverage&0x0004 != 0
+	kc.Override = coverage&0x0008 != 0
+	return
+}
+
+func encodeKernCoverage(format int, kc KernCoverage, isMac bool) int {
+	if isMac {
+		coverage := format & 0x00FF
+		if !kc.Horizontal {
+			coverage |= 0x8000
+		}
+		if kc.CrossStream {
+			coverage |= 0x4000
+		}
+		if kc.Variation {
+			coverage |= 0x2000
+		}
+		return coverage
+	}
+	coverage := (format & 0x00FF) << 8
+	if kc.Horizontal {
+		coverage |= 0x0001
+	}
+	if kc.Minimum {
+		coverage |= 0x0002
+	}
+	if kc.CrossStream {
+		coverage |= 0x0004
+	}
+	if kc.Override {
+		coverage |= 0x0008
+	}
+	return coverage
+}
+
+func getKernPairs(data []byte, pos int, count int) (kernPairs []*nPairs, err error) {
+	if pos+count*6 > len(data) {
+		return nil, errKernTruncated
+	}
+	for i := 0; i < count; i++ {
+		kernPairs = append(kernPairs, &nPairs{
+			getUint16(data[pos : pos+2]),
+			getUint16(data[pos+2 : pos+4]),
+			getFWord(data[pos+4 : pos+6]),
+		})
+		pos += 6
+	}
+	return
+}
+
+func getKernFormat2(data []byte, subtableStart int, pos int, length int) (format2 *KernFormat2, err error) {
+	if pos+8 > len(data) {
+		return nil, errKernTruncated
+	}
+	format2 = new(KernFormat2)
+	format2.RowWidth = getUint16(data[pos : pos+2])
+	format2.LeftOffsetTable = getUint16(data[pos+2 : pos+4])
+	format2.RightOffsetTable = getUint16(data[pos+4 : pos+6])
+	format2.ArrayOffset = getUint16(data[pos+6 : pos+8])
+
+	readClassTable := func(tablePos int) (*KernFormat2ClassTable, error) {
+		if tablePos+4 > len(data) {
+			return nil, errKernTruncated
+		}
+		classTable := &KernFormat2ClassTable{
+			FirstGlyph: getUint16(data[tablePos : tablePos+2]),
+			NGlyphs:    getUint16(data[tablePos+2 : tablePos+4]),
+		}
+		tablePos += 4
+		if tablePos+int(classTable.NGlyphs)*2 > len(data) {
+			return nil, errKernTruncated
+		}
+		for i := 0; i < int(classTable.NGlyphs); i++ {
+			classTable.Offsets = append(classTable.Offsets, getUint16(data[tablePos:tablePos+2]))
+			tablePos += 2
+		}
+		return classTable, nil
+	}
+
+	// Class tables and the value array are addressed from the subtable start
+	if format2.LeftClassTable, err = readClassTable(subtableStart + int(format2.LeftOffsetTable)); err != nil {
+		return nil, err
+	}
+	if format2.RightClassTable, err = readClassTable(subtableStart + int(format2.RightOffsetTable)); err != nil {
+		return nil, err
+	}
+
+	// The value array runs to the end of the subtable, or as far as the
+	// largest class offsets reach when the length field is unreliable.
+	arrayEnd := subtableStart + length
+	maxLeft, maxRight := 0, 0
+	for _, off := range format2.LeftClassTable.Offsets {
+		if int(off) > maxLeft {
+			maxLeft = int(off)
+		}
+	}
+	for _, off := range format2.RightClassTable.Offsets {
+		if int(off) > maxRight {
+			maxRight = int(off)
+		}
+	}
+	if reach := subtableStart + maxLeft + maxRight + 2; reach > arrayEnd {
+		arrayEnd = reach
+	}
+	if arrayEnd > len(data) {
+		arrayEnd = len(data)
+	}
+	for p := subtableStart + int(format2.ArrayOffset); p+2 <= arrayEnd; p += 2 {
+		format2.KernValues = append(format2.KernValues, getFWord(data[p:p+2]))
+	}
+	return
+}
+
+func getKernFormat3(data []byte, pos int) (format3 *KernFormat3, err error) {
+	if pos+6 > len(data) {
+		return nil, errKernTruncated
+	}
+	format3 = new(KernFormat3)
+	format3.GlyphCount = getUint16(data[pos : pos+2])
+	format3.KernValueCount = data[pos+2]
+	format3.LeftClassCount = data[pos+3]
+	format3.RightClassCount = data[pos+4]
+	format3.Flags = data[pos+5]
+	pos += 6
+	size := int(format3.KernValueCount)*2 + int(format3.GlyphCount)*2 + int(format3.LeftClassCount)*int(format3.RightClassCount)
+	if pos+size > len(data) {
+		return nil, errKernTruncated
+	}
+
+	// Read kern values
+	for i := 0; i < int(format3.KernValueCount); i++ {
+		format3.KernValues = append(format3.KernValues, getFWord(data[pos:pos+2]))
+		pos += 2
+	}
+
+	// Read left class array
+	for i := 0; i < int(format3.GlyphCount); i++ {
+		format3.LeftClass = append(format3.LeftClass, data[pos])
+		pos++
+	}
+
+	// Read right class array
+	for i := 0; i < int(format3.GlyphCount); i++ {
+		format3.RightClass = append(format3.RightClass, data[pos])
+		pos++
+	}
+
+	// Read kern index array
+	indexCount := int(format3.LeftClassCount) * int(format3.RightClassCount)
+	for i := 0; i < indexCount; i++ {
+		format3.KernIndex = append(format3.KernIndex, data[pos])
+		pos++
+	}
+	return
+}
+
+// getWindowsKernTable reads one version 0 subtable and returns it together
+// with the number of bytes it occupies.
+func getWindowsKernTable(data []byte, pos int) (subtable *KernSubtable, size int, err error) {
+	if pos+6 > len(data) {
+		return nil, 0, errKernTruncated
+	}
+	subtable = &KernSubtable{SubHeaders: make(map[string]int)}
+	subHeaders := subtable.SubHeaders
 	subtableStart := pos // Save subtable start position
 	subHeaders["version"] = int(getUint16(data[pos : pos+2]))
 	subHeaders["length"] = int(getUint16(data[pos+2 : pos+4]))
 	coverage := int(getUint16(data[pos+4 : pos+6]))
 	subHeaders["coverage"] = coverage
-	format := coverage & 0x00FF
+	format, kc := decodeKernCoverage(coverage, false)
 	subHeaders["format"] = format
+	subtable.Coverage = kc
+	size = subHeaders["length"]
 
 	if format == 0 {
 		// Format 0: ordered list of kerning pairs
+		if pos+14 > len(data) {
+			return nil, 0, errKernTruncated
+		}
 		subHeaders["nPairs"] = int(getUint16(data[pos+6 : pos+8]))
 		subHeaders["searchRange"] = int(getUint16(data[pos+8 : pos+10]))
 		subHeaders["entrySelector"] = int(getUint16(data[pos+10 : pos+12]))
 		subHeaders["rangeShift"] = int(getUint16(data[pos+12 : pos+14]))
-		pos += 14
-		nP := int(subHeaders["nPairs"])
-		for i := 0; i < nP; i++ {
-			kernPairs = append(kernPairs, &nPairs{
-				getUint16(data[pos : pos+2]),
-				getUint16(data[pos+2 : pos+4]),
-				getFWord(data[pos+4 : pos+6]),
-			})
-			pos += 6
+		if subtable.Pairs, err = getKernPairs(data, pos+14, subHeaders["nPairs"]); err != nil {
+			return nil, 0, err
 		}
+		// The uint16 length field overflows for large pair lists, so trust nPairs
+		size = 14 + subHeaders["nPairs"]*6
 	} else if format == 2 {
 		// Format 2: simple n×m array of kerning values
-		format2 = new(KernFormat2)
-		format2.RowWidth = getUint16(data[pos+6 : pos+8])
-		format2.LeftOffsetTable = getUint16(data[pos+8 : pos+10])
-		format2.RightOffsetTable = getUint16(data[pos+10 : pos+12])
-		format2.ArrayOffset = getUint16(data[pos+12 : pos+14])
-
-		// Read left class table (offset from subtable start)
-		leftTablePos := subtableStart + int(format2.LeftOffsetTable)
-		format2.LeftClassTable = &KernFormat2ClassTable{
-			FirstGlyph: getUint16(data[leftTablePos : leftTablePos+2]),
-			NGlyphs:    getUint16(data[leftTablePos+2 : leftTablePos+4]),
-		}
-		leftTablePos += 4
-		for i := 0; i < int(format2.LeftClassTable.NGlyphs); i++ {
-			format2.LeftClassTable.Offsets = append(format2.LeftClassTable.Offsets,
-				getUint16(data[leftTablePos:leftTablePos+2]))
-			leftTablePos += 2
-		}
-
-		// Read right class table (offset from subtable start)
-		rightTablePos := subtableStart + int(format2.RightOffsetTable)
-		format2.RightClassTable = &KernFormat2ClassTable{
-			FirstGlyph: getUint16(data[rightTablePos : rightTablePos+2]),
-			NGlyphs:    getUint16(data[rightTablePos+2 : rightTablePos+4]),
-		}
-		rightTablePos += 4
-		for i := 0; i < int(format2.RightClassTable.NGlyphs); i++ {
-			format2.RightClassTable.Offsets = append(format2.RightClassTable.Offsets,
-				getUint16(data[rightTablePos:rightTablePos+2]))
-			rightTablePos += 2
+		if subtable.Format2, err = getKernFormat2(data, subtableStart, pos+6, size); err != nil {
+			return nil, 0, err
 		}
 	}
+	if size < 6 {
+		return nil, 0, errors.New("kern subtable length " + strconv.Itoa(size) + " too small")
+	}
 	return
 }
 
-func getMacKernTable(data []byte, pos int, nTables int) (subHeaders map[string]int, kernPairs []*nPairs, format2 *KernFormat2, format3 *KernFormat3) {
-	subHeaders = make(map[string]int)
+// getMacKernTable reads one Apple (version 1) subtable and returns it
+// together with the number of bytes it occupies.
+func getMacKernTable(data []byte, pos int) (subtable *KernSubtable, size int, err error) {
+	if pos+8 > len(data) {
+		return nil, 0, errKernTruncated
+	}
+	subtable = &KernSubtable{SubHeaders: make(map[string]int)}
+	subHeaders := subtable.SubHeaders
 	subtableStart := pos // Save subtable start position
 	subHeaders["length"] = int(getUint32(data[pos : pos+4]))
 	coverage := int(getUint16(data[pos+4 : pos+6]))
 	subHeaders["coverage"] = coverage
 	tupleIndex := getUint16(data[pos+6 : pos+8])
 	subHeaders["tupleIndex"] = int(tupleIndex)
-	format := coverage & 0x00FF
+	format, kc := decodeKernCoverage(coverage, true)
 	subHeaders["format"] = format
+	subtable.Coverage = kc
+	size = subHeaders["length"]
 
 	if format == 0 {
 		// Format 0: ordered list of kerning pairs
+		if pos+16 > len(data) {
+			return nil, 0, errKernTruncated
+		}
 		subHeaders["nPairs"] = int(getUint16(data[pos+8 : pos+10]))
 		subHeaders["searchRange"] = int(getUint16(data[pos+10 : pos+12]))
 		subHeaders["entrySelector"] = int(getUint16(data[pos+12 : pos+14]))
 		subHeaders["rangeShift"] = int(getUint16(data[pos+14 : pos+16]))
-		pos += 16
-		if nTables == 1 {
-			for i := 0; i < subHeaders["nPairs"]; i++ {
-				kernPairs = append(kernPairs, &nPairs{
-					getUint16(data[pos : pos+2]),
-					getUint16(data[pos+2 : pos+4]),
-					getFWord(data[pos+4 : pos+6]),
-				})
-				pos += 6
-			}
+		if subtable.Pairs, err = getKernPairs(data, pos+16, subHeaders["nPairs"]); err != nil {
+			return nil, 0, err
 		}
 	} else if format == 2 {
 		// Format 2: simple n×m array of kerning values
-		format2 = new(KernFormat2)
-		format2.RowWidth = getUint16(data[pos+8 : pos+10])
-		format2.LeftOffsetTable = getUint16(data[pos+10 : pos+12])
-		format2.RightOffsetTable = getUint16(data[pos+12 : pos+14])
-		format2.ArrayOffset = getUint16(data[pos+14 : pos+16])
-
-		// Read left class table (offset from subtable start)
-		leftTablePos := subtableStart + int(format2.LeftOffsetTable)
-		format2.LeftClassTable = &KernFormat2ClassTable{
-			FirstGlyph: getUint16(data[leftTablePos : leftTablePos+2]),
-			NGlyphs:    getUint16(data[leftTablePos+2 : leftTablePos+4]),
-		}
-		leftTablePos += 4
-		for i := 0; i < int(format2.LeftClassTable.NGlyphs); i++ {
-			format2.LeftClassTable.Offsets = append(format2.LeftClassTable.Offsets,
-				getUint16(data[leftTablePos:leftTablePos+2]))
-			leftTablePos += 2
-		}
-
-		// Read right class table (offset from subtable start)
-		rightTablePos := subtableStart + int(format2.RightOffsetTable)
-		format2.RightClassTable = &KernFormat2ClassTable{
-			FirstGlyph: getUint16(data[rightTablePos : rightTablePos+2]),
-			NGlyphs:    getUint16(data[rightTablePos+2 : rightTablePos+4]),
-		}
-		rightTablePos += 4
-		for i := 0; i < int(format2.RightClassTable.NGlyphs); i++ {
-			format2.RightClassTable.Offsets = append(format2.RightClassTable.Offsets,
-				getUint16(data[rightTablePos:rightTablePos+2]))
-			rightTablePos += 2
+		if subtable.Format2, err = getKernFormat2(data, subtableStart, pos+8, size); err != nil {
+			return nil, 0, err
 		}
 	} else if format == 3 {
 		// Format 3: simple n×m index array
-		format3 = new(KernFormat3)
-		format3.GlyphCount = getUint16(data[pos+8 : pos+10])
-		format3.KernValueCount = data[pos+10]
-		format3.LeftClassCount = data[pos+11]
-		format3.RightClassCount = data[pos+12]
-		format3.Flags = data[pos+13]
-		pos += 14
-
-		// Read kern values
-		for i := 0; i < int(format3.KernValueCount); i++ {
-			format3.KernValues = append(format3.KernValues, getFWord(data[pos:pos+2]))
-			pos += 2
-		}
-
-		// Read left class array
-		for i := 0; i < int(format3.GlyphCount); i++ {
-			format3.LeftClass = append(format3.LeftClass, data[pos])
-			pos++
-		}
-
-		// Read right class array
-		for i := 0; i < int(format3.GlyphCount); i++ {
-			format3.RightClass = append(format3.RightClass, data[pos])
-			pos++
-		}
-
-		// Read kern index array
-		indexCount := int(format3.LeftClassCount) * int(format3.RightClassCount)
-		for i := 0; i < indexCount; i++ {
-			format3.KernIndex = append(format3.KernIndex, data[pos])
-			pos++
+		if subtable.Format3, err = getKernFormat3(data, pos+8); err != nil {
+			return nil, 0, err
 		}
 	}
 	// Note: Format 1 (state table) is not supported yet due to complexity
@@ -2824,21 +4465,24 @@ func getMacKernTable(data []byte, pos int, nTables int) (subHeaders map[string]i
 }
 
 type Kern struct {
-	Version      int            `json:"version"`
-	NTables      int            `json:"nTables"`
-	SubHeaders   map[string]int `json:"subHeaders"`
-	Pairs        []*nPairs      `json:"pairs,omitempty"`
-	Format2      *KernFormat2   `json:"format2,omitempty"`
-	Format3      *KernFormat3   `json:"format3,omitempty"`
-	IsMacNewKern bool           `json:"isNewKern,omitempty"`
+	Version      int             `json:"version"`
+	NTables      int             `json:"nTables"`
+	Subtables    []*KernSubtable `json:"subtables"`
+	IsMacNewKern bool            `json:"isNewKern,omitempty"`
 }
 
+var errKernTruncated = errors.New("kern table truncated")
+
 func GetKern(data []byte, pos int) (kern *Kern, err error) {
 	kern = new(Kern)
+	if pos+4 > len(data) {
+		err = errKernTruncated
+		return
+	}
 
 	// Check if it's Windows format (version 0) or Mac format (version 1)
 	// Windows: version (uint16) + nTables (uint16)
-	// Mac: version (uint32) + nTables (uint32) or version (uint16) + nTables (uint16) for old format
+	// Mac: version (Fixed 1.0) + nTables (uint32), or version (uint16) + nTables (uint16) for old format
 	version := int(getUint16(data[pos : pos+2]))
 
 	if version == 0 {
@@ -2846,173 +4490,401 @@ func GetKern(data []byte, pos int) (kern *Kern, err error) {
 		kern.Version = 0
 		kern.NTables = int(getUint16(data[pos+2 : pos+4]))
 		pos += 4
-		kern.SubHeaders, kern.Pairs, kern.Format2 = getWindowsKernTable(data, pos)
+		for i := 0; i < kern.NTables && pos+6 <= len(data); i++ {
+			subtable, size, subtableErr := getWindowsKernTable(data, pos)
+			if subtableErr != nil {
+				err = subtableErr
+				return
+			}
+			kern.Subtables = append(kern.Subtables, subtable)
+			pos += size
+		}
 		return
 	} else if version == 1 {
-		// Mac kern table format (old style: version is uint16)
 		kern.Version = 1
-		nTables := int(getUint16(data[pos+2 : pos+4]))
-		kern.NTables = nTables
-		kern.IsMacNewKern = false
-		pos += 4
-		kern.SubHeaders, kern.Pairs, kern.Format2, kern.Format3 = getMacKernTable(data, pos, nTables)
-		return
-	} else if version == 0x0001 {
-		// Check if it's actually new Mac kern format (version 1.0 as Fixed = 0x00010000)
-		// In new Mac format, first 4 bytes are version as Fixed (1.0 = 0x00010000)
-		fullVersion := getUint32(data[pos : pos+4])
-		if fullVersion == 0x00010000 {
-			kern.Version = 1
+		if pos+8 <= len(data) && getUint32(data[pos:pos+4]) == 0x00010000 {
+			// New Mac kern format: version is Fixed 1.0 and nTables is uint32
 			kern.IsMacNewKern = true
 			kern.NTables = int(getUint32(data[pos+4 : pos+8]))
 			pos += 8
-			kern.SubHeaders, kern.Pairs, kern.Format2, kern.Format3 = getMacKernTable(data, pos, kern.NTables)
-			return
+		} else {
+			// Old Mac kern format: version and nTables are uint16
+			kern.NTables = int(getUint16(data[pos+2 : pos+4]))
+			pos += 4
+		}
+		for i := 0; i < kern.NTables && pos+8 <= len(data); i++ {
+			subtable, size, subtableErr := getMacKernTable(data, pos)
+			if subtableErr != nil {
+				err = subtableErr
+				return
+			}
+			kern.Subtables = append(kern.Subtables, subtable)
+			if size <= 0 {
+				break
+			}
+			pos += size
 		}
+		return
 	}
 
 	err = errors.New("Unsupported kern table version:" + strconv.Itoa(version))
 	return
 }
 
+// WriteKern writes the kern table. Subtables of a format that cannot be
+// written are skipped and left out of NTables.
 func WriteKern(kern *Kern) []byte {
 	data := []byte{}
+	subtables := []byte{}
+	kern.NTables = 0
+	for _, subtable := range kern.Subtables {
+		var subtableData []byte
+		if kern.Version == 0 {
+			subtableData = writeWindowsKernSubtable(subtable)
+		} else {
+			subtableData = writeMacKernSubtable(subtable)
+		}
+		if len(subtableData) > 0 {
+			subtables = append(subtables, subtableData...)
+			kern.NTables++
+		}
+	}
 
 	if kern.Version == 0 {
 		// Windows kern table
 		data = append(data, writeUint16(uint16(kern.Version))...)
 		data = append(data, writeUint16(uint16(kern.NTables))...)
-
-		// Write subtable
-		data = append(data, writeWindowsKernSubtable(kern)...)
+		data = append(data, subtables...)
 	} else if kern.Version == 1 {
 		// Mac kern table
-		data = append(data, writeUint32(uint32(kern.Version))...)
-
 		if kern.IsMacNewKern {
+			data = append(data, writeUint32(0x00010000)...)
 			data = append(data, writeUint32(uint32(kern.NTables))...)
 		} else {
+			data = append(data, writeUint16(1)...)
 			data = append(data, writeUint16(uint16(kern.NTables))...)
-			data = append(data, writeUint16(0)...) // padding
 		}
-
-		// Write subtable
-		data = append(data, writeMacKernSubtable(kern)...)
+		data = append(data, subtables...)
 	}
 
 	return data
 }
 
-func writeWindowsKernSubtable(kern *Kern) []byte {
-	data := []byte{}
-
-	if kern.SubHeaders == nil {
-		return data
+// writeKernPairs emits the format 0 body (nPairs, binary search header and
+// the pairs sorted by left then right glyph).
+func writeKernPairs(pairs []*nPairs) []byte {
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].Left != pairs[j].Left {
+			return pairs[i].Left < pairs[j].Left
+		}
+		return pairs[i].Right < pairs[j].Right
+	})
+
+	nPairs := len(pairs)
+	searchRange, entrySelector, rangeShift := 0, 0, 0
+	if nPairs > 0 {
+		searchRange = (1 << uint(log2(nPairs))) * 6
+		entrySelector = log2(searchRange / 6)
+		rangeShift = nPairs*6 - searchRange
 	}
 
-	format := kern.SubHeaders["format"]
-
-	if format == 0 && kern.Pairs != nil {
-		// Format 0: ordered list of kerning pairs
-		nPairs := len(kern.Pairs)
-		searchRange := (1 << uint(log2(nPairs))) * 6
-		entrySelector := log2(searchRange / 6)
-		rangeShift := nPairs*6 - searchRange
-
-		// Calculate length: header (14 bytes) + pairs (6 bytes each)
-		length := 14 + nPairs*6
-
-		data = append(data, writeUint16(uint16(kern.SubHeaders["version"]))...)
-		data = append(data, writeUint16(uint16(length))...)
-		data = append(data, writeUint16(uint16(kern.SubHeaders["coverage"]))...)
-		data = append(data, writeUint16(uint16(nPairs))...)
-		data = append(data, writeUint16(uint16(searchRange))...)
-		data = append(data, writeUint16(uint16(entrySelector))...)
-		data = append(data, writeUint16(uint16(rangeShift))...)
-
-		for _, pair := range kern.Pairs {
-			data = append(data, writeUint16(pair.Left)...)
-			data = append(data, writeUint16(pair.Right)...)
-			data = append(data, writeFWord(pair.Value)...)
-		}
-	} else if format == 2 && kern.Format2 != nil {
-		// Format 2: not commonly used, basic structure
-		data = append(data, writeUint16(0)...) // version
-		data = append(data, writeUint16(0)...) // length (would need calculation)
-		data = append(data, writeUint16(uint16(kern.SubHeaders["coverage"]))...)
-		data = append(data, writeUint16(kern.Format2.RowWidth)...)
-		data = append(data, writeUint16(kern.Format2.LeftOffsetTable)...)
-		data = append(data, writeUint16(kern.Format2.RightOffsetTable)...)
-		data = append(data, writeUint16(kern.Format2.ArrayOffset)...)
+	data := []byte{}
+	data = append(data, writeUint16(uint16(nPairs))...)
+	data = append(data, writeUint16(uint16(searchRange))...)
+	data = append(data, writeUint16(uint16(entrySelector))...)
+	data = append(data, writeUint16(uint16(rangeShift))...)
+	for _, pair := range pairs {
+		data = append(data, writeUint16(pair.Left)...)
+		data = append(data, writeUint16(pair.Right)...)
+		data = append(data, writeFWord(pair.Value)...)
 	}
-
 	return data
 }
 
-func writeMacKernSubtable(kern *Kern) []byte {
-	data := []byte{}
-
-	if kern.SubHeaders == nil {
-		return data
+// writeKernFormat2 emits the format 2 body for a subtable whose common
+// header is headerSize bytes long, relaying out the class tables and value
+// array directly after the format 2 header and shifting the left class
+// offsets to match.
+func writeKernFormat2(f2 *KernFormat2, headerSize int) []byte {
+	left := f2.LeftClassTable
+	if left == nil {
+		left = &KernFormat2ClassTable{}
+	}
+	right := f2.RightClassTable
+	if right == nil {
+		right = &KernFormat2ClassTable{}
+	}
+
+	leftOffset := headerSize + 8
+	rightOffset := leftOffset + 4 + len(left.Offsets)*2
+	arrayOffset := rightOffset + 4 + len(right.Offsets)*2
+	shift := arrayOffset - int(f2.ArrayOffset)
+
+	f2.LeftOffsetTable = uint16(leftOffset)
+	f2.RightOffsetTable = uint16(rightOffset)
+	f2.ArrayOffset = uint16(arrayOffset)
+	left.NGlyphs = uint16(len(left.Offsets))
+	right.NGlyphs = uint16(len(right.Offsets))
+
+	data := []byte{}
+	data = append(data, writeUint16(f2.RowWidth)...)
+	data = append(data, writeUint16(f2.LeftOffsetTable)...)
+	data = append(data, writeUint16(f2.RightOffsetTable)...)
+	data = append(data, writeUint16(f2.ArrayOffset)...)
+
+	data = append(data, writeUint16(left.FirstGlyph)...)
+	data = append(data, writeUint16(left.NGlyphs)...)
+	for i, off := range left.Offsets {
+		// Row offsets are absolute within the subtable, so they move with the
+		// array; zero marks a glyph without a row and stays as is.
+		if off != 0 {
+			left.Offsets[i] = uint16(int(off) + shift)
+		}
+		data = append(data, writeUint16(left.Offsets[i])...)
+	}
+	data = append(data, writeUint16(right.FirstGlyph)...)
+	data = append(data, writeUint16(right.NGlyphs)...)
+	for _, off := range right.Offsets {
+		data = append(data, writeUint16(off)...)
+	}
+	for _, val := range f2.KernValues {
+		data = append(data, writeFWord(val)...)
+	}
+	return data
+}
+
+func writeKernFormat3(f3 *KernFormat3) []byte {
+	data := []byte{}
+	data = append(data, writeUint16(f3.GlyphCount)...)
+	data = append(data, f3.KernValueCount)
+	data = append(data, f3.LeftClassCount)
+	data = append(data, f3.RightClassCount)
+	data = append(data, f3.Flags)
+
+	// Write kern values
+	for _, val := range f3.KernValues {
+		data = append(data, writeFWord(val)...)
+	}
+
+	// Write left class array
+	data = append(data, f3.LeftClass...)
+
+	// Write right class array
+	data = append(data, f3.RightClass...)
+
+	// Write kern index array
+	data = append(data, f3.KernIndex...)
+	return data
+}
+
+func writeWindowsKernSubtable(subtable *KernSubtable) []byte {
+	data := []byte{}
+
+	if subtable == nil || subtable.SubHeaders == nil {
+		return data
+	}
+
+	format := subtable.SubHeaders["format"]
+
+	var body []byte
+	if format == 0 {
+		// Format 0: ordered list of kerning pairs
+		body = writeKernPairs(subtable.Pairs)
+	} else if format == 2 && subtable.Format2 != nil {
+		// Format 2: class-based n×m array
+		body = writeKernFormat2(subtable.Format2, 6)
+	} else {
+		log.Printf("[WARN] kern subtable format %d not supported for version 0 write, skipped", format)
+		return data
+	}
+
+	// header (6 bytes) + body; the length field wraps for huge pair lists
+	length := 6 + len(body)
+	data = append(data, writeUint16(uint16(subtable.SubHeaders["version"]))...)
+	data = append(data, writeUint16(uint16(length))...)
+	data = append(data, writeUint16(uint16(encodeKernCoverage(format, subtable.Coverage, false)))...)
+	data = append(data, body...)
+	return data
+}
+
+func writeMacKernSubtable(subtable *KernSubtable) []byte {
+	data := []byte{}
+
+	if subtable == nil || subtable.SubHeaders == nil {
+		return data
 	}
 
-	format := kern.SubHeaders["format"]
+	format := subtable.SubHeaders["format"]
 
-	if format == 0 && kern.Pairs != nil {
+	var body []byte
+	if format == 0 {
 		// Format 0: ordered list of kerning pairs
-		nPairs := len(kern.Pairs)
-		searchRange := (1 << uint(log2(nPairs))) * 6
-		entrySelector := log2(searchRange / 6)
-		rangeShift := nPairs*6 - searchRange
-
-		// Calculate length: header (16 bytes) + pairs (6 bytes each)
-		length := 16 + nPairs*6
-
-		data = append(data, writeUint32(uint32(length))...)
-		data = append(data, writeUint16(uint16(kern.SubHeaders["coverage"]))...)
-		data = append(data, writeUint16(uint16(kern.SubHeaders["tupleIndex"]))...)
-		data = append(data, writeUint16(uint16(nPairs))...)
-		data = append(data, writeUint16(uint16(searchRange))...)
-		data = append(data, writeUint16(uint16(entrySelector))...)
-		data = append(data, writeUint16(uint16(rangeShift))...)
-
-		for _, pair := range kern.Pairs {
-			data = append(data, writeUint16(pair.Left)...)
-			data = append(data, writeUint16(pair.Right)...)
-			data = append(data, writeFWord(pair.Value)...)
-		}
-	} else if format == 3 && kern.Format3 != nil {
+		body = writeKernPairs(subtable.Pairs)
+	} else if format == 2 && subtable.Format2 != nil {
+		// Format 2: class-based n×m array
+		body = writeKernFormat2(subtable.Format2, 8)
+	} else if format == 3 && subtable.Format3 != nil {
 		// Format 3: simple n×m index array
-		f3 := kern.Format3
+		body = writeKernFormat3(subtable.Format3)
+	} else {
+		log.Printf("[WARN] kern subtable format %d not supported for version 1 write, skipped", format)
+		return data
+	}
+
+	// header (8 bytes) + body
+	length := 8 + len(body)
+	data = append(data, writeUint32(uint32(length))...)
+	data = append(data, writeUint16(uint16(encodeKernCoverage(format, subtable.Coverage, true)))...)
+	data = append(data, writeUint16(uint16(subtable.SubHeaders["tupleIndex"]))...)
+	data = append(data, body...)
+	return data
+}
 
-		// Calculate length
-		length := 14 + len(f3.KernValues)*2 + len(f3.LeftClass) + len(f3.RightClass) + len(f3.KernIndex)
+// lookup returns the kerning value this subtable defines for the pair and
+// whether the subtable has an entry for it at all.
+func (subtable *KernSubtable) lookup(left, right uint16) (value int16, found bool) {
+	switch subtable.SubHeaders["format"] {
+	case 0:
+		pairs := subtable.Pairs
+		key := uint32(left)<<16 | uint32(right)
+		i := sort.Search(len(pairs), func(i int) bool {
+			return uint32(pairs[i].Left)<<16|uint32(pairs[i].Right) >= key
+		})
+		if i < len(pairs) && pairs[i].Left == left && pairs[i].Right == right {
+			return pairs[i].Value, true
+		}
+	case 2:
+		f2 := subtable.Format2
+		if f2 == nil || f2.LeftClassTable == nil || f2.RightClassTable == nil {
+			return
+		}
+		classOffset := func(classTable *KernFormat2ClassTable, glyph uint16) (int, bool) {
+			idx := int(glyph) - int(classTable.FirstGlyph)
+			if idx < 0 || idx >= len(classTable.Offsets) {
+				return 0, false
+			}
+			return int(classTable.Offsets[idx]), true
+		}
+		leftOffset, okLeft := classOffset(f2.LeftClassTable, left)
+		rightOffset, okRight := classOffset(f2.RightClassTable, right)
+		if !okLeft || !okRight || leftOffset < int(f2.ArrayOffset) {
+			return
+		}
+		idx := (leftOffset + rightOffset - int(f2.ArrayOffset)) / 2
+		if idx >= 0 && idx < len(f2.KernValues) {
+			return f2.KernValues[idx], true
+		}
+	case 3:
+		f3 := subtable.Format3
+		if f3 == nil || int(left) >= len(f3.LeftClass) || int(right) >= len(f3.RightClass) {
+			return
+		}
+		idx := int(f3.LeftClass[left])*int(f3.RightClassCount) + int(f3.RightClass[right])
+		if idx < len(f3.KernIndex) && int(f3.KernIndex[idx]) < len(f3.KernValues) {
+			return f3.KernValues[f3.KernIndex[idx]], true
+		}
+	}
+	return
+}
 
-		data = append(data, writeUint32(uint32(length))...)
-		data = append(data, writeUint16(uint16(kern.SubHeaders["coverage"]))...)
-		data = append(data, writeUint16(uint16(kern.SubHeaders["tupleIndex"]))...)
-		data = append(data, writeUint16(f3.GlyphCount)...)
-		data = append(data, f3.KernValueCount)
-		data = append(data, f3.LeftClassCount)
-		data = append(data, f3.RightClassCount)
-		data = append(data, f3.Flags)
+// Kerning resolves the horizontal kerning between two glyphs across every
+// subtable, adding values in table order and letting override subtables
+// replace the running total. Cross-stream, minimum and variation subtables
+// are skipped.
+func (kern *Kern) Kerning(left, right uint16) int16 {
+	var value int16
+	for _, subtable := range kern.Subtables {
+		kc := subtable.Coverage
+		if !kc.Horizontal || kc.CrossStream || kc.Minimum || kc.Variation {
+			continue
+		}
+		v, found := subtable.lookup(left, right)
+		if !found {
+			continue
+		}
+		if kc.Override {
+			value = v
+		} else {
+			value += v
+		}
+	}
+	return value
+}
 
-		// Write kern values
-		for _, val := range f3.KernValues {
-			data = append(data, writeFWord(val)...)
+// remapKern rewrites the glyph IDs of every subtable through oldToNew,
+// dropping pairs and class entries for glyphs that are not retained.
+func remapKern(kern *Kern, oldToNew map[int]int, numGlyphs int) {
+	newToOld := make([]int, numGlyphs)
+	for oldIdx, newIdx := range oldToNew {
+		if newIdx < numGlyphs {
+			newToOld[newIdx] = oldIdx
 		}
+	}
 
-		// Write left class array
-		data = append(data, f3.LeftClass...)
+	for _, subtable := range kern.Subtables {
+		var pairs []*nPairs
+		for _, pair := range subtable.Pairs {
+			newLeft, okLeft := oldToNew[int(pair.Left)]
+			newRight, okRight := oldToNew[int(pair.Right)]
+			if okLeft && okRight {
+				pairs = append(pairs, &nPairs{uint16(newLeft), uint16(newRight), pair.Value})
+			}
+		}
+		sort.SliceStable(pairs, func(i, j int) bool {
+			if pairs[i].Left != pairs[j].Left {
+				return pairs[i].Left < pairs[j].Left
+			}
+			return pairs[i].Right < pairs[j].Right
+		})
+		subtable.Pairs = pairs
+		if subtable.SubHeaders != nil && subtable.SubHeaders["format"] == 0 {
+			subtable.SubHeaders["nPairs"] = len(pairs)
+		}
 
-		// Write right class array
-		data = append(data, f3.RightClass...)
+		if f2 := subtable.Format2; f2 != nil {
+			remapClassTable := func(classTable *KernFormat2ClassTable) {
+				if classTable == nil {
+					return
+				}
+				offsets := make([]uint16, numGlyphs)
+				first, last := -1, -1
+				for newIdx, oldIdx := range newToOld {
+					idx := oldIdx - int(classTable.FirstGlyph)
+					if idx < 0 || idx >= len(classTable.Offsets) {
+						continue
+					}
+					offsets[newIdx] = classTable.Offsets[idx]
+					if first < 0 {
+						first = newIdx
+					}
+					last = newIdx
+				}
+				if first < 0 {
+					classTable.FirstGlyph, classTable.Offsets = 0, nil
+				} else {
+					classTable.FirstGlyph, classTable.Offsets = uint16(first), offsets[first:last+1]
+				}
+				classTable.NGlyphs = uint16(len(classTable.Offsets))
+			}
+			remapClassTable(f2.LeftClassTable)
+			remapClassTable(f2.RightClassTable)
+		}
 
-		// Write kern index array
-		data = append(data, f3.KernIndex...)
+		if f3 := subtable.Format3; f3 != nil {
+			leftClass := make([]uint8, numGlyphs)
+			rightClass := make([]uint8, numGlyphs)
+			for newIdx, oldIdx := range newToOld {
+				if oldIdx < len(f3.LeftClass) {
+					leftClass[newIdx] = f3.LeftClass[oldIdx]
+				}
+				if oldIdx < len(f3.RightClass) {
+					rightClass[newIdx] = f3.RightClass[oldIdx]
+				}
+			}
+			f3.LeftClass, f3.RightClass = leftClass, rightClass
+			f3.GlyphCount = uint16(numGlyphs)
+		}
 	}
-
-	return data
 }
 
 func log2(n int) int {
@@ -3035,6 +4907,24 @@ type FastSetKVOpt struct {
 	toInt   bool
 }
 
+// OS/2 fsSelection bits describing the style
+const (
+	FS_SELECTION_ITALIC  uint16 = 1 << 0
+	FS_SELECTION_BOLD    uint16 = 1 << 5
+	FS_SELECTION_REGULAR uint16 = 1 << 6
+	FS_SELECTION_OBLIQUE uint16 = 1 << 9
+)
+
+// head macStyle bits
+const (
+	MAC_STYLE_BOLD   uint16 = 1 << 0
+	MAC_STYLE_ITALIC uint16 = 1 << 1
+)
+
+// HEAD_INSTRUCTIONS_ALTER_ADVANCE is the head flags bit saying that
+// instructions may change advance widths
+const HEAD_INSTRUCTIONS_ALTER_ADVANCE uint16 = 1 << 4
+
 type OS2 struct {
 	Version             uint16   `json:"version"`
 	XAvgCharWidth       int16    `json:"xAvgCharWidth"`
@@ -3120,8 +5010,8 @@ func GetOS2(data []byte, pos int) (os2 *OS2) {
 
 	version := int(os2.Version)
 	if version >= 1 {
-		os2.UlCodePageRange = append(os2.UlCodePageRange, getUint32(data[pos:pos+4]))
-		pos += 4
+		os2.UlCodePageRange = append(os2.UlCodePageRange, getUint32(data[pos:pos+4]), getUint32(data[pos+4:pos+8]))
+		pos += 8
 	}
 
 	if version >= 2 {
@@ -3502,6 +5392,94 @@ func WritePost(post *Post) []byte {
 	return data
 }
 
+// GlyphName returns the name the post table stores for glyph gid. ok is
+// false for format 3 tables and for glyphs the table does not cover.
+func (post *Post) GlyphName(gid int) (name string, ok bool) {
+	if post == nil || gid < 0 {
+		return
+	}
+	switch post.Format {
+	case 1:
+		if gid < len(standardNames) {
+			return standardNames[gid], true
+		}
+	case 2:
+		if gid < len(post.Names) {
+			return post.Names[gid], true
+		}
+	case 2.5:
+		if gid < len(post.Offset) {
+			if idx := gid + int(post.Offset[gid]); idx >= 0 && idx < len(standardNames) {
+				return standardNames[idx], true
+			}
+		}
+	}
+	return
+}
+
+// glyphNames names each of the numGlyphs glyphs: the post table name where
+// there is one, else the production name of the lowest code point the cmap
+// maps to the glyph, else glyphN. Derived names that are already taken get
+// a numeric suffix.
+func glyphNames(post *Post, cmap *Cmap, numGlyphs int) []string {
+	names := make([]string, numGlyphs)
+	used := make(map[string]bool)
+	for gid := range names {
+		if name, ok := post.GlyphName(gid); ok {
+			names[gid] = name
+			used[name] = true
+		}
+	}
+
+	lowest := make(map[int]int)
+	if cmap != nil {
+		for c, g := range cmap.WindowsCode {
+			if current, ok := lowest[g]; !ok || c < current {
+				lowest[g] = c
+			}
+		}
+	}
+	for gid := range names {
+		if names[gid] != "" {
+			continue
+		}
+		name := "glyph" + strconv.Itoa(gid)
+		if gid == 0 {
+			name = ".notdef"
+		} else if c, ok := lowest[gid]; ok {
+			name = UnicodeToGlyphName(rune(c))
+		}
+		for base, i := name, 1; used[name]; i++ {
+			name = base + "." + strconv.Itoa(i)
+		}
+		names[gid] = name
+		used[name] = true
+	}
+	return names
+}
+
+// remapPost reorders the glyph names for the subset glyph order, where
+// oldIndices[newIdx] is the old glyph ID. Tables with names become format 2,
+// since the standard Macintosh order no longer applies.
+func remapPost(post *Post, oldIndices []int) {
+	if post.Format != 1 && post.Format != 2 && post.Format != 2.5 {
+		return
+	}
+	names := make([]string, len(oldIndices))
+	for newIdx, oldIdx := range oldIndices {
+		name, ok := post.GlyphName(oldIdx)
+		if !ok {
+			name = "glyph" + strconv.Itoa(newIdx)
+		}
+		names[newIdx] = name
+	}
+	post.Format = 2
+	post.Names = names
+	post.NumberOfGlyphs = uint16(len(names))
+	post.GlyphNameIndex = nil
+	post.Offset = nil
+}
+
 type SfntVariationAxis struct {
 	AxisTag      uint32 `json:"axisTag"`
 	MinValue     uint32 `json:"minValue"`
@@ -3963,3 +5941,2982 @@ func WriteMeta(meta *Meta) []byte {
 
 	return data
 }
+
+// getCoverage reads an OpenType Coverage table and returns the covered glyph
+// IDs in coverage index order.
+func getCoverage(data []byte, pos int) (glyphs []uint16, err error) {
+	if pos < 0 || pos+4 > len(data) {
+		err = errors.New("coverage table truncated")
+		return
+	}
+	format := getUint16(data[pos : pos+2])
+	count := int(getUint16(data[pos+2 : pos+4]))
+	pos += 4
+
+	switch format {
+	case 1:
+		if pos+count*2 > len(data) {
+			err = errors.New("coverage format 1 glyph array truncated")
+			return
+		}
+		glyphs = make([]uint16, 0, count)
+		for i := 0; i < count; i++ {
+			glyphs = append(glyphs, getUint16(data[pos:pos+2]))
+			pos += 2
+		}
+	case 2:
+		if pos+count*6 > len(data) {
+			err = errors.New("coverage format 2 range records truncated")
+			return
+		}
+		for i := 0; i < count; i++ {
+			start := int(getUint16(data[pos : pos+2]))
+			end := int(getUint16(data[pos+2 : pos+4]))
+			for g := start; g <= end; g++ {
+				glyphs = append(glyphs, uint16(g))
+			}
+			pos += 6
+		}
+	default:
+		err = errors.New("unsupported coverage format " + strconv.Itoa(int(format)))
+	}
+	return
+}
+
+// writeCoverage encodes glyphs as a Coverage table, picking whichever of
+// format 1 (glyph list) or format 2 (ranges) is smaller.
+func writeCoverage(glyphs []uint16) []byte {
+	sorted := make([]int, 0, len(glyphs))
+	seen := make(map[uint16]bool, len(glyphs))
+	for _, g := range glyphs {
+		if !seen[g] {
+			seen[g] = true
+			sorted = append(sorted, int(g))
+		}
+	}
+	sort.Ints(sorted)
+
+	type rangeRecord struct {
+		start, end, index int
+	}
+	var ranges []rangeRecord
+	for i, g := range sorted {
+		if len(ranges) > 0 && ranges[len(ranges)-1].end+1 == g {
+			ranges[len(ranges)-1].end = g
+			continue
+		}
+		ranges = append(ranges, rangeRecord{g, g, i})
+	}
+
+	data := []byte{}
+	if len(ranges)*6 < len(sorted)*2 {
+		data = append(data, writeUint16(2)...)
+		data = append(data, writeUint16(uint16(len(ranges)))...)
+		for _, r := range ranges {
+			data = append(data, writeUint16(uint16(r.start))...)
+			data = append(data, writeUint16(uint16(r.end))...)
+			data = append(data, writeUint16(uint16(r.index))...)
+		}
+		return data
+	}
+
+	data = append(data, writeUint16(1)...)
+	data = append(data, writeUint16(uint16(len(sorted)))...)
+	for _, g := range sorted {
+		data = append(data, writeUint16(uint16(g))...)
+	}
+	return data
+}
+
+// getClassDef reads an OpenType ClassDef table into a glyph -> class map.
+// Glyphs not listed belong to class 0 and are omitted from the map.
+func getClassDef(data []byte, pos int) (classes map[uint16]uint16, err error) {
+	if pos < 0 || pos+4 > len(data) {
+		err = errors.New("class definition table truncated")
+		return
+	}
+	classes = make(map[uint16]uint16)
+	format := getUint16(data[pos : pos+2])
+
+	switch format {
+	case 1:
+		if pos+6 > len(data) {
+			err = errors.New("class definition format 1 header truncated")
+			return
+		}
+		startGlyph := int(getUint16(data[pos+2 : pos+4]))
+		glyphCount := int(getUint16(data[pos+4 : pos+6]))
+		pos += 6
+		if pos+glyphCount*2 > len(data) {
+			err = errors.New("class definition format 1 class array truncated")
+			return
+		}
+		for i := 0; i < glyphCount; i++ {
+			class := getUint16(data[pos : pos+2])
+			if class != 0 {
+				classes[uint16(startGlyph+i)] = class
+			}
+			pos += 2
+		}
+	case 2:
+		rangeCount := int(getUint16(data[pos+2 : pos+4]))
+		pos += 4
+		if pos+rangeCount*6 > len(data) {
+			err = errors.New("class definition format 2 range records truncated")
+			return
+		}
+		for i := 0; i < rangeCount; i++ {
+			start := int(getUint16(data[pos : pos+2]))
+			end := int(getUint16(data[pos+2 : pos+4]))
+			class := getUint16(data[pos+4 : pos+6])
+			if class != 0 {
+				for g := start; g <= end; g++ {
+					classes[uint16(g)] = class
+				}
+			}
+			pos += 6
+		}
+	default:
+		err = errors.New("unsupported class definition format " + strconv.Itoa(int(format)))
+	}
+	return
+}
+
+// writeClassDef encodes a glyph -> class map as a ClassDef table, picking
+// whichever of format 1 (class array) or format 2 (ranges) is smaller.
+func writeClassDef(classes map[uint16]uint16) []byte {
+	glyphs := make([]int, 0, len(classes))
+	for g, class := range classes {
+		if class != 0 {
+			glyphs = append(glyphs, int(g))
+		}
+	}
+	sort.Ints(glyphs)
+
+	type classRange struct {
+		start, end int
+		class      uint16
+	}
+	var ranges []classRange
+	for _, g := range glyphs {
+		class := classes[uint16(g)]
+		if len(ranges) > 0 {
+			last := &ranges[len(ranges)-1]
+			if last.end+1 == g && last.class == class {
+				last.end = g
+				continue
+			}
+		}
+		ranges = append(ranges, classRange{g, g, class})
+	}
+
+	data := []byte{}
+	format1Size := 6
+	if len(glyphs) > 0 {
+		format1Size += (glyphs[len(glyphs)-1] - glyphs[0] + 1) * 2
+	}
+	if len(glyphs) == 0 || 4+len(ranges)*6 < format1Size {
+		data = append(data, writeUint16(2)...)
+		data = append(data, writeUint16(uint16(len(ranges)))...)
+		for _, r := range ranges {
+			data = append(data, writeUint16(uint16(r.start))...)
+			data = append(data, writeUint16(uint16(r.end))...)
+			data = append(data, writeUint16(r.class)...)
+		}
+		return data
+	}
+
+	startGlyph := glyphs[0]
+	glyphCount := glyphs[len(glyphs)-1] - startGlyph + 1
+	data = append(data, writeUint16(1)...)
+	data = append(data, writeUint16(uint16(startGlyph))...)
+	data = append(data, writeUint16(uint16(glyphCount))...)
+	for g := startGlyph; g < startGlyph+glyphCount; g++ {
+		data = append(data, writeUint16(classes[uint16(g)])...)
+	}
+	return data
+}
+
+// DeviceTable holds either a hinting Device table (DeltaFormat 1-3) or a
+// VariationIndex table (DeltaFormat 0x8000). For a VariationIndex table,
+// StartSize and EndSize carry the outer and inner delta-set indices.
+type DeviceTable struct {
+	StartSize   uint16 `json:"startSize"`
+	EndSize     uint16 `json:"endSize"`
+	DeltaFormat uint16 `json:"deltaFormat"`
+	DeltaValues []int8 `json:"deltaValues,omitempty"`
+}
+
+const DEVICE_VARIATION_INDEX uint16 = 0x8000
+
+func getDeviceTable(data []byte, pos int) (device *DeviceTable, err error) {
+	if pos < 0 || pos+6 > len(data) {
+		err = errors.New("device table truncated")
+		return
+	}
+	device = &DeviceTable{
+		StartSize:   getUint16(data[pos : pos+2]),
+		EndSize:     getUint16(data[pos+2 : pos+4]),
+		DeltaFormat: getUint16(data[pos+4 : pos+6]),
+	}
+	pos += 6
+
+	bits := 0
+	switch device.DeltaFormat {
+	case 1:
+		bits = 2
+	case 2:
+		bits = 4
+	case 3:
+		bits = 8
+	default:
+		// VariationIndex or reserved format: no packed deltas follow.
+		return
+	}
+
+	count := int(device.EndSize) - int(device.StartSize) + 1
+	if count <= 0 {
+		return
+	}
+	perWord := 16 / bits
+	words := (count + perWord - 1) / perWord
+	if pos+words*2 > len(data) {
+		err = errors.New("device table delta values truncated")
+		return
+	}
+	for i := 0; i < count; i++ {
+		word := getUint16(data[pos+(i/perWord)*2 : pos+(i/perWord)*2+2])
+		shift := 16 - bits*(i%perWord+1)
+		raw := int((word >> uint(shift)) & (1<<uint(bits) - 1))
+		if raw >= 1<<uint(bits-1) {
+			raw -= 1 << uint(bits)
+		}
+		device.DeltaValues = append(device.DeltaValues, int8(raw))
+	}
+	return
+}
+
+func writeDeviceTable(device *DeviceTable) []byte {
+	data := []byte{}
+	data = append(data, writeUint16(device.StartSize)...)
+	data = append(data, writeUint16(device.EndSize)...)
+	data = append(data, writeUint16(device.DeltaFormat)...)
+
+	bits := 0
+	switch device.DeltaFormat {
+	case 1:
+		bits = 2
+	case 2:
+		bits = 4
+	case 3:
+		bits = 8
+	default:
+		return data
+	}
+
+	perWord := 16 / bits
+	var word uint16
+	for i, v := range device.DeltaValues {
+		shift := 16 - bits*(i%perWord+1)
+		word |= (uint16(v) & (1<<uint(bits) - 1)) << uint(shift)
+		if i%perWord == perWord-1 || i == len(device.DeltaValues)-1 {
+			data = append(data, writeUint16(word)...)
+			word = 0
+		}
+	}
+	return data
+}
+
+type RegionAxisCoordinates struct {
+	StartCoord float32 `json:"startCoord"`
+	PeakCoord  float32 `json:"peakCoord"`
+	EndCoord   float32 `json:"endCoord"`
+}
+
+type ItemVariationData struct {
+	RegionIndexes []uint16  `json:"regionIndexes"`
+	DeltaSets     [][]int32 `json:"deltaSets"`
+}
+
+// ItemVariationStore is the shared OpenType variation data container used by
+// GDEF, GPOS, HVAR and friends. Regions are indexed [region][axis].
+type ItemVariationStore struct {
+	Format            uint16                     `json:"format"`
+	AxisCount         uint16                     `json:"axisCount"`
+	Regions           [][]*RegionAxisCoordinates `json:"regions"`
+	ItemVariationData []*ItemVariationData       `json:"itemVariationData"`
+}
+
+func getItemVariationStore(data []byte, pos int) (store *ItemVariationStore, err error) {
+	start := pos
+	if pos < 0 || pos+8 > len(data) {
+		err = errors.New("item variation store truncated")
+		return
+	}
+	store = new(ItemVariationStore)
+	store.Format = getUint16(data[pos : pos+2])
+	regionListOffset := int(getUint32(data[pos+2 : pos+6]))
+	dataCount := int(getUint16(data[pos+6 : pos+8]))
+	pos += 8
+	if pos+dataCount*4 > len(data) {
+		err = errors.New("item variation data offsets truncated")
+		return
+	}
+
+	if regionListOffset != 0 {
+		rPos := start + regionListOffset
+		if rPos+4 > len(data) {
+			err = errors.New("variation region list truncated")
+			return
+		}
+		store.AxisCount = getUint16(data[rPos : rPos+2])
+		regionCount := int(getUint16(data[rPos+2 : rPos+4]))
+		axisCount := int(store.AxisCount)
+		rPos += 4
+		if rPos+regionCount*axisCount*6 > len(data) {
+			err = errors.New("variation regions truncated")
+			return
+		}
+		for r := 0; r < regionCount; r++ {
+			region := make([]*RegionAxisCoordinates, 0, axisCount)
+			for a := 0; a < axisCount; a++ {
+				region = append(region, &RegionAxisCoordinates{
+					get2Dot14(data[rPos : rPos+2]),
+					get2Dot14(data[rPos+2 : rPos+4]),
+					get2Dot14(data[rPos+4 : rPos+6]),
+				})
+				rPos += 6
+			}
+			store.Regions = append(store.Regions, region)
+		}
+	}
+
+	for i := 0; i < dataCount; i++ {
+		dPos := start + int(getUint32(data[pos:pos+4]))
+		pos += 4
+		if dPos+6 > len(data) {
+			err = errors.New("item variation data truncated")
+			return
+		}
+		itemCount := int(getUint16(data[dPos : dPos+2]))
+		wordDeltaCount := getUint16(data[dPos+2 : dPos+4])
+		regionIndexCount := int(getUint16(data[dPos+4 : dPos+6]))
+		dPos += 6
+		longWords := wordDeltaCount&0x8000 != 0
+		wordCount := int(wordDeltaCount & 0x7FFF)
+
+		wordSize, shortSize := 2, 1
+		if longWords {
+			wordSize, shortSize = 4, 2
+		}
+		rowSize := wordCount*wordSize + (regionIndexCount-wordCount)*shortSize
+		if dPos+regionIndexCount*2+itemCount*rowSize > len(data) {
+			err = errors.New("item variation delta sets truncated")
+			return
+		}
+
+		ivd := new(ItemVariationData)
+		for r := 0; r < regionIndexCount; r++ {
+			ivd.RegionIndexes = append(ivd.RegionIndexes, getUint16(data[dPos:dPos+2]))
+			dPos += 2
+		}
+		for item := 0; item < itemCount; item++ {
+			deltas := make([]int32, regionIndexCount)
+			for r := 0; r < regionIndexCount; r++ {
+				switch {
+				case r < wordCount && longWords:
+					deltas[r] = getInt32(data[dPos : dPos+4])
+				case r < wordCount || longWords:
+					deltas[r] = int32(getInt16(data[dPos : dPos+2]))
+				default:
+					deltas[r] = int32(getInt8(data[dPos : dPos+1]))
+				}
+				if r < wordCount {
+					dPos += wordSize
+				} else {
+					dPos += shortSize
+				}
+			}
+			ivd.DeltaSets = append(ivd.DeltaSets, deltas)
+		}
+		store.ItemVariationData = append(store.ItemVariationData, ivd)
+	}
+	return
+}
+
+func writeItemVariationStore(store *ItemVariationStore) []byte {
+	format := store.Format
+	if format == 0 {
+		format = 1
+	}
+	dataCount := len(store.ItemVariationData)
+	headerSize := 8 + dataCount*4
+
+	regionList := []byte{}
+	axisCount := int(store.AxisCount)
+	if axisCount == 0 && len(store.Regions) > 0 {
+		axisCount = len(store.Regions[0])
+	}
+	regionList = append(regionList, writeUint16(uint16(axisCount))...)
+	regionList = append(regionList, writeUint16(uint16(len(store.Regions)))...)
+	for _, region := range store.Regions {
+		for a := 0; a < axisCount; a++ {
+			coord := &RegionAxisCoordinates{}
+			if a < len(region) && region[a] != nil {
+				coord = region[a]
+			}
+			regionList = append(regionList, write2Dot14(coord.StartCoord)...)
+			regionList = append(regionList, write2Dot14(coord.PeakCoord)...)
+			regionList = append(regionList, write2Dot14(coord.EndCoord)...)
+		}
+	}
+
+	var subtables [][]byte
+	for _, ivd := range store.ItemVariationData {
+		subtables = append(subtables, writeItemVariationData(ivd))
+	}
+
+	data := []byte{}
+	data = append(data, writeUint16(format)...)
+	data = append(data, writeUint32(uint32(headerSize))...)
+	data = append(data, writeUint16(uint16(dataCount))...)
+	offset := headerSize + len(regionList)
+	for _, sub := range subtables {
+		data = append(data, writeUint32(uint32(offset))...)
+		offset += len(sub)
+	}
+	data = append(data, regionList...)
+	for _, sub := range subtables {
+		data = append(data, sub...)
+	}
+	return data
+}
+
+// writeItemVariationData encodes one delta-set block. The spec requires the
+// wide columns to come first, so columns are reordered (together with their
+// region indexes) when a narrow column precedes a wide one.
+func writeItemVariationData(ivd *ItemVariationData) []byte {
+	regionCount := len(ivd.RegionIndexes)
+	longWords := false
+	wide := make([]bool, regionCount)
+	for _, deltas := range ivd.DeltaSets {
+		for r := 0; r < regionCount && r < len(deltas); r++ {
+			v := deltas[r]
+			if v < -32768 || v > 32767 {
+				longWords = true
+			}
+		}
+	}
+	for _, deltas := range ivd.DeltaSets {
+		for r := 0; r < regionCount && r < len(deltas); r++ {
+			v := deltas[r]
+			if longWords {
+				wide[r] = wide[r] || v < -32768 || v > 32767
+			} else {
+				wide[r] = wide[r] || v < -128 || v > 127
+			}
+		}
+	}
+
+	order := make([]int, 0, regionCount)
+	for r := 0; r < regionCount; r++ {
+		if wide[r] {
+			order = append(order, r)
+		}
+	}
+	wordCount := len(order)
+	for r := 0; r < regionCount; r++ {
+		if !wide[r] {
+			order = append(order, r)
+		}
+	}
+
+	wordDeltaCount := uint16(wordCount)
+	if longWords {
+		wordDeltaCount |= 0x8000
+	}
+
+	data := []byte{}
+	data = append(data, writeUint16(uint16(len(ivd.DeltaSets)))...)
+	data = append(data, writeUint16(wordDeltaCount)...)
+	data = append(data, writeUint16(uint16(regionCount))...)
+	for _, r := range order {
+		data = append(data, writeUint16(ivd.RegionIndexes[r])...)
+	}
+	for _, deltas := range ivd.DeltaSets {
+		for i, r := range order {
+			var v int32
+			if r < len(deltas) {
+				v = deltas[r]
+			}
+			switch {
+			case i < wordCount && longWords:
+				data = append(data, writeInt32(v)...)
+			case i < wordCount || longWords:
+				data = append(data, writeInt16(int16(v))...)
+			default:
+				data = append(data, writeInt8(int8(v))...)
+			}
+		}
+	}
+	return data
+}
+
+// Glyph classes defined by GDEF GlyphClassDef.
+const (
+	GDEF_CLASS_BASE      uint16 = 1
+	GDEF_CLASS_LIGATURE  uint16 = 2
+	GDEF_CLASS_MARK      uint16 = 3
+	GDEF_CLASS_COMPONENT uint16 = 4
+)
+
+type CaretValue struct {
+	Format     uint16       `json:"format"`
+	Coordinate int16        `json:"coordinate,omitempty"`
+	PointIndex uint16       `json:"pointIndex,omitempty"`
+	Device     *DeviceTable `json:"device,omitempty"`
+}
+
+type Gdef struct {
+	MajorVersion       uint16                   `json:"majorVersion"`
+	MinorVersion       uint16                   `json:"minorVersion"`
+	GlyphClassDef      map[uint16]uint16        `json:"glyphClassDef,omitempty"`
+	AttachList         map[uint16][]uint16      `json:"attachList,omitempty"`
+	LigCaretList       map[uint16][]*CaretValue `json:"ligCaretList,omitempty"`
+	MarkAttachClassDef map[uint16]uint16        `json:"markAttachClassDef,omitempty"`
+	MarkGlyphSets      [][]uint16               `json:"markGlyphSets,omitempty"`
+	ItemVarStore       *ItemVariationStore      `json:"itemVarStore,omitempty"`
+}
+
+func GetGdef(data []byte, pos int) (gdef *Gdef, err error) {
+	start := pos
+	gdef = new(Gdef)
+	if pos+12 > len(data) {
+		err = errors.New("GDEF table truncated")
+		return
+	}
+	gdef.MajorVersion = getUint16(data[pos : pos+2])
+	gdef.MinorVersion = getUint16(data[pos+2 : pos+4])
+	if gdef.MajorVersion != 1 {
+		err = errors.New("unsupported GDEF table version")
+		return
+	}
+	glyphClassDefOffset := int(getUint16(data[pos+4 : pos+6]))
+	attachListOffset := int(getUint16(data[pos+6 : pos+8]))
+	ligCaretListOffset := int(getUint16(data[pos+8 : pos+10]))
+	markAttachClassDefOffset := int(getUint16(data[pos+10 : pos+12]))
+	pos += 12
+
+	markGlyphSetsDefOffset := 0
+	if gdef.MinorVersion >= 2 {
+		if pos+2 > len(data) {
+			err = errors.New("GDEF markGlyphSetsDefOffset truncated")
+			return
+		}
+		markGlyphSetsDefOffset = int(getUint16(data[pos : pos+2]))
+		pos += 2
+	}
+	itemVarStoreOffset := 0
+	if gdef.MinorVersion >= 3 {
+		if pos+4 > len(data) {
+			err = errors.New("GDEF itemVarStoreOffset truncated")
+			return
+		}
+		itemVarStoreOffset = int(getUint32(data[pos : pos+4]))
+	}
+
+	if glyphClassDefOffset != 0 {
+		if gdef.GlyphClassDef, err = getClassDef(data, start+glyphClassDefOffset); err != nil {
+			return
+		}
+	}
+
+	if attachListOffset != 0 {
+		aPos := start + attachListOffset
+		if aPos+4 > len(data) {
+			err = errors.New("GDEF attach list truncated")
+			return
+		}
+		var coverage []uint16
+		if coverage, err = getCoverage(data, aPos+int(getUint16(data[aPos:aPos+2]))); err != nil {
+			return
+		}
+		glyphCount := int(getUint16(data[aPos+2 : aPos+4]))
+		if aPos+4+glyphCount*2 > len(data) || glyphCount > len(coverage) {
+			err = errors.New("GDEF attach point offsets truncated")
+			return
+		}
+		gdef.AttachList = make(map[uint16][]uint16)
+		for i := 0; i < glyphCount; i++ {
+			pPos := aPos + int(getUint16(data[aPos+4+i*2:aPos+6+i*2]))
+			if pPos+2 > len(data) {
+				err = errors.New("GDEF attach point table truncated")
+				return
+			}
+			pointCount := int(getUint16(data[pPos : pPos+2]))
+			if pPos+2+pointCount*2 > len(data) {
+				err = errors.New("GDEF attach point indices truncated")
+				return
+			}
+			points := make([]uint16, 0, pointCount)
+			for j := 0; j < pointCount; j++ {
+				points = append(points, getUint16(data[pPos+2+j*2:pPos+4+j*2]))
+			}
+			gdef.AttachList[coverage[i]] = points
+		}
+	}
+
+	if ligCaretListOffset != 0 {
+		lPos := start + ligCaretListOffset
+		if lPos+4 > len(data) {
+			err = errors.New("GDEF ligature caret list truncated")
+			return
+		}
+		var coverage []uint16
+		if coverage, err = getCoverage(data, lPos+int(getUint16(data[lPos:lPos+2]))); err != nil {
+			return
+		}
+		ligGlyphCount := int(getUint16(data[lPos+2 : lPos+4]))
+		if lPos+4+ligGlyphCount*2 > len(data) || ligGlyphCount > len(coverage) {
+			err = errors.New("GDEF ligature glyph offsets truncated")
+			return
+		}
+		gdef.LigCaretList = make(map[uint16][]*CaretValue)
+		for i := 0; i < ligGlyphCount; i++ {
+			gPos := lPos + int(getUint16(data[lPos+4+i*2:lPos+6+i*2]))
+			if gPos+2 > len(data) {
+				err = errors.New("GDEF ligature glyph table truncated")
+				return
+			}
+			caretCount := int(getUint16(data[gPos : gPos+2]))
+			if gPos+2+caretCount*2 > len(data) {
+				err = errors.New("GDEF caret value offsets truncated")
+				return
+			}
+			carets := make([]*CaretValue, 0, caretCount)
+			for j := 0; j < caretCount; j++ {
+				cPos := gPos + int(getUint16(data[gPos+2+j*2:gPos+4+j*2]))
+				if cPos+4 > len(data) {
+					err = errors.New("GDEF caret value truncated")
+					return
+				}
+				caret := &CaretValue{Format: getUint16(data[cPos : cPos+2])}
+				switch caret.Format {
+				case 1:
+					caret.Coordinate = getInt16(data[cPos+2 : cPos+4])
+				case 2:
+					caret.PointIndex = getUint16(data[cPos+2 : cPos+4])
+				case 3:
+					if cPos+6 > len(data) {
+						err = errors.New("GDEF caret value format 3 truncated")
+						return
+					}
+					caret.Coordinate = getInt16(data[cPos+2 : cPos+4])
+					if deviceOffset := int(getUint16(data[cPos+4 : cPos+6])); deviceOffset != 0 {
+						if caret.Device, err = getDeviceTable(data, cPos+deviceOffset); err != nil {
+							return
+						}
+					}
+				default:
+					err = errors.New("unsupported GDEF caret value format " + strconv.Itoa(int(caret.Format)))
+					return
+				}
+				carets = append(carets, caret)
+			}
+			gdef.LigCaretList[coverage[i]] = carets
+		}
+	}
+
+	if markAttachClassDefOffset != 0 {
+		if gdef.MarkAttachClassDef, err = getClassDef(data, start+markAttachClassDefOffset); err != nil {
+			return
+		}
+	}
+
+	if markGlyphSetsDefOffset != 0 {
+		mPos := start + markGlyphSetsDefOffset
+		if mPos+4 > len(data) {
+			err = errors.New("GDEF mark glyph sets truncated")
+			return
+		}
+		setCount := int(getUint16(data[mPos+2 : mPos+4]))
+		if mPos+4+setCount*4 > len(data) {
+			err = errors.New("GDEF mark glyph set offsets truncated")
+			return
+		}
+		for i := 0; i < setCount; i++ {
+			var set []uint16
+			if set, err = getCoverage(data, mPos+int(getUint32(data[mPos+4+i*4:mPos+8+i*4]))); err != nil {
+				return
+			}
+			gdef.MarkGlyphSets = append(gdef.MarkGlyphSets, set)
+		}
+	}
+
+	if itemVarStoreOffset != 0 {
+		if gdef.ItemVarStore, err = getItemVariationStore(data, start+itemVarStoreOffset); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func WriteGdef(gdef *Gdef) []byte {
+	minorVersion := gdef.MinorVersion
+	if len(gdef.MarkGlyphSets) > 0 && minorVersion < 2 {
+		minorVersion = 2
+	}
+	if gdef.ItemVarStore != nil {
+		minorVersion = 3
+	}
+	headerSize := 12
+	if minorVersion >= 2 {
+		headerSize += 2
+	}
+	if minorVersion >= 3 {
+		headerSize += 4
+	}
+
+	body := []byte{}
+	appendSubtable := func(sub []byte) uint16 {
+		offset := headerSize + len(body)
+		if offset > 0xFFFF {
+			log.Printf("[WARN] GDEF subtable offset %d overflows Offset16, subtable dropped", offset)
+			return 0
+		}
+		body = append(body, sub...)
+		return uint16(offset)
+	}
+
+	var glyphClassDefOffset, attachListOffset, ligCaretListOffset, markAttachClassDefOffset, markGlyphSetsDefOffset uint16
+	var itemVarStoreOffset uint32
+
+	if len(gdef.GlyphClassDef) > 0 {
+		glyphClassDefOffset = appendSubtable(writeClassDef(gdef.GlyphClassDef))
+	}
+	if len(gdef.AttachList) > 0 {
+		attachListOffset = appendSubtable(writeGdefAttachList(gdef.AttachList))
+	}
+	if len(gdef.LigCaretList) > 0 {
+		ligCaretListOffset = appendSubtable(writeGdefLigCaretList(gdef.LigCaretList))
+	}
+	if len(gdef.MarkAttachClassDef) > 0 {
+		markAttachClassDefOffset = appendSubtable(writeClassDef(gdef.MarkAttachClassDef))
+	}
+	if minorVersion >= 2 && len(gdef.MarkGlyphSets) > 0 {
+		sets := []byte{}
+		sets = append(sets, writeUint16(1)...)
+		sets = append(sets, writeUint16(uint16(len(gdef.MarkGlyphSets)))...)
+		coverages := []byte{}
+		coverageOffsets := make(map[string]int)
+		offset := 4 + len(gdef.MarkGlyphSets)*4
+		for _, set := range gdef.MarkGlyphSets {
+			coverage := writeCoverage(set)
+			setOffset, ok := coverageOffsets[string(coverage)]
+			if !ok {
+				setOffset = offset + len(coverages)
+				coverageOffsets[string(coverage)] = setOffset
+				coverages = append(coverages, coverage...)
+			}
+			sets = append(sets, writeUint32(uint32(setOffset))...)
+		}
+		sets = append(sets, coverages...)
+		markGlyphSetsDefOffset = appendSubtable(sets)
+	}
+	if minorVersion >= 3 && gdef.ItemVarStore != nil {
+		itemVarStoreOffset = uint32(headerSize + len(body))
+		body = append(body, writeItemVariationStore(gdef.ItemVarStore)...)
+	}
+
+	data := []byte{}
+	data = append(data, writeUint16(1)...)
+	data = append(data, writeUint16(minorVersion)...)
+	data = append(data, writeUint16(glyphClassDefOffset)...)
+	data = append(data, writeUint16(attachListOffset)...)
+	data = append(data, writeUint16(ligCaretListOffset)...)
+	data = append(data, writeUint16(markAttachClassDefOffset)...)
+	if minorVersion >= 2 {
+		data = append(data, writeUint16(markGlyphSetsDefOffset)...)
+	}
+	if minorVersion >= 3 {
+		data = append(data, writeUint32(itemVarStoreOffset)...)
+	}
+	data = append(data, body...)
+	return data
+}
+
+func sortedGlyphKeys(m interface{}) []uint16 {
+	var keys []uint16
+	switch v := m.(type) {
+	case map[uint16][]uint16:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[uint16][]*CaretValue:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[uint16][]LayerRecord:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[uint16]*Paint:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[uint16]*ClipBox:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[uint16]*BitmapGlyph:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// writeGdefAttachList writes the attach list, sharing identical attach
+// point tables.
+func writeGdefAttachList(attachList map[uint16][]uint16) []byte {
+	glyphs := sortedGlyphKeys(attachList)
+	headerSize := 4 + len(glyphs)*2
+	points := []byte{}
+	offsets := []byte{}
+	pointOffsets := make(map[string]int)
+	for _, g := range glyphs {
+		table := writeUint16(uint16(len(attachList[g])))
+		for _, p := range attachList[g] {
+			table = append(table, writeUint16(p)...)
+		}
+		offset, ok := pointOffsets[string(table)]
+		if !ok {
+			offset = headerSize + len(points)
+			pointOffsets[string(table)] = offset
+			points = append(points, table...)
+		}
+		offsets = append(offsets, writeUint16(uint16(offset))...)
+	}
+
+	data := []byte{}
+	data = append(data, writeUint16(uint16(headerSize+len(points)))...)
+	data = append(data, writeUint16(uint16(len(glyphs)))...)
+	data = append(data, offsets...)
+	data = append(data, points...)
+	data = append(data, writeCoverage(glyphs)...)
+	return data
+}
+
+// writeGdefLigCaretList writes the ligature caret list. Identical ligature
+// glyph tables are written once, and the caret values of all of them follow
+// in one pool that shares identical caret values.
+func writeGdefLigCaretList(ligCaretList map[uint16][]*CaretValue) []byte {
+	glyphs := sortedGlyphKeys(ligCaretList)
+	headerSize := 4 + len(glyphs)*2
+
+	// caret values by their index in the pool
+	var caretPool []byte
+	caretOffsets := make(map[string]int)
+	caretIndices := make(map[uint16][]int)
+	for _, g := range glyphs {
+		for _, caret := range ligCaretList[g] {
+			caretData := writeUint16(caret.Format)
+			switch caret.Format {
+			case 2:
+				caretData = append(caretData, writeUint16(caret.PointIndex)...)
+			case 3:
+				caretData = append(caretData, writeInt16(caret.Coordinate)...)
+				if caret.Device != nil {
+					caretData = append(caretData, writeUint16(6)...)
+					caretData = append(caretData, writeDeviceTable(caret.Device)...)
+				} else {
+					caretData = append(caretData, writeUint16(0)...)
+				}
+			default:
+				caretData = append(caretData, writeInt16(caret.Coordinate)...)
+			}
+			offset, ok := caretOffsets[string(caretData)]
+			if !ok {
+				offset = len(caretPool)
+				caretOffsets[string(caretData)] = offset
+				caretPool = append(caretPool, caretData...)
+			}
+			caretIndices[g] = append(caretIndices[g], offset)
+		}
+	}
+
+	// ligature glyph tables hold their caret count and pool positions until
+	// the pool start is known
+	type ligGlyph struct {
+		key    string
+		offset int
+	}
+	var ligGlyphs []ligGlyph
+	ligOffsets := make(map[string]int)
+	ligGlyphsSize := 0
+	for _, g := range glyphs {
+		key := strconv.Itoa(len(caretIndices[g]))
+		for _, index := range caretIndices[g] {
+			key += "," + strconv.Itoa(index)
+		}
+		offset, ok := ligOffsets[key]
+		if !ok {
+			offset = headerSize + ligGlyphsSize
+			ligOffsets[key] = offset
+			ligGlyphsSize += 2 + len(caretIndices[g])*2
+		}
+		ligGlyphs = append(ligGlyphs, ligGlyph{key, offset})
+	}
+	poolStart := headerSize + ligGlyphsSize
+
+	data := []byte{}
+	data = append(data, writeUint16(uint16(poolStart+len(caretPool)))...)
+	data = append(data, writeUint16(uint16(len(glyphs)))...)
+	for _, lig := range ligGlyphs {
+		data = append(data, writeUint16(uint16(lig.offset))...)
+	}
+	written := make(map[string]bool)
+	for i, g := range glyphs {
+		lig := ligGlyphs[i]
+		if written[lig.key] {
+			continue
+		}
+		written[lig.key] = true
+		data = append(data, writeUint16(uint16(len(caretIndices[g])))...)
+		for _, index := range caretIndices[g] {
+			data = append(data, writeUint16(uint16(poolStart+index-lig.offset))...)
+		}
+	}
+	data = append(data, caretPool...)
+	data = append(data, writeCoverage(glyphs)...)
+	return data
+}
+
+// remapClassDef rewrites the glyph IDs of a ClassDef map through oldToNew,
+// dropping glyphs that are not retained.
+func remapClassDef(classes map[uint16]uint16, oldToNew map[int]int) map[uint16]uint16 {
+	if classes == nil {
+		return nil
+	}
+	out := make(map[uint16]uint16)
+	for g, class := range classes {
+		if newIdx, ok := oldToNew[int(g)]; ok {
+			out[uint16(newIdx)] = class
+		}
+	}
+	return out
+}
+
+// remapGdef rewrites every glyph ID referenced by gdef through oldToNew and
+// drops entries for glyphs that are not retained.
+func remapGdef(gdef *Gdef, oldToNew map[int]int) {
+	gdef.GlyphClassDef = remapClassDef(gdef.GlyphClassDef, oldToNew)
+	gdef.MarkAttachClassDef = remapClassDef(gdef.MarkAttachClassDef, oldToNew)
+
+	if gdef.AttachList != nil {
+		attachList := make(map[uint16][]uint16)
+		for g, points := range gdef.AttachList {
+			if newIdx, ok := oldToNew[int(g)]; ok {
+				attachList[uint16(newIdx)] = points
+			}
+		}
+		gdef.AttachList = attachList
+	}
+	if gdef.LigCaretList != nil {
+		ligCaretList := make(map[uint16][]*CaretValue)
+		for g, carets := range gdef.LigCaretList {
+			if newIdx, ok := oldToNew[int(g)]; ok {
+				ligCaretList[uint16(newIdx)] = carets
+			}
+		}
+		gdef.LigCaretList = ligCaretList
+	}
+	for i, set := range gdef.MarkGlyphSets {
+		var newSet []uint16
+		for _, g := range set {
+			if newIdx, ok := oldToNew[int(g)]; ok {
+				newSet = append(newSet, uint16(newIdx))
+			}
+		}
+		sort.Slice(newSet, func(a, b int) bool { return newSet[a] < newSet[b] })
+		gdef.MarkGlyphSets[i] = newSet
+	}
+}
+
+// GPOS value format bits that are modelled. Device and variation index
+// offsets (0x0010-0x0080) are skipped on read and never written.
+const (
+	GPOS_VALUE_X_PLACEMENT  uint16 = 0x0001
+	GPOS_VALUE_Y_PLACEMENT  uint16 = 0x0002
+	GPOS_VALUE_X_ADVANCE    uint16 = 0x0004
+	GPOS_VALUE_Y_ADVANCE    uint16 = 0x0008
+	GPOS_VALUE_X_PLA_DEVICE uint16 = 0x0010
+	GPOS_VALUE_Y_PLA_DEVICE uint16 = 0x0020
+	GPOS_VALUE_X_ADV_DEVICE uint16 = 0x0040
+	GPOS_VALUE_Y_ADV_DEVICE uint16 = 0x0080
+)
+
+const (
+	GPOS_LOOKUP_SINGLE           uint16 = 1
+	GPOS_LOOKUP_PAIR             uint16 = 2
+	GPOS_LOOKUP_CURSIVE          uint16 = 3
+	GPOS_LOOKUP_MARK_TO_BASE     uint16 = 4
+	GPOS_LOOKUP_MARK_TO_LIGATURE uint16 = 5
+	GPOS_LOOKUP_MARK_TO_MARK     uint16 = 6
+	GPOS_LOOKUP_CONTEXT          uint16 = 7
+	GPOS_LOOKUP_CHAINED_CONTEXT  uint16 = 8
+	GPOS_LOOKUP_EXTENSION        uint16 = 9
+
+	LOOKUP_FLAG_USE_MARK_FILTERING_SET uint16 = 0x0010
+)
+
+// ValueRecord holds the adjustments of a GPOS value record. The device
+// tables, hinting deltas or variation indices, are only written when the
+// value format has their bits set.
+type ValueRecord struct {
+	XPlacement int16        `json:"xPlacement,omitempty"`
+	YPlacement int16        `json:"yPlacement,omitempty"`
+	XAdvance   int16        `json:"xAdvance,omitempty"`
+	YAdvance   int16        `json:"yAdvance,omitempty"`
+	XPlaDevice *DeviceTable `json:"xPlaDevice,omitempty"`
+	YPlaDevice *DeviceTable `json:"yPlaDevice,omitempty"`
+	XAdvDevice *DeviceTable `json:"xAdvDevice,omitempty"`
+	YAdvDevice *DeviceTable `json:"yAdvDevice,omitempty"`
+}
+
+type PairValueRecord struct {
+	SecondGlyph uint16      `json:"secondGlyph"`
+	Value1      ValueRecord `json:"value1"`
+	Value2      ValueRecord `json:"value2"`
+}
+
+type PairClassValue struct {
+	Value1 ValueRecord `json:"value1"`
+	Value2 ValueRecord `json:"value2"`
+}
+
+// PairPos is a GPOS lookup type 2 subtable. Format 1 keeps explicit pair
+// sets keyed by first glyph; format 2 keeps the first-glyph coverage, both
+// class definitions and the class1 × class2 value matrix.
+type PairPos struct {
+	Format       uint16                        `json:"format"`
+	ValueFormat1 uint16                        `json:"valueFormat1"`
+	ValueFormat2 uint16                        `json:"valueFormat2"`
+	PairSets     map[uint16][]*PairValueRecord `json:"pairSets,omitempty"`
+	Coverage     []uint16                      `json:"coverage,omitempty"`
+	ClassDef1    map[uint16]uint16             `json:"classDef1,omitempty"`
+	ClassDef2    map[uint16]uint16             `json:"classDef2,omitempty"`
+	ClassValues  [][]*PairClassValue           `json:"classValues,omitempty"`
+}
+
+// GposLookup is one entry of the GPOS lookup list. Extension lookups are
+// unwrapped on read. Only pair adjustment subtables are decoded; the
+// subtables of other lookup types are kept in Subtables as the bytes of the
+// subtable and everything it references; Subset renumbers the glyphs in them.
+type GposLookup struct {
+	LookupType       uint16     `json:"lookupType"`
+	LookupFlag       uint16     `json:"lookupFlag"`
+	MarkFilteringSet uint16     `json:"markFilteringSet,omitempty"`
+	PairPos          []*PairPos `json:"pairPos,omitempty"`
+	Subtables        [][]byte   `json:"subtables,omitempty"`
+}
+
+type LangSys struct {
+	RequiredFeatureIndex uint16   `json:"requiredFeatureIndex"`
+	FeatureIndices       []uint16 `json:"featureIndices"`
+}
+
+type ScriptRecord struct {
+	Tag            string              `json:"tag"`
+	DefaultLangSys *LangSys            `json:"defaultLangSys,omitempty"`
+	LangSys        map[string]*LangSys `json:"langSys,omitempty"`
+}
+
+type FeatureRecord struct {
+	Tag           string   `json:"tag"`
+	LookupIndices []uint16 `json:"lookupIndices"`
+}
+
+type Gpos struct {
+	MajorVersion uint16           `json:"majorVersion"`
+	MinorVersion uint16           `json:"minorVersion"`
+	Scripts      []*ScriptRecord  `json:"scripts"`
+	Features     []*FeatureRecord `json:"features"`
+	Lookups      []*GposLookup    `json:"lookups"`
+	// lookupList is the lookup list as read and lookupListWritten what
+	// writeGposLookupList made of it, so that unchanged lookups are written
+	// back as they were read
+	lookupList        []byte
+	lookupListWritten []byte
+}
+
+func GetGpos(data []byte, pos int) (gpos *Gpos, err error) {
+	start := pos
+	gpos = new(Gpos)
+	if pos+10 > len(data) {
+		err = errors.New("GPOS table truncated")
+		return
+	}
+	gpos.MajorVersion = getUint16(data[pos : pos+2])
+	gpos.MinorVersion = getUint16(data[pos+2 : pos+4])
+	if gpos.MajorVersion != 1 {
+		err = errors.New("unsupported GPOS table version")
+		return
+	}
+	scriptListOffset := int(getUint16(data[pos+4 : pos+6]))
+	featureListOffset := int(getUint16(data[pos+6 : pos+8]))
+	lookupListOffset := int(getUint16(data[pos+8 : pos+10]))
+
+	if scriptListOffset != 0 {
+		if gpos.Scripts, err = getScriptList(data, start+scriptListOffset); err != nil {
+			return
+		}
+	}
+	if featureListOffset != 0 {
+		if gpos.Features, err = getFeatureList(data, start+featureListOffset); err != nil {
+			return
+		}
+	}
+	if lookupListOffset != 0 {
+		listPos := start + lookupListOffset
+		var end int
+		if gpos.Lookups, end, err = getGposLookupList(data, listPos); err != nil {
+			return
+		}
+		gpos.lookupList = append([]byte{}, data[listPos:end]...)
+		gpos.lookupListWritten = writeGposLookupList(gpos.Lookups)
+	}
+	return
+}
+
+// WriteGpos writes a version 1.0 GPOS table; feature variations are not
+// modelled and are dropped. Lookups left as GetGpos read them are written
+// back byte for byte, keeping the tables their subtables share.
+func WriteGpos(gpos *Gpos) []byte {
+	scriptList := writeScriptList(gpos.Scripts)
+	featureList := writeFeatureList(gpos.Features)
+	lookupList := writeGposLookupList(gpos.Lookups)
+	if gpos.lookupList != nil && string(lookupList) == string(gpos.lookupListWritten) {
+		lookupList = gpos.lookupList
+	}
+
+	data := []byte{}
+	data = append(data, writeUint16(1)...)
+	data = append(data, writeUint16(0)...)
+	data = append(data, writeUint16(10)...)
+	data = append(data, writeUint16(uint16(10+len(scriptList)))...)
+	data = append(data, writeUint16(uint16(10+len(scriptList)+len(featureList)))...)
+	data = append(data, scriptList...)
+	data = append(data, featureList...)
+	data = append(data, lookupList...)
+	return data
+}
+
+func getLangSys(data []byte, pos int) (langSys *LangSys, err error) {
+	if pos+6 > len(data) {
+		err = errors.New("LangSys table truncated")
+		return
+	}
+	langSys = &LangSys{RequiredFeatureIndex: getUint16(data[pos+2 : pos+4])}
+	count := int(getUint16(data[pos+4 : pos+6]))
+	if pos+6+count*2 > len(data) {
+		err = errors.New("LangSys feature indices truncated")
+		return
+	}
+	langSys.FeatureIndices = make([]uint16, 0, count)
+	for i := 0; i < count; i++ {
+		langSys.FeatureIndices = append(langSys.FeatureIndices, getUint16(data[pos+6+i*2:pos+8+i*2]))
+	}
+	return
+}
+
+func getScriptList(data []byte, pos int) (scripts []*ScriptRecord, err error) {
+	if pos+2 > len(data) {
+		err = errors.New("script list truncated")
+		return
+	}
+	count := int(getUint16(data[pos : pos+2]))
+	if pos+2+count*6 > len(data) {
+		err = errors.New("script records truncated")
+		return
+	}
+	for i := 0; i < count; i++ {
+		rPos := pos + 2 + i*6
+		script := &ScriptRecord{Tag: FromCharCodeByte(data[rPos : rPos+4])}
+		sPos := pos + int(getUint16(data[rPos+4:rPos+6]))
+		if sPos+4 > len(data) {
+			err = errors.New("script table truncated")
+			return
+		}
+		if defaultOffset := int(getUint16(data[sPos : sPos+2])); defaultOffset != 0 {
+			if script.DefaultLangSys, err = getLangSys(data, sPos+defaultOffset); err != nil {
+				return
+			}
+		}
+		langSysCount := int(getUint16(data[sPos+2 : sPos+4]))
+		if sPos+4+langSysCount*6 > len(data) {
+			err = errors.New("LangSys records truncated")
+			return
+		}
+		if langSysCount > 0 {
+			script.LangSys = make(map[string]*LangSys)
+		}
+		for j := 0; j < langSysCount; j++ {
+			lPos := sPos + 4 + j*6
+			var langSys *LangSys
+			if langSys, err = getLangSys(data, sPos+int(getUint16(data[lPos+4:lPos+6]))); err != nil {
+				return
+			}
+			script.LangSys[FromCharCodeByte(data[lPos:lPos+4])] = langSys
+		}
+		scripts = append(scripts, script)
+	}
+	return
+}
+
+func getFeatureList(data []byte, pos int) (features []*FeatureRecord, err error) {
+	if pos+2 > len(data) {
+		err = errors.New("feature list truncated")
+		return
+	}
+	count := int(getUint16(data[pos : pos+2]))
+	if pos+2+count*6 > len(data) {
+		err = errors.New("feature records truncated")
+		return
+	}
+	for i := 0; i < count; i++ {
+		rPos := pos + 2 + i*6
+		feature := &FeatureRecord{Tag: FromCharCodeByte(data[rPos : rPos+4])}
+		fPos := pos + int(getUint16(data[rPos+4:rPos+6]))
+		if fPos+4 > len(data) {
+			err = errors.New("feature table truncated")
+			return
+		}
+		lookupCount := int(getUint16(data[fPos+2 : fPos+4]))
+		if fPos+4+lookupCount*2 > len(data) {
+			err = errors.New("feature lookup indices truncated")
+			return
+		}
+		feature.LookupIndices = make([]uint16, 0, lookupCount)
+		for j := 0; j < lookupCount; j++ {
+			feature.LookupIndices = append(feature.LookupIndices, getUint16(data[fPos+4+j*2:fPos+6+j*2]))
+		}
+		features = append(features, feature)
+	}
+	return
+}
+
+// getGposLookupList reads the lookup list at pos and returns where the
+// lookup list and everything it references ends. A subtable of a type or
+// format that is not understood is skipped with a warning.
+func getGposLookupList(data []byte, pos int) (lookups []*GposLookup, end int, err error) {
+	end = pos
+	reach := func(p int) {
+		if p > end {
+			end = p
+		}
+	}
+	if pos+2 > len(data) {
+		err = errors.New("lookup list truncated")
+		return
+	}
+	count := int(getUint16(data[pos : pos+2]))
+	if pos+2+count*2 > len(data) {
+		err = errors.New("lookup offsets truncated")
+		return
+	}
+	reach(pos + 2 + count*2)
+	for i := 0; i < count; i++ {
+		lPos := pos + int(getUint16(data[pos+2+i*2:pos+4+i*2]))
+		if lPos+6 > len(data) {
+			err = errors.New("lookup table truncated")
+			return
+		}
+		lookup := &GposLookup{
+			LookupType: getUint16(data[lPos : lPos+2]),
+			LookupFlag: getUint16(data[lPos+2 : lPos+4]),
+		}
+		subTableCount := int(getUint16(data[lPos+4 : lPos+6]))
+		if lPos+6+subTableCount*2 > len(data) {
+			err = errors.New("lookup subtable offsets truncated")
+			return
+		}
+		reach(lPos + 6 + subTableCount*2)
+		if lookup.LookupFlag&LOOKUP_FLAG_USE_MARK_FILTERING_SET != 0 {
+			fPos := lPos + 6 + subTableCount*2
+			if fPos+2 > len(data) {
+				err = errors.New("lookup mark filtering set truncated")
+				return
+			}
+			lookup.MarkFilteringSet = getUint16(data[fPos : fPos+2])
+			reach(fPos + 2)
+		}
+		isExtension := lookup.LookupType == GPOS_LOOKUP_EXTENSION
+		for j := 0; j < subTableCount; j++ {
+			sPos := lPos + int(getUint16(data[lPos+6+j*2:lPos+8+j*2]))
+			if isExtension {
+				if sPos+8 > len(data) {
+					err = errors.New("extension subtable truncated")
+					return
+				}
+				lookup.LookupType = getUint16(data[sPos+2 : sPos+4])
+				reach(sPos + 8)
+				sPos += int(getUint32(data[sPos+4 : sPos+8]))
+			}
+			length, lengthErr := gposSubtableLength(data, sPos, lookup.LookupType)
+			if lengthErr != nil {
+				log.Printf("[WARN] GPOS lookup %d subtable %d skipped: %v", i, j, lengthErr)
+				continue
+			}
+			reach(sPos + length)
+			if lookup.LookupType != GPOS_LOOKUP_PAIR {
+				lookup.Subtables = append(lookup.Subtables, append([]byte{}, data[sPos:sPos+length]...))
+				continue
+			}
+			var pairPos *PairPos
+			if pairPos, err = getPairPos(data, sPos); err != nil {
+				return
+			}
+			lookup.PairPos = append(lookup.PairPos, pairPos)
+		}
+		lookups = append(lookups, lookup)
+	}
+	return
+}
+
+// gposExtent tracks the end of the bytes a GPOS subtable and the tables it
+// references occupy. Referenced tables always follow the table holding the
+// offset, so everything from the subtable start to the end can be copied
+// with its offsets intact.
+type gposExtent struct {
+	data []byte
+	end  int
+	err  error
+}
+
+func (e *gposExtent) span(pos, size int) bool {
+	if e.err != nil {
+		return false
+	}
+	if pos < 0 || size < 0 || pos+size > len(e.data) {
+		e.err = errors.New("GPOS subtable truncated")
+		return false
+	}
+	if pos+size > e.end {
+		e.end = pos + size
+	}
+	return true
+}
+
+func (e *gposExtent) uint16At(pos int) int {
+	if !e.span(pos, 2) {
+		return 0
+	}
+	return int(getUint16(e.data[pos : pos+2]))
+}
+
+// offset reads the Offset16 at pos, relative to base; ok is false for null
+// offsets and after an error.
+func (e *gposExtent) offset(base, pos int) (target int, ok bool) {
+	offset := e.uint16At(pos)
+	return base + offset, offset != 0 && e.err == nil
+}
+
+func (e *gposExtent) fail(what string, format int) {
+	if e.err == nil {
+		e.err = errors.New("unsupported " + what + " format " + strconv.Itoa(format))
+	}
+}
+
+func (e *gposExtent) coverage(base, pos int) {
+	c, ok := e.offset(base, pos)
+	if !ok {
+		return
+	}
+	switch format, count := e.uint16At(c), e.uint16At(c+2); format {
+	case 1:
+		e.span(c+4, count*2)
+	case 2:
+		e.span(c+4, count*6)
+	default:
+		e.fail("coverage", format)
+	}
+}
+
+func (e *gposExtent) classDef(base, pos int) {
+	c, ok := e.offset(base, pos)
+	if !ok {
+		return
+	}
+	switch format := e.uint16At(c); format {
+	case 1:
+		e.span(c+6, e.uint16At(c+4)*2)
+	case 2:
+		e.span(c+4, e.uint16At(c+2)*6)
+	default:
+		e.fail("class definition", format)
+	}
+}
+
+func (e *gposExtent) device(base, pos int) {
+	d, ok := e.offset(base, pos)
+	if !ok {
+		return
+	}
+	start, end, format := e.uint16At(d), e.uint16At(d+2), e.uint16At(d+4)
+	if format >= 1 && format <= 3 && end >= start {
+		bits := 1 << uint(format)
+		e.span(d+6, ((end-start+1)*bits+15)/16*2)
+	}
+}
+
+func (e *gposExtent) anchor(base, pos int) {
+	a, ok := e.offset(base, pos)
+	if !ok {
+		return
+	}
+	switch format := e.uint16At(a); format {
+	case 1:
+		e.span(a, 6)
+	case 2:
+		e.span(a, 8)
+	case 3:
+		e.span(a, 10)
+		e.device(a, a+6)
+		e.device(a, a+8)
+	default:
+		e.fail("anchor", format)
+	}
+}
+
+func (e *gposExtent) valueRecord(base, pos int, valueFormat int) {
+	e.span(pos, valueRecordSize(uint16(valueFormat)))
+	for bit := 0; bit < 4; bit++ {
+		if valueFormat&(1<<uint(bit)) != 0 {
+			pos += 2
+		}
+	}
+	for bit := 4; bit < 8; bit++ {
+		if valueFormat&(1<<uint(bit)) != 0 {
+			e.device(base, pos)
+			pos += 2
+		}
+	}
+}
+
+// markArray covers a MarkArray: mark records of a class and an anchor
+func (e *gposExtent) markArray(base, pos int) {
+	m, ok := e.offset(base, pos)
+	if !ok {
+		return
+	}
+	count := e.uint16At(m)
+	for i := 0; i < count && e.err == nil; i++ {
+		e.anchor(m, m+4+i*4)
+	}
+}
+
+// anchorMatrix covers a BaseArray, Mark2Array or LigatureAttach: rows of
+// one anchor per mark class
+func (e *gposExtent) anchorMatrix(m int, classCount int) {
+	rows := e.uint16At(m)
+	for i := 0; i < rows*classCount && e.err == nil; i++ {
+		e.anchor(m, m+2+i*2)
+	}
+}
+
+// ruleSets covers count offsets at pos to rule sets, each a list of offsets
+// to rules
+func (e *gposExtent) ruleSets(base, pos, count int, rule func(r int)) {
+	for i := 0; i < count && e.err == nil; i++ {
+		set, ok := e.offset(base, pos+i*2)
+		if !ok {
+			continue
+		}
+		n := e.uint16At(set)
+		for j := 0; j < n && e.err == nil; j++ {
+			if r, ok := e.offset(set, set+2+j*2); ok {
+				rule(r)
+			}
+		}
+	}
+}
+
+func (e *gposExtent) sequenceRule(r int) {
+	glyphCount, lookupCount := e.uint16At(r), e.uint16At(r+2)
+	if glyphCount > 0 {
+		glyphCount--
+	}
+	e.span(r+4, glyphCount*2+lookupCount*4)
+}
+
+func (e *gposExtent) chainedSequenceRule(r int) {
+	p := r + 2 + e.uint16At(r)*2
+	inputCount := e.uint16At(p)
+	if inputCount > 0 {
+		inputCount--
+	}
+	p += 2 + inputCount*2
+	p += 2 + e.uint16At(p)*2
+	e.span(p+2, e.uint16At(p)*4)
+}
+
+func (e *gposExtent) subtable(pos int, lookupType uint16) {
+	format := e.uint16At(pos)
+	switch {
+	case lookupType == 1 && format == 1:
+		e.coverage(pos, pos+2)
+		e.valueRecord(pos, pos+6, e.uint16At(pos+4))
+	case lookupType == 1 && format == 2:
+		e.coverage(pos, pos+2)
+		valueFormat, count := e.uint16At(pos+4), e.uint16At(pos+6)
+		size := valueRecordSize(uint16(valueFormat))
+		for i := 0; i < count && e.err == nil; i++ {
+			e.valueRecord(pos, pos+8+i*size, valueFormat)
+		}
+	case lookupType == 2 && format == 1:
+		e.coverage(pos, pos+2)
+		valueFormat1, valueFormat2 := e.uint16At(pos+4), e.uint16At(pos+6)
+		size1, size2 := valueRecordSize(uint16(valueFormat1)), valueRecordSize(uint16(valueFormat2))
+		count := e.uint16At(pos + 8)
+		for i := 0; i < count && e.err == nil; i++ {
+			set, ok := e.offset(pos, pos+10+i*2)
+			if !ok {
+				continue
+			}
+			pairs := e.uint16At(set)
+			for j := 0; j < pairs && e.err == nil; j++ {
+				record := set + 2 + j*(2+size1+size2)
+				e.span(record, 2)
+				e.valueRecord(pos, record+2, valueFormat1)
+				e.valueRecord(pos, record+2+size1, valueFormat2)
+			}
+		}
+	case lookupType == 2 && format == 2:
+		e.coverage(pos, pos+2)
+		valueFormat1, valueFormat2 := e.uint16At(pos+4), e.uint16At(pos+6)
+		size1, size2 := valueRecordSize(uint16(valueFormat1)), valueRecordSize(uint16(valueFormat2))
+		e.classDef(pos, pos+8)
+		e.classDef(pos, pos+10)
+		class1Count, class2Count := e.uint16At(pos+12), e.uint16At(pos+14)
+		for i := 0; i < class1Count*class2Count && e.err == nil; i++ {
+			record := pos + 16 + i*(size1+size2)
+			e.valueRecord(pos, record, valueFormat1)
+			e.valueRecord(pos, record+size1, valueFormat2)
+		}
+	case lookupType == 3 && format == 1:
+		e.coverage(pos, pos+2)
+		count := e.uint16At(pos + 4)
+		for i := 0; i < count*2 && e.err == nil; i++ {
+			e.anchor(pos, pos+6+i*2)
+		}
+	case (lookupType == 4 || lookupType == 6) && format == 1:
+		e.coverage(pos, pos+2)
+		e.coverage(pos, pos+4)
+		classCount := e.uint16At(pos + 6)
+		e.markArray(pos, pos+8)
+		if m, ok := e.offset(pos, pos+10); ok {
+			e.anchorMatrix(m, classCount)
+		}
+	case lookupType == 5 && format == 1:
+		e.coverage(pos, pos+2)
+		e.coverage(pos, pos+4)
+		classCount := e.uint16At(pos + 6)
+		e.markArray(pos, pos+8)
+		if l, ok := e.offset(pos, pos+10); ok {
+			count := e.uint16At(l)
+			for i := 0; i < count && e.err == nil; i++ {
+				if attach, ok := e.offset(l, l+2+i*2); ok {
+					e.anchorMatrix(attach, classCount)
+				}
+			}
+		}
+	case lookupType == 7 && format == 1:
+		e.coverage(pos, pos+2)
+		e.ruleSets(pos, pos+6, e.uint16At(pos+4), e.sequenceRule)
+	case lookupType == 7 && format == 2:
+		e.coverage(pos, pos+2)
+		e.classDef(pos, pos+4)
+		e.ruleSets(pos, pos+8, e.uint16At(pos+6), e.sequenceRule)
+	case lookupType == 7 && format == 3:
+		glyphCount, lookupCount := e.uint16At(pos+2), e.uint16At(pos+4)
+		for i := 0; i < glyphCount && e.err == nil; i++ {
+			e.coverage(pos, pos+6+i*2)
+		}
+		e.span(pos+6+glyphCount*2, lookupCount*4)
+	case lookupType == 8 && format == 1:
+		e.coverage(pos, pos+2)
+		e.ruleSets(pos, pos+6, e.uint16At(pos+4), e.chainedSequenceRule)
+	case lookupType == 8 && format == 2:
+		e.coverage(pos, pos+2)
+		e.classDef(pos, pos+4)
+		e.classDef(pos, pos+6)
+		e.classDef(pos, pos+8)
+		e.ruleSets(pos, pos+12, e.uint16At(pos+10), e.chainedSequenceRule)
+	case lookupType == 8 && format == 3:
+		p := pos + 2
+		for k := 0; k < 3 && e.err == nil; k++ {
+			count := e.uint16At(p)
+			for i := 0; i < count && e.err == nil; i++ {
+				e.coverage(pos, p+2+i*2)
+			}
+			p += 2 + count*2
+		}
+		e.span(p+2, e.uint16At(p)*4)
+	default:
+		e.fail("GPOS lookup type "+strconv.Itoa(int(lookupType))+" subtable", format)
+	}
+}
+
+// gposSubtableLength returns the length of the GPOS subtable at pos
+// together with the coverage, class, anchor and device tables it refers to.
+func gposSubtableLength(data []byte, pos int, lookupType uint16) (int, error) {
+	e := &gposExtent{data: data, end: pos}
+	e.subtable(pos, lookupType)
+	if e.err != nil {
+		return 0, e.err
+	}
+	return e.end - pos, nil
+}
+
+// valueRecordSize returns the encoded size of a ValueRecord, including any
+// device offsets the format declares.
+func valueRecordSize(valueFormat uint16) int {
+	size := 0
+	for f := valueFormat & 0x00FF; f != 0; f >>= 1 {
+		size += int(f&1) * 2
+	}
+	return size
+}
+
+// getValueRecord reads a value record; device offsets are relative to the
+// subtable at base.
+func getValueRecord(data []byte, pos int, valueFormat uint16, base int) (value ValueRecord, err error) {
+	fields := []*int16{&value.XPlacement, &value.YPlacement, &value.XAdvance, &value.YAdvance}
+	for bit, field := range fields {
+		if valueFormat&(1<<uint(bit)) != 0 {
+			*field = getInt16(data[pos : pos+2])
+			pos += 2
+		}
+	}
+	devices := []**DeviceTable{&value.XPlaDevice, &value.YPlaDevice, &value.XAdvDevice, &value.YAdvDevice}
+	for bit, device := range devices {
+		if valueFormat&(1<<uint(bit+4)) == 0 {
+			continue
+		}
+		if offset := int(getUint16(data[pos : pos+2])); offset != 0 {
+			if *device, err = getDeviceTable(data, base+offset); err != nil {
+				return
+			}
+		}
+		pos += 2
+	}
+	return
+}
+
+// deviceTables lays out the device tables of a subtable's value records
+// from base on, sharing identical ones.
+type deviceTables struct {
+	base    int
+	data    []byte
+	offsets map[string]int
+}
+
+func (devices *deviceTables) offset(device *DeviceTable) uint16 {
+	if device == nil {
+		return 0
+	}
+	encoded := writeDeviceTable(device)
+	if devices.offsets == nil {
+		devices.offsets = make(map[string]int)
+	}
+	offset, ok := devices.offsets[string(encoded)]
+	if !ok {
+		offset = devices.base + len(devices.data)
+		devices.offsets[string(encoded)] = offset
+		devices.data = append(devices.data, encoded...)
+	}
+	return uint16(offset)
+}
+
+func writeValueRecord(value ValueRecord, valueFormat uint16, devices *deviceTables) []byte {
+	data := []byte{}
+	fields := []int16{value.XPlacement, value.YPlacement, value.XAdvance, value.YAdvance}
+	for bit, field := range fields {
+		if valueFormat&(1<<uint(bit)) != 0 {
+			data = append(data, writeInt16(field)...)
+		}
+	}
+	for bit, device := range []*DeviceTable{value.XPlaDevice, value.YPlaDevice, value.XAdvDevice, value.YAdvDevice} {
+		if valueFormat&(1<<uint(bit+4)) != 0 {
+			data = append(data, writeUint16(devices.offset(device))...)
+		}
+	}
+	return data
+}
+
+func getPairPos(data []byte, pos int) (pairPos *PairPos, err error) {
+	if pos+10 > len(data) {
+		err = errors.New("PairPos subtable truncated")
+		return
+	}
+	pairPos = &PairPos{Format: getUint16(data[pos : pos+2])}
+	var coverage []uint16
+	if coverage, err = getCoverage(data, pos+int(getUint16(data[pos+2:pos+4]))); err != nil {
+		return
+	}
+	valueFormat1 := getUint16(data[pos+4 : pos+6])
+	valueFormat2 := getUint16(data[pos+6 : pos+8])
+	size1, size2 := valueRecordSize(valueFormat1), valueRecordSize(valueFormat2)
+	pairPos.ValueFormat1 = valueFormat1 & 0x00FF
+	pairPos.ValueFormat2 = valueFormat2 & 0x00FF
+
+	switch pairPos.Format {
+	case 1:
+		pairSetCount := int(getUint16(data[pos+8 : pos+10]))
+		if pos+10+pairSetCount*2 > len(data) || pairSetCount > len(coverage) {
+			err = errors.New("PairPos pair set offsets truncated")
+			return
+		}
+		pairPos.PairSets = make(map[uint16][]*PairValueRecord)
+		recordSize := 2 + size1 + size2
+		for i := 0; i < pairSetCount; i++ {
+			sPos := pos + int(getUint16(data[pos+10+i*2:pos+12+i*2]))
+			if sPos+2 > len(data) {
+				err = errors.New("PairPos pair set truncated")
+				return
+			}
+			pairValueCount := int(getUint16(data[sPos : sPos+2]))
+			if sPos+2+pairValueCount*recordSize > len(data) {
+				err = errors.New("PairPos pair value records truncated")
+				return
+			}
+			records := make([]*PairValueRecord, 0, pairValueCount)
+			for j := 0; j < pairValueCount; j++ {
+				rPos := sPos + 2 + j*recordSize
+				record := &PairValueRecord{SecondGlyph: getUint16(data[rPos : rPos+2])}
+				if record.Value1, err = getValueRecord(data, rPos+2, valueFormat1, pos); err != nil {
+					return
+				}
+				if record.Value2, err = getValueRecord(data, rPos+2+size1, valueFormat2, pos); err != nil {
+					return
+				}
+				records = append(records, record)
+			}
+			pairPos.PairSets[coverage[i]] = records
+		}
+	case 2:
+		if pos+16 > len(data) {
+			err = errors.New("PairPos format 2 header truncated")
+			return
+		}
+		pairPos.Coverage = coverage
+		if pairPos.ClassDef1, err = getClassDef(data, pos+int(getUint16(data[pos+8:pos+10]))); err != nil {
+			return
+		}
+		if pairPos.ClassDef2, err = getClassDef(data, pos+int(getUint16(data[pos+10:pos+12]))); err != nil {
+			return
+		}
+		class1Count := int(getUint16(data[pos+12 : pos+14]))
+		class2Count := int(getUint16(data[pos+14 : pos+16]))
+		if pos+16+class1Count*class2Count*(size1+size2) > len(data) {
+			err = errors.New("PairPos class records truncated")
+			return
+		}
+		rPos := pos + 16
+		pairPos.ClassValues = make([][]*PairClassValue, class1Count)
+		for c1 := 0; c1 < class1Count; c1++ {
+			row := make([]*PairClassValue, class2Count)
+			for c2 := 0; c2 < class2Count; c2++ {
+				value := &PairClassValue{}
+				if value.Value1, err = getValueRecord(data, rPos, valueFormat1, pos); err != nil {
+					return
+				}
+				if value.Value2, err = getValueRecord(data, rPos+size1, valueFormat2, pos); err != nil {
+					return
+				}
+				row[c2] = value
+				rPos += size1 + size2
+			}
+			pairPos.ClassValues[c1] = row
+		}
+	default:
+		err = errors.New("unsupported PairPos format " + strconv.Itoa(int(pairPos.Format)))
+	}
+	return
+}
+
+// writePairPos writes a pair adjustment subtable with the device tables of
+// its value records at the end.
+func writePairPos(pairPos *PairPos) []byte {
+	size1, size2 := valueRecordSize(pairPos.ValueFormat1), valueRecordSize(pairPos.ValueFormat2)
+	data := []byte{}
+	if pairPos.Format == 2 {
+		class2Count := 0
+		if len(pairPos.ClassValues) > 0 {
+			class2Count = len(pairPos.ClassValues[0])
+		}
+		coverage := writeCoverage(pairPos.Coverage)
+		classDef1 := writeClassDef(pairPos.ClassDef1)
+		classDef2 := writeClassDef(pairPos.ClassDef2)
+		coverageOffset := 16 + len(pairPos.ClassValues)*class2Count*(size1+size2)
+		devices := &deviceTables{base: coverageOffset + len(coverage) + len(classDef1) + len(classDef2)}
+		records := []byte{}
+		for _, row := range pairPos.ClassValues {
+			for c2 := 0; c2 < class2Count; c2++ {
+				value := &PairClassValue{}
+				if c2 < len(row) && row[c2] != nil {
+					value = row[c2]
+				}
+				records = append(records, writeValueRecord(value.Value1, pairPos.ValueFormat1, devices)...)
+				records = append(records, writeValueRecord(value.Value2, pairPos.ValueFormat2, devices)...)
+			}
+		}
+
+		data = append(data, writeUint16(2)...)
+		data = append(data, writeUint16(uint16(coverageOffset))...)
+		data = append(data, writeUint16(pairPos.ValueFormat1)...)
+		data = append(data, writeUint16(pairPos.ValueFormat2)...)
+		data = append(data, writeUint16(uint16(coverageOffset+len(coverage)))...)
+		data = append(data, writeUint16(uint16(coverageOffset+len(coverage)+len(classDef1)))...)
+		data = append(data, writeUint16(uint16(len(pairPos.ClassValues)))...)
+		data = append(data, writeUint16(uint16(class2Count))...)
+		data = append(data, records...)
+		data = append(data, coverage...)
+		data = append(data, classDef1...)
+		data = append(data, classDef2...)
+		return append(data, devices.data...)
+	}
+
+	firsts := make([]uint16, 0, len(pairPos.PairSets))
+	for g := range pairPos.PairSets {
+		firsts = append(firsts, g)
+	}
+	sort.Slice(firsts, func(i, j int) bool { return firsts[i] < firsts[j] })
+
+	headerSize := 10 + len(firsts)*2
+	setsSize := 0
+	for _, g := range firsts {
+		setsSize += 2 + len(pairPos.PairSets[g])*(2+size1+size2)
+	}
+	coverage := writeCoverage(firsts)
+	devices := &deviceTables{base: headerSize + setsSize + len(coverage)}
+	offsets := []byte{}
+	sets := []byte{}
+	for _, g := range firsts {
+		offsets = append(offsets, writeUint16(uint16(headerSize+len(sets)))...)
+		records := pairPos.PairSets[g]
+		sort.SliceStable(records, func(i, j int) bool { return records[i].SecondGlyph < records[j].SecondGlyph })
+		sets = append(sets, writeUint16(uint16(len(records)))...)
+		for _, record := range records {
+			sets = append(sets, writeUint16(record.SecondGlyph)...)
+			sets = append(sets, writeValueRecord(record.Value1, pairPos.ValueFormat1, devices)...)
+			sets = append(sets, writeValueRecord(record.Value2, pairPos.ValueFormat2, devices)...)
+		}
+	}
+	data = append(data, writeUint16(1)...)
+	data = append(data, writeUint16(uint16(headerSize+len(sets)))...)
+	data = append(data, writeUint16(pairPos.ValueFormat1)...)
+	data = append(data, writeUint16(pairPos.ValueFormat2)...)
+	data = append(data, writeUint16(uint16(len(firsts)))...)
+	data = append(data, offsets...)
+	data = append(data, sets...)
+	data = append(data, coverage...)
+	return append(data, devices.data...)
+}
+
+func writeLangSys(langSys *LangSys) []byte {
+	data := []byte{}
+	data = append(data, writeUint16(0)...)
+	data = append(data, writeUint16(langSys.RequiredFeatureIndex)...)
+	data = append(data, writeUint16(uint16(len(langSys.FeatureIndices)))...)
+	for _, index := range langSys.FeatureIndices {
+		data = append(data, writeUint16(index)...)
+	}
+	return data
+}
+
+func writeTag(tag string) []byte {
+	buf := []byte{' ', ' ', ' ', ' '}
+	copy(buf, tag)
+	return buf
+}
+
+func writeScriptList(scripts []*ScriptRecord) []byte {
+	headerSize := 2 + len(scripts)*6
+	records := []byte{}
+	tables := []byte{}
+	for _, script := range scripts {
+		records = append(records, writeTag(script.Tag)...)
+		records = append(records, writeUint16(uint16(headerSize+len(tables)))...)
+
+		tags := make([]string, 0, len(script.LangSys))
+		for tag := range script.LangSys {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+
+		scriptHeaderSize := 4 + len(tags)*6
+		langSysData := []byte{}
+		defaultOffset := 0
+		if script.DefaultLangSys != nil {
+			defaultOffset = scriptHeaderSize
+			langSysData = append(langSysData, writeLangSys(script.DefaultLangSys)...)
+		}
+		table := []byte{}
+		table = append(table, writeUint16(uint16(defaultOffset))...)
+		table = append(table, writeUint16(uint16(len(tags)))...)
+		for _, tag := range tags {
+			table = append(table, writeTag(tag)...)
+			table = append(table, writeUint16(uint16(scriptHeaderSize+len(langSysData)))...)
+			langSysData = append(langSysData, writeLangSys(script.LangSys[tag])...)
+		}
+		tables = append(tables, table...)
+		tables = append(tables, langSysData...)
+	}
+
+	data := []byte{}
+	data = append(data, writeUint16(uint16(len(scripts)))...)
+	data = append(data, records...)
+	data = append(data, tables...)
+	return data
+}
+
+// writeFeatureList writes the feature records, sharing identical feature
+// tables.
+func writeFeatureList(features []*FeatureRecord) []byte {
+	headerSize := 2 + len(features)*6
+	records := []byte{}
+	tables := []byte{}
+	offsets := make(map[string]int)
+	for _, feature := range features {
+		table := writeUint16(0)
+		table = append(table, writeUint16(uint16(len(feature.LookupIndices)))...)
+		for _, index := range feature.LookupIndices {
+			table = append(table, writeUint16(index)...)
+		}
+		offset, ok := offsets[string(table)]
+		if !ok {
+			offset = headerSize + len(tables)
+			offsets[string(table)] = offset
+			tables = append(tables, table...)
+		}
+		records = append(records, writeTag(feature.Tag)...)
+		records = append(records, writeUint16(uint16(offset))...)
+	}
+
+	data := []byte{}
+	data = append(data, writeUint16(uint16(len(features)))...)
+	data = append(data, records...)
+	data = append(data, tables...)
+	return data
+}
+
+// writeGposLookupList lays every lookup out with its subtables directly
+// after it. When that would overflow an Offset16, all lookups are written as
+// extension lookups instead, with the subtables moved behind them and
+// reached through Offset32.
+func writeGposLookupList(lookups []*GposLookup) []byte {
+	subtables := make([][][]byte, len(lookups))
+	for i, lookup := range lookups {
+		for _, pairPos := range lookup.PairPos {
+			subtables[i] = append(subtables[i], writePairPos(pairPos))
+		}
+		subtables[i] = append(subtables[i], lookup.Subtables...)
+	}
+	lookupHeader := func(lookup *GposLookup, lookupType uint16, subtableOffsets []int) []byte {
+		header := []byte{}
+		header = append(header, writeUint16(lookupType)...)
+		header = append(header, writeUint16(lookup.LookupFlag)...)
+		header = append(header, writeUint16(uint16(len(subtableOffsets)))...)
+		for _, offset := range subtableOffsets {
+			header = append(header, writeUint16(uint16(offset))...)
+		}
+		if lookup.LookupFlag&LOOKUP_FLAG_USE_MARK_FILTERING_SET != 0 {
+			header = append(header, writeUint16(lookup.MarkFilteringSet)...)
+		}
+		return header
+	}
+	lookupHeaderSize := func(lookup *GposLookup, count int) int {
+		size := 6 + count*2
+		if lookup.LookupFlag&LOOKUP_FLAG_USE_MARK_FILTERING_SET != 0 {
+			size += 2
+		}
+		return size
+	}
+
+	headerSize := 2 + len(lookups)*2
+	offsets := []byte{}
+	body := []byte{}
+	overflow := false
+	for i, lookup := range lookups {
+		if headerSize+len(body) > 0xFFFF {
+			overflow = true
+			break
+		}
+		offsets = append(offsets, writeUint16(uint16(headerSize+len(body)))...)
+		size := lookupHeaderSize(lookup, len(subtables[i]))
+		subtableOffsets := []int{}
+		for _, sub := range subtables[i] {
+			if size > 0xFFFF {
+				overflow = true
+			}
+			subtableOffsets = append(subtableOffsets, size)
+			size += len(sub)
+		}
+		body = append(body, lookupHeader(lookup, lookup.LookupType, subtableOffsets)...)
+		for _, sub := range subtables[i] {
+			body = append(body, sub...)
+		}
+	}
+
+	if overflow {
+		offsets = []byte{}
+		body = []byte{}
+		tablesSize := headerSize
+		for i, lookup := range lookups {
+			tablesSize += lookupHeaderSize(lookup, len(subtables[i])) + len(subtables[i])*8
+		}
+		trailer := []byte{}
+		for i, lookup := range lookups {
+			lookupStart := headerSize + len(body)
+			offsets = append(offsets, writeUint16(uint16(lookupStart))...)
+			lookupType := lookup.LookupType
+			if len(subtables[i]) > 0 {
+				lookupType = GPOS_LOOKUP_EXTENSION
+			}
+			size := lookupHeaderSize(lookup, len(subtables[i]))
+			subtableOffsets := []int{}
+			for j := range subtables[i] {
+				subtableOffsets = append(subtableOffsets, size+j*8)
+			}
+			body = append(body, lookupHeader(lookup, lookupType, subtableOffsets)...)
+			for j, sub := range subtables[i] {
+				extensionStart := lookupStart + size + j*8
+				body = append(body, writeUint16(1)...)
+				body = append(body, writeUint16(lookup.LookupType)...)
+				body = append(body, writeUint32(uint32(tablesSize+len(trailer)-extensionStart))...)
+				trailer = append(trailer, sub...)
+			}
+		}
+		body = append(body, trailer...)
+	}
+
+	data := []byte{}
+	data = append(data, writeUint16(uint16(len(lookups)))...)
+	data = append(data, offsets...)
+	data = append(data, body...)
+	return data
+}
+
+// adjustment returns the value records this subtable applies to the pair
+// and whether the subtable matched it at all.
+func (pairPos *PairPos) adjustment(first, second uint16) (value *PairClassValue, matched bool) {
+	if pairPos.Format == 2 {
+		i := sort.Search(len(pairPos.Coverage), func(i int) bool { return pairPos.Coverage[i] >= first })
+		if i == len(pairPos.Coverage) || pairPos.Coverage[i] != first {
+			return
+		}
+		c1, c2 := int(pairPos.ClassDef1[first]), int(pairPos.ClassDef2[second])
+		if c1 >= len(pairPos.ClassValues) || c2 >= len(pairPos.ClassValues[c1]) || pairPos.ClassValues[c1][c2] == nil {
+			return
+		}
+		return pairPos.ClassValues[c1][c2], true
+	}
+	for _, record := range pairPos.PairSets[first] {
+		if record.SecondGlyph == second {
+			return &PairClassValue{record.Value1, record.Value2}, true
+		}
+	}
+	return
+}
+
+// featureLookups returns the sorted, de-duplicated lookup indices of every
+// feature with the given tag.
+func (gpos *Gpos) featureLookups(tag string) []uint16 {
+	seen := make(map[uint16]bool)
+	var indices []uint16
+	for _, feature := range gpos.Features {
+		if feature.Tag != tag {
+			continue
+		}
+		for _, index := range feature.LookupIndices {
+			if !seen[index] && int(index) < len(gpos.Lookups) {
+				seen[index] = true
+				indices = append(indices, index)
+			}
+		}
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	return indices
+}
+
+// Kerning returns the horizontal adjustment the 'kern' feature applies
+// between two glyphs: the first glyph's XAdvance summed over the feature's
+// pair lookups, each lookup contributing its first matching subtable.
+func (gpos *Gpos) Kerning(left, right uint16) int16 {
+	return gpos.pairKerning(gpos.featureLookups("kern"), left, right)
+}
+
+func (gpos *Gpos) pairKerning(lookupIndices []uint16, left, right uint16) int16 {
+	var value int16
+	for _, index := range lookupIndices {
+		for _, pairPos := range gpos.Lookups[index].PairPos {
+			if v, matched := pairPos.adjustment(left, right); matched {
+				value += v.Value1.XAdvance
+				break
+			}
+		}
+	}
+	return value
+}
+
+// gposWriter lays out a table followed by the tables its Offset16s point
+// at, sharing identical ones.
+type gposWriter struct {
+	head []byte
+	refs []int
+	subs [][]byte
+}
+
+func (w *gposWriter) uint16(v int) {
+	w.head = append(w.head, writeUint16(uint16(v))...)
+}
+
+// offset appends an Offset16 to sub, or a null offset when sub is nil
+func (w *gposWriter) offset(sub []byte) {
+	w.refs = append(w.refs, len(w.head))
+	w.subs = append(w.subs, sub)
+	w.head = append(w.head, 0, 0)
+}
+
+func (w *gposWriter) bytes() ([]byte, error) {
+	data := w.head
+	offsets := make(map[string]int)
+	for i, at := range w.refs {
+		sub := w.subs[i]
+		if sub == nil {
+			continue
+		}
+		offset, ok := offsets[string(sub)]
+		if !ok {
+			offset = len(data)
+			offsets[string(sub)] = offset
+			data = append(data, sub...)
+		}
+		if offset > 0xFFFF {
+			return nil, errors.New("GPOS subtable offset " + strconv.Itoa(offset) + " overflows Offset16")
+		}
+		copy(data[at:], writeUint16(uint16(offset)))
+	}
+	return data, nil
+}
+
+// coveredGlyph is a retained glyph of a coverage table with its new glyph
+// ID and its index in the original coverage
+type coveredGlyph struct {
+	glyph uint16
+	index int
+}
+
+func coveredGlyphIDs(covered []coveredGlyph) []uint16 {
+	glyphs := make([]uint16, len(covered))
+	for i, c := range covered {
+		glyphs[i] = c.glyph
+	}
+	return glyphs
+}
+
+// gposSubsetter rebuilds a raw GPOS subtable for a subset font, renumbering
+// its glyphs through oldToNew and dropping the records of glyphs that are
+// not retained. The subtable has passed gposSubtableLength, so every table
+// it references lies within data.
+type gposSubsetter struct {
+	data     []byte
+	oldToNew map[int]int
+	err      error
+}
+
+func (s *gposSubsetter) uint16At(pos int) int {
+	return int(getUint16(s.data[pos : pos+2]))
+}
+
+// target returns where the Offset16 at pos, relative to base, points, or -1
+// for a null offset
+func (s *gposSubsetter) target(base, pos int) int {
+	if offset := s.uint16At(pos); offset != 0 {
+		return base + offset
+	}
+	return -1
+}
+
+func (s *gposSubsetter) bytes(w *gposWriter) []byte {
+	data, err := w.bytes()
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	return data
+}
+
+// coverage returns the retained glyphs of the coverage table the Offset16
+// at pos points at, sorted by new glyph ID and limited to the first count
+// coverage indices
+func (s *gposSubsetter) coverage(base, pos, count int) []coveredGlyph {
+	glyphs, _ := getCoverage(s.data, base+s.uint16At(pos))
+	var covered []coveredGlyph
+	for i, g := range glyphs {
+		if newGlyph, ok := s.oldToNew[int(g)]; ok && i < count {
+			covered = append(covered, coveredGlyph{uint16(newGlyph), i})
+		}
+	}
+	sort.Slice(covered, func(i, j int) bool { return covered[i].glyph < covered[j].glyph })
+	return covered
+}
+
+// classDef returns the renumbered class definition the Offset16 at pos
+// points at, or nil for a null offset
+func (s *gposSubsetter) classDef(base, pos int) []byte {
+	target := s.target(base, pos)
+	if target < 0 {
+		return nil
+	}
+	classes, _ := getClassDef(s.data, target)
+	return writeClassDef(remapClassDef(classes, s.oldToNew))
+}
+
+// device copies the device table the Offset16 at pos points at
+func (s *gposSubsetter) device(base, pos int) []byte {
+	target := s.target(base, pos)
+	if target < 0 {
+		return nil
+	}
+	e := &gposExtent{data: s.data, end: target}
+	e.device(base, pos)
+	return append([]byte{}, s.data[target:e.end]...)
+}
+
+func (s *gposSubsetter) anchor(base, pos int) []byte {
+	a := s.target(base, pos)
+	if a < 0 {
+		return nil
+	}
+	w := &gposWriter{head: append([]byte{}, s.data[a:a+6]...)}
+	switch s.uint16At(a) {
+	case 2:
+		w.head = append(w.head, s.data[a+6:a+8]...)
+	case 3:
+		w.offset(s.device(a, a+6))
+		w.offset(s.device(a, a+8))
+	}
+	return s.bytes(w)
+}
+
+// valueRecord appends the value record at pos and its device tables to w
+func (s *gposSubsetter) valueRecord(w *gposWriter, base, pos, valueFormat int) {
+	for bit := 0; bit < 8; bit++ {
+		if valueFormat&(1<<uint(bit)) == 0 {
+			continue
+		}
+		if bit < 4 {
+			w.head = append(w.head, s.data[pos:pos+2]...)
+		} else {
+			w.offset(s.device(base, pos))
+		}
+		pos += 2
+	}
+}
+
+// markArray writes the records of the retained marks of the MarkArray at m
+func (s *gposSubsetter) markArray(m int, marks []coveredGlyph) []byte {
+	w := &gposWriter{}
+	w.uint16(len(marks))
+	for _, mark := range marks {
+		record := m + 2 + mark.index*4
+		w.head = append(w.head, s.data[record:record+2]...)
+		w.offset(s.anchor(m, record+2))
+	}
+	return s.bytes(w)
+}
+
+// anchorMatrix writes the given rows of the BaseArray, Mark2Array or
+// LigatureAttach at m
+func (s *gposSubsetter) anchorMatrix(m int, rows []int, classCount int) []byte {
+	w := &gposWriter{}
+	w.uint16(len(rows))
+	for _, row := range rows {
+		for class := 0; class < classCount; class++ {
+			w.offset(s.anchor(m, m+2+(row*classCount+class)*2))
+		}
+	}
+	return s.bytes(w)
+}
+
+// rule copies the sequence rule at r, or the chained sequence rule when
+// chained is set. Glyph rules are renumbered and dropped, by returning nil,
+// when they use a glyph that is not retained; class rules are copied as is.
+func (s *gposSubsetter) rule(r int, chained, glyphs bool) []byte {
+	data := []byte{}
+	p := r
+	sequence := func(count int) bool {
+		for i := 0; i < count; i++ {
+			v := s.uint16At(p)
+			p += 2
+			if glyphs {
+				newGlyph, ok := s.oldToNew[v]
+				if !ok {
+					return false
+				}
+				v = newGlyph
+			}
+			data = append(data, writeUint16(uint16(v))...)
+		}
+		return true
+	}
+	// counted copies a count and the sequence it counts; the input sequence
+	// leaves out its first glyph
+	counted := func(input bool) bool {
+		count := s.uint16At(p)
+		data = append(data, s.data[p:p+2]...)
+		p += 2
+		if input && count > 0 {
+			count--
+		}
+		return sequence(count)
+	}
+	if chained {
+		if !counted(false) || !counted(true) || !counted(false) {
+			return nil
+		}
+		lookupCount := s.uint16At(p)
+		return append(data, s.data[p:p+2+lookupCount*4]...)
+	}
+	glyphCount, lookupCount := s.uint16At(p), s.uint16At(p+2)
+	data = append(data, s.data[p:p+4]...)
+	p += 4
+	if glyphCount > 0 {
+		glyphCount--
+	}
+	if !sequence(glyphCount) {
+		return nil
+	}
+	return append(data, s.data[p:p+lookupCount*4]...)
+}
+
+// ruleSet writes the rule set at set with the rules that are kept, or
+// returns nil when none is
+func (s *gposSubsetter) ruleSet(set int, chained, glyphs bool) []byte {
+	if set < 0 {
+		return nil
+	}
+	var rules [][]byte
+	count := s.uint16At(set)
+	for i := 0; i < count; i++ {
+		if r := s.target(set, set+2+i*2); r >= 0 {
+			if rule := s.rule(r, chained, glyphs); rule != nil {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	w := &gposWriter{}
+	w.uint16(len(rules))
+	for _, rule := range rules {
+		w.offset(rule)
+	}
+	return s.bytes(w)
+}
+
+// coverages writes count Offset16s to renumbered coverage tables from pos
+// and reports false when one of them has no retained glyph left
+func (s *gposSubsetter) coverages(w *gposWriter, pos, count int) bool {
+	for i := 0; i < count; i++ {
+		covered := s.coverage(0, pos+i*2, 0xFFFF)
+		if len(covered) == 0 {
+			return false
+		}
+		w.offset(writeCoverage(coveredGlyphIDs(covered)))
+	}
+	return true
+}
+
+// subtable rebuilds the subtable, or returns nil when nothing of it
+// applies to the retained glyphs
+func (s *gposSubsetter) subtable(lookupType uint16) []byte {
+	format := s.uint16At(0)
+	w := &gposWriter{}
+	w.uint16(format)
+	switch {
+	case lookupType == GPOS_LOOKUP_SINGLE && format == 1:
+		covered := s.coverage(0, 2, 0xFFFF)
+		if len(covered) == 0 {
+			return nil
+		}
+		valueFormat := s.uint16At(4)
+		w.offset(writeCoverage(coveredGlyphIDs(covered)))
+		w.uint16(valueFormat)
+		s.valueRecord(w, 0, 6, valueFormat)
+	case lookupType == GPOS_LOOKUP_SINGLE && format == 2:
+		covered := s.coverage(0, 2, s.uint16At(6))
+		if len(covered) == 0 {
+			return nil
+		}
+		valueFormat := s.uint16At(4)
+		size := valueRecordSize(uint16(valueFormat))
+		w.offset(writeCoverage(coveredGlyphIDs(covered)))
+		w.uint16(valueFormat)
+		w.uint16(len(covered))
+		for _, c := range covered {
+			s.valueRecord(w, 0, 8+c.index*size, valueFormat)
+		}
+	case lookupType == GPOS_LOOKUP_CURSIVE && format == 1:
+		covered := s.coverage(0, 2, s.uint16At(4))
+		if len(covered) == 0 {
+			return nil
+		}
+		w.offset(writeCoverage(coveredGlyphIDs(covered)))
+		w.uint16(len(covered))
+		for _, c := range covered {
+			w.offset(s.anchor(0, 6+c.index*4))
+			w.offset(s.anchor(0, 8+c.index*4))
+		}
+	case (lookupType == GPOS_LOOKUP_MARK_TO_BASE || lookupType == GPOS_LOOKUP_MARK_TO_LIGATURE ||
+		lookupType == GPOS_LOOKUP_MARK_TO_MARK) && format == 1:
+		markArray, baseArray := s.target(0, 8), s.target(0, 10)
+		if markArray < 0 || baseArray < 0 {
+			return nil
+		}
+		marks := s.coverage(0, 2, s.uint16At(markArray))
+		bases := s.coverage(0, 4, s.uint16At(baseArray))
+		if len(marks) == 0 || len(bases) == 0 {
+			return nil
+		}
+		classCount := s.uint16At(6)
+		w.offset(writeCoverage(coveredGlyphIDs(marks)))
+		w.offset(writeCoverage(coveredGlyphIDs(bases)))
+		w.uint16(classCount)
+		w.offset(s.markArray(markArray, marks))
+		if lookupType != GPOS_LOOKUP_MARK_TO_LIGATURE {
+			rows := make([]int, len(bases))
+			for i, base := range bases {
+				rows[i] = base.index
+			}
+			w.offset(s.anchorMatrix(baseArray, rows, classCount))
+			break
+		}
+		// the ligature array holds one anchor matrix per ligature, with a
+		// row per component
+		ligatures := &gposWriter{}
+		ligatures.uint16(len(bases))
+		for _, ligature := range bases {
+			attach := s.target(baseArray, baseArray+2+ligature.index*2)
+			if attach < 0 {
+				ligatures.offset(nil)
+				continue
+			}
+			rows := make([]int, s.uint16At(attach))
+			for i := range rows {
+				rows[i] = i
+			}
+			ligatures.offset(s.anchorMatrix(attach, rows, classCount))
+		}
+		w.offset(s.bytes(ligatures))
+	case (lookupType == GPOS_LOOKUP_CONTEXT || lookupType == GPOS_LOOKUP_CHAINED_CONTEXT) && format == 1:
+		chained := lookupType == GPOS_LOOKUP_CHAINED_CONTEXT
+		var glyphs []uint16
+		var sets [][]byte
+		for _, c := range s.coverage(0, 2, s.uint16At(4)) {
+			if set := s.ruleSet(s.target(0, 6+c.index*2), chained, true); set != nil {
+				glyphs = append(glyphs, c.glyph)
+				sets = append(sets, set)
+			}
+		}
+		if len(glyphs) == 0 {
+			return nil
+		}
+		w.offset(writeCoverage(glyphs))
+		w.uint16(len(sets))
+		for _, set := range sets {
+			w.offset(set)
+		}
+	case (lookupType == GPOS_LOOKUP_CONTEXT || lookupType == GPOS_LOOKUP_CHAINED_CONTEXT) && format == 2:
+		chained := lookupType == GPOS_LOOKUP_CHAINED_CONTEXT
+		covered := s.coverage(0, 2, 0xFFFF)
+		if len(covered) == 0 {
+			return nil
+		}
+		w.offset(writeCoverage(coveredGlyphIDs(covered)))
+		w.offset(s.classDef(0, 4))
+		p := 6
+		if chained {
+			w.offset(s.classDef(0, 6))
+			w.offset(s.classDef(0, 8))
+			p = 10
+		}
+		count := s.uint16At(p)
+		w.uint16(count)
+		for i := 0; i < count; i++ {
+			w.offset(s.ruleSet(s.target(0, p+2+i*2), chained, false))
+		}
+	case lookupType == GPOS_LOOKUP_CONTEXT && format == 3:
+		glyphCount, lookupCount := s.uint16At(2), s.uint16At(4)
+		w.uint16(glyphCount)
+		w.uint16(lookupCount)
+		if !s.coverages(w, 6, glyphCount) {
+			return nil
+		}
+		p := 6 + glyphCount*2
+		w.head = append(w.head, s.data[p:p+lookupCount*4]...)
+	case lookupType == GPOS_LOOKUP_CHAINED_CONTEXT && format == 3:
+		p := 2
+		for k := 0; k < 3; k++ {
+			count := s.uint16At(p)
+			w.uint16(count)
+			if !s.coverages(w, p+2, count) {
+				return nil
+			}
+			p += 2 + count*2
+		}
+		lookupCount := s.uint16At(p)
+		w.head = append(w.head, s.data[p:p+2+lookupCount*4]...)
+	default:
+		s.err = errors.New("unsupported GPOS lookup type " + strconv.Itoa(int(lookupType)) + " subtable format " + strconv.Itoa(format))
+		return nil
+	}
+	return s.bytes(w)
+}
+
+// remapGpos rewrites the glyph IDs of every subtable through oldToNew,
+// dropping pairs, coverage and class entries, rules and whole subtables
+// that only concern glyphs that are not retained. Lookups are kept, even
+// when left without subtables, so lookup indices stay valid.
+func remapGpos(gpos *Gpos, oldToNew map[int]int) error {
+	for i, lookup := range gpos.Lookups {
+		var subtables [][]byte
+		for j, sub := range lookup.Subtables {
+			if _, err := gposSubtableLength(sub, 0, lookup.LookupType); err != nil {
+				return errors.New("GPOS lookup " + strconv.Itoa(i) + " subtable " + strconv.Itoa(j) + ": " + err.Error())
+			}
+			subsetter := &gposSubsetter{data: sub, oldToNew: oldToNew}
+			remapped := subsetter.subtable(lookup.LookupType)
+			if subsetter.err != nil {
+				return errors.New("GPOS lookup " + strconv.Itoa(i) + " subtable " + strconv.Itoa(j) + ": " + subsetter.err.Error())
+			}
+			if remapped != nil {
+				subtables = append(subtables, remapped)
+			}
+		}
+		lookup.Subtables = subtables
+	}
+	for _, lookup := range gpos.Lookups {
+		var kept []*PairPos
+		for _, pairPos := range lookup.PairPos {
+			if pairPos.Format == 2 {
+				var coverage []uint16
+				for _, g := range pairPos.Coverage {
+					if newIdx, ok := oldToNew[int(g)]; ok {
+						coverage = append(coverage, uint16(newIdx))
+					}
+				}
+				sort.Slice(coverage, func(i, j int) bool { return coverage[i] < coverage[j] })
+				pairPos.Coverage = coverage
+				pairPos.ClassDef1 = remapClassDef(pairPos.ClassDef1, oldToNew)
+				pairPos.ClassDef2 = remapClassDef(pairPos.ClassDef2, oldToNew)
+				if len(coverage) > 0 {
+					kept = append(kept, pairPos)
+				}
+				continue
+			}
+			pairSets := make(map[uint16][]*PairValueRecord)
+			for first, records := range pairPos.PairSets {
+				newFirst, ok := oldToNew[int(first)]
+				if !ok {
+					continue
+				}
+				var newRecords []*PairValueRecord
+				for _, record := range records {
+					if newSecond, ok := oldToNew[int(record.SecondGlyph)]; ok {
+						newRecords = append(newRecords, &PairValueRecord{uint16(newSecond), record.Value1, record.Value2})
+					}
+				}
+				if len(newRecords) > 0 {
+					pairSets[uint16(newFirst)] = newRecords
+				}
+			}
+			pairPos.PairSets = pairSets
+			if len(pairSets) > 0 {
+				kept = append(kept, pairPos)
+			}
+		}
+		lookup.PairPos = kept
+	}
+	return nil
+}
+
+// KERN_MAX_PAIRS_PER_SUBTABLE is the most pairs a format 0 kern subtable can
+// hold before its uint16 length field overflows (14 + 10920*6 = 65534).
+const KERN_MAX_PAIRS_PER_SUBTABLE = 10920
+
+// KernToGpos converts the horizontal kerning of kern into a GPOS table with
+// a single 'kern' feature enabled for the DFLT and latn scripts. Pairs are
+// first resolved through Kern.Kerning, so accumulating and overriding
+// subtables are flattened, then regrouped into a class-based PairPos
+// subtable when that is smaller than listing them. It returns nil when kern
+// has no non-zero horizontal pair.
+func KernToGpos(kern *Kern) *Gpos {
+	values := kernPairValues(kern)
+	if len(values) == 0 {
+		return nil
+	}
+	defaultLangSys := func() *LangSys {
+		return &LangSys{RequiredFeatureIndex: 0xFFFF, FeatureIndices: []uint16{0}}
+	}
+	return &Gpos{
+		MajorVersion: 1,
+		Scripts: []*ScriptRecord{
+			{Tag: "DFLT", DefaultLangSys: defaultLangSys()},
+			{Tag: "latn", DefaultLangSys: defaultLangSys()},
+		},
+		Features: []*FeatureRecord{{Tag: "kern", LookupIndices: []uint16{0}}},
+		Lookups:  []*GposLookup{{LookupType: GPOS_LOOKUP_PAIR, PairPos: buildKernPairPos(values)}},
+	}
+}
+
+// GposToKern flattens the pair adjustments of the GPOS 'kern' feature into
+// a Windows format 0 kern table, splitting the pairs over as many subtables
+// as KERN_MAX_PAIRS_PER_SUBTABLE requires. Only the first glyph's XAdvance
+// is carried over. numGlyphs bounds class 0 of class-based subtables. It
+// returns nil when the feature yields no non-zero pair.
+func GposToKern(gpos *Gpos, numGlyphs int) *Kern {
+	lookupIndices := gpos.featureLookups("kern")
+	candidates := make(map[uint32]bool)
+	addProduct := func(lefts, rights []uint16) {
+		for _, l := range lefts {
+			for _, r := range rights {
+				candidates[uint32(l)<<16|uint32(r)] = true
+			}
+		}
+	}
+
+	for _, index := range lookupIndices {
+		for _, pairPos := range gpos.Lookups[index].PairPos {
+			if pairPos.Format != 2 {
+				for first, records := range pairPos.PairSets {
+					for _, record := range records {
+						if record.Value1.XAdvance != 0 {
+							candidates[uint32(first)<<16|uint32(record.SecondGlyph)] = true
+						}
+					}
+				}
+				continue
+			}
+			lefts := make(map[uint16][]uint16)
+			for _, g := range pairPos.Coverage {
+				c1 := pairPos.ClassDef1[g]
+				lefts[c1] = append(lefts[c1], g)
+			}
+			rights := make(map[uint16][]uint16)
+			for g, c2 := range pairPos.ClassDef2 {
+				rights[c2] = append(rights[c2], g)
+			}
+			for c1, glyphs := range lefts {
+				if int(c1) >= len(pairPos.ClassValues) {
+					continue
+				}
+				for c2, value := range pairPos.ClassValues[c1] {
+					if value == nil || value.Value1.XAdvance == 0 {
+						continue
+					}
+					if c2 == 0 {
+						if _, ok := rights[0]; !ok {
+							for g := 0; g < numGlyphs; g++ {
+								if _, classed := pairPos.ClassDef2[uint16(g)]; !classed {
+									rights[0] = append(rights[0], uint16(g))
+								}
+							}
+						}
+					}
+					addProduct(glyphs, rights[uint16(c2)])
+				}
+			}
+		}
+	}
+
+	var pairs []*nPairs
+	for key := range candidates {
+		left, right := uint16(key>>16), uint16(key)
+		if value := gpos.pairKerning(lookupIndices, left, right); value != 0 {
+			pairs = append(pairs, &nPairs{left, right, value})
+		}
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Left != pairs[j].Left {
+			return pairs[i].Left < pairs[j].Left
+		}
+		return pairs[i].Right < pairs[j].Right
+	})
+
+	kern := &Kern{Version: 0}
+	for start := 0; start < len(pairs); start += KERN_MAX_PAIRS_PER_SUBTABLE {
+		end := start + KERN_MAX_PAIRS_PER_SUBTABLE
+		if end > len(pairs) {
+			end = len(pairs)
+		}
+		coverage := KernCoverage{Horizontal: true}
+		kern.Subtables = append(kern.Subtables, &KernSubtable{
+			Coverage: coverage,
+			SubHeaders: map[string]int{
+				"version":  0,
+				"length":   14 + (end-start)*6,
+				"coverage": encodeKernCoverage(0, coverage, false),
+				"format":   0,
+				"nPairs":   end - start,
+			},
+			Pairs: pairs[start:end],
+		})
+	}
+	kern.NTables = len(kern.Subtables)
+	return kern
+}
+
+// kernPairValues resolves every pair that a horizontal kern subtable gives
+// a non-zero value to, keyed by left<<16 | right.
+func kernPairValues(kern *Kern) map[uint32]int16 {
+	candidates := make(map[uint32]bool)
+	addProduct := func(lefts, rights []uint16) {
+		for _, l := range lefts {
+			for _, r := range rights {
+				candidates[uint32(l)<<16|uint32(r)] = true
+			}
+		}
+	}
+
+	for _, subtable := range kern.Subtables {
+		kc := subtable.Coverage
+		if !kc.Horizontal || kc.CrossStream || kc.Minimum || kc.Variation {
+			continue
+		}
+		switch subtable.SubHeaders["format"] {
+		case 0:
+			for _, pair := range subtable.Pairs {
+				candidates[uint32(pair.Left)<<16|uint32(pair.Right)] = true
+			}
+		case 2:
+			f2 := subtable.Format2
+			if f2 == nil || f2.LeftClassTable == nil || f2.RightClassTable == nil {
+				continue
+			}
+			lefts := make(map[int][]uint16)
+			for i, offset := range f2.LeftClassTable.Offsets {
+				if offset >= f2.ArrayOffset {
+					lefts[int(offset)] = append(lefts[int(offset)], f2.LeftClassTable.FirstGlyph+uint16(i))
+				}
+			}
+			rights := make(map[int][]uint16)
+			for i, offset := range f2.RightClassTable.Offsets {
+				rights[int(offset)] = append(rights[int(offset)], f2.RightClassTable.FirstGlyph+uint16(i))
+			}
+			for leftOffset, leftGlyphs := range lefts {
+				for rightOffset, rightGlyphs := range rights {
+					idx := (leftOffset + rightOffset - int(f2.ArrayOffset)) / 2
+					if idx < len(f2.KernValues) && f2.KernValues[idx] != 0 {
+						addProduct(leftGlyphs, rightGlyphs)
+					}
+				}
+			}
+		case 3:
+			f3 := subtable.Format3
+			if f3 == nil {
+				continue
+			}
+			lefts := make(map[int][]uint16)
+			for g, class := range f3.LeftClass {
+				lefts[int(class)] = append(lefts[int(class)], uint16(g))
+			}
+			rights := make(map[int][]uint16)
+			for g, class := range f3.RightClass {
+				rights[int(class)] = append(rights[int(class)], uint16(g))
+			}
+			for leftClass, leftGlyphs := range lefts {
+				for rightClass, rightGlyphs := range rights {
+					idx := leftClass*int(f3.RightClassCount) + rightClass
+					if idx < len(f3.KernIndex) && int(f3.KernIndex[idx]) < len(f3.KernValues) && f3.KernValues[f3.KernIndex[idx]] != 0 {
+						addProduct(leftGlyphs, rightGlyphs)
+					}
+				}
+			}
+		}
+	}
+
+	values := make(map[uint32]int16)
+	for key := range candidates {
+		if value := kern.Kerning(uint16(key>>16), uint16(key)); value != 0 {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// buildKernPairPos turns resolved pair values into PairPos subtables. First
+// glyphs with identical rows and second glyphs with identical columns are
+// grouped into classes; the class-based format 2 subtable is used when it is
+// smaller than format 1, which is otherwise split so that every subtable
+// stays within Offset16 range.
+func buildKernPairPos(values map[uint32]int16) []*PairPos {
+	rows := make(map[uint16]map[uint16]int16)
+	secondSet := make(map[uint16]bool)
+	for key, value := range values {
+		first, second := uint16(key>>16), uint16(key)
+		if rows[first] == nil {
+			rows[first] = make(map[uint16]int16)
+		}
+		rows[first][second] = value
+		secondSet[second] = true
+	}
+	firsts := make([]uint16, 0, len(rows))
+	for g := range rows {
+		firsts = append(firsts, g)
+	}
+	sort.Slice(firsts, func(i, j int) bool { return firsts[i] < firsts[j] })
+	seconds := make([]uint16, 0, len(secondSet))
+	for g := range secondSet {
+		seconds = append(seconds, g)
+	}
+	sort.Slice(seconds, func(i, j int) bool { return seconds[i] < seconds[j] })
+
+	sortedRow := func(first uint16) []uint16 {
+		row := make([]uint16, 0, len(rows[first]))
+		for g := range rows[first] {
+			row = append(row, g)
+		}
+		sort.Slice(row, func(i, j int) bool { return row[i] < row[j] })
+		return row
+	}
+
+	// Group first glyphs by row and second glyphs by column. Left classes
+	// start at 0 so the first group needs no ClassDef1 entries; right class 0
+	// is reserved for glyphs that are never kerned.
+	classDef1 := make(map[uint16]uint16)
+	leftClasses := make(map[string]uint16)
+	columns := make(map[uint16]*strings.Builder)
+	for _, first := range firsts {
+		var key strings.Builder
+		for _, second := range sortedRow(first) {
+			value := strconv.Itoa(int(rows[first][second]))
+			key.WriteString(strconv.Itoa(int(second)) + ":" + value + ",")
+			if columns[second] == nil {
+				columns[second] = &strings.Builder{}
+			}
+			columns[second].WriteString(strconv.Itoa(int(first)) + ":" + value + ",")
+		}
+		class, ok := leftClasses[key.String()]
+		if !ok {
+			class = uint16(len(leftClasses))
+			leftClasses[key.String()] = class
+		}
+		if class != 0 {
+			classDef1[first] = class
+		}
+	}
+	classDef2 := make(map[uint16]uint16)
+	rightClasses := make(map[string]uint16)
+	for _, second := range seconds {
+		key := columns[second].String()
+		class, ok := rightClasses[key]
+		if !ok {
+			class = uint16(len(rightClasses) + 1)
+			rightClasses[key] = class
+		}
+		classDef2[second] = class
+	}
+
+	format1Size := 14 + len(firsts)*2
+	for _, first := range firsts {
+		format1Size += 4 + len(rows[first])*4
+	}
+	class1Count, class2Count := len(leftClasses), len(rightClasses)+1
+	format2Size := 16 + class1Count*class2Count*2 + len(writeCoverage(firsts)) + len(writeClassDef(classDef1)) + len(writeClassDef(classDef2))
+
+	if format2Size < format1Size && format2Size <= 0xFFFF {
+		classValues := make([][]*PairClassValue, class1Count)
+		for c1 := range classValues {
+			classValues[c1] = make([]*PairClassValue, class2Count)
+			for c2 := range classValues[c1] {
+				classValues[c1][c2] = &PairClassValue{}
+			}
+		}
+		for _, first := range firsts {
+			for second, value := range rows[first] {
+				classValues[classDef1[first]][classDef2[second]].Value1.XAdvance = value
+			}
+		}
+		return []*PairPos{{
+			Format:       2,
+			ValueFormat1: GPOS_VALUE_X_ADVANCE,
+			Coverage:     firsts,
+			ClassDef1:    classDef1,
+			ClassDef2:    classDef2,
+			ClassValues:  classValues,
+		}}
+	}
+
+	var subtables []*PairPos
+	var current *PairPos
+	size := 0
+	for _, first := range firsts {
+		setSize := 6 + len(rows[first])*4
+		if current == nil || size+setSize > 0xFFFF {
+			current = &PairPos{Format: 1, ValueFormat1: GPOS_VALUE_X_ADVANCE, PairSets: make(map[uint16][]*PairValueRecord)}
+			subtables = append(subtables, current)
+			size = 14
+		}
+		records := make([]*PairValueRecord, 0, len(rows[first]))
+		for _, second := range sortedRow(first) {
+			records = append(records, &PairValueRecord{SecondGlyph: second, Value1: ValueRecord{XAdvance: rows[first][second]}})
+		}
+		current.PairSets[first] = records
+		size += setSize
+	}
+	return subtables
+}
+
+// addKernFeature appends lookup to gpos under a new 'kern' feature, inserted
+// in tag order and enabled for every script and language system.
+func addKernFeature(gpos *Gpos, lookup *GposLookup) {
+	lookupIndex := uint16(len(gpos.Lookups))
+	gpos.Lookups = append(gpos.Lookups, lookup)
+
+	at := sort.Search(len(gpos.Features), func(i int) bool { return gpos.Features[i].Tag > "kern" })
+	feature := &FeatureRecord{Tag: "kern", LookupIndices: []uint16{lookupIndex}}
+	gpos.Features = append(gpos.Features[:at], append([]*FeatureRecord{feature}, gpos.Features[at:]...)...)
+
+	enable := func(langSys *LangSys) {
+		if langSys.RequiredFeatureIndex != 0xFFFF && int(langSys.RequiredFeatureIndex) >= at {
+			langSys.RequiredFeatureIndex++
+		}
+		for i, index := range langSys.FeatureIndices {
+			if int(index) >= at {
+				langSys.FeatureIndices[i]++
+			}
+		}
+		langSys.FeatureIndices = append(langSys.FeatureIndices, uint16(at))
+		sort.Slice(langSys.FeatureIndices, func(i, j int) bool { return langSys.FeatureIndices[i] < langSys.FeatureIndices[j] })
+	}
+	if len(gpos.Scripts) == 0 {
+		gpos.Scripts = []*ScriptRecord{{Tag: "DFLT"}}
+	}
+	for _, script := range gpos.Scripts {
+		if script.DefaultLangSys == nil {
+			script.DefaultLangSys = &LangSys{RequiredFeatureIndex: 0xFFFF}
+		}
+		enable(script.DefaultLangSys)
+		for _, langSys := range script.LangSys {
+			enable(langSys)
+		}
+	}
+}
@@ -4,9 +4,13 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/color"
+	"math"
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -1506,6 +1510,9 @@ func TestGetKernWindows(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetKern failed: %v", err)
 	}
+	if len(kern.Subtables) != 1 {
+		t.Fatalf("Expected 1 subtable, got %d", len(kern.Subtables))
+	}
 
 	// Verify kern table header
 	if kern.Version != 0 {
@@ -1519,35 +1526,35 @@ func TestGetKernWindows(t *testing.T) {
 	}
 
 	// Verify subtable headers
-	if kern.SubHeaders["version"] != 0 {
-		t.Errorf("Expected subtable version=0, got %d", kern.SubHeaders["version"])
+	if kern.Subtables[0].SubHeaders["version"] != 0 {
+		t.Errorf("Expected subtable version=0, got %d", kern.Subtables[0].SubHeaders["version"])
 	}
-	if kern.SubHeaders["length"] != 26 {
-		t.Errorf("Expected subtable length=26, got %d", kern.SubHeaders["length"])
+	if kern.Subtables[0].SubHeaders["length"] != 26 {
+		t.Errorf("Expected subtable length=26, got %d", kern.Subtables[0].SubHeaders["length"])
 	}
-	if kern.SubHeaders["coverage"] != 0 {
-		t.Errorf("Expected coverage=0, got %d", kern.SubHeaders["coverage"])
+	if kern.Subtables[0].SubHeaders["coverage"] != 0 {
+		t.Errorf("Expected coverage=0, got %d", kern.Subtables[0].SubHeaders["coverage"])
 	}
-	if kern.SubHeaders["format"] != 0 {
-		t.Errorf("Expected format=0, got %d", kern.SubHeaders["format"])
+	if kern.Subtables[0].SubHeaders["format"] != 0 {
+		t.Errorf("Expected format=0, got %d", kern.Subtables[0].SubHeaders["format"])
 	}
-	if kern.SubHeaders["nPairs"] != 2 {
-		t.Errorf("Expected nPairs=2, got %d", kern.SubHeaders["nPairs"])
+	if kern.Subtables[0].SubHeaders["nPairs"] != 2 {
+		t.Errorf("Expected nPairs=2, got %d", kern.Subtables[0].SubHeaders["nPairs"])
 	}
 
 	// Verify kerning pairs
-	if len(kern.Pairs) != 2 {
-		t.Fatalf("Expected 2 kerning pairs, got %d", len(kern.Pairs))
+	if len(kern.Subtables[0].Pairs) != 2 {
+		t.Fatalf("Expected 2 kerning pairs, got %d", len(kern.Subtables[0].Pairs))
 	}
 
 	// Pair 1: A-V = -50
-	pair1 := kern.Pairs[0]
+	pair1 := kern.Subtables[0].Pairs[0]
 	if pair1.Left != 65 || pair1.Right != 86 || pair1.Value != -50 {
 		t.Errorf("Pair 1 expected (65,86,-50), got (%d,%d,%d)", pair1.Left, pair1.Right, pair1.Value)
 	}
 
 	// Pair 2: F-. = -30
-	pair2 := kern.Pairs[1]
+	pair2 := kern.Subtables[0].Pairs[1]
 	if pair2.Left != 70 || pair2.Right != 46 || pair2.Value != -30 {
 		t.Errorf("Pair 2 expected (70,46,-30), got (%d,%d,%d)", pair2.Left, pair2.Right, pair2.Value)
 	}
@@ -1572,6 +1579,9 @@ func TestGetKernMac(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetKern failed: %v", err)
 	}
+	if len(kern.Subtables) != 1 {
+		t.Fatalf("Expected 1 subtable, got %d", len(kern.Subtables))
+	}
 
 	// Verify kern table header
 	if kern.Version != 1 {
@@ -1585,29 +1595,29 @@ func TestGetKernMac(t *testing.T) {
 	}
 
 	// Verify subtable headers
-	if kern.SubHeaders["length"] != 32 {
-		t.Errorf("Expected subtable length=32, got %d", kern.SubHeaders["length"])
+	if kern.Subtables[0].SubHeaders["length"] != 32 {
+		t.Errorf("Expected subtable length=32, got %d", kern.Subtables[0].SubHeaders["length"])
 	}
-	if kern.SubHeaders["coverage"] != 0x8000 {
-		t.Errorf("Expected coverage=0x8000, got %d", kern.SubHeaders["coverage"])
+	if kern.Subtables[0].SubHeaders["coverage"] != 0x8000 {
+		t.Errorf("Expected coverage=0x8000, got %d", kern.Subtables[0].SubHeaders["coverage"])
 	}
-	if kern.SubHeaders["nPairs"] != 2 {
-		t.Errorf("Expected nPairs=2, got %d", kern.SubHeaders["nPairs"])
+	if kern.Subtables[0].SubHeaders["nPairs"] != 2 {
+		t.Errorf("Expected nPairs=2, got %d", kern.Subtables[0].SubHeaders["nPairs"])
 	}
 
 	// Verify kerning pairs
-	if len(kern.Pairs) != 2 {
-		t.Fatalf("Expected 2 kerning pairs, got %d", len(kern.Pairs))
+	if len(kern.Subtables[0].Pairs) != 2 {
+		t.Fatalf("Expected 2 kerning pairs, got %d", len(kern.Subtables[0].Pairs))
 	}
 
 	// Pair 1: T-o = -40
-	pair1 := kern.Pairs[0]
+	pair1 := kern.Subtables[0].Pairs[0]
 	if pair1.Left != 84 || pair1.Right != 111 || pair1.Value != -40 {
 		t.Errorf("Pair 1 expected (84,111,-40), got (%d,%d,%d)", pair1.Left, pair1.Right, pair1.Value)
 	}
 
 	// Pair 2: W-a = -20
-	pair2 := kern.Pairs[1]
+	pair2 := kern.Subtables[0].Pairs[1]
 	if pair2.Left != 87 || pair2.Right != 97 || pair2.Value != -20 {
 		t.Errorf("Pair 2 expected (87,97,-20), got (%d,%d,%d)", pair2.Left, pair2.Right, pair2.Value)
 	}
@@ -1628,33 +1638,36 @@ func TestGetKernMacNew(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetKern failed: %v", err)
 	}
+	if len(kern.Subtables) != 1 {
+		t.Fatalf("Expected 1 subtable, got %d", len(kern.Subtables))
+	}
 
 	// Verify kern table header
 	if kern.Version != 1 {
 		t.Errorf("Expected Version=1, got %d", kern.Version)
 	}
-	if kern.NTables != 0 { // nTables is 0 in header for new format
-		t.Errorf("Expected NTables=0 (new format indicator), got %d", kern.NTables)
+	if kern.NTables != 1 { // read from the 32-bit nTables field of the new format
+		t.Errorf("Expected NTables=1, got %d", kern.NTables)
 	}
 	if !kern.IsMacNewKern {
 		t.Errorf("Expected IsMacNewKern=true for new Mac format")
 	}
 
 	// Verify subtable headers
-	if kern.SubHeaders["length"] != 32 {
-		t.Errorf("Expected subtable length=32, got %d", kern.SubHeaders["length"])
+	if kern.Subtables[0].SubHeaders["length"] != 32 {
+		t.Errorf("Expected subtable length=32, got %d", kern.Subtables[0].SubHeaders["length"])
 	}
-	if kern.SubHeaders["nPairs"] != 1 {
-		t.Errorf("Expected nPairs=1, got %d", kern.SubHeaders["nPairs"])
+	if kern.Subtables[0].SubHeaders["nPairs"] != 1 {
+		t.Errorf("Expected nPairs=1, got %d", kern.Subtables[0].SubHeaders["nPairs"])
 	}
 
 	// Verify kerning pairs
-	if len(kern.Pairs) != 1 {
-		t.Fatalf("Expected 1 kerning pair, got %d", len(kern.Pairs))
+	if len(kern.Subtables[0].Pairs) != 1 {
+		t.Fatalf("Expected 1 kerning pair, got %d", len(kern.Subtables[0].Pairs))
 	}
 
 	// Pair 1: L-Y = -80
-	pair1 := kern.Pairs[0]
+	pair1 := kern.Subtables[0].Pairs[0]
 	if pair1.Left != 76 || pair1.Right != 89 || pair1.Value != -80 {
 		t.Errorf("Pair expected (76,89,-80), got (%d,%d,%d)", pair1.Left, pair1.Right, pair1.Value)
 	}
@@ -1695,6 +1708,9 @@ func TestGetKernWindowsFormat2(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetKern failed: %v", err)
 	}
+	if len(kern.Subtables) != 1 {
+		t.Fatalf("Expected 1 subtable, got %d", len(kern.Subtables))
+	}
 
 	// Verify kern table header
 	if kern.Version != 0 {
@@ -1705,49 +1721,49 @@ func TestGetKernWindowsFormat2(t *testing.T) {
 	}
 
 	// Verify subtable headers
-	if kern.SubHeaders["format"] != 2 {
-		t.Errorf("Expected format=2, got %d", kern.SubHeaders["format"])
+	if kern.Subtables[0].SubHeaders["format"] != 2 {
+		t.Errorf("Expected format=2, got %d", kern.Subtables[0].SubHeaders["format"])
 	}
-	if kern.SubHeaders["coverage"] != 2 {
-		t.Errorf("Expected coverage=2, got %d", kern.SubHeaders["coverage"])
+	if kern.Subtables[0].SubHeaders["coverage"] != 0x0201 {
+		t.Errorf("Expected coverage=0x0201, got %d", kern.Subtables[0].SubHeaders["coverage"])
 	}
 
 	// Verify format 2 data exists
-	if kern.Format2 == nil {
+	if kern.Subtables[0].Format2 == nil {
 		t.Fatal("Expected Format2 data to be non-nil")
 	}
 
 	// Verify format 2 structure
-	if kern.Format2.RowWidth != 4 {
-		t.Errorf("Expected rowWidth=4, got %d", kern.Format2.RowWidth)
+	if kern.Subtables[0].Format2.RowWidth != 4 {
+		t.Errorf("Expected rowWidth=4, got %d", kern.Subtables[0].Format2.RowWidth)
 	}
-	if kern.Format2.LeftOffsetTable != 14 {
-		t.Errorf("Expected leftOffsetTable=14, got %d", kern.Format2.LeftOffsetTable)
+	if kern.Subtables[0].Format2.LeftOffsetTable != 14 {
+		t.Errorf("Expected leftOffsetTable=14, got %d", kern.Subtables[0].Format2.LeftOffsetTable)
 	}
-	if kern.Format2.RightOffsetTable != 22 {
-		t.Errorf("Expected rightOffsetTable=22, got %d", kern.Format2.RightOffsetTable)
+	if kern.Subtables[0].Format2.RightOffsetTable != 22 {
+		t.Errorf("Expected rightOffsetTable=22, got %d", kern.Subtables[0].Format2.RightOffsetTable)
 	}
 
 	// Verify left class table
-	if kern.Format2.LeftClassTable == nil {
+	if kern.Subtables[0].Format2.LeftClassTable == nil {
 		t.Fatal("Expected LeftClassTable to be non-nil")
 	}
-	if kern.Format2.LeftClassTable.FirstGlyph != 65 {
-		t.Errorf("Expected left firstGlyph=65, got %d", kern.Format2.LeftClassTable.FirstGlyph)
+	if kern.Subtables[0].Format2.LeftClassTable.FirstGlyph != 65 {
+		t.Errorf("Expected left firstGlyph=65, got %d", kern.Subtables[0].Format2.LeftClassTable.FirstGlyph)
 	}
-	if kern.Format2.LeftClassTable.NGlyphs != 2 {
-		t.Errorf("Expected left nGlyphs=2, got %d", kern.Format2.LeftClassTable.NGlyphs)
+	if kern.Subtables[0].Format2.LeftClassTable.NGlyphs != 2 {
+		t.Errorf("Expected left nGlyphs=2, got %d", kern.Subtables[0].Format2.LeftClassTable.NGlyphs)
 	}
 
 	// Verify right class table
-	if kern.Format2.RightClassTable == nil {
+	if kern.Subtables[0].Format2.RightClassTable == nil {
 		t.Fatal("Expected RightClassTable to be non-nil")
 	}
-	if kern.Format2.RightClassTable.FirstGlyph != 86 {
-		t.Errorf("Expected right firstGlyph=86, got %d", kern.Format2.RightClassTable.FirstGlyph)
+	if kern.Subtables[0].Format2.RightClassTable.FirstGlyph != 86 {
+		t.Errorf("Expected right firstGlyph=86, got %d", kern.Subtables[0].Format2.RightClassTable.FirstGlyph)
 	}
-	if kern.Format2.RightClassTable.NGlyphs != 2 {
-		t.Errorf("Expected right nGlyphs=2, got %d", kern.Format2.RightClassTable.NGlyphs)
+	if kern.Subtables[0].Format2.RightClassTable.NGlyphs != 2 {
+		t.Errorf("Expected right nGlyphs=2, got %d", kern.Subtables[0].Format2.RightClassTable.NGlyphs)
 	}
 }
 
@@ -1758,6 +1774,9 @@ func TestGetKernMacFormat2(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetKern failed: %v", err)
 	}
+	if len(kern.Subtables) != 1 {
+		t.Fatalf("Expected 1 subtable, got %d", len(kern.Subtables))
+	}
 
 	// Verify kern table header
 	if kern.Version != 1 {
@@ -1768,40 +1787,40 @@ func TestGetKernMacFormat2(t *testing.T) {
 	}
 
 	// Verify subtable headers
-	if kern.SubHeaders["format"] != 2 {
-		t.Errorf("Expected format=2, got %d", kern.SubHeaders["format"])
+	if kern.Subtables[0].SubHeaders["format"] != 2 {
+		t.Errorf("Expected format=2, got %d", kern.Subtables[0].SubHeaders["format"])
 	}
-	if kern.SubHeaders["coverage"] != 0x8002 {
-		t.Errorf("Expected coverage=0x8002, got %d", kern.SubHeaders["coverage"])
+	if kern.Subtables[0].SubHeaders["coverage"] != 0x8002 {
+		t.Errorf("Expected coverage=0x8002, got %d", kern.Subtables[0].SubHeaders["coverage"])
 	}
 
 	// Verify format 2 data exists
-	if kern.Format2 == nil {
+	if kern.Subtables[0].Format2 == nil {
 		t.Fatal("Expected Format2 data to be non-nil")
 	}
 
 	// Verify format 2 structure
-	if kern.Format2.RowWidth != 4 {
-		t.Errorf("Expected rowWidth=4, got %d", kern.Format2.RowWidth)
+	if kern.Subtables[0].Format2.RowWidth != 4 {
+		t.Errorf("Expected rowWidth=4, got %d", kern.Subtables[0].Format2.RowWidth)
 	}
 
 	// Verify left class table
-	if kern.Format2.LeftClassTable == nil {
+	if kern.Subtables[0].Format2.LeftClassTable == nil {
 		t.Fatal("Expected LeftClassTable to be non-nil")
 	}
-	if kern.Format2.LeftClassTable.FirstGlyph != 84 {
-		t.Errorf("Expected left firstGlyph=84 ('T'), got %d", kern.Format2.LeftClassTable.FirstGlyph)
+	if kern.Subtables[0].Format2.LeftClassTable.FirstGlyph != 84 {
+		t.Errorf("Expected left firstGlyph=84 ('T'), got %d", kern.Subtables[0].Format2.LeftClassTable.FirstGlyph)
 	}
-	if kern.Format2.LeftClassTable.NGlyphs != 2 {
-		t.Errorf("Expected left nGlyphs=2, got %d", kern.Format2.LeftClassTable.NGlyphs)
+	if kern.Subtables[0].Format2.LeftClassTable.NGlyphs != 2 {
+		t.Errorf("Expected left nGlyphs=2, got %d", kern.Subtables[0].Format2.LeftClassTable.NGlyphs)
 	}
 
 	// Verify right class table
-	if kern.Format2.RightClassTable == nil {
+	if kern.Subtables[0].Format2.RightClassTable == nil {
 		t.Fatal("Expected RightClassTable to be non-nil")
 	}
-	if kern.Format2.RightClassTable.FirstGlyph != 111 {
-		t.Errorf("Expected right firstGlyph=111 ('o'), got %d", kern.Format2.RightClassTable.FirstGlyph)
+	if kern.Subtables[0].Format2.RightClassTable.FirstGlyph != 111 {
+		t.Errorf("Expected right firstGlyph=111 ('o'), got %d", kern.Subtables[0].Format2.RightClassTable.FirstGlyph)
 	}
 }
 
@@ -1812,6 +1831,9 @@ func TestGetKernMacFormat3(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetKern failed: %v", err)
 	}
+	if len(kern.Subtables) != 1 {
+		t.Fatalf("Expected 1 subtable, got %d", len(kern.Subtables))
+	}
 
 	// Verify kern table header
 	if kern.Version != 1 {
@@ -1822,54 +1844,54 @@ func TestGetKernMacFormat3(t *testing.T) {
 	}
 
 	// Verify subtable headers
-	if kern.SubHeaders["format"] != 3 {
-		t.Errorf("Expected format=3, got %d", kern.SubHeaders["format"])
+	if kern.Subtables[0].SubHeaders["format"] != 3 {
+		t.Errorf("Expected format=3, got %d", kern.Subtables[0].SubHeaders["format"])
 	}
 
 	// Verify format 3 data exists
-	if kern.Format3 == nil {
+	if kern.Subtables[0].Format3 == nil {
 		t.Fatal("Expected Format3 data to be non-nil")
 	}
 
 	// Verify format 3 structure
-	if kern.Format3.GlyphCount != 4 {
-		t.Errorf("Expected glyphCount=4, got %d", kern.Format3.GlyphCount)
+	if kern.Subtables[0].Format3.GlyphCount != 4 {
+		t.Errorf("Expected glyphCount=4, got %d", kern.Subtables[0].Format3.GlyphCount)
 	}
-	if kern.Format3.KernValueCount != 2 {
-		t.Errorf("Expected kernValueCount=2, got %d", kern.Format3.KernValueCount)
+	if kern.Subtables[0].Format3.KernValueCount != 2 {
+		t.Errorf("Expected kernValueCount=2, got %d", kern.Subtables[0].Format3.KernValueCount)
 	}
-	if kern.Format3.LeftClassCount != 2 {
-		t.Errorf("Expected leftClassCount=2, got %d", kern.Format3.LeftClassCount)
+	if kern.Subtables[0].Format3.LeftClassCount != 2 {
+		t.Errorf("Expected leftClassCount=2, got %d", kern.Subtables[0].Format3.LeftClassCount)
 	}
-	if kern.Format3.RightClassCount != 2 {
-		t.Errorf("Expected rightClassCount=2, got %d", kern.Format3.RightClassCount)
+	if kern.Subtables[0].Format3.RightClassCount != 2 {
+		t.Errorf("Expected rightClassCount=2, got %d", kern.Subtables[0].Format3.RightClassCount)
 	}
 
 	// Verify kern values
-	if len(kern.Format3.KernValues) != 2 {
-		t.Fatalf("Expected 2 kern values, got %d", len(kern.Format3.KernValues))
+	if len(kern.Subtables[0].Format3.KernValues) != 2 {
+		t.Fatalf("Expected 2 kern values, got %d", len(kern.Subtables[0].Format3.KernValues))
 	}
-	if kern.Format3.KernValues[0] != -50 {
-		t.Errorf("Expected kernValue[0]=-50, got %d", kern.Format3.KernValues[0])
+	if kern.Subtables[0].Format3.KernValues[0] != -50 {
+		t.Errorf("Expected kernValue[0]=-50, got %d", kern.Subtables[0].Format3.KernValues[0])
 	}
-	if kern.Format3.KernValues[1] != -20 {
-		t.Errorf("Expected kernValue[1]=-20, got %d", kern.Format3.KernValues[1])
+	if kern.Subtables[0].Format3.KernValues[1] != -20 {
+		t.Errorf("Expected kernValue[1]=-20, got %d", kern.Subtables[0].Format3.KernValues[1])
 	}
 
 	// Verify left class array
-	if len(kern.Format3.LeftClass) != 4 {
-		t.Errorf("Expected 4 left class entries, got %d", len(kern.Format3.LeftClass))
+	if len(kern.Subtables[0].Format3.LeftClass) != 4 {
+		t.Errorf("Expected 4 left class entries, got %d", len(kern.Subtables[0].Format3.LeftClass))
 	}
 
 	// Verify right class array
-	if len(kern.Format3.RightClass) != 4 {
-		t.Errorf("Expected 4 right class entries, got %d", len(kern.Format3.RightClass))
+	if len(kern.Subtables[0].Format3.RightClass) != 4 {
+		t.Errorf("Expected 4 right class entries, got %d", len(kern.Subtables[0].Format3.RightClass))
 	}
 
 	// Verify kern index array
 	expectedIndexCount := 2 * 2 // leftClassCount × rightClassCount
-	if len(kern.Format3.KernIndex) != expectedIndexCount {
-		t.Errorf("Expected %d kern index entries, got %d", expectedIndexCount, len(kern.Format3.KernIndex))
+	if len(kern.Subtables[0].Format3.KernIndex) != expectedIndexCount {
+		t.Errorf("Expected %d kern index entries, got %d", expectedIndexCount, len(kern.Subtables[0].Format3.KernIndex))
 	}
 }
 
@@ -2241,3 +2263,2431 @@ func TestGetNameFormat1Truncated(t *testing.T) {
 	}
 	// Should detect overflow and return early
 }
+
+// TestGetGdef parses a hand-built GDEF 1.2 table and checks it round-trips
+// through WriteGdef.
+func TestGetGdef(t *testing.T) {
+	var buf []byte
+	// Header (14 bytes, version 1.2)
+	buf = append(buf, 0x00, 0x01) // majorVersion = 1
+	buf = append(buf, 0x00, 0x02) // minorVersion = 2
+	buf = append(buf, 0x00, 0x0E) // glyphClassDefOffset = 14
+	buf = append(buf, 0x00, 0x1E) // attachListOffset = 30
+	buf = append(buf, 0x00, 0x30) // ligCaretListOffset = 48
+	buf = append(buf, 0x00, 0x00) // markAttachClassDefOffset = 0
+	buf = append(buf, 0x00, 0x4A) // markGlyphSetsDefOffset = 74
+
+	// offset 14: ClassDef format 2, glyphs 1-2 base, glyph 5 mark
+	buf = append(buf, 0x00, 0x02, 0x00, 0x02)
+	buf = append(buf, 0x00, 0x01, 0x00, 0x02, 0x00, 0x01)
+	buf = append(buf, 0x00, 0x05, 0x00, 0x05, 0x00, 0x03)
+
+	// offset 30: AttachList for glyph 1 with points [3, 7]
+	buf = append(buf, 0x00, 0x0C) // coverageOffset = 12
+	buf = append(buf, 0x00, 0x01) // glyphCount = 1
+	buf = append(buf, 0x00, 0x06) // attachPointOffset = 6
+	buf = append(buf, 0x00, 0x02, 0x00, 0x03, 0x00, 0x07)
+	buf = append(buf, 0x00, 0x01, 0x00, 0x01, 0x00, 0x01) // coverage format 1: [1]
+
+	// offset 48: LigCaretList for glyph 9 with carets coordinate 250 and point 4
+	buf = append(buf, 0x00, 0x14)                         // coverageOffset = 20
+	buf = append(buf, 0x00, 0x01)                         // ligGlyphCount = 1
+	buf = append(buf, 0x00, 0x06)                         // ligGlyphOffset = 6
+	buf = append(buf, 0x00, 0x02, 0x00, 0x06, 0x00, 0x0A) // caretCount = 2, offsets 6, 10
+	buf = append(buf, 0x00, 0x01, 0x00, 0xFA)             // format 1, coordinate 250
+	buf = append(buf, 0x00, 0x02, 0x00, 0x04)             // format 2, point 4
+	buf = append(buf, 0x00, 0x01, 0x00, 0x01, 0x00, 0x09) // coverage format 1: [9]
+
+	// offset 74: MarkGlyphSets with one set [5]
+	buf = append(buf, 0x00, 0x01, 0x00, 0x01)
+	buf = append(buf, 0x00, 0x00, 0x00, 0x08)
+	buf = append(buf, 0x00, 0x01, 0x00, 0x01, 0x00, 0x05)
+
+	gdef, err := GetGdef(buf, 0)
+	if err != nil {
+		t.Fatalf("GetGdef failed: %v", err)
+	}
+
+	wantClasses := map[uint16]uint16{1: GDEF_CLASS_BASE, 2: GDEF_CLASS_BASE, 5: GDEF_CLASS_MARK}
+	if !reflect.DeepEqual(gdef.GlyphClassDef, wantClasses) {
+		t.Errorf("GlyphClassDef expected %v, got %v", wantClasses, gdef.GlyphClassDef)
+	}
+	if !reflect.DeepEqual(gdef.AttachList, map[uint16][]uint16{1: {3, 7}}) {
+		t.Errorf("unexpected AttachList %v", gdef.AttachList)
+	}
+	carets := gdef.LigCaretList[9]
+	if len(carets) != 2 || carets[0].Coordinate != 250 || carets[1].PointIndex != 4 {
+		t.Errorf("unexpected LigCaretList for glyph 9: %+v", carets)
+	}
+	if !reflect.DeepEqual(gdef.MarkGlyphSets, [][]uint16{{5}}) {
+		t.Errorf("unexpected MarkGlyphSets %v", gdef.MarkGlyphSets)
+	}
+
+	reparsed, err := GetGdef(WriteGdef(gdef), 0)
+	if err != nil {
+		t.Fatalf("GetGdef of written table failed: %v", err)
+	}
+	if !reflect.DeepEqual(gdef, reparsed) {
+		t.Errorf("GDEF round trip mismatch:\nwant %+v\ngot  %+v", gdef, reparsed)
+	}
+
+	remapGdef(gdef, map[int]int{0: 0, 2: 1, 5: 2})
+	if !reflect.DeepEqual(gdef.GlyphClassDef, map[uint16]uint16{1: GDEF_CLASS_BASE, 2: GDEF_CLASS_MARK}) {
+		t.Errorf("unexpected remapped GlyphClassDef %v", gdef.GlyphClassDef)
+	}
+	if len(gdef.AttachList) != 0 || len(gdef.LigCaretList) != 0 {
+		t.Errorf("expected dropped glyphs to be removed from AttachList and LigCaretList")
+	}
+}
+
+// TestGetGdefItemVarStore checks the GDEF 1.3 item variation store and
+// device tables survive a write/read cycle.
+func TestGdefSharedTables(t *testing.T) {
+	fileByte, err := os.ReadFile("../test/Changa-Regular.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := GetTableContent(int(GetOffsetTable(fileByte).NumTables), fileByte)["GDEF"]
+	gdef, err := GetGdef(fileByte, int(info.Offset))
+	if err != nil {
+		t.Fatalf("GetGdef failed: %v", err)
+	}
+	data := WriteGdef(gdef)
+	// the ligature glyphs share their caret tables
+	if len(data) > int(info.Length) {
+		t.Errorf("GDEF grew from %d to %d bytes", info.Length, len(data))
+	}
+	reparsed, err := GetGdef(data, 0)
+	if err != nil {
+		t.Fatalf("GetGdef of written table failed: %v", err)
+	}
+	if !reflect.DeepEqual(reparsed, gdef) {
+		t.Errorf("GDEF round trip mismatch")
+	}
+}
+
+func TestGetGdefItemVarStore(t *testing.T) {
+	gdef := &Gdef{
+		MajorVersion:  1,
+		GlyphClassDef: map[uint16]uint16{3: GDEF_CLASS_LIGATURE},
+		LigCaretList: map[uint16][]*CaretValue{
+			3: {{Format: 3, Coordinate: -12, Device: &DeviceTable{StartSize: 9, EndSize: 12, DeltaFormat: 1, DeltaValues: []int8{1, -1, 0, -2}}}},
+		},
+		ItemVarStore: &ItemVariationStore{
+			Format:    1,
+			AxisCount: 1,
+			Regions:   [][]*RegionAxisCoordinates{{{0, 1, 1}}, {{-1, -1, 0}}},
+			ItemVariationData: []*ItemVariationData{
+				{RegionIndexes: []uint16{0, 1}, DeltaSets: [][]int32{{5, 300}, {-7, 2}}},
+			},
+		},
+	}
+
+	reparsed, err := GetGdef(WriteGdef(gdef), 0)
+	if err != nil {
+		t.Fatalf("GetGdef failed: %v", err)
+	}
+	if reparsed.MinorVersion != 3 {
+		t.Errorf("expected minorVersion 3 when an item variation store is present, got %d", reparsed.MinorVersion)
+	}
+	if !reflect.DeepEqual(gdef.LigCaretList, reparsed.LigCaretList) {
+		t.Errorf("caret device table mismatch: %+v", reparsed.LigCaretList[3][0].Device)
+	}
+	ivd := reparsed.ItemVarStore.ItemVariationData[0]
+	// The word-sized column is moved first on write.
+	if !reflect.DeepEqual(ivd.RegionIndexes, []uint16{1, 0}) || !reflect.DeepEqual(ivd.DeltaSets, [][]int32{{300, 5}, {2, -7}}) {
+		t.Errorf("unexpected item variation data %+v", ivd)
+	}
+	if !reflect.DeepEqual(gdef.ItemVarStore.Regions, reparsed.ItemVarStore.Regions) {
+		t.Errorf("region list mismatch")
+	}
+}
+
+// TestGetKernMultipleSubtables verifies every subtable is kept and that
+// Kerning accumulates horizontal subtables while skipping cross-stream ones.
+func TestGetKernMultipleSubtables(t *testing.T) {
+	kern, err := GetKern(getWindowsMultiKernData(), 0)
+	if err != nil {
+		t.Fatalf("GetKern failed: %v", err)
+	}
+	if len(kern.Subtables) != 3 {
+		t.Fatalf("Expected 3 subtables, got %d", len(kern.Subtables))
+	}
+	if !kern.Subtables[2].Coverage.CrossStream || !kern.Subtables[2].Coverage.Horizontal {
+		t.Errorf("Expected subtable 2 to be horizontal cross-stream, got %+v", kern.Subtables[2].Coverage)
+	}
+	if v := kern.Kerning(65, 86); v != -60 {
+		t.Errorf("Expected A-V kerning -60, got %d", v)
+	}
+	if v := kern.Kerning(70, 46); v != -30 {
+		t.Errorf("Expected F-. kerning -30, got %d", v)
+	}
+	if v := kern.Kerning(46, 70); v != 0 {
+		t.Errorf("Expected .-F kerning 0, got %d", v)
+	}
+
+	reparsed, err := GetKern(WriteKern(kern), 0)
+	if err != nil {
+		t.Fatalf("GetKern of written table failed: %v", err)
+	}
+	if len(reparsed.Subtables) != 3 || reparsed.Kerning(65, 86) != -60 {
+		t.Errorf("Round trip lost subtables: %d subtables, A-V=%d", len(reparsed.Subtables), reparsed.Kerning(65, 86))
+	}
+}
+
+// TestGetKernTruncated cuts kern tables short at every length and checks
+// that GetKern reports an error instead of reading past the data.
+func TestGetKernTruncated(t *testing.T) {
+	for _, data := range [][]byte{getWindowsMultiKernData(), getWindowsFormat2KernData(), getMacFormat2KernData(), getMacFormat3KernData()} {
+		for n := 0; n < len(data); n++ {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("GetKern panicked on %d of %d bytes: %v", n, len(data), r)
+					}
+				}()
+				GetKern(data[:n], 0)
+			}()
+		}
+	}
+}
+
+// TestWriteKernSkippedSubtables checks that subtables WriteKern cannot write
+// are not counted in the header.
+func TestWriteKernSkippedSubtables(t *testing.T) {
+	kern, err := GetKern(getMacFormat3KernData(), 0)
+	if err != nil {
+		t.Fatalf("GetKern failed: %v", err)
+	}
+	kern.Subtables = append(kern.Subtables,
+		&KernSubtable{Coverage: KernCoverage{Horizontal: true}, SubHeaders: map[string]int{"format": 1}},
+		&KernSubtable{Coverage: KernCoverage{Horizontal: true}, SubHeaders: map[string]int{"format": 2}})
+	data := WriteKern(kern)
+	if kern.NTables != 1 || getUint16(data[2:4]) != 1 {
+		t.Errorf("expected one written subtable, got NTables %d", kern.NTables)
+	}
+	reparsed, err := GetKern(data, 0)
+	if err != nil {
+		t.Fatalf("GetKern of written table failed: %v", err)
+	}
+	if len(reparsed.Subtables) != 1 || reparsed.Subtables[0].Format3 == nil {
+		t.Errorf("unexpected subtables %+v", reparsed.Subtables)
+	}
+}
+
+// TestKernKerningFormat2And3 resolves pair values from class-based subtables
+// and checks they survive WriteKern.
+func TestKernKerningFormat2And3(t *testing.T) {
+	kern, err := GetKern(getWindowsFormat2KernData(), 0)
+	if err != nil {
+		t.Fatalf("GetKern failed: %v", err)
+	}
+	// A row at offset 30, W column at offset 2 -> value index 1
+	if v := kern.Kerning(65, 87); v != -40 {
+		t.Errorf("Expected format 2 A-W kerning -40, got %d", v)
+	}
+	reparsed, err := GetKern(WriteKern(kern), 0)
+	if err != nil {
+		t.Fatalf("GetKern of written format 2 table failed: %v", err)
+	}
+	if v := reparsed.Kerning(65, 87); v != -40 {
+		t.Errorf("Expected format 2 A-W kerning -40 after round trip, got %d", v)
+	}
+
+	kern, err = GetKern(getMacFormat3KernData(), 0)
+	if err != nil {
+		t.Fatalf("GetKern failed: %v", err)
+	}
+	// The fixture sets the vertical bit, so mark it horizontal for lookup
+	kern.Subtables[0].Coverage.Horizontal = true
+	// glyph 2: left class 1, glyph 1: right class 1 -> kernIndex[3] = 0 -> -50
+	if v := kern.Kerning(2, 1); v != -50 {
+		t.Errorf("Expected format 3 kerning -50, got %d", v)
+	}
+	// glyph 0: left class 0, glyph 1: right class 1 -> kernIndex[1] = 1 -> -20
+	if v := kern.Kerning(0, 1); v != -20 {
+		t.Errorf("Expected format 3 kerning -20, got %d", v)
+	}
+	reparsed, err = GetKern(WriteKern(kern), 0)
+	if err != nil {
+		t.Fatalf("GetKern of written format 3 table failed: %v", err)
+	}
+	if !reparsed.IsMacNewKern && reparsed.Version != 1 {
+		t.Errorf("Expected Mac kern table after round trip")
+	}
+	if v := reparsed.Kerning(2, 1); v != -50 {
+		t.Errorf("Expected format 3 kerning -50 after round trip, got %d", v)
+	}
+}
+
+func TestGetGpos(t *testing.T) {
+	var buf []byte
+	// Header (10 bytes, version 1.0)
+	buf = append(buf, 0x00, 0x01, 0x00, 0x00) // version 1.0
+	buf = append(buf, 0x00, 0x0A)             // scriptListOffset = 10
+	buf = append(buf, 0x00, 0x1E)             // featureListOffset = 30
+	buf = append(buf, 0x00, 0x2C)             // lookupListOffset = 44
+
+	// offset 10: ScriptList with DFLT -> default LangSys enabling feature 0
+	buf = append(buf, 0x00, 0x01, 'D', 'F', 'L', 'T', 0x00, 0x08)
+	buf = append(buf, 0x00, 0x04, 0x00, 0x00)             // defaultLangSysOffset = 4, langSysCount = 0
+	buf = append(buf, 0x00, 0x00, 0xFF, 0xFF, 0x00, 0x01) // lookupOrder, no required feature, 1 feature
+	buf = append(buf, 0x00, 0x00)                         // featureIndex 0
+
+	// offset 30: FeatureList with 'kern' -> lookup 0
+	buf = append(buf, 0x00, 0x01, 'k', 'e', 'r', 'n', 0x00, 0x08)
+	buf = append(buf, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00)
+
+	// offset 44: LookupList with one extension lookup
+	buf = append(buf, 0x00, 0x01, 0x00, 0x04)
+	buf = append(buf, 0x00, 0x09, 0x00, 0x00, 0x00, 0x01, 0x00, 0x08) // type 9, flag 0, 1 subtable at 8
+	buf = append(buf, 0x00, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00, 0x08) // extension of type 2, offset 8
+
+	// offset 64: PairPos format 1, first glyph 3, pair (3, 7) XAdvance -40
+	buf = append(buf, 0x00, 0x01, 0x00, 0x14) // format 1, coverageOffset = 20
+	buf = append(buf, 0x00, 0x14, 0x00, 0x00) // valueFormat1 = XAdvance | XPlaDevice, valueFormat2 = 0
+	buf = append(buf, 0x00, 0x01, 0x00, 0x0C) // pairSetCount = 1, pairSetOffset = 12
+	buf = append(buf, 0x00, 0x01)             // pairValueCount = 1
+	buf = append(buf, 0x00, 0x07, 0xFF, 0xD8) // secondGlyph = 7, XAdvance = -40
+	buf = append(buf, 0x00, 0x1A)             // XPlaDevice offset = 26
+	buf = append(buf, 0x00, 0x01, 0x00, 0x01, 0x00, 0x03)
+	buf = append(buf, 0x00, 0x0C, 0x00, 0x0C, 0x00, 0x01, 0x40, 0x00) // device: ppem 12, delta +1
+
+	gpos, err := GetGpos(buf, 0)
+	if err != nil {
+		t.Fatalf("GetGpos failed: %v", err)
+	}
+	if len(gpos.Scripts) != 1 || gpos.Scripts[0].Tag != "DFLT" || !reflect.DeepEqual(gpos.Scripts[0].DefaultLangSys.FeatureIndices, []uint16{0}) {
+		t.Errorf("unexpected scripts %+v", gpos.Scripts)
+	}
+	if len(gpos.Features) != 1 || gpos.Features[0].Tag != "kern" {
+		t.Errorf("unexpected features %+v", gpos.Features)
+	}
+	if len(gpos.Lookups) != 1 || gpos.Lookups[0].LookupType != GPOS_LOOKUP_PAIR || len(gpos.Lookups[0].PairPos) != 1 {
+		t.Fatalf("expected one unwrapped pair lookup, got %+v", gpos.Lookups)
+	}
+	if vf := gpos.Lookups[0].PairPos[0].ValueFormat1; vf != GPOS_VALUE_X_ADVANCE|GPOS_VALUE_X_PLA_DEVICE {
+		t.Errorf("expected device bits to be kept in valueFormat1, got 0x%04X", vf)
+	}
+	device := gpos.Lookups[0].PairPos[0].PairSets[3][0].Value1.XPlaDevice
+	if device == nil || device.StartSize != 12 || !reflect.DeepEqual(device.DeltaValues, []int8{1}) {
+		t.Errorf("unexpected XPlaDevice %+v", device)
+	}
+	if v := gpos.Kerning(3, 7); v != -40 {
+		t.Errorf("expected kerning -40, got %d", v)
+	}
+
+	reparsed, err := GetGpos(WriteGpos(gpos), 0)
+	if err != nil {
+		t.Fatalf("GetGpos of written table failed: %v", err)
+	}
+	if !reflect.DeepEqual(reparsed, gpos) {
+		t.Errorf("round trip mismatch: %+v vs %+v", reparsed, gpos)
+	}
+
+	remapGpos(gpos, map[int]int{0: 0, 3: 1, 7: 2})
+	if v := gpos.Kerning(1, 2); v != -40 {
+		t.Errorf("expected remapped kerning -40, got %d", v)
+	}
+}
+
+func TestGposRawLookups(t *testing.T) {
+	fileByte, err := os.ReadFile("../test/Changa-Regular.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := GetTableContent(int(GetOffsetTable(fileByte).NumTables), fileByte)["GPOS"]
+	gpos, err := GetGpos(fileByte, int(info.Offset))
+	if err != nil {
+		t.Fatalf("GetGpos failed: %v", err)
+	}
+	raw := 0
+	for _, lookup := range gpos.Lookups {
+		if lookup.LookupType != GPOS_LOOKUP_PAIR {
+			if len(lookup.Subtables) == 0 {
+				t.Errorf("lookup type %d kept no subtables", lookup.LookupType)
+			}
+			raw++
+		}
+	}
+	if raw == 0 {
+		t.Fatalf("expected mark and cursive lookups in Changa")
+	}
+
+	// unchanged lookups are written back as read, with their shared tables
+	data := WriteGpos(gpos)
+	if len(data) > int(info.Length) {
+		t.Errorf("GPOS grew from %d to %d bytes", info.Length, len(data))
+	}
+	reparsed, err := GetGpos(data, 0)
+	if err != nil {
+		t.Fatalf("GetGpos of written table failed: %v", err)
+	}
+	if !reflect.DeepEqual(reparsed.Lookups, gpos.Lookups) {
+		t.Errorf("round trip lost lookups")
+	}
+
+	// every third glyph is dropped
+	oldToNew := make(map[int]int)
+	for g := 0; g < 1000; g++ {
+		if g%3 != 1 {
+			oldToNew[g] = len(oldToNew)
+		}
+	}
+	if err := remapGpos(gpos, oldToNew); err != nil {
+		t.Fatalf("remapGpos failed: %v", err)
+	}
+	for i, lookup := range gpos.Lookups {
+		if lookup.LookupType != GPOS_LOOKUP_PAIR && len(lookup.Subtables) == 0 {
+			t.Errorf("lookup %d lost its subtables", i)
+		}
+		for _, sub := range lookup.Subtables {
+			if _, err := gposSubtableLength(sub, 0, lookup.LookupType); err != nil {
+				t.Errorf("lookup %d remapped subtable is invalid: %v", i, err)
+			}
+		}
+	}
+	if _, err := GetGpos(WriteGpos(gpos), 0); err != nil {
+		t.Errorf("GetGpos of remapped table failed: %v", err)
+	}
+}
+
+func TestRemapGposContext(t *testing.T) {
+	// context format 1: glyph 4 followed by 8, and glyph 6 followed by 10
+	context := []byte{
+		0x00, 0x01, 0x00, 0x0A, 0x00, 0x02, 0x00, 0x12, 0x00, 0x20, // format, coverage, 2 rule sets
+		0x00, 0x01, 0x00, 0x02, 0x00, 0x04, 0x00, 0x06, // coverage 4, 6
+		0x00, 0x01, 0x00, 0x04, // rule set with one rule
+		0x00, 0x02, 0x00, 0x01, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, // 2 glyphs, 1 lookup record
+		0x00, 0x01, 0x00, 0x04,
+		0x00, 0x02, 0x00, 0x01, 0x00, 0x0A, 0x00, 0x00, 0x00, 0x00,
+	}
+	// chained context format 3: input glyph 5, lookahead glyphs 7 or 9
+	chained := []byte{
+		0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x12, 0x00, 0x01, 0x00, 0x18, // format, no backtrack, input, lookahead
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x01, // one lookup record
+		0x00, 0x01, 0x00, 0x01, 0x00, 0x05,
+		0x00, 0x01, 0x00, 0x02, 0x00, 0x07, 0x00, 0x09,
+	}
+	gpos := &Gpos{Lookups: []*GposLookup{
+		{LookupType: GPOS_LOOKUP_CONTEXT, Subtables: [][]byte{context}},
+		{LookupType: GPOS_LOOKUP_CHAINED_CONTEXT, Subtables: [][]byte{chained}},
+	}}
+	if err := remapGpos(gpos, map[int]int{4: 1, 5: 2, 6: 3, 8: 4, 9: 5}); err != nil {
+		t.Fatalf("remapGpos failed: %v", err)
+	}
+
+	// the rule of glyph 6 uses the dropped glyph 10, so only glyph 4 stays
+	sub := gpos.Lookups[0].Subtables[0]
+	coverage, _ := getCoverage(sub, int(getUint16(sub[2:4])))
+	if !reflect.DeepEqual(coverage, []uint16{1}) || getUint16(sub[4:6]) != 1 {
+		t.Fatalf("unexpected context subtable % x", sub)
+	}
+	set := int(getUint16(sub[6:8]))
+	rule := set + int(getUint16(sub[set+2:set+4]))
+	if getUint16(sub[rule+4:rule+6]) != 4 {
+		t.Errorf("expected the rule's second glyph to become 4, got % x", sub[rule:])
+	}
+
+	sub = gpos.Lookups[1].Subtables[0]
+	input, _ := getCoverage(sub, int(getUint16(sub[6:8])))
+	lookahead, _ := getCoverage(sub, int(getUint16(sub[10:12])))
+	if !reflect.DeepEqual(input, []uint16{2}) || !reflect.DeepEqual(lookahead, []uint16{5}) {
+		t.Errorf("unexpected chained context coverages %v %v", input, lookahead)
+	}
+
+	// without glyph 5 the chained rule can never match
+	gpos.Lookups[1].Subtables = [][]byte{chained}
+	if err := remapGpos(gpos, map[int]int{7: 0}); err != nil {
+		t.Fatalf("remapGpos failed: %v", err)
+	}
+	if len(gpos.Lookups[1].Subtables) != 0 {
+		t.Errorf("expected the chained context subtable to be dropped")
+	}
+}
+
+func TestKernToGpos(t *testing.T) {
+	kern, err := GetKern(getWindowsKernData(), 0)
+	if err != nil {
+		t.Fatalf("GetKern failed: %v", err)
+	}
+	kern.Subtables[0].Coverage.Horizontal = true
+	gpos := KernToGpos(kern)
+	if gpos == nil {
+		t.Fatalf("KernToGpos returned nil")
+	}
+	if len(gpos.Features) != 1 || gpos.Features[0].Tag != "kern" || len(gpos.Scripts) != 2 {
+		t.Errorf("unexpected feature layout %+v %+v", gpos.Features, gpos.Scripts)
+	}
+	pairPos := gpos.Lookups[0].PairPos
+	if len(pairPos) != 1 || pairPos[0].Format != 1 {
+		t.Errorf("expected a single format 1 subtable for sparse pairs, got %+v", pairPos)
+	}
+	reparsed, err := GetGpos(WriteGpos(gpos), 0)
+	if err != nil {
+		t.Fatalf("GetGpos of converted table failed: %v", err)
+	}
+	if v := reparsed.Kerning(65, 86); v != -50 {
+		t.Errorf("expected A-V -50, got %d", v)
+	}
+	if v := reparsed.Kerning(70, 46); v != -30 {
+		t.Errorf("expected F-. -30, got %d", v)
+	}
+
+	back := GposToKern(reparsed, 100)
+	if back == nil || len(back.Subtables) != 1 || len(back.Subtables[0].Pairs) != 2 {
+		t.Fatalf("unexpected kern from GPOS: %+v", back)
+	}
+	if v := back.Kerning(65, 86); v != -50 {
+		t.Errorf("expected A-V -50 after converting back, got %d", v)
+	}
+
+	// Glyphs 10-19 kern against 30-39 by the same amount: one class each side
+	kern = &Kern{Subtables: []*KernSubtable{{
+		Coverage:   KernCoverage{Horizontal: true},
+		SubHeaders: map[string]int{"format": 0},
+	}}}
+	for l := uint16(10); l < 20; l++ {
+		for r := uint16(30); r < 40; r++ {
+			kern.Subtables[0].Pairs = append(kern.Subtables[0].Pairs, &nPairs{l, r, -20})
+		}
+	}
+	gpos = KernToGpos(kern)
+	pairPos = gpos.Lookups[0].PairPos
+	if len(pairPos) != 1 || pairPos[0].Format != 2 || len(pairPos[0].ClassValues) != 1 || len(pairPos[0].ClassValues[0]) != 2 {
+		t.Fatalf("expected a 1x2 class-based subtable, got %+v", pairPos)
+	}
+	if v := gpos.Kerning(12, 35); v != -20 {
+		t.Errorf("expected class kerning -20, got %d", v)
+	}
+	if v := gpos.Kerning(12, 40); v != 0 {
+		t.Errorf("expected no kerning for unclassed glyph, got %d", v)
+	}
+}
+
+func TestGposToKernSplit(t *testing.T) {
+	// 120 first glyphs kerned against 100 second glyphs: 12000 pairs
+	classDef2 := make(map[uint16]uint16)
+	for g := uint16(200); g < 300; g++ {
+		classDef2[g] = 1
+	}
+	coverage := make([]uint16, 0, 120)
+	for g := uint16(0); g < 120; g++ {
+		coverage = append(coverage, g)
+	}
+	gpos := &Gpos{
+		MajorVersion: 1,
+		Features:     []*FeatureRecord{{Tag: "kern", LookupIndices: []uint16{0}}},
+		Lookups: []*GposLookup{{LookupType: GPOS_LOOKUP_PAIR, PairPos: []*PairPos{{
+			Format:       2,
+			ValueFormat1: GPOS_VALUE_X_ADVANCE,
+			Coverage:     coverage,
+			ClassDef2:    classDef2,
+			ClassValues:  [][]*PairClassValue{{{}, {Value1: ValueRecord{XAdvance: -10}}}},
+		}}}},
+	}
+
+	kern := GposToKern(gpos, 300)
+	if kern == nil || len(kern.Subtables) != 2 {
+		t.Fatalf("expected two subtables, got %+v", kern)
+	}
+	if n := len(kern.Subtables[0].Pairs); n != KERN_MAX_PAIRS_PER_SUBTABLE {
+		t.Errorf("expected first subtable to hold %d pairs, got %d", KERN_MAX_PAIRS_PER_SUBTABLE, n)
+	}
+	if n := len(kern.Subtables[1].Pairs); n != 12000-KERN_MAX_PAIRS_PER_SUBTABLE {
+		t.Errorf("expected second subtable to hold %d pairs, got %d", 12000-KERN_MAX_PAIRS_PER_SUBTABLE, n)
+	}
+	reparsed, err := GetKern(WriteKern(kern), 0)
+	if err != nil {
+		t.Fatalf("GetKern of flattened table failed: %v", err)
+	}
+	if len(reparsed.Subtables) != 2 || reparsed.Kerning(119, 299) != -10 || reparsed.Kerning(0, 200) != -10 {
+		t.Errorf("flattened kern table did not survive a round trip")
+	}
+}
+
+func TestConvertKernToGposMerge(t *testing.T) {
+	kern, err := GetKern(getWindowsMultiKernData(), 0)
+	if err != nil {
+		t.Fatalf("GetKern failed: %v", err)
+	}
+	existing := &Gpos{
+		MajorVersion: 1,
+		Scripts:      []*ScriptRecord{{Tag: "latn", DefaultLangSys: &LangSys{RequiredFeatureIndex: 0xFFFF, FeatureIndices: []uint16{0}}}},
+		Features:     []*FeatureRecord{{Tag: "mark", LookupIndices: []uint16{0}}},
+		Lookups:      []*GposLookup{{LookupType: 4}},
+	}
+	f := &Font{fontInfo: &FontInfo{Tables: &Tables{Kern: kern, Gpos: existing}}}
+	if err := f.ConvertKernToGpos(); err != nil {
+		t.Fatalf("ConvertKernToGpos failed: %v", err)
+	}
+	if existing.Features[0].Tag != "kern" || existing.Features[1].Tag != "mark" {
+		t.Errorf("expected kern feature inserted before mark, got %+v", existing.Features)
+	}
+	if !reflect.DeepEqual(existing.Features[0].LookupIndices, []uint16{1}) {
+		t.Errorf("expected kern feature to use lookup 1, got %v", existing.Features[0].LookupIndices)
+	}
+	if !reflect.DeepEqual(existing.Scripts[0].DefaultLangSys.FeatureIndices, []uint16{0, 1}) {
+		t.Errorf("expected both features enabled, got %v", existing.Scripts[0].DefaultLangSys.FeatureIndices)
+	}
+	// -50 + -10 from the horizontal subtables; the cross-stream one is ignored
+	if v := existing.Kerning(65, 86); v != -60 {
+		t.Errorf("expected merged A-V kerning -60, got %d", v)
+	}
+	if err := f.ConvertKernToGpos(); err == nil {
+		t.Errorf("expected an error when GPOS already has a kern feature")
+	}
+}
+
+func TestGetCmapFormat14(t *testing.T) {
+	var buf []byte
+	buf = append(buf, 0x00, 0x00, 0x00, 0x02)             // version 0, numberSubtables 2
+	buf = append(buf, 0x00, 0x00, 0x00, 0x05)             // platform 0, encoding 5
+	buf = append(buf, 0x00, 0x00, 0x00, 0x14)             // offset 20
+	buf = append(buf, 0x00, 0x01, 0x00, 0x00)             // platform 1, encoding 0
+	buf = append(buf, 0x00, 0x00, 0x00, 0x3A)             // offset 58
+	buf = append(buf, 0x00, 0x0E, 0x00, 0x00, 0x00, 0x26) // format 14, length 38
+	buf = append(buf, 0x00, 0x00, 0x00, 0x01)             // numVarSelectorRecords 1
+	buf = append(buf, 0x00, 0xFE, 0x00)                   // varSelector U+FE00
+	buf = append(buf, 0x00, 0x00, 0x00, 0x15)             // defaultUVSOffset 21
+	buf = append(buf, 0x00, 0x00, 0x00, 0x1D)             // nonDefaultUVSOffset 29
+	buf = append(buf, 0x00, 0x00, 0x00, 0x01)             // numUnicodeValueRanges 1
+	buf = append(buf, 0x00, 0x00, 0x41, 0x00)             // 'A', additionalCount 0
+	buf = append(buf, 0x00, 0x00, 0x00, 0x01)             // numUVSMappings 1
+	buf = append(buf, 0x00, 0x00, 0x42, 0x00, 0x09)       // 'B' -> glyph 9
+	// format 0: 'A' -> 3, 'B' -> 4
+	buf = append(buf, 0x00, 0x00, 0x01, 0x06, 0x00, 0x00)
+	glyphIndexArray := make([]byte, 256)
+	glyphIndexArray['A'], glyphIndexArray['B'] = 3, 4
+	buf = append(buf, glyphIndexArray...)
+
+	cmap, err := GetCmap(buf, 0, 10)
+	if err != nil {
+		t.Fatalf("GetCmap failed: %v", err)
+	}
+	if cmap.WindowsCode['B'] != 4 {
+		t.Errorf("expected the variant glyph to stay out of WindowsCode, got %d", cmap.WindowsCode['B'])
+	}
+	wantUVS := map[int]*UVSSelector{0xFE00: {DefaultUVS: []int{'A'}, NonDefaultUVS: map[int]int{'B': 9}}}
+	if !reflect.DeepEqual(cmap.UVS, wantUVS) {
+		t.Errorf("unexpected UVS %+v", cmap.UVS)
+	}
+	if glyph, ok := cmap.GlyphForVariation('A', 0xFE00); !ok || glyph != 3 {
+		t.Errorf("expected default sequence to resolve to glyph 3, got %d %v", glyph, ok)
+	}
+	if glyph, ok := cmap.GlyphForVariation('B', 0xFE00); !ok || glyph != 9 {
+		t.Errorf("expected non-default sequence to resolve to glyph 9, got %d %v", glyph, ok)
+	}
+	if _, ok := cmap.GlyphForVariation('A', 0xFE01); ok {
+		t.Errorf("expected undefined sequence to report ok=false")
+	}
+
+	cmap.UVS[0xE0100] = &UVSSelector{NonDefaultUVS: map[int]int{'A': 8}}
+	data, err := WriteCmap(cmap)
+	if err != nil {
+		t.Fatalf("WriteCmap failed: %v", err)
+	}
+	reparsed, err := GetCmap(data, 0, 10)
+	if err != nil {
+		t.Fatalf("GetCmap of written table failed: %v", err)
+	}
+	if !reflect.DeepEqual(reparsed.UVS, cmap.UVS) {
+		t.Errorf("UVS round trip mismatch: %+v vs %+v", reparsed.UVS, cmap.UVS)
+	}
+
+	f := &Font{fontInfo: &FontInfo{Tables: &Tables{Cmap: reparsed}, Glyphs: &Glyphs{}}}
+	if err := f.Subset([]string{"B"}); err != nil {
+		t.Fatalf("Subset failed: %v", err)
+	}
+	// glyphs 0, 4 and the variant 9 are kept as 0, 1 and 2
+	if glyph, ok := f.GlyphForVariation('B', 0xFE00); !ok || glyph != 2 {
+		t.Errorf("expected subset variant glyph 2, got %d %v", glyph, ok)
+	}
+	if _, ok := f.GlyphForVariation('A', 0xE0100); ok {
+		t.Errorf("expected sequences on dropped bases to be removed")
+	}
+	hasFormat14 := false
+	for _, subTable := range reparsed.SubTables {
+		if subTable["format"].(uint16) == 14 {
+			hasFormat14 = true
+		}
+	}
+	if !hasFormat14 {
+		t.Errorf("expected Subset to keep a format 14 subtable")
+	}
+}
+
+func TestEncodeCmapFormats(t *testing.T) {
+	// two single-byte codes and two lead bytes, as in a Shift-JIS font
+	mixed := map[int]int{0x41: 3, 0x7A: 9, 0x8140: 20, 0x8141: 22, 0x9F40: 30}
+	// sparse BMP codes that mix runs and scattered glyphs
+	bmp := map[int]int{0x20: 1, 0x21: 2, 0x22: 3, 0x30: 10, 0x32: 7, 0x33: 5, 0x4E00: 40, 0xFF01: 41}
+	astral := map[int]int{0x41: 3, 0x42: 4, 0x1F600: 50, 0x1F601: 51}
+	// codes sharing one glyph collapse into a single format 13 group
+	lastResort := map[int]int{0x10000: 1, 0x10001: 1, 0x10002: 1}
+
+	tests := []struct {
+		format uint16
+		codes  map[int]int
+	}{
+		{0, map[int]int{0x41: 3, 0xFF: 200}},
+		{2, mixed},
+		{4, bmp},
+		{6, map[int]int{0x30: 10, 0x32: 7, 0x33: 5}},
+		{10, astral},
+		{12, astral},
+		{13, lastResort},
+	}
+	for _, tt := range tests {
+		subTable, ok := encodeCmapSubtable(tt.format, 3, 10, 0, tt.codes)
+		if !ok {
+			t.Fatalf("format %d: encode failed", tt.format)
+		}
+		data, err := WriteCmap(&Cmap{SubTables: []map[string]interface{}{subTable}})
+		if err != nil {
+			t.Fatalf("format %d: WriteCmap failed: %v", tt.format, err)
+		}
+		if len(data) != 12+cmapSubtableLength(subTable) {
+			t.Errorf("format %d: length %d does not match %d written bytes", tt.format, cmapSubtableLength(subTable), len(data)-12)
+		}
+		cmap, err := GetCmap(data, 0, 0x10000)
+		if err != nil {
+			t.Fatalf("format %d: GetCmap failed: %v", tt.format, err)
+		}
+		if tt.format == 13 {
+			if want := []*CmapFormat8nGroup{{0x10000, 0x10002, 1}}; !reflect.DeepEqual(cmap.LastResort, want) {
+				t.Errorf("format 13: unexpected groups %v", cmap.LastResort)
+			}
+			continue
+		}
+		if got := decodeCmapSubtable(cmap.SubTables[0]); !reflect.DeepEqual(got, tt.codes) {
+			t.Errorf("format %d: round trip mismatch %v", tt.format, got)
+		}
+	}
+
+	if _, ok := encodeCmapFormat0(1, 0, 0, map[int]int{0x100: 1}); ok {
+		t.Errorf("expected format 0 to reject two-byte codes")
+	}
+	if _, ok := encodeCmapFormat2(3, 2, 0, map[int]int{0x81: 1, 0x8140: 2}); ok {
+		t.Errorf("expected format 2 to reject a single-byte code that is also a lead byte")
+	}
+	if _, ok := encodeCmapFormat6(3, 1, 0, map[int]int{0x20: 1, 0xFFF0: 2}); ok {
+		t.Errorf("expected format 6 to reject a range that overflows its length")
+	}
+}
+
+func TestCmapLastResortRanges(t *testing.T) {
+	// a last resort subtable over every code point beside a BMP subtable
+	lastResort := cmapGroupsSubtable(13, 0, 6, 0, []*CmapFormat8nGroup{{0, 0xFFFF, 1}, {0x10000, 0x10FFFF, 2}})
+	bmp, _ := encodeCmapSubtable(4, 3, 1, 0, map[int]int{'A': 3, 'B': 4})
+	data, err := WriteCmap(&Cmap{SubTables: []map[string]interface{}{lastResort, bmp}})
+	if err != nil {
+		t.Fatalf("WriteCmap failed: %v", err)
+	}
+	cmap, err := GetCmap(data, 0, 5)
+	if err != nil {
+		t.Fatalf("GetCmap failed: %v", err)
+	}
+	if len(cmap.WindowsCode) != 2 {
+		t.Errorf("expected the last resort ranges to stay out of WindowsCode, got %d codes", len(cmap.WindowsCode))
+	}
+	for code, want := range map[int]int{'A': 3, 'C': 1, 0x1F600: 2, 0x10FFFF: 2} {
+		if glyph, ok := cmap.GlyphIndex(code); !ok || glyph != want {
+			t.Errorf("code %#x: got glyph %d, want %d", code, glyph, want)
+		}
+	}
+
+	OptimizeCmap(cmap)
+	if got := cmap.SubTables[0]["groups"]; !reflect.DeepEqual(got, lastResort["groups"]) {
+		t.Errorf("expected OptimizeCmap to keep the last resort groups, got %v", got)
+	}
+
+	// glyph 1 is dropped and glyph 2 becomes 1
+	if err := rebuildCmapSubtables(cmap, map[int]int{0: 0, 2: 1, 3: 2}, map[int]int{'A': 2}); err != nil {
+		t.Fatalf("rebuildCmapSubtables failed: %v", err)
+	}
+	if want := []*CmapFormat8nGroup{{0x10000, 0x10FFFF, 1}}; !reflect.DeepEqual(cmap.LastResort, want) {
+		t.Errorf("unexpected remapped last resort groups %v", cmap.LastResort)
+	}
+}
+
+func TestEncodeCmapFormat4RangeOffset(t *testing.T) {
+	// one glyph per code with no consecutive glyphs: a single
+	// idRangeOffset segment is cheaper than one idDelta segment per code
+	codes := map[int]int{}
+	for c := 0x41; c <= 0x5A; c++ {
+		codes[c] = (c * 37) % 101
+	}
+	delete(codes, 0x50)
+	subTable, ok := encodeCmapFormat4(3, 1, 0, codes)
+	if !ok {
+		t.Fatalf("encode failed")
+	}
+	if segCountX2 := subTable["segCountX2"].(uint16); segCountX2 != 4 {
+		t.Errorf("expected one segment plus the 0xFFFF terminator, got segCountX2 %d", segCountX2)
+	}
+	if idRangeOffset := subTable["idRangeOffset"].([]uint16); idRangeOffset[0] != 4 || idRangeOffset[1] != 0 {
+		t.Errorf("unexpected idRangeOffset %v", idRangeOffset)
+	}
+	if got := decodeCmapSubtable(subTable); !reflect.DeepEqual(got, codes) {
+		t.Errorf("decode mismatch %v", got)
+	}
+
+	// a long run stays a single idDelta segment
+	run := map[int]int{}
+	for c := 0x20; c < 0x7F; c++ {
+		run[c] = c - 0x1D
+	}
+	subTable, _ = encodeCmapFormat4(3, 1, 0, run)
+	if length := subTable["length"].(uint16); length != 32 {
+		t.Errorf("expected 32 bytes for a single run, got %d", length)
+	}
+}
+
+func TestOptimizeCmap(t *testing.T) {
+	// sparse Unicode codes, where format 4 beats format 6
+	unicode := map[int]int{0x41: 3, 0x42: 4, 0x4E00: 5}
+	// Mac Roman codes spread over the whole byte range
+	roman := map[int]int{0x20: 1, 0x41: 3, 0xF0: 7}
+	format6, _ := encodeCmapFormat6(0, 3, 0, unicode)
+	mac, _ := encodeCmapFormat6(1, 0, 0, roman)
+	windows, _ := encodeCmapGroups(12, 3, 1, 0, unicode)
+	duplicate, _ := encodeCmapFormat4(3, 1, 0, map[int]int{0x41: 9})
+	cmap := &Cmap{SubTables: []map[string]interface{}{format6, mac, windows, duplicate}, NumberSubtables: 4}
+
+	OptimizeCmap(cmap)
+	if cmap.NumberSubtables != 3 {
+		t.Fatalf("expected the repeated (3, 1) record to be dropped, got %d subtables", cmap.NumberSubtables)
+	}
+	for i, want := range []struct {
+		format uint16
+		codes  map[int]int
+	}{{4, unicode}, {0, roman}, {4, unicode}} {
+		if format := cmap.SubTables[i]["format"].(uint16); format != want.format {
+			t.Errorf("subtable %d: expected format %d, got %d", i, want.format, format)
+		}
+		if got := decodeCmapSubtable(cmap.SubTables[i]); !reflect.DeepEqual(got, want.codes) {
+			t.Errorf("subtable %d: mapping changed to %v", i, got)
+		}
+	}
+
+	// the (0, 3) and (3, 1) subtables are identical and written once
+	data, err := WriteCmap(cmap)
+	if err != nil {
+		t.Fatalf("WriteCmap failed: %v", err)
+	}
+	if want := 4 + 3*8 + cmapSubtableLength(cmap.SubTables[0]) + 262; len(data) != want {
+		t.Errorf("expected %d bytes with a shared subtable, got %d", want, len(data))
+	}
+	if offset0, offset2 := getUint32(data[8:]), getUint32(data[24:]); offset0 != offset2 {
+		t.Errorf("expected shared offsets, got %d and %d", offset0, offset2)
+	}
+}
+
+func TestCmapSymbolAndUnicodePlatform(t *testing.T) {
+	parse := func(subTables ...map[string]interface{}) *Cmap {
+		data, err := WriteCmap(&Cmap{SubTables: subTables})
+		if err != nil {
+			t.Fatalf("WriteCmap failed: %v", err)
+		}
+		cmap, err := GetCmap(data, 0, 10)
+		if err != nil {
+			t.Fatalf("GetCmap failed: %v", err)
+		}
+		return cmap
+	}
+
+	// a symbol font encodes its characters at 0xF000 + byte
+	symbol, _ := encodeCmapFormat4(3, 0, 0, map[int]int{0xF041: 1, 0xF0E8: 2})
+	cmap := parse(symbol)
+	for _, tt := range []struct {
+		code, glyph int
+	}{{0x41, 1}, {0xF041, 1}, {0xE8, 2}} {
+		if glyph, ok := cmap.GlyphIndex(tt.code); !ok || glyph != tt.glyph {
+			t.Errorf("symbol U+%04X: expected glyph %d, got %d %v", tt.code, tt.glyph, glyph, ok)
+		}
+	}
+
+	f := &Font{fontInfo: &FontInfo{Tables: &Tables{Cmap: cmap}, Glyphs: &Glyphs{}}}
+	if err := f.Subset([]string{"\u00E8"}); err != nil {
+		t.Fatalf("Subset of symbol font failed: %v", err)
+	}
+	subTables := f.fontInfo.Tables.Cmap.SubTables
+	if len(subTables) != 1 || subTables[0]["platformSpecificID"].(uint16) != 0 {
+		t.Fatalf("expected only the (3, 0) subtable to remain, got %d subtables", len(subTables))
+	}
+	if got := decodeCmapSubtable(subTables[0]); !reflect.DeepEqual(got, map[int]int{0xF0E8: 1}) {
+		t.Errorf("unexpected subset symbol mapping %v", got)
+	}
+
+	// platform 0 only: BMP and full repertoire subtables are merged
+	bmp, _ := encodeCmapFormat4(0, 3, 0, map[int]int{0x41: 1, 0x42: 2})
+	full, _ := encodeCmapGroups(12, 0, 4, 0, map[int]int{0x41: 1, 0x42: 3, 0x1F600: 4})
+	cmap = parse(bmp, full)
+	if want := map[int]int{0x41: 1, 0x42: 3, 0x1F600: 4}; !reflect.DeepEqual(cmap.WindowsCode, want) {
+		t.Errorf("unexpected platform 0 mapping %v", cmap.WindowsCode)
+	}
+
+	// a Mac Roman only font is converted to Unicode
+	roman, _ := encodeCmapFormat0(1, 0, 0, map[int]int{0x41: 1, 0x8A: 2, 0xD2: 3})
+	cmap = parse(roman)
+	if want := map[int]int{'A': 1, 'ä': 2, '“': 3}; !reflect.DeepEqual(cmap.WindowsCode, want) {
+		t.Errorf("unexpected Mac Roman mapping %v", cmap.WindowsCode)
+	}
+}
+
+func TestGlyphNameToUnicode(t *testing.T) {
+	tests := []struct {
+		name string
+		want []rune
+	}{
+		{"Aacute", []rune{0x00C1}},
+		{"Delta", []rune{0x2206}},
+		{"uni4E00", []rune{0x4E00}},
+		{"uni00410042", []rune{'A', 'B'}},
+		{"u1F600", []rune{0x1F600}},
+		{"f_i", []rune{'f', 'i'}},
+		{"a.sc", []rune{'a'}},
+		{"uni4e00", nil}, // lowercase hex is not an AGL name
+		{"uniD800", nil}, // surrogates are rejected
+		{"u110000", nil}, // beyond Unicode
+		{"foo_bar", nil}, // an unknown component voids the name
+		{".notdef", nil}, // nothing before the suffix
+		{"fi", []rune{0xFB01}},
+		{"ffi", []rune{0xFB03}},
+		{"onesuperior", []rune{0x00B9}},
+		{"Tcommaaccent", []rune{0x0162}},
+		{"afii10017", []rune{0x0410}},
+		{"Asmall", []rune{0xF761}},
+		{"lamedholamdagesh", []rune{0x05DC, 0x05B9, 0x05BC}},
+		{"afii10017_lamedholam", []rune{0x0410, 0x05DC, 0x05B9}},
+	}
+	for _, tt := range tests {
+		if got := GlyphNameToUnicode(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %U, got %U", tt.name, tt.want, got)
+		}
+	}
+
+	for r, want := range map[rune]string{'A': "A", 0x2206: "Delta", 0x0394: "uni0394", 0x1F600: "u1F600", 0x00B9: "onesuperior", 0xFB03: "uniFB03"} {
+		if got := UnicodeToGlyphName(r); got != want {
+			t.Errorf("%U: expected %s, got %s", r, want, got)
+		}
+	}
+}
+
+func TestGlyphNames(t *testing.T) {
+	cmap := &Cmap{WindowsCode: map[int]int{'A': 1, 0x4E00: 2, 0x1F600: 3, 'B': 4}}
+	tables := &Tables{Cmap: cmap, Maxp: &Maxp{NumGlyphs: 6}, Post: &Post{Format: 3}}
+	f := &Font{fontInfo: &FontInfo{Tables: tables, Glyphs: &Glyphs{}}}
+
+	// format 3 stores no names, so they come from the cmap
+	wantNames := []string{".notdef", "A", "uni4E00", "u1F600", "B", "glyph5"}
+	for gid, want := range wantNames {
+		if got := f.GlyphName(gid); got != want {
+			t.Errorf("glyph %d: expected %s, got %s", gid, want, got)
+		}
+	}
+	if f.GlyphName(6) != "" {
+		t.Errorf("expected no name beyond numGlyphs")
+	}
+	if glyph, ok := f.GlyphByName("uni0042"); !ok || glyph != 4 {
+		t.Errorf("expected uni0042 to resolve to glyph 4 through the cmap, got %d %v", glyph, ok)
+	}
+	if _, ok := f.GlyphByName("Aacute"); ok {
+		t.Errorf("expected an unmapped AGL name not to resolve")
+	}
+	// the names are derived once and follow a new cmap
+	cache := f.names
+	if f.GlyphName(2); f.names != cache {
+		t.Errorf("expected the glyph names to be cached")
+	}
+	tables.Cmap = &Cmap{WindowsCode: map[int]int{'C': 4}}
+	if got := f.GlyphName(4); got != "C" {
+		t.Errorf("expected glyph 4 to follow the new cmap, got %s", got)
+	}
+	if glyph, ok := f.GlyphByName("C"); !ok || glyph != 4 {
+		t.Errorf("expected C to be glyph 4, got %d %v", glyph, ok)
+	}
+	tables.Cmap = cmap
+
+	if err := f.RegenerateGlyphNames(); err != nil {
+		t.Fatalf("RegenerateGlyphNames failed: %v", err)
+	}
+	post := GetPost(WritePost(tables.Post), 0)
+	if post.Format != 2 || !reflect.DeepEqual(post.Names, wantNames) {
+		t.Fatalf("unexpected regenerated post format %v names %v", post.Format, post.Names)
+	}
+
+	// a ligature without a cmap entry takes its code points from its name
+	post.Names[5] = "A_B"
+	tables.Post = post
+	if got := f.GlyphUnicodes(5); !reflect.DeepEqual(got, []rune{'A', 'B'}) {
+		t.Errorf("expected the ligature name to give U+0041 U+0042, got %U", got)
+	}
+	if glyph, ok := f.GlyphByName("A_B"); !ok || glyph != 5 {
+		t.Errorf("expected A_B to be glyph 5, got %d %v", glyph, ok)
+	}
+
+	// format 2.5 names are offsets into the standard Macintosh order
+	post25 := &Post{Format: 2.5, NumberOfGlyphs: 3, Offset: []int8{0, 35, 35}}
+	for gid, want := range []string{".notdef", "A", "B"} {
+		if got, ok := post25.GlyphName(gid); !ok || got != want {
+			t.Errorf("format 2.5 glyph %d: expected %s, got %s", gid, want, got)
+		}
+	}
+	remapPost(post25, []int{0, 2})
+	if post25.Format != 2 || !reflect.DeepEqual(post25.Names, []string{".notdef", "B"}) {
+		t.Errorf("unexpected remapped post %v %v", post25.Format, post25.Names)
+	}
+}
+
+func TestNameTableSetName(t *testing.T) {
+	nameTable := &NameTable{
+		NameRecord: []*NameRecord{
+			{PlatformID: 1, PlatformSpecificID: 0, LanguageID: 0, NameID: 1},
+			{PlatformID: 3, PlatformSpecificID: 1, LanguageID: 0x0409, NameID: 1},
+		},
+		Info: map[string]map[string]string{"fontFamily": {"en": "Sample", "en-US": "Sample"}},
+	}
+
+	if err := nameTable.SetName(1, "en", "Café"); err != nil {
+		t.Fatalf("SetName failed: %v", err)
+	}
+	if err := nameTable.SetName(1, "zh-Hans", "示例"); err != nil {
+		t.Fatalf("SetName failed: %v", err)
+	}
+	if err := nameTable.SetName(300, "fr", "Gras étroit"); err != nil {
+		t.Fatalf("SetName failed: %v", err)
+	}
+	if err := nameTable.SetName(1, "", "?"); err == nil {
+		t.Errorf("expected an error for an empty language tag")
+	}
+
+	// the Chinese name does not fit Mac Roman, so only Windows gets it
+	want := []NameEntry{
+		{1, 1, 0, 0, "en", "Café"},
+		{300, 1, 0, 1, "fr", "Gras étroit"},
+		{1, 3, 1, 0x0409, "en-US", "Café"},
+		{300, 3, 1, 0x040C, "fr-FR", "Gras étroit"},
+		{1, 3, 1, 0x0804, "zh", "示例"},
+	}
+	entries := nameTable.Names()
+	if len(entries) != len(want) {
+		t.Fatalf("expected %d records, got %d", len(want), len(entries))
+	}
+	for i, entry := range entries {
+		if *entry != want[i] {
+			t.Errorf("record %d: expected %+v, got %+v", i, want[i], *entry)
+		}
+	}
+	if nameTable.Count != 5 || nameTable.StringOffset != 6+12*5 {
+		t.Errorf("unexpected Count %d StringOffset %d", nameTable.Count, nameTable.StringOffset)
+	}
+
+	reparsed := GetName(WriteName(nameTable), 0)
+	if got := reparsed.Info["fontFamily"]; !reflect.DeepEqual(got, map[string]string{"en": "Café", "en-US": "Café", "zh": "示例"}) {
+		t.Errorf("unexpected family names after round trip %v", got)
+	}
+	if got := reparsed.Info["300"]["fr"]; got != "Gras étroit" {
+		t.Errorf("expected the Mac record to round trip through Mac Roman, got %q", got)
+	}
+
+	nameTable.DeleteName(300, "")
+	nameTable.DeleteName(1, "zh-Hans")
+	if len(nameTable.NameRecord) != 2 || nameTable.Count != 2 {
+		t.Errorf("expected 2 records after deleting, got %d", len(nameTable.NameRecord))
+	}
+	if _, ok := nameTable.Info["300"]; ok {
+		t.Errorf("expected name 300 to be gone from Info")
+	}
+}
+
+func TestRenameFamily(t *testing.T) {
+	nameTable := &NameTable{
+		NameRecord: []*NameRecord{
+			{PlatformID: 1, LanguageID: 0, NameID: 1},
+			{PlatformID: 1, LanguageID: 0, NameID: 4},
+			{PlatformID: 3, PlatformSpecificID: 1, LanguageID: 0x0409, NameID: 1},
+			{PlatformID: 3, PlatformSpecificID: 1, LanguageID: 0x0409, NameID: 2},
+			{PlatformID: 3, PlatformSpecificID: 1, LanguageID: 0x0409, NameID: 16},
+			{PlatformID: 3, PlatformSpecificID: 1, LanguageID: 0x0409, NameID: 17},
+			{PlatformID: 3, PlatformSpecificID: 1, LanguageID: 0x0411, NameID: 1},
+		},
+		Info: map[string]map[string]string{
+			"fontFamily":         {"en": "Old Light", "en-US": "Old Light", "ja-JP": "旧"},
+			"fullName":           {"en": "Old Light Italic"},
+			"fontSubfamily":      {"en-US": "Italic"},
+			"preferredFamily":    {"en-US": "Old"},
+			"preferredSubfamily": {"en-US": "Light Italic"},
+		},
+	}
+	tables := &Tables{
+		Name: nameTable,
+		Os2:  &OS2{Version: 4, FsSelection: FS_SELECTION_ITALIC | 1<<7, AchVendID: "ACME", UsWeightClass: 300},
+		Head: &Head{FontRevision: 1.5, MacStyle: MAC_STYLE_ITALIC},
+		Post: &Post{Format: 3, ItalicAngle: -12},
+		Hhea: &Hhea{CaretSlopeRise: 1000, CaretSlopeRun: 213},
+	}
+	f := &Font{fontInfo: &FontInfo{Tables: tables}}
+
+	// the current style is kept when none is given
+	if err := f.RenameFamily("Acme (Pro)", nil); err != nil {
+		t.Fatalf("RenameFamily failed: %v", err)
+	}
+	want := map[string]map[string]string{
+		"fontFamily":         {"en": "Acme (Pro) Light", "en-US": "Acme (Pro) Light", "ja-JP": "Acme (Pro) Light"},
+		"fullName":           {"en": "Acme (Pro) Light Italic", "en-US": "Acme (Pro) Light Italic"},
+		"fontSubfamily":      {"en-US": "Italic"},
+		"preferredFamily":    {"en-US": "Acme (Pro)", "en": "Acme (Pro)", "ja-JP": "Acme (Pro)"},
+		"preferredSubfamily": {"en-US": "Light Italic", "en": "Light Italic", "ja-JP": "Light Italic"},
+		"uniqueID":           {"en-US": "1.500;ACME;AcmePro-LightItalic", "en": "1.500;ACME;AcmePro-LightItalic"},
+		"postScriptName":     {"en-US": "AcmePro-LightItalic", "en": "AcmePro-LightItalic"},
+	}
+	if !reflect.DeepEqual(nameTable.Info, want) {
+		t.Errorf("unexpected names after rename:\n%v\nwant\n%v", nameTable.Info, want)
+	}
+	if tables.Post.ItalicAngle != -12 || tables.Os2.FsSelection != FS_SELECTION_ITALIC|1<<7 {
+		t.Errorf("expected italic data to be kept, got angle %v fsSelection %#x", tables.Post.ItalicAngle, tables.Os2.FsSelection)
+	}
+
+	// a RIBBI style drops the typographic names
+	if err := f.RenameFamily("Acme", &RenameOptions{Style: "Bold", UniqueID: "acme-bold"}); err != nil {
+		t.Fatalf("RenameFamily failed: %v", err)
+	}
+	for _, nr := range nameTable.NameRecord {
+		if nr.NameID == 16 || nr.NameID == 17 {
+			t.Errorf("expected name %d to be removed", nr.NameID)
+		}
+	}
+	if got := nameTable.Info["fontFamily"]["ja-JP"]; got != "Acme" {
+		t.Errorf("expected the Japanese family to be renamed, got %q", got)
+	}
+	if got := nameTable.Info["fontSubfamily"]["en-US"]; got != "Bold" {
+		t.Errorf("expected subfamily Bold, got %q", got)
+	}
+	if got := nameTable.Info["uniqueID"]["en-US"]; got != "acme-bold" {
+		t.Errorf("expected the given unique ID, got %q", got)
+	}
+	if tables.Os2.FsSelection != FS_SELECTION_BOLD|1<<7 || tables.Head.MacStyle != MAC_STYLE_BOLD {
+		t.Errorf("unexpected style bits fsSelection %#x macStyle %#x", tables.Os2.FsSelection, tables.Head.MacStyle)
+	}
+	if tables.Post.ItalicAngle != 0 {
+		t.Errorf("expected an upright style to clear the italic angle, got %v", tables.Post.ItalicAngle)
+	}
+	if tables.Os2.UsWeightClass != 300 {
+		t.Errorf("expected a Light weight class to be kept for Bold, got %d", tables.Os2.UsWeightClass)
+	}
+
+	// Bold and Regular swap the default weight classes; a zero italic angle
+	// follows the caret slope
+	tables.Os2.UsWeightClass = 400
+	if err := f.RenameFamily("Acme", &RenameOptions{Style: "Bold Italic"}); err != nil {
+		t.Fatalf("RenameFamily failed: %v", err)
+	}
+	if tables.Os2.UsWeightClass != 700 || math.Abs(tables.Post.ItalicAngle+12.03) > 0.01 {
+		t.Errorf("unexpected weight class %d and italic angle %v", tables.Os2.UsWeightClass, tables.Post.ItalicAngle)
+	}
+	if err := f.RenameFamily("Acme", &RenameOptions{Style: "Regular"}); err != nil {
+		t.Fatalf("RenameFamily failed: %v", err)
+	}
+	if tables.Os2.UsWeightClass != 400 || tables.Post.ItalicAngle != 0 {
+		t.Errorf("unexpected weight class %d and italic angle %v", tables.Os2.UsWeightClass, tables.Post.ItalicAngle)
+	}
+
+	// weight words set the weight class; before OS/2 version 4 an oblique
+	// style is only italic
+	tables.Os2.Version = 3
+	if err := f.RenameFamily("Acme", &RenameOptions{Style: "Condensed Black Oblique"}); err != nil {
+		t.Fatalf("RenameFamily failed: %v", err)
+	}
+	if tables.Os2.UsWeightClass != 900 || tables.Os2.FsSelection != FS_SELECTION_ITALIC|1<<7 {
+		t.Errorf("unexpected weight class %d and fsSelection %#x", tables.Os2.UsWeightClass, tables.Os2.FsSelection)
+	}
+	if err := f.RenameFamily("Acme", &RenameOptions{Style: "Extra Light"}); err != nil {
+		t.Fatalf("RenameFamily failed: %v", err)
+	}
+	if tables.Os2.UsWeightClass != 200 {
+		t.Errorf("expected weight class 200 for Extra Light, got %d", tables.Os2.UsWeightClass)
+	}
+	if nameTable.Count != uint16(len(nameTable.NameRecord)) {
+		t.Errorf("Count %d does not match %d records", nameTable.Count, len(nameTable.NameRecord))
+	}
+}
+
+func TestNameFormat1LangTags(t *testing.T) {
+	var buf []byte
+	buf = append(buf, 0x00, 0x01, 0x00, 0x02, 0x00, 0x24)             // format 1, count 2, stringOffset 36
+	buf = append(buf, 0x00, 0x03, 0x00, 0x01, 0x04, 0x09)             // Windows, Unicode BMP, en-US
+	buf = append(buf, 0x00, 0x01, 0x00, 0x04, 0x00, 0x00)             // nameID 1, length 4, offset 0
+	buf = append(buf, 0x00, 0x03, 0x00, 0x01, 0x80, 0x00)             // Windows, Unicode BMP, lang tag 0
+	buf = append(buf, 0x00, 0x01, 0x00, 0x02, 0x00, 0x04)             // nameID 1, length 2, offset 4
+	buf = append(buf, 0x00, 0x01)                                     // langTagCount 1
+	buf = append(buf, 0x00, 0x08, 0x00, 0x06)                         // length 8, offset 6
+	buf = append(buf, 0x00, 0x41, 0x00, 0x42)                         // "AB"
+	buf = append(buf, 0x01, 0x0D)                                     // "č"
+	buf = append(buf, 0x00, 0x63, 0x00, 0x73, 0x00, 0x2D, 0x00, 0x43) // "cs-C"
+
+	nameTable := GetName(buf, 0)
+	if !reflect.DeepEqual(nameTable.LangTags, []string{"cs-C"}) {
+		t.Fatalf("unexpected lang tags %v", nameTable.LangTags)
+	}
+	if got := nameTable.Info["fontFamily"]; !reflect.DeepEqual(got, map[string]string{"en-US": "AB", "cs-C": "č"}) {
+		t.Errorf("unexpected family names %v", got)
+	}
+
+	// a tag without an LCID gets a language-tag record of its own
+	if err := nameTable.SetName(1, "tlh-Piqd", "x"); err != nil {
+		t.Fatalf("SetName failed: %v", err)
+	}
+	data := WriteName(nameTable)
+	if nameTable.StringOffset != 6+3*12+2+2*4 {
+		t.Errorf("unexpected StringOffset %d", nameTable.StringOffset)
+	}
+	reparsed := GetName(data, 0)
+	if !reflect.DeepEqual(reparsed.LangTags, []string{"cs-C", "tlh-Piqd"}) {
+		t.Fatalf("unexpected lang tags after round trip %v", reparsed.LangTags)
+	}
+	if got := reparsed.Info["fontFamily"]; !reflect.DeepEqual(got, map[string]string{"en-US": "AB", "cs-C": "č", "tlh-Piqd": "x"}) {
+		t.Errorf("unexpected family names after round trip %v", got)
+	}
+	if reparsed.NameRecord[2].LanguageID != 0x8001 {
+		t.Errorf("expected the new record to use language ID 0x8001, got %#x", reparsed.NameRecord[2].LanguageID)
+	}
+
+	reparsed.DeleteName(1, "cs-C")
+	if got := reparsed.Info["fontFamily"]; !reflect.DeepEqual(got, map[string]string{"en-US": "AB", "tlh-Piqd": "x"}) {
+		t.Errorf("unexpected family names after delete %v", got)
+	}
+}
+
+func TestMacDoubleByteNames(t *testing.T) {
+	tests := []struct {
+		encoding string
+		text     string
+		data     []byte
+	}{
+		{"x-mac-japanese", "日本語ﾌｫﾝﾄ", []byte{0x93, 0xFA, 0x96, 0x7B, 0x8C, 0xEA, 0xCC, 0xAB, 0xDD, 0xC4}},
+		{"x-mac-chinesetrad", "繁體中文", []byte{0xC1, 0x63, 0xC5, 0xE9, 0xA4, 0xA4, 0xA4, 0xE5}},
+		{"x-mac-korean", "한국어 글꼴", []byte{0xC7, 0xD1, 0xB1, 0xB9, 0xBE, 0xEE, 0x20, 0xB1, 0xDB, 0xB2, 0xC3}},
+		{"x-mac-chinesesimp", "简体中文", []byte{0xBC, 0xF2, 0xCC, 0xE5, 0xD6, 0xD0, 0xCE, 0xC4}},
+		// Apple's single-byte additions
+		{"x-mac-japanese", "\\©™…", []byte{0x80, 0xFD, 0xFE, 0xFF}},
+	}
+	for _, tt := range tests {
+		if got := encodeNameString(tt.text, tt.encoding); !reflect.DeepEqual(got, tt.data) {
+			t.Errorf("%s: encode %q gave % X", tt.encoding, tt.text, got)
+		}
+		if got := DecodeMACSTRING(tt.data, 0, len(tt.data), tt.encoding); got != tt.text {
+			t.Errorf("%s: decode gave %q, want %q", tt.encoding, got, tt.text)
+		}
+	}
+
+	// Japanese (script 1, language 11) and Korean (script 3, language 23)
+	// Mac records survive a name table round trip
+	nameTable := &NameTable{
+		NameRecord: []*NameRecord{
+			{PlatformID: 1, PlatformSpecificID: 1, LanguageID: 11, NameID: 1},
+			{PlatformID: 1, PlatformSpecificID: 3, LanguageID: 23, NameID: 1},
+		},
+		Info: map[string]map[string]string{"fontFamily": {"ja": "游ゴシック", "ko": "맑은 고딕"}},
+	}
+	reparsed := GetName(WriteName(nameTable), 0)
+	if !reflect.DeepEqual(reparsed.Info, nameTable.Info) {
+		t.Errorf("unexpected names after round trip %v", reparsed.Info)
+	}
+}
+
+func TestNameLossless(t *testing.T) {
+	var buf []byte
+	buf = append(buf, 0x00, 0x00, 0x00, 0x04, 0x00, 0x36)                   // format 0, count 4, stringOffset 54
+	buf = append(buf, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00)                   // Unicode, full repertoire
+	buf = append(buf, 0x00, 0x01, 0x00, 0x04, 0x00, 0x00)                   // nameID 1, length 4, offset 0
+	buf = append(buf, 0x00, 0x03, 0x00, 0x01, 0x04, 0x09)                   // Windows, Unicode BMP, en-US
+	buf = append(buf, 0x00, 0x01, 0x00, 0x04, 0x00, 0x00)                   // nameID 1, length 4, offset 0
+	buf = append(buf, 0x00, 0x03, 0x00, 0x0A, 0x04, 0x09)                   // Windows, Unicode full, en-US
+	buf = append(buf, 0x00, 0x01, 0x00, 0x04, 0x00, 0x04)                   // nameID 1, length 4, offset 4
+	buf = append(buf, 0x00, 0x03, 0x00, 0x01, 0x04, 0x09)                   // Windows, Unicode BMP, en-US
+	buf = append(buf, 0x00, 0x02, 0x00, 0x03, 0x00, 0x08)                   // nameID 2, length 3, offset 8
+	buf = append(buf, 0x00, 0x41, 0x00, 0x42, 0x00, 0x41, 0x00, 0x43, 0x00) // "AB", "AC"
+	buf = append(buf, 0x52, 0x00)                                           // odd-length "R"
+
+	nameTable := GetName(buf, 0)
+	if data := WriteName(nameTable); !reflect.DeepEqual(data, buf) {
+		t.Fatalf("name table not preserved:\n got %x\nwant %x", data, buf)
+	}
+
+	// only the edited record is re-encoded; identical strings are shared.
+	// SetName sorts the records, moving the edited one before (3,10).
+	if err := nameTable.SetName(2, "en-US", "Bold"); err != nil {
+		t.Fatalf("SetName failed: %v", err)
+	}
+	data := WriteName(nameTable)
+	reparsed := GetName(data, 0)
+	want := [][]byte{
+		{0x00, 0x41, 0x00, 0x42},
+		{0x00, 0x41, 0x00, 0x42},
+		{0x00, 0x42, 0x00, 0x6F, 0x00, 0x6C, 0x00, 0x64},
+		{0x00, 0x41, 0x00, 0x43},
+	}
+	for i, nr := range reparsed.NameRecord {
+		if !reflect.DeepEqual(nr.Raw, want[i]) {
+			t.Errorf("record %d: got %x, want %x", i, nr.Raw, want[i])
+		}
+	}
+	if reparsed.NameRecord[0].Offset != reparsed.NameRecord[1].Offset {
+		t.Errorf("expected identical strings to share an offset")
+	}
+	if len(data) != 54+16 {
+		t.Errorf("unexpected table length %d", len(data))
+	}
+}
+
+func TestRecalculateMetrics(t *testing.T) {
+	square := GlyphSimple{
+		GlyphCommon:      GlyphCommon{Index: 1, Type: GLYPH_TYPE_SIMPLE},
+		EndPtsOfContours: []uint16{3},
+		Points:           []*Point{{X: 10, Y: -20}, {X: 100}, {Y: 720}, {X: -100}},
+	}
+	glyphs := &Glyphs{
+		Simples: []GlyphSimple{square},
+		Compounds: []GlyphCompound{
+			{
+				GlyphCommon: GlyphCommon{Index: 2, NumberOfContours: -1, Type: GLYPH_TYPE_COMPOUND},
+				Component: []Component{
+					{Flags: ARGS_ARE_XY_VALUES | WE_HAVE_A_SCALE, GlyphIndex: 1, Argument1: 50, Argument2: 10, Xscale: 0.5, Yscale: 0.5},
+				},
+			},
+			{
+				GlyphCommon: GlyphCommon{Index: 3, NumberOfContours: -1, Type: GLYPH_TYPE_COMPOUND},
+				Component: []Component{
+					{Flags: ARGS_ARE_XY_VALUES, GlyphIndex: 2},
+					// point matching: glyph 1's point 2 lands on point 0
+					{GlyphIndex: 1, Argument1: 0, Argument2: 2},
+				},
+			},
+		},
+	}
+	tables := &Tables{
+		Head: &Head{},
+		Maxp: &Maxp{Version: "1.0", NumGlyphs: 4, MaxZones: 2},
+		Hhea: &Hhea{NumOfLongHorMetrics: 3},
+		Hmtx: &Hmtx{
+			HMetrics:        []*LongHorMetric{{AdvanceWidth: 500}, {AdvanceWidth: 600}, {AdvanceWidth: 700}},
+			LeftSideBearing: []int16{99},
+		},
+		Cmap: &Cmap{WindowsCode: map[int]int{0x41: 1, 0x42: 2, 0x4E00: 3, 0x1F600: 3}},
+		Os2:  &OS2{Version: 4, UsMaxContext: 1},
+		Gpos: &Gpos{Lookups: []*GposLookup{{LookupType: 1}, {LookupType: 2}}},
+	}
+	f := &Font{fontInfo: &FontInfo{Tables: tables, Glyphs: glyphs}}
+	if err := f.RecalculateMetrics(); err != nil {
+		t.Fatalf("RecalculateMetrics failed: %v", err)
+	}
+
+	bbox := func(c GlyphCommon) [4]int16 { return [4]int16{c.XMin, c.YMin, c.XMax, c.YMax} }
+	if got := bbox(glyphs.Simples[0].GlyphCommon); got != [4]int16{10, -20, 110, 700} {
+		t.Errorf("simple bbox %v", got)
+	}
+	if got := bbox(glyphs.Compounds[0].GlyphCommon); got != [4]int16{55, 0, 105, 360} {
+		t.Errorf("scaled compound bbox %v", got)
+	}
+	if got := bbox(glyphs.Compounds[1].GlyphCommon); got != [4]int16{-45, -720, 105, 360} {
+		t.Errorf("nested compound bbox %v", got)
+	}
+	head := tables.Head
+	if got := [4]int16{head.XMin, head.YMin, head.XMax, head.YMax}; got != [4]int16{-45, -720, 110, 700} {
+		t.Errorf("head bbox %v", got)
+	}
+
+	hhea := tables.Hhea
+	if hhea.AdvanceWidthMax != 700 || hhea.MinLeftSideBearing != -45 || hhea.MinRightSideBearing != 490 || hhea.XMaxExtent != 110 {
+		t.Errorf("unexpected hhea %+v", *hhea)
+	}
+	if _, lsb := tables.Hmtx.Metric(3); lsb != -45 {
+		t.Errorf("expected lsb of glyph 3 to follow xMin, got %d", lsb)
+	}
+
+	want := Maxp{Version: "1.0", NumGlyphs: 4, MaxPoints: 4, MaxContours: 1, MaxComponentPoints: 8,
+		MaxComponentContours: 2, MaxZones: 2, MaxComponentElements: 2, MaxComponentDepth: 2}
+	if *tables.Maxp != want {
+		t.Errorf("unexpected maxp %+v", *tables.Maxp)
+	}
+
+	os2 := tables.Os2
+	if os2.XAvgCharWidth != 625 {
+		t.Errorf("unexpected xAvgCharWidth %d", os2.XAvgCharWidth)
+	}
+	if os2.FsFirstCharIndex != 0x41 || os2.FsLastCharIndex != 0xFFFF {
+		t.Errorf("unexpected char index range %#x-%#x", os2.FsFirstCharIndex, os2.FsLastCharIndex)
+	}
+	if !reflect.DeepEqual(os2.UlUnicodeRange, []uint32{1, 1<<(57-32) | 1<<(59-32), 0, 0}) {
+		t.Errorf("unexpected unicode ranges %#x", os2.UlUnicodeRange)
+	}
+	if !reflect.DeepEqual(os2.UlCodePageRange, []uint32{1, 0}) {
+		t.Errorf("unexpected code page ranges %#x", os2.UlCodePageRange)
+	}
+	if os2.UsMaxContext != 2 {
+		t.Errorf("unexpected usMaxContext %d", os2.UsMaxContext)
+	}
+
+	// contexts that are not decoded, such as GSUB's, keep a larger value
+	tables.Gpos = nil
+	os2.UsMaxContext = 20
+	if err := f.RecalculateMetrics(); err != nil {
+		t.Fatalf("RecalculateMetrics failed: %v", err)
+	}
+	if os2.UsMaxContext != 20 {
+		t.Errorf("usMaxContext dropped to %d", os2.UsMaxContext)
+	}
+}
+
+func TestHmtxCollapse(t *testing.T) {
+	hmtx := &Hmtx{
+		HMetrics: []*LongHorMetric{
+			{AdvanceWidth: 500, LeftSideBearing: 10},
+			{AdvanceWidth: 1000, LeftSideBearing: 11},
+			{AdvanceWidth: 1000, LeftSideBearing: 12},
+			{AdvanceWidth: 1000, LeftSideBearing: 13},
+		},
+		LeftSideBearing: []int16{14},
+	}
+	if n := hmtx.NumberOfHMetrics(); n != 2 {
+		t.Fatalf("expected 2 long metrics, got %d", n)
+	}
+	data := WriteHmtx(hmtx)
+	want := []byte{0x01, 0xF4, 0x00, 0x0A, 0x03, 0xE8, 0x00, 0x0B, 0x00, 0x0C, 0x00, 0x0D, 0x00, 0x0E}
+	if !reflect.DeepEqual(data, want) {
+		t.Fatalf("unexpected hmtx %x", data)
+	}
+	reparsed := GetHmtx(data, 0, 2, 5)
+	for gid := 0; gid < 5; gid++ {
+		advance, lsb := reparsed.Metric(gid)
+		wantAdvance, wantLSB := hmtx.Metric(gid)
+		if advance != wantAdvance || lsb != wantLSB {
+			t.Errorf("glyph %d: got (%d, %d), want (%d, %d)", gid, advance, lsb, wantAdvance, wantLSB)
+		}
+	}
+
+	hmtx.compact()
+	if len(hmtx.HMetrics) != 2 || !reflect.DeepEqual(hmtx.LeftSideBearing, []int16{12, 13, 14}) {
+		t.Errorf("unexpected compacted hmtx %d %v", len(hmtx.HMetrics), hmtx.LeftSideBearing)
+	}
+
+	// a monospaced font keeps a single long metric
+	mono := &Hmtx{HMetrics: []*LongHorMetric{{AdvanceWidth: 600}, {AdvanceWidth: 600}}}
+	if n := mono.NumberOfHMetrics(); n != 1 {
+		t.Errorf("expected 1 long metric for a monospaced font, got %d", n)
+	}
+}
+
+func TestVerticalMetrics(t *testing.T) {
+	glyphs := &Glyphs{Simples: []GlyphSimple{{
+		GlyphCommon:      GlyphCommon{Index: 1, Type: GLYPH_TYPE_SIMPLE},
+		EndPtsOfContours: []uint16{3},
+		Points:           []*Point{{X: 10, Y: -20}, {X: 100}, {Y: 720}, {X: -100}},
+	}}}
+	tables := &Tables{
+		Maxp: &Maxp{Version: "1.0", NumGlyphs: 3},
+		Cmap: &Cmap{WindowsCode: map[int]int{'A': 1, 'B': 2}},
+		Os2:  &OS2{Version: 4, STypoAscender: 880, STypoDescender: -120, STypoLineGap: 50},
+	}
+	f := &Font{fontInfo: &FontInfo{Tables: tables, Glyphs: glyphs}}
+	if err := f.SynthesizeVerticalMetrics(); err != nil {
+		t.Fatalf("SynthesizeVerticalMetrics failed: %v", err)
+	}
+	wantVmtx := &Vmtx{VMetrics: []*LongVerMetric{{AdvanceHeight: 1000}}, TopSideBearing: []int16{180, 0}}
+	if !reflect.DeepEqual(tables.Vmtx, wantVmtx) {
+		t.Errorf("unexpected vmtx %+v %v", tables.Vmtx.VMetrics[0], tables.Vmtx.TopSideBearing)
+	}
+	wantVhea := &Vhea{Version: 1, Ascent: 500, Descent: -500, LineGap: 50, AdvanceHeightMax: 1000, MinTopSideBearing: 180,
+		MinBottomSideBearing: 100, YMaxExtent: 900, CaretSlopeRun: 1, NumOfLongVerMetrics: 1}
+	if !reflect.DeepEqual(tables.Vhea, wantVhea) {
+		t.Errorf("unexpected vhea %+v", *tables.Vhea)
+	}
+
+	if vhea, err := GetVhea(WriteVhea(tables.Vhea), 0); err != nil || !reflect.DeepEqual(vhea, tables.Vhea) {
+		t.Errorf("vhea round trip mismatch %+v %v", vhea, err)
+	}
+	if vmtx, err := GetVmtx(WriteVmtx(tables.Vmtx), 0, 1, 3); err != nil || !reflect.DeepEqual(vmtx, tables.Vmtx) {
+		t.Errorf("vmtx round trip mismatch %+v %v", vmtx, err)
+	}
+	if _, err := GetVhea(WriteVhea(tables.Vhea)[:35], 0); err == nil {
+		t.Errorf("expected an error for a truncated vhea")
+	}
+	if _, err := GetVmtx(WriteVmtx(tables.Vmtx)[:7], 0, 1, 3); err == nil {
+		t.Errorf("expected an error for a truncated vmtx")
+	}
+
+	if err := f.Subset([]string{"B"}); err != nil {
+		t.Fatalf("Subset failed: %v", err)
+	}
+	if advance, tsb := tables.Vmtx.Metric(1); advance != 1000 || tsb != 0 {
+		t.Errorf("unexpected subset metric of glyph 1: %d %d", advance, tsb)
+	}
+	if tables.Vhea.NumOfLongVerMetrics != uint16(len(tables.Vmtx.VMetrics)) {
+		t.Errorf("vhea count %d does not match vmtx", tables.Vhea.NumOfLongVerMetrics)
+	}
+}
+
+func TestVorg(t *testing.T) {
+	var buf []byte
+	buf = append(buf, 0x00, 0x01, 0x00, 0x00) // version 1.0
+	buf = append(buf, 0x03, 0x70, 0x00, 0x02) // default 880, 2 metrics
+	buf = append(buf, 0x00, 0x02, 0x03, 0x84) // glyph 2: 900
+	buf = append(buf, 0x00, 0x05, 0x02, 0xBC) // glyph 5: 700
+
+	vorg, err := GetVorg(buf, 0)
+	if err != nil {
+		t.Fatalf("GetVorg failed: %v", err)
+	}
+	if vorg.OriginY(2) != 900 || vorg.OriginY(5) != 700 || vorg.OriginY(3) != 880 {
+		t.Errorf("unexpected origins %v", vorg.VertOriginY)
+	}
+	if data := WriteVorg(vorg); !reflect.DeepEqual(data, buf) {
+		t.Errorf("VORG round trip mismatch %x", data)
+	}
+
+	tables := &Tables{Vorg: vorg, Cmap: &Cmap{WindowsCode: map[int]int{'A': 2, 'B': 3, 'C': 5}}}
+	f := &Font{fontInfo: &FontInfo{Tables: tables, Glyphs: &Glyphs{}}}
+	if err := f.Subset([]string{"AB"}); err != nil {
+		t.Fatalf("Subset failed: %v", err)
+	}
+	// glyphs 0, 2 and 3 are kept as 0, 1 and 2
+	if !reflect.DeepEqual(vorg.VertOriginY, map[uint16]int16{1: 900}) {
+		t.Errorf("unexpected subset origins %v", vorg.VertOriginY)
+	}
+	if got := f.VerticalOrigin(1); got != 900 {
+		t.Errorf("expected VORG origin 900, got %d", got)
+	}
+
+	// without VORG the origin is the top side bearing above yMax
+	tables.Vorg = nil
+	tables.Vmtx = &Vmtx{VMetrics: []*LongVerMetric{{AdvanceHeight: 1000, TopSideBearing: 120}}, TopSideBearing: []int16{80, 0}}
+	f.fontInfo.Glyphs = &Glyphs{Simples: []GlyphSimple{{GlyphCommon: GlyphCommon{Index: 1, YMax: 760}}}}
+	if got := f.VerticalOrigin(1); got != 840 {
+		t.Errorf("expected vmtx origin 840, got %d", got)
+	}
+	tables.Vmtx = nil
+	tables.Os2 = &OS2{STypoAscender: 880}
+	if got := f.VerticalOrigin(1); got != 880 {
+		t.Errorf("expected ascender origin 880, got %d", got)
+	}
+}
+
+func TestColrCpal(t *testing.T) {
+	cpal := &Cpal{
+		Version:           1,
+		NumPaletteEntries: 2,
+		Palettes: [][]color.NRGBA{
+			{{R: 0xFF, A: 0xFF}, {G: 0x80, B: 0x40, A: 0x80}},
+			{{R: 0x10, G: 0x20, B: 0x30, A: 0xFF}, {A: 0xFF}},
+			{{R: 0xFF, A: 0xFF}, {G: 0x80, B: 0x40, A: 0x80}},
+		},
+		PaletteTypes:       []uint32{CPAL_PALETTE_LIGHT_BACKGROUND, CPAL_PALETTE_DARK_BACKGROUND, 0},
+		PaletteLabels:      []uint16{256, 257, 0xFFFF},
+		PaletteEntryLabels: []uint16{258, 0xFFFF},
+	}
+	data := WriteCpal(cpal)
+	// the first and last palettes share their color records
+	if numColorRecords := binary.BigEndian.Uint16(data[6:8]); numColorRecords != 4 {
+		t.Errorf("expected 4 color records, got %d", numColorRecords)
+	}
+	gotCpal, err := GetCpal(data, 0)
+	if err != nil {
+		t.Fatalf("GetCpal failed: %v", err)
+	}
+	if !reflect.DeepEqual(gotCpal, cpal) {
+		t.Errorf("CPAL round trip mismatch %+v", gotCpal)
+	}
+
+	clip := &ClipBox{Format: 1, XMin: -10, YMin: -20, XMax: 500, YMax: 700}
+	colr := &Colr{
+		Version:         1,
+		BaseGlyphLayers: map[uint16][]LayerRecord{6: {{5, 0}, {2, 1}}},
+		BaseGlyphPaints: map[uint16]*Paint{
+			3:  {Format: PAINT_COLR_LAYERS, NumLayers: 2, FirstLayerIndex: 1},
+			8:  {Format: PAINT_GLYPH, GlyphID: 7, Paint: &Paint{Format: PAINT_RADIAL_GRADIENT, X0: 100, Y0: 200, Radius0: 10, X1: 100, Y1: 200, Radius1: 300, ColorLine: &ColorLine{Extend: EXTEND_REFLECT, Stops: []ColorStop{{0, 0, 1, 0}, {1, 1, 0.5, 0}}}}},
+			10: {Format: PAINT_GLYPH, GlyphID: 11, Paint: &Paint{Format: PAINT_VAR_SOLID, PaletteIndex: 1, Alpha: 0.75, VarIndexBase: 4}},
+		},
+		LayerList: []*Paint{
+			{Format: PAINT_GLYPH, GlyphID: 9, Paint: &Paint{Format: PAINT_SOLID, PaletteIndex: 0, Alpha: 1}},
+			{Format: PAINT_GLYPH, GlyphID: 4, Paint: &Paint{Format: PAINT_VAR_LINEAR_GRADIENT, X0: 0, Y0: 0, X1: 500, Y1: 0, X2: 0, Y2: -500, VarIndexBase: 2,
+				ColorLine: &ColorLine{Extend: EXTEND_PAD, Stops: []ColorStop{{0, 0, 1, 0}, {0.5, 1, 1, 1}}}}},
+			{Format: PAINT_COMPOSITE, CompositeMode: 3,
+				Paint: &Paint{Format: PAINT_ROTATE_AROUND_CENTER, Angle: 0.25, CenterX: 250, CenterY: 250,
+					Paint: &Paint{Format: PAINT_TRANSFORM, Transform: &Affine2x3{XX: 1, YY: 1.5, Dx: 20},
+						Paint: &Paint{Format: PAINT_COLR_GLYPH, GlyphID: 8}}},
+				Backdrop: &Paint{Format: PAINT_SWEEP_GRADIENT, CenterX: 250, CenterY: 250, StartAngle: 0, EndAngle: 1.5,
+					ColorLine: &ColorLine{Extend: EXTEND_REPEAT, Stops: []ColorStop{{0, 1, 1, 0}}}}},
+		},
+		Clips:       map[uint16]*ClipBox{3: clip, 4: clip, 8: {Format: 2, XMax: 100, YMax: 100, VarIndexBase: 7}, 10: clip},
+		VarIndexMap: []byte{0, 0x00, 0, 2, 0, 1},
+		ItemVariationStore: []byte{
+			0, 1, 0, 0, 0, 12, 0, 1, 0, 0, 0, 22, // format, region list at 12, one data at 22
+			0, 1, 0, 1, 0, 0, 0x40, 0, 0x40, 0, // one axis, one region
+			0, 1, 0, 1, 0, 1, 0, 0, 0xFF, 0xF6, // one item, one word delta
+		},
+	}
+	data, err = WriteColr(colr)
+	if err != nil {
+		t.Fatalf("WriteColr failed: %v", err)
+	}
+	gotColr, err := GetColr(data, 0)
+	if err != nil {
+		t.Fatalf("GetColr failed: %v", err)
+	}
+	if !reflect.DeepEqual(gotColr, colr) {
+		gotJson, _ := json.Marshal(gotColr)
+		t.Errorf("COLR round trip mismatch %s", gotJson)
+	}
+	if again, _ := WriteColr(gotColr); !reflect.DeepEqual(again, data) {
+		t.Errorf("COLR rewrite is not stable")
+	}
+
+	tables := &Tables{Colr: colr, Cpal: cpal, Cmap: &Cmap{WindowsCode: map[int]int{'A': 3, 'B': 6, 'C': 10}}}
+	f := &Font{fontInfo: &FontInfo{Tables: tables, Glyphs: &Glyphs{}}}
+	if err := f.Subset([]string{"AB"}); err != nil {
+		t.Fatalf("Subset failed: %v", err)
+	}
+	// glyphs 0, 2, 3, 4, 5, 6, 7 and 8 are kept as 0 to 7
+	if !reflect.DeepEqual(colr.BaseGlyphLayers, map[uint16][]LayerRecord{5: {{4, 0}, {1, 1}}}) {
+		t.Errorf("unexpected subset layers %v", colr.BaseGlyphLayers)
+	}
+	if len(colr.BaseGlyphPaints) != 2 || colr.BaseGlyphPaints[2] == nil || colr.BaseGlyphPaints[7] == nil {
+		t.Fatalf("unexpected subset base paints %v", colr.BaseGlyphPaints)
+	}
+	if p := colr.BaseGlyphPaints[2]; p.FirstLayerIndex != 0 || p.NumLayers != 2 {
+		t.Errorf("expected layers 0 and 1, got %d+%d", p.FirstLayerIndex, p.NumLayers)
+	}
+	if colr.BaseGlyphPaints[7].GlyphID != 6 {
+		t.Errorf("expected paint glyph 6, got %d", colr.BaseGlyphPaints[7].GlyphID)
+	}
+	if len(colr.LayerList) != 2 || colr.LayerList[0].GlyphID != 3 || colr.LayerList[1].Paint.Paint.Paint.GlyphID != 7 {
+		t.Errorf("unexpected subset layer list")
+	}
+	if len(colr.Clips) != 3 || colr.Clips[2] != clip || colr.Clips[3] != clip || colr.Clips[7] == nil {
+		t.Errorf("unexpected subset clips %v", colr.Clips)
+	}
+	if data, err = WriteColr(colr); err != nil {
+		t.Fatalf("WriteColr of subset failed: %v", err)
+	}
+	if _, err := GetColr(data, 0); err != nil {
+		t.Errorf("subset COLR does not parse: %v", err)
+	}
+}
+
+func TestColrSharedPaints(t *testing.T) {
+	solid := &Paint{Format: PAINT_SOLID, PaletteIndex: 1, Alpha: 1}
+	shared := &Paint{Format: PAINT_TRANSLATE, Dx: 10, Paint: &Paint{Format: PAINT_GLYPH, GlyphID: 5, Paint: solid}}
+	colr := &Colr{
+		Version: 1,
+		BaseGlyphPaints: map[uint16]*Paint{
+			3: {Format: PAINT_COMPOSITE, CompositeMode: 3, Paint: shared, Backdrop: &Paint{Format: PAINT_GLYPH, GlyphID: 6, Paint: solid}},
+			4: shared,
+		},
+		LayerList: []*Paint{shared},
+	}
+	data, err := WriteColr(colr)
+	if err != nil {
+		t.Fatalf("WriteColr failed: %v", err)
+	}
+	// header, base glyph list and layer list, then the composite, the
+	// translate, both glyph paints and the solid paint once each
+	if len(data) != 34+16+8+8+8+6+6+5 {
+		t.Errorf("expected shared paints to be written once, got %d bytes", len(data))
+	}
+	gotColr, err := GetColr(data, 0)
+	if err != nil {
+		t.Fatalf("GetColr failed: %v", err)
+	}
+	if !reflect.DeepEqual(gotColr.BaseGlyphPaints, colr.BaseGlyphPaints) {
+		t.Errorf("COLR round trip mismatch")
+	}
+	got := gotColr.BaseGlyphPaints[4]
+	if gotColr.BaseGlyphPaints[3].Paint != got || gotColr.LayerList[0] != got ||
+		gotColr.BaseGlyphPaints[3].Backdrop.Paint != got.Paint.Paint {
+		t.Errorf("expected shared paints to be read as one paint")
+	}
+
+	// a composite whose source is laid out after a backdrop too large for
+	// an Offset24 to skip
+	backdrop := &Paint{Format: PAINT_SOLID}
+	for i := 0; i < 45; i++ {
+		stops := make([]ColorStop, 65535)
+		for j := range stops {
+			stops[j] = ColorStop{PaletteIndex: uint16(i), Alpha: 1}
+		}
+		backdrop = &Paint{Format: PAINT_COMPOSITE, Paint: &Paint{Format: PAINT_LINEAR_GRADIENT, ColorLine: &ColorLine{Stops: stops}}, Backdrop: backdrop}
+	}
+	colr = &Colr{Version: 1, BaseGlyphPaints: map[uint16]*Paint{1: {Format: PAINT_COMPOSITE, Paint: solid, Backdrop: backdrop}}}
+	if _, err := WriteColr(colr); err == nil {
+		t.Errorf("expected an Offset24 overflow error")
+	}
+
+	// composites that use the previous one as both source and backdrop
+	// reach the glyph through 2^40 paths
+	dag := &Paint{Format: PAINT_GLYPH, GlyphID: 7, Paint: solid}
+	for i := 0; i < 40; i++ {
+		dag = &Paint{Format: PAINT_COMPOSITE, Paint: dag, Backdrop: dag}
+	}
+	colr = &Colr{Version: 1, BaseGlyphPaints: map[uint16]*Paint{2: dag}}
+	if glyphs := colr.ColorGlyphs(2); !reflect.DeepEqual(glyphs, []int{7}) {
+		t.Errorf("expected the shared glyph once, got %v", glyphs)
+	}
+}
+
+func TestBitmapColorGlyphs(t *testing.T) {
+	png := func(tag byte) []byte {
+		return []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n', tag}
+	}
+	shared := BigGlyphMetrics{Height: 20, Width: 18, HoriBearingY: 16, HoriAdvance: 20, VertBearingX: -9, VertAdvance: 22}
+	cbdt := &BitmapTables{
+		MajorVersion: 3,
+		Strikes: []*BitmapStrike{
+			{
+				Hori: SbitLineMetrics{Ascender: 15, Descender: -5, WidthMax: 20}, PpemX: 20, PpemY: 20, BitDepth: 32, Flags: BITMAP_FLAG_HORIZONTAL_METRICS,
+				Glyphs: map[uint16]*BitmapGlyph{
+					3: {ImageFormat: 17, Metrics: BigGlyphMetrics{Height: 20, Width: 20, HoriBearingY: 15, HoriAdvance: 20}, Data: png(3)},
+					4: {ImageFormat: 17, Metrics: BigGlyphMetrics{Height: 20, Width: 19, HoriBearingX: 1, HoriBearingY: 15, HoriAdvance: 20}, Data: png(4)},
+					7: {ImageFormat: 18, Metrics: shared, Data: png(7)},
+					8: {ImageFormat: 19, Metrics: shared, Data: png(8)},
+					9: {ImageFormat: 19, Metrics: shared, Data: png(9)},
+				},
+			},
+			{
+				PpemX: 109, PpemY: 109, BitDepth: 32, Flags: BITMAP_FLAG_HORIZONTAL_METRICS,
+				Glyphs: map[uint16]*BitmapGlyph{
+					3: {ImageFormat: 18, Metrics: shared, Data: append(png(3), 0xFF)},
+				},
+			},
+		},
+	}
+	cblcData, cbdtData := WriteCblc(cbdt), WriteCbdt(cbdt)
+	// glyphs 3-4, 7 and 8-9 each get one index subtable in the first strike
+	if n := binary.BigEndian.Uint32(cblcData[16:20]); n != 3 {
+		t.Errorf("expected 3 index subtables, got %d", n)
+	}
+	data := append(append([]byte{}, cblcData...), cbdtData...)
+	gotCbdt, err := GetCbdt(data, 0, len(cblcData))
+	if err != nil {
+		t.Fatalf("GetCbdt failed: %v", err)
+	}
+	if !reflect.DeepEqual(gotCbdt, cbdt) {
+		gotJson, _ := json.Marshal(gotCbdt)
+		t.Errorf("CBDT round trip mismatch %s", gotJson)
+	}
+
+	sbix := &Sbix{
+		Version: 1,
+		Flags:   1,
+		Strikes: []*SbixStrike{
+			{Ppem: 40, Ppi: 72, Glyphs: map[uint16]*SbixGlyph{
+				5: {GraphicType: "dupe", Data: []byte{0, 9}},
+				9: {OriginOffsetX: 2, OriginOffsetY: -3, GraphicType: "png ", Data: png(9)},
+			}},
+			{Ppem: 160, Ppi: 72, Glyphs: map[uint16]*SbixGlyph{
+				9: {GraphicType: "png ", Data: png(19)},
+			}},
+		},
+	}
+	sbixData := WriteSbix(sbix, 12)
+	gotSbix, err := GetSbix(sbixData, 0, 12)
+	if err != nil {
+		t.Fatalf("GetSbix failed: %v", err)
+	}
+	if !reflect.DeepEqual(gotSbix, sbix) {
+		t.Errorf("sbix round trip mismatch %+v", gotSbix)
+	}
+
+	tables := &Tables{Cbdt: cbdt, Sbix: sbix, Cmap: &Cmap{WindowsCode: map[int]int{'A': 3, 'B': 7, 'C': 4, 'D': 5}}}
+	f := &Font{fontInfo: &FontInfo{Tables: tables, Glyphs: &Glyphs{}}}
+	if png, ppem, err := f.GlyphPNG(3, 70); err != nil || ppem != 109 || len(png) != 10 {
+		t.Errorf("expected the 109 ppem image of glyph 3, got %d %v", ppem, err)
+	}
+	if png, ppem, _ := f.GlyphPNG(3, 64); ppem != 20 || len(png) != 9 {
+		t.Errorf("expected the 20 ppem image of glyph 3, got %d", ppem)
+	}
+	// glyph 5 only has a dupe of glyph 9 in the 40 ppem strike
+	if png, ppem, _ := f.GlyphPNG(5, 150); ppem != 40 || png[8] != 9 {
+		t.Errorf("expected the dupe image at 40 ppem, got %d", ppem)
+	}
+	if png, ppem, _ := f.GlyphPNG(9, 100); ppem != 160 || png[8] != 19 {
+		t.Errorf("expected the 160 ppem image of glyph 9, got %d", ppem)
+	}
+	if _, _, err := f.GlyphPNG(1, 20); err == nil {
+		t.Errorf("expected an error for a glyph without images")
+	}
+
+	if err := f.Subset([]string{"ABD"}); err != nil {
+		t.Fatalf("Subset failed: %v", err)
+	}
+	// glyphs 0, 3, 5, 7 and 9 are kept as 0 to 4, 9 through the sbix dupe
+	if got := sortedGlyphKeys(cbdt.Strikes[0].Glyphs); !reflect.DeepEqual(got, []uint16{1, 3, 4}) {
+		t.Errorf("unexpected subset strike glyphs %v", got)
+	}
+	if len(cbdt.Strikes) != 2 || cbdt.Strikes[1].Glyphs[1] == nil {
+		t.Errorf("expected both strikes to remain")
+	}
+	if glyph := sbix.Strikes[0].Glyphs[2]; glyph == nil || !reflect.DeepEqual(glyph.Data, []byte{0, 4}) {
+		t.Errorf("expected the dupe to point at glyph 4")
+	}
+	if _, err := GetCbdt(append(WriteCblc(cbdt), WriteCbdt(cbdt)...), 0, len(WriteCblc(cbdt))); err != nil {
+		t.Errorf("subset CBDT does not parse: %v", err)
+	}
+}
+
+func TestSvg(t *testing.T) {
+	icons := `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">` +
+		`<defs><linearGradient id="fill"/></defs>` +
+		`<g id="glyph2"><use xlink:href="#glyph4"/><g><path d="M0 0"/></g></g>` +
+		`<g id="glyph3"><!-- <g id="glyph9"> --><g><path d="M1 1"/></g><path title='a > b' d="M2 2"/></g>` +
+		`<path id="glyph4" fill="url(#fill)" d="M3 3"/>` +
+		`</svg>`
+	star := `<svg xmlns="http://www.w3.org/2000/svg"><path id="glyph7" d="M4 4"/></svg>`
+	svg := &Svg{Documents: []*SvgDocument{
+		{StartGlyphID: 7, EndGlyphID: 7, Document: star},
+		{StartGlyphID: 2, EndGlyphID: 4, Document: icons, Compressed: true},
+	}}
+	data := WriteSvg(svg)
+	gotSvg, err := GetSvg(data, 0)
+	if err != nil {
+		t.Fatalf("GetSvg failed: %v", err)
+	}
+	if len(gotSvg.Documents) != 2 || gotSvg.Documents[0].Document != icons || !gotSvg.Documents[0].Compressed ||
+		gotSvg.Documents[1].Document != star || gotSvg.Documents[1].Compressed {
+		t.Errorf("SVG round trip mismatch %+v", gotSvg.Documents)
+	}
+	if again := WriteSvg(gotSvg); !reflect.DeepEqual(again, data) {
+		t.Errorf("unchanged SVG documents are not written back as read")
+	}
+
+	tables := &Tables{Svg: gotSvg, Cmap: &Cmap{WindowsCode: map[int]int{'A': 2, 'B': 4, 'C': 3, 'D': 7}}}
+	f := &Font{fontInfo: &FontInfo{Tables: tables, Glyphs: &Glyphs{}}}
+	if doc, ok := f.GlyphSVG(3); !ok || doc != icons {
+		t.Errorf("expected the icons document for glyph 3")
+	}
+	if _, ok := f.GlyphSVG(5); ok {
+		t.Errorf("expected no document for glyph 5")
+	}
+
+	if err := f.Subset([]string{"ABD"}); err != nil {
+		t.Fatalf("Subset failed: %v", err)
+	}
+	// glyphs 0, 2, 4 and 7 are kept as 0 to 3
+	want := `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">` +
+		`<defs><linearGradient id="fill"/></defs>` +
+		`<g id="glyph1"><use xlink:href="#glyph2"/><g><path d="M0 0"/></g></g>` +
+		`<path id="glyph2" fill="url(#fill)" d="M3 3"/>` +
+		`</svg>`
+	docs := tables.Svg.Documents
+	if len(docs) != 2 || docs[0].StartGlyphID != 1 || docs[0].EndGlyphID != 2 || docs[0].Document != want {
+		t.Errorf("unexpected subset icons document %+v", docs[0])
+	}
+	if docs[1].StartGlyphID != 3 || docs[1].Document != strings.Replace(star, "glyph7", "glyph3", 1) {
+		t.Errorf("unexpected subset star document %+v", docs[1])
+	}
+	if _, err := GetSvg(WriteSvg(tables.Svg), 0); err != nil {
+		t.Errorf("subset SVG does not parse: %v", err)
+	}
+}
+
+func TestEmbeddedBitmaps(t *testing.T) {
+	// every glyph but the composites draws the same 3x2 checker:
+	// X.X
+	// .X.
+	small := BigGlyphMetrics{Height: 2, Width: 3, HoriBearingY: 2, HoriAdvance: 4}
+	big := BigGlyphMetrics{Height: 2, Width: 3, HoriBearingY: 2, HoriAdvance: 4, VertBearingX: -1, VertAdvance: 3}
+	ebdt := &BitmapTables{
+		MajorVersion: 2,
+		Strikes: []*BitmapStrike{{
+			PpemX: 8, PpemY: 8, BitDepth: 1, Flags: BITMAP_FLAG_HORIZONTAL_METRICS,
+			Glyphs: map[uint16]*BitmapGlyph{
+				1: {ImageFormat: 1, Metrics: small, Data: []byte{0xA0, 0x40}},
+				2: {ImageFormat: 2, Metrics: small, Data: []byte{0xA8}},
+				3: {ImageFormat: 6, Metrics: big, Data: []byte{0xA0, 0x40}},
+				4: {ImageFormat: 7, Metrics: big, Data: []byte{0xA8}},
+				5: {ImageFormat: 5, Metrics: big, Data: []byte{0xA8}},
+				6: {ImageFormat: 5, Metrics: big, Data: []byte{0xA8}},
+				7: {ImageFormat: 8, Metrics: BigGlyphMetrics{Height: 2, Width: 4, HoriBearingY: 2, HoriAdvance: 5},
+					Components: []EbdtComponent{{GlyphID: 1}, {GlyphID: 2, XOffset: 1}}},
+				8: {ImageFormat: 9, Metrics: big, Components: []EbdtComponent{{GlyphID: 5}}},
+			},
+		}},
+	}
+	eblcData, ebdtData := WriteEblc(ebdt), WriteEbdt(ebdt)
+	data := append(append([]byte{}, eblcData...), ebdtData...)
+	gotEbdt, err := GetEbdt(data, 0, len(eblcData))
+	if err != nil {
+		t.Fatalf("GetEbdt failed: %v", err)
+	}
+	if !reflect.DeepEqual(gotEbdt, ebdt) {
+		gotJson, _ := json.Marshal(gotEbdt)
+		t.Errorf("EBDT round trip mismatch %s", gotJson)
+	}
+
+	checker := []uint8{255, 0, 255, 0, 255, 0}
+	strike := gotEbdt.Strikes[0]
+	for gid := 1; gid <= 6; gid++ {
+		img, err := strike.Image(gid)
+		if err != nil {
+			t.Fatalf("glyph %d: %v", gid, err)
+		}
+		if img.Rect != image.Rect(0, -2, 3, 0) || !reflect.DeepEqual(img.Pix, checker) {
+			t.Errorf("glyph %d: unexpected image %v %v", gid, img.Rect, img.Pix)
+		}
+	}
+	if img, err := strike.Image(7); err != nil || !reflect.DeepEqual(img.Pix, []uint8{255, 255, 255, 255, 0, 255, 255, 0}) {
+		t.Errorf("unexpected composite image %v", err)
+	}
+
+	// The same images indexed by formats 3, 4 and 5. EBDT holds glyphs 1 to
+	// 8 from offset 4 with sizes 7, 6, 10, 9, 1, 1, 16 and 14.
+	var eblc []byte
+	eblc = append(eblc, writeUint16(2)...)
+	eblc = append(eblc, writeUint16(0)...)
+	eblc = append(eblc, writeUint32(1)...)
+	eblc = append(eblc, writeUint32(56)...)      // index subtable array
+	eblc = append(eblc, writeUint32(24+60)...)   // array and subtables size
+	eblc = append(eblc, writeUint32(3)...)       // three subtables
+	eblc = append(eblc, make([]byte, 4+24)...)   // colorRef and line metrics
+	eblc = append(eblc, 0, 1, 0, 7, 8, 8, 1, 1)  // glyphs 1-7, ppem 8, 1 bit
+	eblc = append(eblc, 0, 1, 0, 1, 0, 0, 0, 24) // glyph 1 at 24
+	eblc = append(eblc, 0, 5, 0, 6, 0, 0, 0, 36) // glyphs 5-6 at 36
+	eblc = append(eblc, 0, 7, 0, 7, 0, 0, 0, 64) // glyph 7 at 64
+	eblc = append(eblc, 0, 3, 0, 1, 0, 0, 0, 4)  // format 3, image 1 at 4
+	eblc = append(eblc, 0, 0, 0, 7)              // offsets 0 and 7
+	eblc = append(eblc, 0, 5, 0, 5, 0, 0, 0, 36) // format 5, image 5 at 36
+	eblc = append(eblc, 0, 0, 0, 1)              // image size 1
+	eblc = append(eblc, writeBigGlyphMetrics(big)...)
+	eblc = append(eblc, 0, 0, 0, 2, 0, 5, 0, 6)  // glyphs 5 and 6
+	eblc = append(eblc, 0, 4, 0, 8, 0, 0, 0, 38) // format 4, image 8 at 38
+	eblc = append(eblc, 0, 0, 0, 1, 0, 7, 0, 0)  // one glyph, glyph 7 at 0
+	eblc = append(eblc, 0, 0, 0, 16)             // end at 16
+	data = append(append([]byte{}, eblc...), ebdtData...)
+	handEbdt, err := GetEbdt(data, 0, len(eblc))
+	if err != nil {
+		t.Fatalf("GetEbdt of index formats 3 to 5 failed: %v", err)
+	}
+	handGlyphs := handEbdt.Strikes[0].Glyphs
+	for _, gid := range []uint16{1, 5, 6, 7} {
+		if !reflect.DeepEqual(handGlyphs[gid], ebdt.Strikes[0].Glyphs[gid]) {
+			t.Errorf("glyph %d: unexpected glyph %+v", gid, handGlyphs[gid])
+		}
+	}
+	if len(handGlyphs) != 4 {
+		t.Errorf("expected 4 glyphs, got %d", len(handGlyphs))
+	}
+
+	ebsc := &Ebsc{MajorVersion: 2, Scales: []BitmapScale{{Hori: SbitLineMetrics{Ascender: 9, Descender: -3}, PpemX: 12, PpemY: 12, SubstitutePpemX: 8, SubstitutePpemY: 8}}}
+	if gotEbsc, err := GetEbsc(WriteEbsc(ebsc), 0); err != nil || !reflect.DeepEqual(gotEbsc, ebsc) {
+		t.Errorf("EBSC round trip mismatch %+v %v", gotEbsc, err)
+	}
+
+	tables := &Tables{Ebdt: gotEbdt, Cmap: &Cmap{WindowsCode: map[int]int{'A': 7, 'B': 8}}}
+	f := &Font{fontInfo: &FontInfo{Tables: tables, Glyphs: &Glyphs{}}}
+	if err := f.Subset([]string{"AB"}); err != nil {
+		t.Fatalf("Subset failed: %v", err)
+	}
+	// glyphs 0, 1, 2, 5, 7 and 8 are kept as 0 to 5
+	glyphs := gotEbdt.Strikes[0].Glyphs
+	if len(glyphs) != 5 || !reflect.DeepEqual(glyphs[4].Components, []EbdtComponent{{GlyphID: 1}, {GlyphID: 2, XOffset: 1}}) ||
+		!reflect.DeepEqual(glyphs[5].Components, []EbdtComponent{{GlyphID: 3}}) {
+		t.Errorf("unexpected subset strike %v", sortedGlyphKeys(glyphs))
+	}
+	if img, err := f.GlyphBitmap(5, 8); err != nil || !reflect.DeepEqual(img.Pix, checker) {
+		t.Errorf("unexpected subset composite image %v", err)
+	}
+	if _, err := f.GlyphBitmap(5, 9); err == nil {
+		t.Errorf("expected no bitmap at 9 ppem")
+	}
+}
+
+func TestHintingTables(t *testing.T) {
+	fileByte, err := os.ReadFile("../test/Changa-Regular.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tableContent := GetTableContent(int(GetOffsetTable(fileByte).NumTables), fileByte)
+	raw := func(tag string) []byte {
+		info := tableContent[tag]
+		return fileByte[info.Offset : info.Offset+info.Length]
+	}
+
+	cvt := GetCvt(fileByte, int(tableContent["cvt "].Offset), int(tableContent["cvt "].Length))
+	if len(cvt) != 77 || !reflect.DeepEqual(WriteCvt(cvt), raw("cvt ")) {
+		t.Errorf("cvt round trip mismatch, %d values", len(cvt))
+	}
+	for _, tag := range []string{"fpgm", "prep"} {
+		program := GetProgram(fileByte, int(tableContent[tag].Offset), int(tableContent[tag].Length))
+		if !reflect.DeepEqual(WriteProgram(program), raw(tag)) {
+			t.Errorf("%s round trip mismatch", tag)
+		}
+	}
+	gasp, err := GetGasp(fileByte, int(tableContent["gasp"].Offset))
+	if err != nil {
+		t.Fatalf("GetGasp failed: %v", err)
+	}
+	if !reflect.DeepEqual(gasp.Ranges, []GaspRange{{0xFFFF, GASP_GRIDFIT | GASP_DOGRAY | GASP_SYMMETRIC_GRIDFIT | GASP_SYMMETRIC_SMOOTHING}}) ||
+		!reflect.DeepEqual(WriteGasp(gasp), raw("gasp")) {
+		t.Errorf("unexpected gasp %+v", gasp)
+	}
+
+	f, err := ReadFontFile("../test/Changa-Regular.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.GetFontInfo(); err != nil {
+		t.Fatal(err)
+	}
+	f.fontInfo.Tables.Head.Flags |= HEAD_INSTRUCTIONS_ALTER_ADVANCE
+	out := t.TempDir() + "/unhinted.ttf"
+	if err := f.Write(out, &WriteOptions{StripHinting: true}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	g, err := ReadFontFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.GetFontInfo(); err != nil {
+		t.Fatal(err)
+	}
+	tables := g.fontInfo.Tables
+	if tables.Cvt != nil || tables.Fpgm != nil || tables.Prep != nil {
+		t.Errorf("hinting tables were written")
+	}
+	if tables.Head.Flags&HEAD_INSTRUCTIONS_ALTER_ADVANCE != 0 {
+		t.Errorf("head still says instructions alter advance widths")
+	}
+	if tables.Gasp.Ranges[0].RangeGaspBehavior != GASP_DOGRAY|GASP_SYMMETRIC_SMOOTHING {
+		t.Errorf("unexpected gasp behavior %#x", tables.Gasp.Ranges[0].RangeGaspBehavior)
+	}
+	if m := tables.Maxp; m.MaxZones != 1 || m.MaxTwilightPoints != 0 || m.MaxStorage != 0 || m.MaxFunctionDefs != 0 ||
+		m.MaxStackElements != 0 || m.MaxSizeOfInstructions != 0 || m.MaxPoints != 369 {
+		t.Errorf("unexpected maxp %+v", *m)
+	}
+	// the glyphs lost their instructions and nothing else, and the short
+	// loca format still holds the offsets
+	if tables.Head.IndexToLocFormat != 0 || len(g.fontInfo.Glyphs.Simples) != 337 || len(g.fontInfo.Glyphs.Compounds) != 448 {
+		t.Fatalf("unexpected glyphs after write")
+	}
+	for i, simple := range g.fontInfo.Glyphs.Simples {
+		if len(simple.Instructions) != 0 {
+			t.Fatalf("glyph %d kept its instructions", simple.Index)
+		}
+		want := f.fontInfo.Glyphs.Simples[i]
+		for j, p := range simple.Points {
+			if q := want.Points[j]; p.X != q.X || p.Y != q.Y || p.Flag.OnCurve != q.Flag.OnCurve {
+				t.Fatalf("glyph %d outline changed", simple.Index)
+			}
+		}
+	}
+}
+
+func TestInstructionAssembler(t *testing.T) {
+	code := []uint8{
+		0xB1, 7, 3, // PUSHB 7 3
+		OP_FDEF,
+		0xB8, 0xFF, 0x38, // PUSHW -200
+		0xF6,                         // MIRP rp0, rnd, white
+		0x58, 0x31, 0x1B, 0x2F, 0x59, // IF IUP[x] ELSE MDAP[rnd] EIF
+		OP_ENDF,
+		0x40, 2, 9, 1, // NPUSHB 9 1
+		OP_CALL,
+		0x83, // undefined
+	}
+	want := "PUSHB[] 7 3\n" +
+		"FDEF[]  ; function 3\n" +
+		"  PUSHW[] -200\n" +
+		"  MIRP[rp0,rnd,white]\n" +
+		"  IF[]\n" +
+		"    IUP[x]\n" +
+		"  ELSE[]\n" +
+		"    MDAP[rnd]\n" +
+		"  EIF[]\n" +
+		"ENDF[]  ; end function 3\n" +
+		"NPUSHB[] 9 1\n" +
+		"CALL[]  ; function 1\n" +
+		"UNDEF[0x83]\n"
+	text, err := Disassemble(code)
+	if err != nil {
+		t.Fatalf("Disassemble failed: %v", err)
+	}
+	if text != want {
+		t.Errorf("unexpected disassembly\n%s", text)
+	}
+	if back, err := Assemble(text); err != nil || !reflect.DeepEqual(back, code) {
+		t.Errorf("assembly mismatch %v %v", back, err)
+	}
+
+	// every opcode without inline data survives the round trip
+	var all []uint8
+	for op := 0; op < 256; op++ {
+		if _, _, _, isPush := pushCount([]uint8{uint8(op), 0}, 0); !isPush {
+			all = append(all, uint8(op))
+		}
+	}
+	text, _ = Disassemble(all)
+	if back, err := Assemble(text); err != nil || !reflect.DeepEqual(back, all) {
+		t.Errorf("opcode round trip mismatch %v", err)
+	}
+
+	// modifiers in any order, in binary, and mnemonics without brackets
+	if back, err := Assemble("mirp[grey, rnd , rp0]\nMIRP[10100] ; comment\nSVTCA[x]\nDUP\nPUSHW 0x10 -1"); err != nil ||
+		!reflect.DeepEqual(back, []uint8{0xF4, 0xF4, 0x01, 0x20, 0xB9, 0x00, 0x10, 0xFF, 0xFF}) {
+		t.Errorf("unexpected assembly %v %v", back, err)
+	}
+	for _, bad := range []string{"MIRP[rnd]", "PUSHB[] 256", "PUSHB[] 1 2 3 4 5 6 7 8 9", "DUP[] 1", "FOO[]", "SVTCA[z]"} {
+		if _, err := Assemble(bad); err == nil {
+			t.Errorf("expected an error assembling %q", bad)
+		}
+	}
+	if _, err := Disassemble([]uint8{0xB2, 1}); err == nil {
+		t.Errorf("expected an error for a truncated push")
+	}
+
+	fileByte, err := os.ReadFile("../test/Changa-Regular.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tableContent := GetTableContent(int(GetOffsetTable(fileByte).NumTables), fileByte)
+	fpgm := GetProgram(fileByte, int(tableContent["fpgm"].Offset), int(tableContent["fpgm"].Length))
+	text, err = Disassemble(fpgm)
+	if err != nil {
+		t.Fatalf("Disassemble fpgm failed: %v", err)
+	}
+	if back, err := Assemble(text); err != nil || !reflect.DeepEqual(back, fpgm) {
+		t.Errorf("fpgm round trip mismatch %v", err)
+	}
+	if !strings.Contains(text, "FDEF[]  ; function 0\n") {
+		t.Errorf("expected function 0 in fpgm")
+	}
+}
+
+func TestHinting(t *testing.T) {
+	f, err := ReadFontFile("../test/Changa-Regular.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.HintedGlyph(4, 12); err == nil {
+		t.Errorf("expected an error before GetFontInfo")
+	}
+	if _, err := f.GetFontInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.NewHinter(0); err == nil {
+		t.Errorf("expected an error for ppem 0")
+	}
+
+	// outlines as grid-fitted by FreeType's v35 interpreter
+	for _, c := range []struct {
+		gid, ppem int
+		want      string
+	}{
+		{4, 48, "1664: 1213,447 479,447 353,0 46,0 614,1984 1078,1984 1647,0 1339,0 1143,704 860,1727 833,1727 550,704"},
+		{18, 20, "640: 548,15 445,0 376,0 255,0 132,67 83,227 83,383 83,541 133,702 262,768 389,768 433,768 544,753 577,742 561,638 516,650 398,664 361,664 283,664 220,629 220,577 220,121 285,104 372,104 472,104 581,132 594,32"},
+	} {
+		g, err := f.HintedGlyph(c.gid, c.ppem)
+		if err != nil {
+			t.Fatalf("HintedGlyph(%d, %d) failed: %v", c.gid, c.ppem, err)
+		}
+		got := strconv.Itoa(int(g.AdvanceWidth)) + ":"
+		for _, p := range g.Points {
+			got += " " + strconv.Itoa(int(p.X)) + "," + strconv.Itoa(int(p.Y))
+		}
+		if got != c.want {
+			t.Errorf("glyph %d at %dppem\ngot  %s\nwant %s", c.gid, c.ppem, got, c.want)
+		}
+	}
+
+	h, err := f.NewHinter(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for gid := 0; gid < int(f.fontInfo.Tables.Maxp.NumGlyphs); gid++ {
+		g, err := h.Glyph(gid)
+		if err != nil {
+			t.Fatalf("glyph %d failed: %v", gid, err)
+		}
+		if g.AdvanceWidth%64 != 0 {
+			t.Errorf("glyph %d advance %d is not on the pixel grid", gid, g.AdvanceWidth)
+		}
+	}
+	if _, err := h.Glyph(-1); err == nil {
+		t.Errorf("expected an error for a bad glyph id")
+	}
+}
+
+func TestDeviceMetrics(t *testing.T) {
+	f, err := ReadFontFile("../test/Changa-Regular.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.GenerateHdmx([]int{12}, true); err == nil {
+		t.Errorf("expected an error before GetFontInfo")
+	}
+	if _, err := f.GetFontInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.GenerateHdmx([]int{0}, false); err == nil {
+		t.Errorf("expected an error for size 0")
+	}
+	if err := f.GenerateVdmx(20, 10, false); err == nil {
+		t.Errorf("expected an error for an empty size range")
+	}
+
+	if err := f.GenerateHdmx([]int{20, 12, 20}, true); err != nil {
+		t.Fatalf("GenerateHdmx failed: %v", err)
+	}
+	hdmx := f.fontInfo.Tables.Hdmx
+	if len(hdmx.Records) != 2 || hdmx.Records[0].PixelSize != 12 || len(hdmx.Records[1].Widths) != 790 {
+		t.Fatalf("unexpected hdmx records")
+	}
+	gid, _ := f.GlyphIndex('B')
+	hinted, err := f.HintedGlyph(gid, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	width, ok := hdmx.Width(gid, 20)
+	if !ok || width != int(hinted.AdvanceWidth/64) {
+		t.Errorf("hdmx width %d, hinted advance %d", width, hinted.AdvanceWidth)
+	}
+
+	// subsetting keeps each retained glyph's widths
+	if err := f.Subset([]string{"BH"}); err != nil {
+		t.Fatalf("Subset failed: %v", err)
+	}
+	newGid, _ := f.GlyphIndex('B')
+	if w, ok := hdmx.Width(newGid, 20); !ok || w != width || len(hdmx.Records[0].Widths) != f.numGlyphs() {
+		t.Errorf("hdmx width %d after subsetting, want %d", w, width)
+	}
+
+	if err := f.GenerateLtsh(); err != nil {
+		t.Fatalf("GenerateLtsh failed: %v", err)
+	}
+	if err := f.GenerateVdmx(8, 24, true); err != nil {
+		t.Fatalf("GenerateVdmx failed: %v", err)
+	}
+	if len(f.fontInfo.Tables.Ltsh.YPels) != f.numGlyphs() {
+		t.Errorf("LTSH covers %d glyphs", len(f.fontInfo.Tables.Ltsh.YPels))
+	}
+	for ppem := 8; ppem <= 24; ppem++ {
+		yMax, yMin, ok := f.fontInfo.Tables.Vdmx.Extent(ppem, 1, 1)
+		if !ok || yMax <= 0 || yMin > 0 {
+			t.Fatalf("unexpected extent %d %d at %d ppem", yMax, yMin, ppem)
+		}
+		// the 0:0:0 ratio serves any other aspect ratio
+		if y, _, _ := f.fontInfo.Tables.Vdmx.Extent(ppem, 2, 1); y != yMax {
+			t.Errorf("no default ratio at %d ppem", ppem)
+		}
+	}
+
+	out := t.TempDir() + "/device.ttf"
+	if err := f.Write(out); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	g, err := ReadFontFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.GetFontInfo(); err != nil {
+		t.Fatal(err)
+	}
+	tables, want := g.fontInfo.Tables, f.fontInfo.Tables
+	if !reflect.DeepEqual(tables.Hdmx, want.Hdmx) || !reflect.DeepEqual(tables.Ltsh, want.Ltsh) || !reflect.DeepEqual(tables.Vdmx, want.Vdmx) {
+		t.Errorf("device metrics round trip mismatch")
+	}
+
+	// without hinting, widths and extents are scaled linearly
+	if err := g.GenerateHdmx([]int{20}, false); err != nil {
+		t.Fatalf("GenerateHdmx failed: %v", err)
+	}
+	advance, _ := tables.Hmtx.Metric(newGid)
+	if w, _ := tables.Hdmx.Width(newGid, 20); w != (int(advance)*20+500)/1000 {
+		t.Errorf("unexpected unhinted width %d for advance %d", w, advance)
+	}
+	if err := g.StripHinting(); err != nil {
+		t.Fatal(err)
+	}
+	if tables.Hdmx != nil || tables.Ltsh != nil || tables.Vdmx != nil {
+		t.Errorf("StripHinting kept the device metrics")
+	}
+}
+
+func TestInstantiate(t *testing.T) {
+	base, err := ReadFontFile("../test/Changa-Regular.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := base.Instantiate(nil); err == nil {
+		t.Errorf("expected an error before GetFontInfo")
+	}
+	if _, err := base.GetFontInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if err := base.Instantiate(map[string]float64{"wght": 700}); err == nil {
+		t.Errorf("expected an error for a static font")
+	}
+
+	// a weight axis from 200 to 800 with deltas on H
+	numGlyphs := base.numGlyphs()
+	hGid, _ := base.GlyphIndex('H')
+	simple := newGlyphSet(base.fontInfo.Glyphs).simples[hGid]
+	if simple == nil {
+		t.Fatal("H is not a simple glyph")
+	}
+	xs, ys := simpleCoordinates(simple)
+	n := len(xs)
+	fixed := func(v float64) uint32 { return uint32(int32(v * 65536)) }
+	tables := base.fontInfo.Tables
+	tables.Fvar = &Fvar{
+		Version: "1.0",
+		Axis:    []*SfntVariationAxis{{AxisTag: binary.BigEndian.Uint32([]byte("wght")), MinValue: fixed(200), DefaultValue: fixed(400), MaxValue: fixed(800), NameID: 257}},
+		Instance: []*SfntInstance{
+			{NameID: 256, Coordinates: []uint32{fixed(300)}},
+		},
+	}
+	if err := tables.Name.SetName(256, "en", "Light"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tables.Name.SetName(257, "en", "Weight"); err != nil {
+		t.Fatal(err)
+	}
+	tables.Avar = &Avar{Version: "1.0", SegmentMaps: [][]AxisValueMap{{{-1, -1}, {0, 0}, {0.5, 0.25}, {1, 1}}}}
+	heavy := &TupleVariation{Peak: []float32{1}, DeltasX: make([]int32, n+4), DeltasY: make([]int32, n+4)}
+	raised := &TupleVariation{Peak: []float32{0.5}, Start: []float32{0}, End: []float32{1}, DeltasX: make([]int32, n+4), DeltasY: make([]int32, n+4)}
+	for i := 0; i < n; i++ {
+		heavy.DeltasX[i] = 20
+		raised.DeltasY[i] = 10
+	}
+	heavy.DeltasX[n+1] = 40
+	light := &TupleVariation{Peak: []float32{-1}, Points: []uint16{0, uint16(n + 1)}, DeltasX: []int32{-30, -60}, DeltasY: []int32{0, 0}}
+	tables.Gvar = &Gvar{Version: "1.0", AxisCount: 1, SharedTuples: [][]float32{{1}}, Variations: make([][]*TupleVariation, numGlyphs)}
+	tables.Gvar.Variations[hGid] = []*TupleVariation{heavy, raised, light}
+
+	out := t.TempDir() + "/variable.ttf"
+	if err := base.Write(out); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	open := func() *Font {
+		f, err := ReadFontFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.GetFontInfo(); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	f := open()
+	if !reflect.DeepEqual(f.fontInfo.Tables.Gvar, tables.Gvar) || !reflect.DeepEqual(f.fontInfo.Tables.Avar, tables.Avar) {
+		t.Fatalf("gvar or avar round trip mismatch")
+	}
+	if err := f.Instantiate(map[string]float64{"wdth": 100}); err == nil {
+		t.Errorf("expected an error for a missing axis")
+	}
+
+	baseAdvance, _ := tables.Hmtx.Metric(hGid)
+	check := func(f *Font, dx, dy []int, advance int) {
+		t.Helper()
+		got := newGlyphSet(f.fontInfo.Glyphs).simples[hGid]
+		gx, gy := simpleCoordinates(got)
+		for i := range xs {
+			if gx[i] != xs[i]+dx[i] || gy[i] != ys[i]+dy[i] {
+				t.Fatalf("point %d at %d,%d, want %d,%d", i, gx[i], gy[i], xs[i]+dx[i], ys[i]+dy[i])
+			}
+		}
+		if a, _ := f.fontInfo.Tables.Hmtx.Metric(hGid); int(a) != int(baseAdvance)+advance {
+			t.Errorf("advance %d, want %d", a, int(baseAdvance)+advance)
+		}
+	}
+	fill := func(v int) []int {
+		d := make([]int, n)
+		for i := range d {
+			d[i] = v
+		}
+		return d
+	}
+
+	// at the maximum only the heavy tuple applies in full
+	if err := f.Instantiate(map[string]float64{"wght": 1000}); err != nil {
+		t.Fatalf("Instantiate failed: %v", err)
+	}
+	check(f, fill(20), fill(0), 40)
+	ft := f.fontInfo.Tables
+	if ft.Fvar != nil || ft.Gvar != nil || ft.Avar != nil || ft.Os2.UsWeightClass != 800 {
+		t.Errorf("unexpected tables after instancing, weight class %d", ft.Os2.UsWeightClass)
+	}
+	if style := nameText(ft.Name, 17); style != "ExtraBold" {
+		t.Errorf("style %q, want ExtraBold", style)
+	}
+	if nameText(ft.Name, 25) != "" {
+		t.Errorf("variations PostScript name prefix kept")
+	}
+
+	// avar maps 600 from 0.5 to 0.25, halfway to the intermediate peak
+	f = open()
+	if err := f.Instantiate(map[string]float64{"wght": 600}); err != nil {
+		t.Fatalf("Instantiate failed: %v", err)
+	}
+	check(f, fill(5), fill(5), 10)
+	if f.fontInfo.Tables.Os2.UsWeightClass != 600 || nameText(f.fontInfo.Tables.Name, 17) != "SemiBold" {
+		t.Errorf("unexpected weight class or style for 600")
+	}
+
+	// the light tuple lists one point; the rest of its contour follows
+	f = open()
+	if err := f.Instantiate(map[string]float64{"wght": 300}); err != nil {
+		t.Fatalf("Instantiate failed: %v", err)
+	}
+	dx := fill(0)
+	for i := 0; i <= int(simple.EndPtsOfContours[0]); i++ {
+		dx[i] = -15
+	}
+	check(f, dx, fill(0), -30)
+	if style := nameText(f.fontInfo.Tables.Name, 17); style != "Light" {
+		t.Errorf("style %q, want the named instance", style)
+	}
+
+	// HVAR advances take precedence over phantom points
+	g := open()
+	deltas := make([][]int32, numGlyphs)
+	for gid := range deltas {
+		deltas[gid] = []int32{0}
+	}
+	deltas[hGid][0] = 300
+	g.fontInfo.Tables.Hvar = &Hvar{Version: "1.0", Store: &ItemVariationStore{
+		Format:            1,
+		AxisCount:         1,
+		Regions:           [][]*RegionAxisCoordinates{{{0, 1, 1}}},
+		ItemVariationData: []*ItemVariationData{{RegionIndexes: []uint16{0}, DeltaSets: deltas}},
+	}}
+	if err := g.Write(out); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	f = open()
+	if !reflect.DeepEqual(f.fontInfo.Tables.Hvar, g.fontInfo.Tables.Hvar) {
+		t.Fatalf("HVAR round trip mismatch")
+	}
+	if err := f.Subset([]string{"BH"}); err != nil {
+		t.Fatalf("Subset failed: %v", err)
+	}
+	newGid, _ := f.GlyphIndex('H')
+	if v := f.fontInfo.Tables.Gvar.Variations[newGid]; len(v) != 3 {
+		t.Errorf("H has %d variations after subsetting", len(v))
+	}
+	if e := f.fontInfo.Tables.Hvar.AdvanceWidthMap.Entries[newGid]; int(e.Inner) != hGid {
+		t.Errorf("H maps to HVAR row %d after subsetting", e.Inner)
+	}
+	hGid = newGid
+	if err := f.Instantiate(map[string]float64{"wght": 800}); err != nil {
+		t.Fatalf("Instantiate failed: %v", err)
+	}
+	check(f, fill(20), fill(0), 300)
+
+	// inferred deltas interpolate between the listed neighbors
+	ddx, ddy := []float64{10, 0, 30, 0}, make([]float64, 4)
+	inferDeltas([]int{0, 50, 100, 100}, []int{0, 0, 0, 100}, []uint16{3}, []bool{true, false, true, false}, ddx, ddy)
+	if !reflect.DeepEqual(ddx, []float64{10, 20, 30, 30}) {
+		t.Errorf("unexpected inferred deltas %v", ddx)
+	}
+}
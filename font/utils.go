@@ -6,8 +6,10 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf16"
+	"unicode/utf8"
 )
 
 func getUint8(data []byte) uint8 {
@@ -249,7 +251,119 @@ var eightBitMacEncodings = map[string]string{
 		"¿¡¬√ƒ≈∆«»… ÀÃÕŒœ–—“”‘’÷◊ÿŸĞğİıŞş‡·‚„‰ÂÊÁËÈÍÎÏÌÓÔÒÚÛÙˆ˜¯˘˙˚¸˝˛ˇ",
 }
 
+// macCharToUnicode converts a character code of an 8-bit Mac encoding, as
+// chosen by the Mac script and language IDs, to Unicode.
+func macCharToUnicode(c int, script int, language int) (rune, bool) {
+	if c < 0 || c > 0xFF {
+		return 0, false
+	}
+	if c <= 0x7F {
+		return rune(c), true
+	}
+	encoding, exist := macLanguageEncodings[language]
+	if !exist {
+		encoding = macScriptEncodings[script]
+	}
+	table := []rune(eightBitMacEncodings[encoding])
+	if c-0x80 >= len(table) {
+		return 0, false
+	}
+	return table[c-0x80], true
+}
+
+// doubleByteEncoding is a Mac CJK encoding: single bytes below 0x80 are
+// ASCII, the bytes in single stand alone, and a lead byte with a row in rows
+// starts a two-byte character.
+type doubleByteEncoding struct {
+	single   map[byte]rune
+	trailLow byte
+	rows     map[byte]string
+
+	once    sync.Once
+	decoded map[byte][]rune
+	encoded map[rune][]byte
+}
+
+var doubleByteMacEncodings = map[string]*doubleByteEncoding{
+	"x-mac-japanese":    macJapanese,
+	"x-mac-chinesetrad": macChineseTrad,
+	"x-mac-korean":      macKorean,
+	"x-mac-chinesesimp": macChineseSimp,
+}
+
+// init expands the row strings and builds the reverse mapping on first use.
+func (enc *doubleByteEncoding) init() {
+	enc.once.Do(func() {
+		enc.decoded = make(map[byte][]rune, len(enc.rows))
+		enc.encoded = make(map[rune][]byte)
+		for lead, row := range enc.rows {
+			runes := []rune(row)
+			enc.decoded[lead] = runes
+			for i, r := range runes {
+				if _, exist := enc.encoded[r]; !exist && r != utf8.RuneError {
+					enc.encoded[r] = []byte{lead, enc.trailLow + byte(i)}
+				}
+			}
+		}
+		// single-byte forms win over double-byte duplicates
+		for b, r := range enc.single {
+			enc.encoded[r] = []byte{b}
+		}
+	})
+}
+
+func (enc *doubleByteEncoding) decode(data []byte) string {
+	enc.init()
+	var result strings.Builder
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if r, ok := enc.single[c]; ok {
+			result.WriteRune(r)
+			continue
+		}
+		if c <= 0x7F {
+			result.WriteByte(c)
+			continue
+		}
+		row, ok := enc.decoded[c]
+		if !ok || i+1 >= len(data) {
+			result.WriteRune(utf8.RuneError)
+			continue
+		}
+		i++
+		if t := int(data[i]) - int(enc.trailLow); t >= 0 && t < len(row) {
+			result.WriteRune(row[t])
+		} else {
+			result.WriteRune(utf8.RuneError)
+		}
+	}
+	return result.String()
+}
+
+// encode maps text back to the encoding; unmapped runes become '?'.
+func (enc *doubleByteEncoding) encode(text string) []byte {
+	enc.init()
+	var out []byte
+	for _, r := range text {
+		if b, ok := enc.encoded[r]; ok {
+			out = append(out, b...)
+		} else if r <= 0x7F {
+			out = append(out, byte(r))
+		} else {
+			out = append(out, '?')
+		}
+	}
+	return out
+}
+
 func DecodeMACSTRING(data []byte, offset int, dataLength int, platformSpecifi string) string {
+	if enc, ok := doubleByteMacEncodings[platformSpecifi]; ok {
+		if offset < 0 || dataLength < 0 || offset+dataLength > len(data) {
+			log.Printf("[WARNING] DecodeMACSTRING: out of bounds, offset=%d dataLength=%d dataLen=%d", offset, dataLength, len(data))
+			return ""
+		}
+		return enc.decode(data[offset : offset+dataLength])
+	}
 	table, exists := eightBitMacEncodings[platformSpecifi]
 	if !exists {
 		log.Printf("[WARNING] DecodeMACSTRING: encoding not found for platformSpecific=%s", platformSpecifi)
@@ -262,6 +376,7 @@ func DecodeMACSTRING(data []byte, offset int, dataLength int, platformSpecifi st
 		return ""
 	}
 
+	runes := []rune(table)
 	var result strings.Builder
 	result.Grow(dataLength)
 	for i := 0; i < dataLength; i++ {
@@ -270,8 +385,8 @@ func DecodeMACSTRING(data []byte, offset int, dataLength int, platformSpecifi st
 		// mapped to U+0000..U+007F; we only need to look up the others.
 		if c <= 0x7F {
 			result.WriteByte(c)
-		} else {
-			result.WriteRune(rune(table[c&0x7F]))
+		} else if int(c&0x7F) < len(runes) {
+			result.WriteRune(runes[c&0x7F])
 		}
 	}
 
@@ -0,0 +1,1148 @@
+package font
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// gvar tuple variation flags
+const (
+	EMBEDDED_PEAK_TUPLE   uint16 = 0x8000
+	INTERMEDIATE_REGION   uint16 = 0x4000
+	PRIVATE_POINT_NUMBERS uint16 = 0x2000
+	TUPLE_INDEX_MASK      uint16 = 0x0FFF
+	SHARED_POINT_NUMBERS  uint16 = 0x8000
+	TUPLE_COUNT_MASK      uint16 = 0x0FFF
+)
+
+var errGvarTruncated = errors.New("gvar table truncated")
+
+// TupleVariation is one set of point deltas of a glyph. It applies in full
+// at the Peak coordinates and fades out towards the edges of its region,
+// Start to End for an intermediate region, zero to Peak otherwise.
+type TupleVariation struct {
+	Peak  []float32 `json:"peak"`
+	Start []float32 `json:"start,omitempty"`
+	End   []float32 `json:"end,omitempty"`
+	// Points lists, in ascending order, the points the deltas move; nil
+	// means every point, the four phantom points included. Outline points
+	// left out take deltas inferred from their neighbors.
+	Points  []uint16 `json:"points,omitempty"`
+	DeltasX []int32  `json:"deltasX"`
+	DeltasY []int32  `json:"deltasY"`
+}
+
+type Gvar struct {
+	Version      string      `json:"version"`
+	AxisCount    uint16      `json:"axisCount"`
+	SharedTuples [][]float32 `json:"sharedTuples"`
+	// Variations holds the tuple variations of every glyph by glyph index
+	Variations [][]*TupleVariation `json:"variations"`
+}
+
+func readTuple(data []byte, pos int, axisCount int) []float32 {
+	tuple := make([]float32, axisCount)
+	for i := range tuple {
+		tuple[i] = get2Dot14(data[pos+i*2 : pos+i*2+2])
+	}
+	return tuple
+}
+
+func writeTuple(tuple []float32, axisCount int) []byte {
+	data := make([]byte, 0, axisCount*2)
+	for i := 0; i < axisCount; i++ {
+		var v float32
+		if i < len(tuple) {
+			v = tuple[i]
+		}
+		data = append(data, write2Dot14(v)...)
+	}
+	return data
+}
+
+// readPackedPoints reads packed point numbers; nil stands for all points
+func readPackedPoints(data []byte, pos int) (points []uint16, next int, err error) {
+	if pos >= len(data) {
+		return nil, 0, errGvarTruncated
+	}
+	count := int(data[pos])
+	pos++
+	if count&0x80 != 0 {
+		if pos >= len(data) {
+			return nil, 0, errGvarTruncated
+		}
+		count = (count&0x7F)<<8 | int(data[pos])
+		pos++
+	}
+	if count == 0 {
+		return nil, pos, nil
+	}
+	points = make([]uint16, 0, count)
+	last := 0
+	for len(points) < count {
+		if pos >= len(data) {
+			return nil, 0, errGvarTruncated
+		}
+		control := data[pos]
+		pos++
+		words := control&0x80 != 0
+		for run := int(control&0x7F) + 1; run > 0 && len(points) < count; run-- {
+			if words {
+				if pos+2 > len(data) {
+					return nil, 0, errGvarTruncated
+				}
+				last += int(getUint16(data[pos : pos+2]))
+				pos += 2
+			} else {
+				if pos >= len(data) {
+					return nil, 0, errGvarTruncated
+				}
+				last += int(data[pos])
+				pos++
+			}
+			points = append(points, uint16(last))
+		}
+	}
+	return points, pos, nil
+}
+
+func writePackedPoints(points []uint16) []byte {
+	n := len(points)
+	if n < 0x80 {
+		data := []byte{uint8(n)}
+		if n == 0 {
+			return data
+		}
+		return append(data, packPointRuns(points)...)
+	}
+	return append([]byte{0x80 | uint8(n>>8), uint8(n)}, packPointRuns(points)...)
+}
+
+func packPointRuns(points []uint16) []byte {
+	diffs := make([]int, len(points))
+	last := 0
+	for i, p := range points {
+		diffs[i] = int(p) - last
+		last = int(p)
+	}
+	data := []byte{}
+	for i := 0; i < len(diffs); {
+		words := diffs[i] > 0xFF
+		j := i
+		for j < len(diffs) && j-i < 128 && (diffs[j] > 0xFF) == words {
+			j++
+		}
+		control := uint8(j - i - 1)
+		if words {
+			control |= 0x80
+		}
+		data = append(data, control)
+		for _, d := range diffs[i:j] {
+			if words {
+				data = append(data, writeUint16(uint16(d))...)
+			} else {
+				data = append(data, uint8(d))
+			}
+		}
+		i = j
+	}
+	return data
+}
+
+// readPackedDeltas reads the packed deltas between pos and end
+func readPackedDeltas(data []byte, pos int, end int) ([]int32, error) {
+	var deltas []int32
+	for pos < end {
+		control := data[pos]
+		pos++
+		run := int(control&0x3F) + 1
+		size := 1
+		switch control & 0xC0 {
+		case 0x80:
+			size = 0
+		case 0x40:
+			size = 2
+		case 0xC0:
+			size = 4
+		}
+		if pos+run*size > end {
+			return nil, errGvarTruncated
+		}
+		for i := 0; i < run; i++ {
+			switch size {
+			case 0:
+				deltas = append(deltas, 0)
+			case 1:
+				deltas = append(deltas, int32(int8(data[pos])))
+			case 2:
+				deltas = append(deltas, int32(getInt16(data[pos:pos+2])))
+			case 4:
+				deltas = append(deltas, getInt32(data[pos:pos+4]))
+			}
+			pos += size
+		}
+	}
+	return deltas, nil
+}
+
+func writePackedDeltas(deltas []int32) []byte {
+	fitsByte := func(v int32) bool { return v >= -128 && v <= 127 }
+	fitsWord := func(v int32) bool { return v >= -32768 && v <= 32767 }
+	data := []byte{}
+	for i := 0; i < len(deltas); {
+		j := i
+		var control uint8
+		switch v := deltas[i]; {
+		case v == 0:
+			for j < len(deltas) && j-i < 64 && deltas[j] == 0 {
+				j++
+			}
+			control = 0x80
+		case fitsByte(v):
+			// a lone zero costs less inside a byte run than as a run
+			for j < len(deltas) && j-i < 64 && fitsByte(deltas[j]) && (deltas[j] != 0 || j+1 >= len(deltas) || deltas[j+1] != 0) {
+				j++
+			}
+		case fitsWord(v):
+			for j < len(deltas) && j-i < 64 && fitsWord(deltas[j]) && !fitsByte(deltas[j]) {
+				j++
+			}
+			control = 0x40
+		default:
+			for j < len(deltas) && j-i < 64 && !fitsWord(deltas[j]) {
+				j++
+			}
+			control = 0xC0
+		}
+		data = append(data, control|uint8(j-i-1))
+		for _, d := range deltas[i:j] {
+			switch control {
+			case 0:
+				data = append(data, uint8(int8(d)))
+			case 0x40:
+				data = append(data, writeInt16(int16(d))...)
+			case 0xC0:
+				data = append(data, writeInt32(d)...)
+			}
+		}
+		i = j
+	}
+	return data
+}
+
+func getGlyphVariations(data []byte, pos int, end int, axisCount int, shared [][]float32) ([]*TupleVariation, error) {
+	if pos+4 > end {
+		return nil, errGvarTruncated
+	}
+	countField := getUint16(data[pos : pos+2])
+	dataPos := pos + int(getUint16(data[pos+2:pos+4]))
+	p := pos + 4
+	count := int(countField & TUPLE_COUNT_MASK)
+	variations := make([]*TupleVariation, count)
+	sizes := make([]int, count)
+	private := make([]bool, count)
+	for i := 0; i < count; i++ {
+		if p+4 > end {
+			return nil, errGvarTruncated
+		}
+		sizes[i] = int(getUint16(data[p : p+2]))
+		index := getUint16(data[p+2 : p+4])
+		p += 4
+		tv := &TupleVariation{}
+		if index&EMBEDDED_PEAK_TUPLE != 0 {
+			if p+axisCount*2 > end {
+				return nil, errGvarTruncated
+			}
+			tv.Peak = readTuple(data, p, axisCount)
+			p += axisCount * 2
+		} else {
+			k := int(index & TUPLE_INDEX_MASK)
+			if k >= len(shared) {
+				return nil, errors.New("shared tuple " + strconv.Itoa(k) + " out of range")
+			}
+			tv.Peak = append([]float32{}, shared[k]...)
+		}
+		if index&INTERMEDIATE_REGION != 0 {
+			if p+axisCount*4 > end {
+				return nil, errGvarTruncated
+			}
+			tv.Start = readTuple(data, p, axisCount)
+			tv.End = readTuple(data, p+axisCount*2, axisCount)
+			p += axisCount * 4
+		}
+		private[i] = index&PRIVATE_POINT_NUMBERS != 0
+		variations[i] = tv
+	}
+
+	var sharedPoints []uint16
+	if countField&SHARED_POINT_NUMBERS != 0 {
+		var err error
+		if sharedPoints, dataPos, err = readPackedPoints(data[:end], dataPos); err != nil {
+			return nil, err
+		}
+	}
+	for i, tv := range variations {
+		tupleEnd := dataPos + sizes[i]
+		if tupleEnd > end {
+			return nil, errGvarTruncated
+		}
+		p, points := dataPos, sharedPoints
+		if private[i] {
+			var err error
+			if points, p, err = readPackedPoints(data[:tupleEnd], p); err != nil {
+				return nil, err
+			}
+		}
+		deltas, err := readPackedDeltas(data, p, tupleEnd)
+		if err != nil {
+			return nil, err
+		}
+		if len(deltas)%2 != 0 || (points != nil && len(deltas) != len(points)*2) {
+			return nil, errors.New("tuple variation has " + strconv.Itoa(len(deltas)) + " deltas")
+		}
+		n := len(deltas) / 2
+		tv.Points = append([]uint16(nil), points...)
+		tv.DeltasX, tv.DeltasY = deltas[:n], deltas[n:]
+		dataPos = tupleEnd
+	}
+	return variations, nil
+}
+
+func GetGvar(data []byte, pos int) (gvar *Gvar, err error) {
+	start := pos
+	if pos+20 > len(data) {
+		return nil, errGvarTruncated
+	}
+	gvar = &Gvar{
+		Version:   getVersion(data[pos : pos+4]),
+		AxisCount: getUint16(data[pos+4 : pos+6]),
+	}
+	axisCount := int(gvar.AxisCount)
+	sharedCount := int(getUint16(data[pos+6 : pos+8]))
+	sharedOffset := int(getUint32(data[pos+8 : pos+12]))
+	glyphCount := int(getUint16(data[pos+12 : pos+14]))
+	longOffsets := getUint16(data[pos+14:pos+16])&1 != 0
+	arrayStart := start + int(getUint32(data[pos+16:pos+20]))
+
+	if p := start + sharedOffset; p+sharedCount*axisCount*2 > len(data) {
+		return nil, errGvarTruncated
+	}
+	for i := 0; i < sharedCount; i++ {
+		gvar.SharedTuples = append(gvar.SharedTuples, readTuple(data, start+sharedOffset+i*axisCount*2, axisCount))
+	}
+
+	offsets := make([]int, glyphCount+1)
+	p := pos + 20
+	for i := range offsets {
+		if longOffsets {
+			if p+4 > len(data) {
+				return nil, errGvarTruncated
+			}
+			offsets[i] = int(getUint32(data[p : p+4]))
+			p += 4
+		} else {
+			if p+2 > len(data) {
+				return nil, errGvarTruncated
+			}
+			offsets[i] = int(getUint16(data[p:p+2])) * 2
+			p += 2
+		}
+	}
+	gvar.Variations = make([][]*TupleVariation, glyphCount)
+	for gid := 0; gid < glyphCount; gid++ {
+		begin, end := arrayStart+offsets[gid], arrayStart+offsets[gid+1]
+		if end <= begin {
+			continue
+		}
+		if end > len(data) {
+			return nil, errGvarTruncated
+		}
+		variations, err := getGlyphVariations(data, begin, end, axisCount, gvar.SharedTuples)
+		if err != nil {
+			return nil, errors.New("gvar glyph " + strconv.Itoa(gid) + ": " + err.Error())
+		}
+		gvar.Variations[gid] = variations
+	}
+	return gvar, nil
+}
+
+// WriteGvar writes the variations of numGlyphs glyphs. Peaks equal to a
+// shared tuple refer to it and every tuple carries its own point numbers.
+func WriteGvar(gvar *Gvar, numGlyphs int) []byte {
+	axisCount := int(gvar.AxisCount)
+	sharedIndex := make(map[string]int)
+	shared := []byte{}
+	for i, tuple := range gvar.SharedTuples {
+		encoded := writeTuple(tuple, axisCount)
+		if _, ok := sharedIndex[string(encoded)]; !ok {
+			sharedIndex[string(encoded)] = i
+		}
+		shared = append(shared, encoded...)
+	}
+
+	glyphData := []byte{}
+	offsets := make([]int, numGlyphs+1)
+	for gid := 0; gid < numGlyphs; gid++ {
+		offsets[gid] = len(glyphData)
+		if gid >= len(gvar.Variations) || len(gvar.Variations[gid]) == 0 {
+			continue
+		}
+		variations := gvar.Variations[gid]
+		headers, serialized := []byte{}, []byte{}
+		for _, tv := range variations {
+			peak := writeTuple(tv.Peak, axisCount)
+			index := PRIVATE_POINT_NUMBERS
+			k, isShared := sharedIndex[string(peak)]
+			if isShared {
+				index |= uint16(k)
+			} else {
+				index |= EMBEDDED_PEAK_TUPLE
+			}
+			intermediate := tv.Start != nil && tv.End != nil
+			if intermediate {
+				index |= INTERMEDIATE_REGION
+			}
+			body := writePackedPoints(tv.Points)
+			body = append(body, writePackedDeltas(append(append([]int32{}, tv.DeltasX...), tv.DeltasY...))...)
+			headers = append(headers, writeUint16(uint16(len(body)))...)
+			headers = append(headers, writeUint16(index)...)
+			if !isShared {
+				headers = append(headers, peak...)
+			}
+			if intermediate {
+				headers = append(headers, writeTuple(tv.Start, axisCount)...)
+				headers = append(headers, writeTuple(tv.End, axisCount)...)
+			}
+			serialized = append(serialized, body...)
+		}
+		glyphData = append(glyphData, writeUint16(uint16(len(variations))&TUPLE_COUNT_MASK)...)
+		glyphData = append(glyphData, writeUint16(uint16(4+len(headers)))...)
+		glyphData = append(glyphData, headers...)
+		glyphData = append(glyphData, serialized...)
+		if len(glyphData)%2 == 1 {
+			// keep offsets even so the short format can hold them
+			glyphData = append(glyphData, 0)
+		}
+	}
+	offsets[numGlyphs] = len(glyphData)
+
+	longOffsets := len(glyphData) > 0x1FFFE
+	offsetData := []byte{}
+	for _, offset := range offsets {
+		if longOffsets {
+			offsetData = append(offsetData, writeUint32(uint32(offset))...)
+		} else {
+			offsetData = append(offsetData, writeUint16(uint16(offset/2))...)
+		}
+	}
+	var flags uint16
+	if longOffsets {
+		flags = 1
+	}
+	version := gvar.Version
+	if version == "" {
+		version = "1.0"
+	}
+	sharedOffset := 20 + len(offsetData)
+	data := []byte{}
+	data = append(data, writeVersion(version)...)
+	data = append(data, writeUint16(gvar.AxisCount)...)
+	data = append(data, writeUint16(uint16(len(gvar.SharedTuples)))...)
+	data = append(data, writeUint32(uint32(sharedOffset))...)
+	data = append(data, writeUint16(uint16(numGlyphs))...)
+	data = append(data, writeUint16(flags)...)
+	data = append(data, writeUint32(uint32(sharedOffset+len(shared)))...)
+	data = append(data, offsetData...)
+	data = append(data, shared...)
+	return append(data, glyphData...)
+}
+
+func remapGvar(gvar *Gvar, oldIndices []int) {
+	variations := make([][]*TupleVariation, len(oldIndices))
+	for newIdx, oldIdx := range oldIndices {
+		if oldIdx < len(gvar.Variations) {
+			variations[newIdx] = gvar.Variations[oldIdx]
+		}
+	}
+	gvar.Variations = variations
+}
+
+// AxisValueMap maps a normalized coordinate before avar to the one used
+type AxisValueMap struct {
+	FromCoordinate float32 `json:"fromCoordinate"`
+	ToCoordinate   float32 `json:"toCoordinate"`
+}
+
+type Avar struct {
+	Version string `json:"version"`
+	// SegmentMaps holds the maps of every fvar axis, in fromCoordinate
+	// order
+	SegmentMaps [][]AxisValueMap `json:"segmentMaps"`
+}
+
+func GetAvar(data []byte, pos int) (avar *Avar, err error) {
+	if pos+8 > len(data) {
+		return nil, errors.New("avar table truncated")
+	}
+	avar = &Avar{Version: getVersion(data[pos : pos+4])}
+	axisCount := int(getUint16(data[pos+6 : pos+8]))
+	pos += 8
+	for i := 0; i < axisCount; i++ {
+		if pos+2 > len(data) {
+			return nil, errors.New("avar table truncated")
+		}
+		count := int(getUint16(data[pos : pos+2]))
+		pos += 2
+		if pos+count*4 > len(data) {
+			return nil, errors.New("avar table truncated")
+		}
+		segments := make([]AxisValueMap, count)
+		for j := range segments {
+			segments[j] = AxisValueMap{get2Dot14(data[pos : pos+2]), get2Dot14(data[pos+2 : pos+4])}
+			pos += 4
+		}
+		avar.SegmentMaps = append(avar.SegmentMaps, segments)
+	}
+	return avar, nil
+}
+
+// WriteAvar writes a version 1.0 table; the axis variations of version 2
+// are not kept
+func WriteAvar(avar *Avar) []byte {
+	data := []byte{}
+	data = append(data, writeVersion("1.0")...)
+	data = append(data, writeUint16(0)...)
+	data = append(data, writeUint16(uint16(len(avar.SegmentMaps)))...)
+	for _, segments := range avar.SegmentMaps {
+		data = append(data, writeUint16(uint16(len(segments)))...)
+		for _, m := range segments {
+			data = append(data, write2Dot14(m.FromCoordinate)...)
+			data = append(data, write2Dot14(m.ToCoordinate)...)
+		}
+	}
+	return data
+}
+
+// mapAxisValue applies an avar segment map to a normalized coordinate,
+// interpolating linearly between the map entries
+func mapAxisValue(segments []AxisValueMap, v float64) float64 {
+	if len(segments) == 0 {
+		return v
+	}
+	first, last := segments[0], segments[len(segments)-1]
+	if v <= float64(first.FromCoordinate) {
+		return v + float64(first.ToCoordinate-first.FromCoordinate)
+	}
+	for k := 1; k < len(segments); k++ {
+		lo, hi := segments[k-1], segments[k]
+		if v > float64(hi.FromCoordinate) {
+			continue
+		}
+		if hi.FromCoordinate == lo.FromCoordinate {
+			return float64(hi.ToCoordinate)
+		}
+		t := (v - float64(lo.FromCoordinate)) / float64(hi.FromCoordinate-lo.FromCoordinate)
+		return float64(lo.ToCoordinate) + t*float64(hi.ToCoordinate-lo.ToCoordinate)
+	}
+	return v + float64(last.ToCoordinate-last.FromCoordinate)
+}
+
+// delta returns the interpolated delta of an item at the normalized
+// coordinates
+func (store *ItemVariationStore) delta(outer, inner int, coords []float64) float64 {
+	if outer < 0 || outer >= len(store.ItemVariationData) {
+		return 0
+	}
+	ivd := store.ItemVariationData[outer]
+	if inner < 0 || inner >= len(ivd.DeltaSets) {
+		return 0
+	}
+	var delta float64
+	for k, r := range ivd.RegionIndexes {
+		if int(r) >= len(store.Regions) || k >= len(ivd.DeltaSets[inner]) {
+			continue
+		}
+		region := store.Regions[r]
+		peak, start, end := make([]float32, len(region)), make([]float32, len(region)), make([]float32, len(region))
+		for i, c := range region {
+			start[i], peak[i], end[i] = c.StartCoord, c.PeakCoord, c.EndCoord
+		}
+		delta += tupleScalar(coords, peak, start, end) * float64(ivd.DeltaSets[inner][k])
+	}
+	return delta
+}
+
+// VariationIndex addresses a row of an ItemVariationStore
+type VariationIndex struct {
+	Outer uint16 `json:"outer"`
+	Inner uint16 `json:"inner"`
+}
+
+// DeltaSetIndexMap maps glyph indices to variation store rows. Glyphs past
+// the end use the last entry.
+type DeltaSetIndexMap struct {
+	Entries []VariationIndex `json:"entries"`
+}
+
+func getDeltaSetIndexMap(data []byte, pos int) (*DeltaSetIndexMap, error) {
+	length, err := deltaSetIndexMapLength(data, pos)
+	if err != nil {
+		return nil, errors.New("delta set index map truncated")
+	}
+	entryFormat := data[pos+1]
+	innerBits := uint(entryFormat&0x0F) + 1
+	entrySize := int(entryFormat>>4&0x3) + 1
+	header := 4
+	if data[pos] == 1 {
+		header = 6
+	}
+	m := &DeltaSetIndexMap{}
+	for p := pos + header; p+entrySize <= pos+length; p += entrySize {
+		var v uint32
+		for _, b := range data[p : p+entrySize] {
+			v = v<<8 | uint32(b)
+		}
+		m.Entries = append(m.Entries, VariationIndex{uint16(v >> innerBits), uint16(v & (1<<innerBits - 1))})
+	}
+	return m, nil
+}
+
+func writeDeltaSetIndexMap(m *DeltaSetIndexMap) []byte {
+	innerBits, outerBits := uint(1), uint(0)
+	for _, e := range m.Entries {
+		for e.Inner>>innerBits != 0 {
+			innerBits++
+		}
+		for e.Outer>>outerBits != 0 {
+			outerBits++
+		}
+	}
+	entrySize := int(innerBits+outerBits+7) / 8
+	data := []byte{}
+	if len(m.Entries) > 0xFFFF {
+		data = append(data, 1, uint8(entrySize-1)<<4|uint8(innerBits-1))
+		data = append(data, writeUint32(uint32(len(m.Entries)))...)
+	} else {
+		data = append(data, 0, uint8(entrySize-1)<<4|uint8(innerBits-1))
+		data = append(data, writeUint16(uint16(len(m.Entries)))...)
+	}
+	for _, e := range m.Entries {
+		v := uint32(e.Outer)<<innerBits | uint32(e.Inner)
+		for i := entrySize - 1; i >= 0; i-- {
+			data = append(data, uint8(v>>(8*uint(i))))
+		}
+	}
+	return data
+}
+
+// index returns the variation store row of glyph gid. Without a map the
+// glyph index is the row of the first subtable.
+func (m *DeltaSetIndexMap) index(gid int) (outer, inner int) {
+	if m == nil || len(m.Entries) == 0 {
+		return 0, gid
+	}
+	if gid >= len(m.Entries) {
+		gid = len(m.Entries) - 1
+	}
+	return int(m.Entries[gid].Outer), int(m.Entries[gid].Inner)
+}
+
+// Hvar holds the horizontal metrics variations. The side bearing maps are
+// nil when the font has no side bearing variations.
+type Hvar struct {
+	Version         string              `json:"version"`
+	Store           *ItemVariationStore `json:"store"`
+	AdvanceWidthMap *DeltaSetIndexMap   `json:"advanceWidthMap,omitempty"`
+	LsbMap          *DeltaSetIndexMap   `json:"lsbMap,omitempty"`
+	RsbMap          *DeltaSetIndexMap   `json:"rsbMap,omitempty"`
+}
+
+func GetHvar(data []byte, pos int) (hvar *Hvar, err error) {
+	if pos+20 > len(data) {
+		return nil, errors.New("HVAR table truncated")
+	}
+	hvar = &Hvar{Version: getVersion(data[pos : pos+4])}
+	if hvar.Store, err = getItemVariationStore(data, pos+int(getUint32(data[pos+4:pos+8]))); err != nil {
+		return nil, err
+	}
+	maps := []**DeltaSetIndexMap{&hvar.AdvanceWidthMap, &hvar.LsbMap, &hvar.RsbMap}
+	for i, m := range maps {
+		offset := int(getUint32(data[pos+8+i*4 : pos+12+i*4]))
+		if offset == 0 {
+			continue
+		}
+		if *m, err = getDeltaSetIndexMap(data, pos+offset); err != nil {
+			return nil, err
+		}
+	}
+	return hvar, nil
+}
+
+func WriteHvar(hvar *Hvar) []byte {
+	version := hvar.Version
+	if version == "" {
+		version = "1.0"
+	}
+	body := writeItemVariationStore(hvar.Store)
+	data := []byte{}
+	data = append(data, writeVersion(version)...)
+	data = append(data, writeUint32(20)...)
+	for _, m := range []*DeltaSetIndexMap{hvar.AdvanceWidthMap, hvar.LsbMap, hvar.RsbMap} {
+		if m == nil {
+			data = append(data, writeUint32(0)...)
+			continue
+		}
+		data = append(data, writeUint32(uint32(20+len(body)))...)
+		body = append(body, writeDeltaSetIndexMap(m)...)
+	}
+	return append(data, body...)
+}
+
+// remapHvar gives the retained glyphs the rows they had; a font without an
+// advance map gets one, since glyph indices no longer match the rows
+func remapHvar(hvar *Hvar, oldIndices []int) {
+	remap := func(m *DeltaSetIndexMap) *DeltaSetIndexMap {
+		remapped := &DeltaSetIndexMap{Entries: make([]VariationIndex, len(oldIndices))}
+		for newIdx, oldIdx := range oldIndices {
+			outer, inner := m.index(oldIdx)
+			remapped.Entries[newIdx] = VariationIndex{uint16(outer), uint16(inner)}
+		}
+		return remapped
+	}
+	hvar.AdvanceWidthMap = remap(hvar.AdvanceWidthMap)
+	if hvar.LsbMap != nil {
+		hvar.LsbMap = remap(hvar.LsbMap)
+	}
+	if hvar.RsbMap != nil {
+		hvar.RsbMap = remap(hvar.RsbMap)
+	}
+}
+
+// tupleScalar returns how much of a variation applies at the normalized
+// coordinates: 1 at the peak, falling to 0 at the edges of the region.
+// Without start and end the region spans from 0 to the peak.
+func tupleScalar(coords []float64, peak, start, end []float32) float64 {
+	scalar := 1.0
+	for i, p := range peak {
+		if p == 0 {
+			continue
+		}
+		var v float64
+		if i < len(coords) {
+			v = coords[i]
+		}
+		pk := float64(p)
+		if v == pk {
+			continue
+		}
+		lo, hi := math.Min(pk, 0), math.Max(pk, 0)
+		if start != nil && end != nil && i < len(start) && i < len(end) {
+			lo, hi = float64(start[i]), float64(end[i])
+			if lo > pk || pk > hi || (lo < 0 && hi > 0) {
+				// invalid regions leave the axis out
+				continue
+			}
+		}
+		if v <= lo || v >= hi {
+			return 0
+		}
+		if v < pk {
+			scalar *= (v - lo) / (pk - lo)
+		} else {
+			scalar *= (hi - v) / (hi - pk)
+		}
+	}
+	return scalar
+}
+
+// inferDelta interpolates the delta of a coordinate c from the nearest
+// listed points on either side, at c1 and c2 with deltas d1 and d2
+func inferDelta(c, c1, c2 int, d1, d2 float64) float64 {
+	if c1 > c2 {
+		c1, c2, d1, d2 = c2, c1, d2, d1
+	}
+	switch {
+	case c1 == c2:
+		if d1 == d2 {
+			return d1
+		}
+		return 0
+	case c <= c1:
+		return d1
+	case c >= c2:
+		return d2
+	}
+	return d1 + (d2-d1)*float64(c-c1)/float64(c2-c1)
+}
+
+// inferDeltas fills in the deltas of the outline points a variation does
+// not list from the listed points before and after them on their contour.
+// Contours without listed points do not move.
+func inferDeltas(xs, ys []int, ends []uint16, listed []bool, dx, dy []float64) {
+	start := 0
+	for _, last := range ends {
+		end := int(last)
+		if end >= len(xs) {
+			break
+		}
+		var refs []int
+		for i := start; i <= end; i++ {
+			if listed[i] {
+				refs = append(refs, i)
+			}
+		}
+		if len(refs) > 0 && len(refs) <= end-start {
+			for i := start; i <= end; i++ {
+				if listed[i] {
+					continue
+				}
+				// the nearest listed points before and after i, wrapping
+				// around the contour
+				prev, next := refs[len(refs)-1], refs[0]
+				for _, r := range refs {
+					if r < i {
+						prev = r
+					} else {
+						next = r
+						break
+					}
+				}
+				dx[i] = inferDelta(xs[i], xs[prev], xs[next], dx[prev], dx[next])
+				dy[i] = inferDelta(ys[i], ys[prev], ys[next], dy[prev], dy[next])
+			}
+		}
+		start = end + 1
+	}
+}
+
+// glyphDeltas sums the deltas of the variations at the normalized
+// coordinates for a glyph with n points before its phantom points. xs, ys
+// and ends describe a simple glyph's outline and are nil for compound
+// glyphs, whose unlisted points do not move.
+func glyphDeltas(variations []*TupleVariation, coords []float64, n int, xs, ys []int, ends []uint16) (dx, dy []float64) {
+	dx, dy = make([]float64, n+4), make([]float64, n+4)
+	for _, tv := range variations {
+		scalar := tupleScalar(coords, tv.Peak, tv.Start, tv.End)
+		if scalar == 0 {
+			continue
+		}
+		tx, ty := make([]float64, n+4), make([]float64, n+4)
+		if tv.Points == nil {
+			for i := 0; i < n+4 && i < len(tv.DeltasX) && i < len(tv.DeltasY); i++ {
+				tx[i], ty[i] = float64(tv.DeltasX[i]), float64(tv.DeltasY[i])
+			}
+		} else {
+			listed := make([]bool, n)
+			for k, p := range tv.Points {
+				if int(p) >= n+4 || k >= len(tv.DeltasX) || k >= len(tv.DeltasY) {
+					continue
+				}
+				tx[p], ty[p] = float64(tv.DeltasX[k]), float64(tv.DeltasY[k])
+				if int(p) < n {
+					listed[p] = true
+				}
+			}
+			if xs != nil {
+				inferDeltas(xs, ys, ends, listed, tx, ty)
+			}
+		}
+		for i := range dx {
+			dx[i] += scalar * tx[i]
+			dy[i] += scalar * ty[i]
+		}
+	}
+	return dx, dy
+}
+
+func fixedValue(v uint32) float64 {
+	return float64(int32(v)) / 65536
+}
+
+func roundF2Dot14(v float64) float64 {
+	return math.Round(v*16384) / 16384
+}
+
+// normalizeAxis maps a user coordinate to -1 at the axis minimum, 0 at the
+// default and 1 at the maximum
+func normalizeAxis(v, min, def, max float64) float64 {
+	switch {
+	case v < def && def > min:
+		return (v - def) / (def - min)
+	case v > def && max > def:
+		return (v - def) / (max - def)
+	}
+	return 0
+}
+
+// weightStyle names a weight class the usual way
+func weightStyle(weight float64) string {
+	names := []string{"Thin", "ExtraLight", "Light", "Regular", "Medium", "SemiBold", "Bold", "ExtraBold", "Black"}
+	k := int(math.Round(weight / 100))
+	if k < 1 {
+		k = 1
+	}
+	if k > len(names) {
+		k = len(names)
+	}
+	return names[k-1]
+}
+
+// widthClass returns the OS/2 width class closest to a wdth percentage
+func widthClass(percent float64) uint16 {
+	widths := []float64{50, 62.5, 75, 87.5, 100, 112.5, 125, 150, 200}
+	class := 0
+	for i, w := range widths {
+		if math.Abs(w-percent) < math.Abs(widths[class]-percent) {
+			class = i
+		}
+	}
+	return uint16(class + 1)
+}
+
+// Instantiate turns a variable TrueType font into the static instance at
+// coords, user space values by axis tag such as {"wght": 700}. Axes left
+// out keep their default and values outside an axis range are clamped.
+//
+// Coordinates are normalized through avar, then the gvar deltas move the
+// points and component offsets, with deltas inferred for outline points a
+// variation leaves out. Advance widths come from HVAR, or from the phantom
+// points without one; the outlines are shifted so that the origin phantom
+// point stays at x=0. The font is renamed after the named instance at
+// coords or else after its weight, OS/2 weight and width classes follow
+// the wght and wdth axes, fvar, gvar, avar and HVAR are dropped and the
+// metrics are recalculated. cvar and MVAR are not applied.
+func (f *Font) Instantiate(coords map[string]float64) error {
+	if f.fontInfo == nil {
+		return errors.New("fontInfo is nil, call GetFontInfo first")
+	}
+	tables := f.fontInfo.Tables
+	if tables.Fvar == nil || len(tables.Fvar.Axis) == 0 {
+		return errors.New("font has no variation axes")
+	}
+	axes := tables.Fvar.Axis
+	user := make([]float64, len(axes))
+	normalized := make([]float64, len(axes))
+	known := make(map[string]bool)
+	for i, axis := range axes {
+		tag := string(writeUint32(axis.AxisTag))
+		known[tag] = true
+		min, def, max := fixedValue(axis.MinValue), fixedValue(axis.DefaultValue), fixedValue(axis.MaxValue)
+		v := def
+		if c, ok := coords[tag]; ok {
+			v = math.Max(min, math.Min(max, c))
+		}
+		user[i] = v
+		n := roundF2Dot14(normalizeAxis(v, min, def, max))
+		if tables.Avar != nil && i < len(tables.Avar.SegmentMaps) {
+			n = roundF2Dot14(mapAxisValue(tables.Avar.SegmentMaps[i], n))
+		}
+		normalized[i] = n
+	}
+	for tag := range coords {
+		if !known[tag] {
+			return errors.New("font has no " + tag + " axis")
+		}
+	}
+	style := f.instanceStyle(user)
+
+	if err := f.applyVariations(normalized); err != nil {
+		return err
+	}
+
+	tables.Fvar, tables.Gvar, tables.Avar, tables.Hvar = nil, nil, nil, nil
+	if os2 := tables.Os2; os2 != nil {
+		for i, axis := range axes {
+			switch string(writeUint32(axis.AxisTag)) {
+			case "wght":
+				os2.UsWeightClass = uint16(math.Max(1, math.Min(1000, math.Round(user[i]))))
+			case "wdth":
+				os2.UsWidthClass = widthClass(user[i])
+			}
+		}
+	}
+	if tables.Name != nil {
+		// the variations PostScript name prefix only applies to
+		// variable fonts
+		tables.Name.DeleteName(25, "")
+		family := nameText(tables.Name, 16)
+		if family == "" {
+			family = nameText(tables.Name, 1)
+		}
+		if style != "" && family != "" {
+			if err := f.RenameFamily(family, &RenameOptions{Style: style}); err != nil {
+				return err
+			}
+		}
+	}
+	return f.RecalculateMetrics()
+}
+
+// instanceStyle returns the subfamily name of the named instance at the
+// user coordinates or else one derived from the weight. It returns "" when
+// neither applies.
+func (f *Font) instanceStyle(user []float64) string {
+	tables := f.fontInfo.Tables
+	if tables.Name == nil {
+		return ""
+	}
+	for _, instance := range tables.Fvar.Instance {
+		matches := len(instance.Coordinates) == len(user)
+		for i := 0; matches && i < len(user); i++ {
+			matches = math.Abs(fixedValue(instance.Coordinates[i])-user[i]) < 0.5/65536
+		}
+		if matches {
+			if style := nameText(tables.Name, instance.NameID); style != "" {
+				return style
+			}
+		}
+	}
+	for i, axis := range tables.Fvar.Axis {
+		if string(writeUint32(axis.AxisTag)) != "wght" {
+			continue
+		}
+		style := weightStyle(user[i])
+		current := nameText(tables.Name, 17)
+		if current == "" {
+			current = nameText(tables.Name, 2)
+		}
+		if italic, _, _ := styleFlags(current); italic {
+			if style == "Regular" {
+				return "Italic"
+			}
+			return style + " Italic"
+		}
+		return style
+	}
+	return ""
+}
+
+// applyVariations moves the points, component offsets and phantom points of
+// every glyph by its gvar deltas and sets the advances from HVAR or the
+// phantom points
+func (f *Font) applyVariations(coords []float64) error {
+	tables := f.fontInfo.Tables
+	numGlyphs := f.numGlyphs()
+	set := newGlyphSet(f.fontInfo.Glyphs)
+	phantoms := make([][4]hintPoint, numGlyphs)
+	for gid := range phantoms {
+		phantoms[gid] = f.phantomPoints(gid)
+	}
+
+	shifts := make([]int, numGlyphs)
+	advances := make([]int, numGlyphs)
+	verticals := make([][2]int, numGlyphs)
+	for gid := 0; gid < numGlyphs; gid++ {
+		var variations []*TupleVariation
+		if tables.Gvar != nil && gid < len(tables.Gvar.Variations) {
+			variations = tables.Gvar.Variations[gid]
+		}
+		var dx, dy []float64
+		if simple, ok := set.simples[gid]; ok {
+			xs, ys := simpleCoordinates(simple)
+			n := len(xs)
+			dx, dy = glyphDeltas(variations, coords, n, xs, ys, simple.EndPtsOfContours)
+			if len(variations) > 0 {
+				shift := int(math.Round(float64(phantoms[gid][0].x)+dx[n])) - int(phantoms[gid][0].x)
+				for i := range xs {
+					xs[i] += int(math.Round(dx[i])) - shift
+					ys[i] += int(math.Round(dy[i]))
+				}
+				setSimpleCoordinates(simple, xs, ys)
+			}
+			dx, dy = dx[n:], dy[n:]
+		} else if compound, ok := set.compounds[gid]; ok {
+			n := len(compound.Component)
+			dx, dy = glyphDeltas(variations, coords, n, nil, nil, nil)
+			for i := range compound.Component {
+				c := &compound.Component[i]
+				if c.Flags&ARGS_ARE_XY_VALUES != 0 {
+					c.Argument1 += int(math.Round(dx[i]))
+					c.Argument2 += int(math.Round(dy[i]))
+				}
+			}
+			dx, dy = dx[n:], dy[n:]
+		} else {
+			dx, dy = glyphDeltas(variations, coords, 0, nil, nil, nil)
+		}
+
+		var p [4][2]int
+		for k := range p {
+			p[k] = [2]int{int(math.Round(float64(phantoms[gid][k].x) + dx[k])), int(math.Round(float64(phantoms[gid][k].y) + dy[k]))}
+		}
+		shifts[gid] = p[0][0] - int(phantoms[gid][0].x)
+		advances[gid] = p[1][0] - p[0][0]
+		verticals[gid] = [2]int{p[2][1], p[2][1] - p[3][1]}
+	}
+
+	// Compound glyphs place their components' outlines as they are, so
+	// components moved to keep their origin at x=0 are moved back, and the
+	// compound then shifts as a whole like a simple glyph
+	for gid, compound := range set.compounds {
+		own := shifts[gid]
+		for j := range compound.Component {
+			c := &compound.Component[j]
+			if c.Flags&ARGS_ARE_XY_VALUES == 0 {
+				continue
+			}
+			child := 0
+			if int(c.GlyphIndex) < numGlyphs {
+				child = shifts[c.GlyphIndex]
+			}
+			a, b := 1.0, 0.0
+			if c.Flags&(WE_HAVE_A_SCALE|WE_HAVE_AN_X_AND_Y_SCALE|WE_HAVE_A_TWO_BY_TWO) != 0 {
+				a, b = float64(c.Xscale), float64(c.Scale01)
+			}
+			c.Argument1 += int(math.Round(a*float64(child))) - own
+			c.Argument2 += int(math.Round(b * float64(child)))
+			if c.Argument1 < -128 || c.Argument1 > 127 || c.Argument2 < -128 || c.Argument2 > 127 {
+				c.Flags |= ARG_1_AND_2_ARE_WORDS
+			}
+		}
+	}
+
+	if tables.Hvar != nil && tables.Hvar.Store != nil && tables.Hmtx != nil {
+		for gid := range advances {
+			base, _ := tables.Hmtx.Metric(gid)
+			outer, inner := tables.Hvar.AdvanceWidthMap.index(gid)
+			advances[gid] = int(base) + int(math.Round(tables.Hvar.Store.delta(outer, inner, coords)))
+		}
+	}
+
+	// the bounds of the new outlines give the side bearings
+	set = newGlyphSet(f.fontInfo.Glyphs)
+	if f.fontInfo.Glyphs != nil {
+		recalcGlyphBounds(f.fontInfo.Glyphs, set)
+	}
+	clamp := func(v int) uint16 {
+		return uint16(math.Max(0, math.Min(0xFFFF, float64(v))))
+	}
+	if hmtx := tables.Hmtx; hmtx != nil {
+		newHmtx := &Hmtx{}
+		for gid := 0; gid < numGlyphs; gid++ {
+			_, lsb := hmtx.Metric(gid)
+			newHmtx.HMetrics = append(newHmtx.HMetrics, &LongHorMetric{AdvanceWidth: clamp(advances[gid]), LeftSideBearing: lsb})
+		}
+		newHmtx.compact()
+		tables.Hmtx = newHmtx
+		if tables.Hhea != nil {
+			tables.Hhea.NumOfLongHorMetrics = uint16(len(newHmtx.HMetrics))
+		}
+	}
+	if vmtx := tables.Vmtx; vmtx != nil {
+		newVmtx := &Vmtx{}
+		for gid := 0; gid < numGlyphs; gid++ {
+			_, tsb := vmtx.Metric(gid)
+			if _, _, _, yMax, ok := set.outline(gid, 0).bounds(); ok {
+				tsb = int16(verticals[gid][0] - int(yMax))
+			}
+			newVmtx.VMetrics = append(newVmtx.VMetrics, &LongVerMetric{AdvanceHeight: clamp(verticals[gid][1]), TopSideBearing: tsb})
+		}
+		newVmtx.compact()
+		tables.Vmtx = newVmtx
+		if tables.Vhea != nil {
+			tables.Vhea.NumOfLongVerMetrics = uint16(len(newVmtx.VMetrics))
+		}
+	}
+	return nil
+}